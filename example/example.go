@@ -19,7 +19,7 @@ func main() {
 			local.TCP(`localhost:8080`),
 		),
 		api.Rig(
-			// api.Use(hog.Middleware()),
+			api.Use(api.Logger()),
 			api.FS(wwwFS, // is either os.DirFS(`www`) or an embed.FS when built with `deploy` tag.
 				`GET /`, // becomes index.html due to screwy Go behavior.
 				`GET /style.css`,
@@ -3,13 +3,17 @@ module github.com/swdunlop/rig-go
 go 1.22.0
 
 require (
+	github.com/andybalholm/brotli v1.1.0
 	github.com/evanw/esbuild v0.22.0
 	github.com/fsnotify/fsnotify v1.7.0
 	github.com/gobwas/glob v0.2.3
+	github.com/pkg/errors v0.9.1
 	github.com/rs/zerolog v1.33.0
 	github.com/swdunlop/html-go v0.0.0-20240325145910-5746e466b36f
 	github.com/swdunlop/zugzug-go v0.0.0-20231203221927-9874d313168b
 	github.com/tinylib/msgp v1.1.9
+	golang.org/x/crypto v0.24.0
+	golang.org/x/net v0.26.0
 	nhooyr.io/websocket v1.8.11
 	tailscale.com v1.60.0
 )
@@ -65,7 +69,6 @@ require (
 	github.com/mitchellh/go-ps v1.0.0 // indirect
 	github.com/philhofer/fwd v1.1.2 // indirect
 	github.com/pierrec/lz4/v4 v4.1.21 // indirect
-	github.com/pkg/errors v0.9.1 // indirect
 	github.com/safchain/ethtool v0.3.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/tailscale/certstore v0.1.1-0.20231202035212-d3fa0460f47e // indirect
@@ -83,10 +86,8 @@ require (
 	github.com/x448/float16 v0.8.4 // indirect
 	go4.org/mem v0.0.0-20220726221520-4f986261bf13 // indirect
 	go4.org/netipx v0.0.0-20231129151722-fdeea329fbba // indirect
-	golang.org/x/crypto v0.24.0 // indirect
 	golang.org/x/exp v0.0.0-20240119083558-1b970713d09a // indirect
 	golang.org/x/mod v0.18.0 // indirect
-	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
 	golang.org/x/term v0.21.0 // indirect
@@ -0,0 +1,57 @@
+package rig
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"slices"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig/hook"
+	"golang.org/x/net/http2"
+)
+
+// ALPN returns an option that hands TLS connections that negotiate the given application protocol to fn instead of
+// serving them as HTTP, using http.Server.TLSNextProto.  This lets one TLS port serve HTTP alongside a custom protocol.
+// The handler passed to fn is the handler of the server, which fn may use or ignore.
+//
+// HTTP/1.1 and HTTP/2 ("http/1.1" and "h2") remain supported; since Go disables its automatic HTTP/2 support when
+// TLSNextProto is set, this option configures HTTP/2 explicitly.  Registering "h2" itself replaces the HTTP/2 server.
+//
+// Protocols are only negotiated with TLS listeners, and the protocol must be offered by the TLS configuration of the
+// listener.  This option adds the protocol to the TLSConfig of the server, which is used when the server terminates
+// TLS itself; listeners that terminate TLS separately must list it in their own configuration.  The supervisor applies
+// server hooks, so connections are handed to fn by the supervisor rather than a worker.
+func ALPN(proto string, fn func(server *http.Server, conn *tls.Conn, handler http.Handler)) Option {
+	return func(cfg *Config) error {
+		cfg.Hook(alpnHook{proto, fn})
+		return nil
+	}
+}
+
+type alpnHook struct {
+	proto string
+	fn    func(*http.Server, *tls.Conn, http.Handler)
+}
+
+// RigServer implements hook.Server by registering the protocol with the server.
+func (ah alpnHook) RigServer(server *http.Server) {
+	if server.TLSNextProto == nil {
+		// Setting TLSNextProto disables the automatic HTTP/2 support, so we configure it explicitly first.
+		err := http2.ConfigureServer(server, nil)
+		if err != nil {
+			hog.From(context.Background()).Warn().Err(err).Msg(`cannot configure HTTP/2`)
+			server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+	}
+	server.TLSNextProto[ah.proto] = ah.fn
+	if server.TLSConfig == nil {
+		server.TLSConfig = new(tls.Config)
+	}
+	if !slices.Contains(server.TLSConfig.NextProtos, ah.proto) {
+		// Custom protocols are preferred over HTTP, since clients offering them presumably want them.
+		server.TLSConfig.NextProtos = append([]string{ah.proto}, server.TLSConfig.NextProtos...)
+	}
+}
+
+var _ hook.Server = alpnHook{}
@@ -0,0 +1,72 @@
+package rig
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestALPN(t *testing.T) {
+	certFile, keyFile, pool := testCertificate(t)
+	cfg, err := New(
+		TLS(certFile, keyFile),
+		ALPN(`echo/1`, func(server *http.Server, conn *tls.Conn, handler http.Handler) {
+			defer conn.Close()
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			_, _ = io.WriteString(conn, `echo: `+line)
+		}),
+		func(cfg *Config) error {
+			cfg.Hook(testMux{`ok`, `/`})
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr, err := net.Listen(`tcp`, `localhost:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- cfg.ServeListener(ctx, lr) }()
+	defer func() {
+		cancel()
+		<-errCh
+	}()
+
+	conn, err := tls.Dial(`tcp`, lr.Addr().String(), &tls.Config{RootCAs: pool, NextProtos: []string{`echo/1`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != `echo/1` {
+		t.Fatalf(`expected echo/1 to be negotiated, got %q`, proto)
+	}
+	_, err = io.WriteString(conn, "hello\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || reply != "echo: hello\n" {
+		t.Fatalf(`expected the handler of the protocol to reply, got %q (%v)`, reply, err)
+	}
+
+	// Clients that only offer HTTP are still served by the rig.
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	defer client.CloseIdleConnections()
+	rsp, err := client.Get(`https://` + lr.Addr().String() + `/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if string(body) != `ok` {
+		t.Fatalf(`expected the rig to serve HTTP alongside the protocol, got %q`, body)
+	}
+}
@@ -1,10 +1,16 @@
 package api
 
 import (
+	"fmt"
 	"io/fs"
 	"net/http"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
 
 	"github.com/swdunlop/rig-go/rig"
+	"github.com/swdunlop/rig-go/rig/hook"
 )
 
 // Handler returns a http.Handler that serves the given rig.
@@ -21,13 +27,41 @@ func Rig(options ...Option) rig.Option {
 	return cfg.rigOption
 }
 
-// FS returns an option that serves the given file system at any of the given patterns.
+// FS returns an option that serves the given file system at any of the given patterns, applying middleware like Handle.
 // Note that this uses Go's built in file server, so it will not serve files with etags or cache headers.
 func FS(filesystem fs.FS, patterns ...string) Option {
 	return func(cfg *config) error {
 		fs := http.FileServer(http.FS(filesystem))
 		for _, pattern := range patterns {
-			cfg.patternHandlers = append(cfg.patternHandlers, patternHandler{pattern, fs})
+			cfg.handle(pattern, fs, `api.FS`)
+		}
+		return nil
+	}
+}
+
+// SPA returns an option that serves a single page application from the given file system at any of the given
+// patterns, like FS, except that requests for files that do not exist are answered with the index file, such as
+// "index.html", so client side routes like "/users/42" survive a refresh.  Requests for missing files with an
+// extension, such as "/app.js" or "/style.css", still fail with 404 Not Found so broken references to assets are not
+// hidden behind the index.
+func SPA(filesystem fs.FS, index string, patterns ...string) Option {
+	return func(cfg *config) error {
+		files := http.FileServer(http.FS(filesystem))
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := strings.TrimPrefix(path.Clean(`/`+r.URL.Path), `/`)
+			if name == `` {
+				name = `.`
+			}
+			_, err := fs.Stat(filesystem, name)
+			switch {
+			case err == nil, path.Ext(name) != ``:
+				files.ServeHTTP(w, r)
+			default:
+				http.ServeFileFS(w, r, filesystem, index)
+			}
+		})
+		for _, pattern := range patterns {
+			cfg.handle(pattern, handler, `api.SPA`)
 		}
 		return nil
 	}
@@ -53,26 +87,83 @@ func HandleFunc(pattern string, fn func(w http.ResponseWriter, r *http.Request))
 
 // Handle accepts a http.ServeMux pattern and a http.Handler.
 func Handle(pattern string, handler http.Handler) Option {
+	description := describe(handler)
 	return func(cfg *config) error {
-		for i := len(cfg.middleware) - 1; i >= 0; i-- {
-			handler = cfg.middleware[i](handler)
-		}
-		cfg.patternHandlers = append(cfg.patternHandlers, patternHandler{
-			pattern: pattern,
-			handler: handler,
-		})
+		cfg.handle(pattern, handler, description)
 		return nil
 	}
 }
 
+// handle applies the middleware of the current group to a handler and adds it for a pattern.
+func (cfg *config) handle(pattern string, handler http.Handler, description string) {
+	for i := len(cfg.middleware) - 1; i >= 0; i-- {
+		handler = cfg.middleware[i](handler)
+	}
+	pattern = cfg.addHandler(pattern, handler, description)
+	if cfg.cors != nil {
+		cfg.addPreflight(pattern)
+	}
+}
+
+// GET returns an option that handles GET requests for a pattern without a method, such as "/users/{id}", like
+// HandleFunc("GET /users/{id}", fn).  Go's ServeMux also routes HEAD requests to GET patterns.
+func GET(pattern string, fn func(w http.ResponseWriter, r *http.Request)) Option {
+	return method(http.MethodGet, pattern, fn)
+}
+
+// HEAD returns an option that handles HEAD requests for a pattern without a method, see GET.
+func HEAD(pattern string, fn func(w http.ResponseWriter, r *http.Request)) Option {
+	return method(http.MethodHead, pattern, fn)
+}
+
+// POST returns an option that handles POST requests for a pattern without a method, see GET.
+func POST(pattern string, fn func(w http.ResponseWriter, r *http.Request)) Option {
+	return method(http.MethodPost, pattern, fn)
+}
+
+// PUT returns an option that handles PUT requests for a pattern without a method, see GET.
+func PUT(pattern string, fn func(w http.ResponseWriter, r *http.Request)) Option {
+	return method(http.MethodPut, pattern, fn)
+}
+
+// PATCH returns an option that handles PATCH requests for a pattern without a method, see GET.
+func PATCH(pattern string, fn func(w http.ResponseWriter, r *http.Request)) Option {
+	return method(http.MethodPatch, pattern, fn)
+}
+
+// DELETE returns an option that handles DELETE requests for a pattern without a method, see GET.
+func DELETE(pattern string, fn func(w http.ResponseWriter, r *http.Request)) Option {
+	return method(http.MethodDelete, pattern, fn)
+}
+
+// OPTIONS returns an option that handles OPTIONS requests for a pattern without a method, see GET.
+func OPTIONS(pattern string, fn func(w http.ResponseWriter, r *http.Request)) Option {
+	return method(http.MethodOptions, pattern, fn)
+}
+
+// method returns an option that handles requests with the given method for a pattern without one.
+func method(method, pattern string, fn func(w http.ResponseWriter, r *http.Request)) Option {
+	if before, _, ok := strings.Cut(pattern, ` `); ok && !strings.Contains(before, `/`) {
+		return func(cfg *config) error {
+			return fmt.Errorf(`api pattern %q for %v already has a method`, pattern, method)
+		}
+	}
+	return HandleFunc(method+` `+pattern, fn)
+}
+
 // Group organizes a group of options into a single option.  This is useful for isolating a set of handlers and middleware so that
 // the middleware does not affect handlers outside of the group.
 func Group(options ...Option) Option {
 	return func(cfg *config) error {
 		old := struct {
 			middleware []func(http.Handler) http.Handler
-		}{cfg.middleware}
-		defer func() { cfg.middleware = old.middleware }()
+			group      string
+			prefix     string
+			cors       *CORSOptions
+		}{cfg.middleware, cfg.group, cfg.prefix, cfg.cors}
+		defer func() {
+			cfg.middleware, cfg.group, cfg.prefix, cfg.cors = old.middleware, old.group, old.prefix, old.cors
+		}()
 		for _, option := range options {
 			err := option(cfg)
 			if err != nil {
@@ -83,24 +174,116 @@ func Group(options ...Option) Option {
 	}
 }
 
+// Name returns an option that names the current group in route listings, see rig.Config.Routes.  Like middleware, the
+// name applies to subsequent handlers and does not extend outside of the group.
+func Name(name string) Option {
+	return func(cfg *config) error {
+		cfg.group = name
+		return nil
+	}
+}
+
+// Prefix returns an option that mounts subsequent handlers under a path prefix, such as "/api/v1", so a handler for
+// "GET /users" serves "GET /api/v1/users".  The prefix is stripped from the path of each request before the handler
+// sees it, like http.StripPrefix.  Like middleware, the prefix does not extend outside of the group, and prefixes in
+// nested groups are joined, so Prefix("/api") followed by Prefix("/v1") mounts handlers under "/api/v1".
+//
+// The prefix must start with "/" and may not contain wildcards, since they cannot be stripped from the path.
+func Prefix(prefix string) Option {
+	return func(cfg *config) error {
+		if !strings.HasPrefix(prefix, `/`) || strings.ContainsAny(prefix, `{}`) {
+			return fmt.Errorf(`invalid api prefix %q`, prefix)
+		}
+		cfg.prefix += strings.TrimRight(prefix, `/`)
+		return nil
+	}
+}
+
+// Fail returns an option that fails with err, so packages that return options, such as mrpc.API, can report a
+// mistake in how they were configured when the rig is configured.
+func Fail(err error) Option {
+	return func(*config) error { return err }
+}
+
 type Option func(*config) error
 
 type config struct {
 	middleware      []func(http.Handler) http.Handler
 	patternHandlers []patternHandler
+	group           string       // name of the current group, see Name
+	prefix          string       // path prefix of the current group, see Prefix
+	cors            *CORSOptions // CORS options of the current group, nil if there are none, see CORS
+	noRecover       bool         // set by RecoverPanics(false)
 	err             error
 }
 
 // RigMux adds the configured handlers to the provided ServeMux, implementing the hook.Mux interface.
 func (cfg *config) RigMux(mux *http.ServeMux) {
 	for _, it := range cfg.patternHandlers {
-		mux.Handle(it.pattern, it.handler)
+		mux.Handle(it.pattern, cfg.wrap(it.handler))
+	}
+}
+
+// wrap applies Recover to a handler unless RecoverPanics(false) was used.
+func (cfg *config) wrap(handler http.Handler) http.Handler {
+	if cfg.noRecover {
+		return handler
+	}
+	return Recover()(handler)
+}
+
+// RigRoutes describes the configured handlers, implementing the hook.Routes interface.
+func (cfg *config) RigRoutes() []hook.Route {
+	routes := make([]hook.Route, 0, len(cfg.patternHandlers))
+	for _, it := range cfg.patternHandlers {
+		routes = append(routes, hook.Route{Pattern: it.pattern, Group: it.group, Handler: it.description})
+	}
+	return routes
+}
+
+// addHandler adds a handler for a pattern, mounting it under the prefix of the current group, and returns the pattern
+// that was added.
+func (cfg *config) addHandler(pattern string, handler http.Handler, description string) string {
+	if cfg.prefix != `` {
+		// Patterns look like "[METHOD ][HOST]/[PATH]", and the prefix goes between the host and the path.
+		method, rest, ok := strings.Cut(pattern, ` `)
+		if !ok {
+			method, rest = ``, pattern
+		} else {
+			method += ` `
+		}
+		rest = strings.TrimLeft(rest, ` `)
+		host, path := rest, `/`
+		if i := strings.Index(rest, `/`); i >= 0 {
+			host, path = rest[:i], rest[i:]
+		}
+		pattern = method + host + cfg.prefix + path
+		handler = http.StripPrefix(cfg.prefix, handler)
 	}
+	cfg.patternHandlers = append(cfg.patternHandlers, patternHandler{
+		pattern:     pattern,
+		handler:     handler,
+		group:       cfg.group,
+		description: description,
+	})
+	return pattern
 }
 
 type patternHandler struct {
-	pattern string
-	handler http.Handler
+	pattern     string
+	handler     http.Handler
+	group       string
+	description string
+}
+
+// describe returns a description of a handler for route listings, using the function name for handler functions.
+func describe(handler http.Handler) string {
+	if fn, ok := handler.(http.HandlerFunc); ok {
+		if info := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); info != nil {
+			return info.Name()
+		}
+	}
+	return fmt.Sprintf(`%T`, handler)
 }
 
 func (cfg *config) apply(options ...Option) {
@@ -113,6 +296,9 @@ func (cfg *config) apply(options ...Option) {
 }
 
 func (cfg *config) rigOption(r *rig.Config) error {
+	if cfg.err != nil {
+		return cfg.err
+	}
 	r.Hook(cfg)
 	return nil
 }
@@ -120,7 +306,7 @@ func (cfg *config) rigOption(r *rig.Config) error {
 func (cfg *config) handler() http.Handler {
 	mux := http.NewServeMux()
 	for _, it := range cfg.patternHandlers {
-		mux.Handle(it.pattern, it.handler)
+		mux.Handle(it.pattern, cfg.wrap(it.handler))
 	}
 	return mux
 }
@@ -0,0 +1,343 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/rs/zerolog"
+	"github.com/swdunlop/html-go/hog"
+	"nhooyr.io/websocket"
+)
+
+func TestPrefix(t *testing.T) {
+	h := Handler(
+		Group(
+			Prefix(`/api`),
+			Group(
+				Prefix(`/v1/`),
+				HandleFunc(`GET /users/{id}`, func(w http.ResponseWriter, r *http.Request) {
+					_, _ = io.WriteString(w, r.URL.Path+` `+r.PathValue(`id`))
+				}),
+			),
+		),
+		HandleFunc(`GET /users/{id}`, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, `unprefixed`)
+		}),
+	)
+	for path, expect := range map[string]string{
+		`/api/v1/users/42`: `/users/42 42`,
+		`/users/42`:        `unprefixed`,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(`GET`, path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != expect {
+			t.Errorf(`%v: expected %q, got %v %q`, path, expect, w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(`GET`, `/api/users/42`, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf(`expected /api/users/42 to be missing, got %v`, w.Code)
+	}
+}
+
+func TestInvalidPrefix(t *testing.T) {
+	var cfg config
+	cfg.apply(Prefix(`/{tenant}`))
+	if cfg.err == nil {
+		t.Error(`expected an error for a prefix with a wildcard`)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := Handler(
+		Group(
+			CORS(CORSOptions{Origins: []string{`https://example.com`}, Methods: []string{`GET`, `POST`}}),
+			HandleFunc(`GET /users`, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, `users`)
+			}),
+			HandleFunc(`POST /users`, func(w http.ResponseWriter, r *http.Request) {}),
+		),
+		HandleFunc(`GET /private`, func(w http.ResponseWriter, r *http.Request) {}),
+	)
+	serve := func(method, path, origin string, headers ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set(`Origin`, origin)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(`OPTIONS`, `/users`, `https://example.com`, `Access-Control-Request-Method`, `POST`)
+	if w.Code != http.StatusNoContent || w.Header().Get(`Access-Control-Allow-Methods`) != `GET, POST` {
+		t.Errorf(`expected a preflight response, got %v %v`, w.Code, w.Header())
+	}
+	w = serve(`GET`, `/users`, `https://example.com`)
+	if w.Body.String() != `users` || w.Header().Get(`Access-Control-Allow-Origin`) != `https://example.com` {
+		t.Errorf(`expected an allowed response, got %v %q`, w.Header(), w.Body.String())
+	}
+	w = serve(`GET`, `/users`, `https://evil.example`)
+	if w.Header().Get(`Access-Control-Allow-Origin`) != `` {
+		t.Errorf(`expected an origin that is not allowed to be ignored, got %v`, w.Header())
+	}
+	w = serve(`GET`, `/private`, `https://example.com`)
+	if w.Header().Get(`Access-Control-Allow-Origin`) != `` {
+		t.Errorf(`expected CORS to stay inside its group, got %v`, w.Header())
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	done := make(chan struct{})
+	srv := httptest.NewServer(Handler(
+		Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(log.WithContext(r.Context())))
+				if r.URL.Path == `/ws` {
+					close(done)
+				}
+			})
+		}),
+		Use(Logger()),
+		Use(Logger()), // stacked loggers share the same writer.
+		HandleFunc(`GET /missing`, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `missing`, http.StatusNotFound)
+		}),
+		HandleFunc(`GET /ws`, func(w http.ResponseWriter, r *http.Request) {
+			c, err := websocket.Accept(w, r, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			c.Close(websocket.StatusNormalClosure, ``)
+		}),
+	))
+	defer srv.Close()
+	rsp, err := http.Get(srv.URL + `/missing`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	ctx := context.Background()
+	c, _, err := websocket.Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`)+`/ws`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _ = c.Read(ctx) // waits for the server to close the connection.
+	c.CloseNow()
+	<-done
+
+	type line struct {
+		Level  string `json:"level"`
+		Path   string `json:"path"`
+		Status int    `json:"status"`
+		Wrote  int    `json:"wrote"`
+	}
+	var lines []line
+	for _, js := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var it line
+		err := json.Unmarshal(js, &it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, it)
+	}
+	expect := []line{
+		{Level: `warn`, Path: `/missing`, Status: 404, Wrote: 8},
+		{Level: `warn`, Path: `/missing`, Status: 404, Wrote: 8},
+		{Level: `info`, Path: `/ws`, Status: 101},
+		{Level: `info`, Path: `/ws`, Status: 101},
+	}
+	if len(lines) != len(expect) {
+		t.Fatalf(`expected %v, got %v`, expect, lines)
+	}
+	for i := range expect {
+		if lines[i] != expect[i] {
+			t.Errorf(`expected %v, got %v`, expect[i], lines[i])
+		}
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	h := Handler(
+		Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(log.WithContext(r.Context())))
+			})
+		}),
+		Use(WithFields(func(r *http.Request) map[string]any { return map[string]any{`tenant`: `acme`} })),
+		Use(WithFields(func(r *http.Request) map[string]any { return map[string]any{`user`: r.PathValue(`user`)} })),
+		HandleFunc(`GET /users/{user}`, func(w http.ResponseWriter, r *http.Request) {
+			hog.From(r.Context()).Info().Msg(`hello`)
+		}),
+	)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(`GET`, `/users/alice`, nil))
+	var line map[string]any
+	err := json.Unmarshal(buf.Bytes(), &line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line[`tenant`] != `acme` || line[`user`] != `alice` {
+		t.Fatalf(`expected both fields, got %v`, line)
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	h := Handler(
+		Use(RealIP(netip.MustParsePrefix(`10.0.0.0/8`))),
+		HandleFunc(`GET /`, func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, r.RemoteAddr) }),
+	)
+	for _, test := range []struct {
+		peer, header, value, expect string
+	}{
+		{`@`, `X-Forwarded-For`, `192.0.2.1`, `192.0.2.1:0`},                                      // the supervisor
+		{`10.0.0.2:80`, `X-Forwarded-For`, `192.0.2.1, 198.51.100.7, 10.0.0.3`, `198.51.100.7:0`}, // a forged first hop
+		{`10.0.0.2:80`, `Forwarded`, `for="[2001:db8::1]:4711";proto=https`, `[2001:db8::1]:0`},
+		{`192.0.2.9:80`, `X-Forwarded-For`, `198.51.100.7`, `192.0.2.9:80`}, // an untrusted peer
+		{`10.0.0.2:80`, `X-Forwarded-For`, `unknown`, `10.0.0.2:80`},
+	} {
+		r := httptest.NewRequest(`GET`, `/`, nil)
+		r.RemoteAddr = test.peer
+		r.Header.Set(test.header, test.value)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Body.String() != test.expect {
+			t.Errorf(`expected %v for %v: %v from %v, got %v`, test.expect, test.header, test.value, test.peer, w.Body)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	srv := httptest.NewServer(Handler(
+		HandleFunc(`GET /panic`, func(w http.ResponseWriter, r *http.Request) { panic(`oops`) }),
+		HandleFunc(`GET /ok`, func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, `ok`) }),
+	))
+	defer srv.Close()
+	for path, expect := range map[string]int{`/panic`: 500, `/ok`: 200} {
+		rsp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+		if rsp.StatusCode != expect {
+			t.Errorf(`%v: expected %v, got %v`, path, expect, rsp.StatusCode)
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	h := Handler(
+		GET(`/items/{id}`, func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, `get `+r.PathValue(`id`)) }),
+		DELETE(`/items/{id}`, func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, `delete`) }),
+	)
+	for _, test := range []struct{ method, expect string }{{`GET`, `get 1`}, {`DELETE`, `delete`}, {`PUT`, ``}} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, `/items/1`, nil))
+		if w.Body.String() != test.expect && !(test.expect == `` && w.Code == http.StatusMethodNotAllowed) {
+			t.Errorf(`%v: expected %q, got %v %q`, test.method, test.expect, w.Code, w.Body.String())
+		}
+	}
+	var cfg config
+	cfg.apply(POST(`GET /items`, func(w http.ResponseWriter, r *http.Request) {}))
+	if cfg.err == nil {
+		t.Error(`expected an error for a pattern that already has a method`)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	text := strings.Repeat(`hello, world `, 200)
+	h := Handler(
+		Use(Compress(5)),
+		HandleFunc(`GET /text`, func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, text) }),
+		HandleFunc(`GET /small`, func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, `hello`) }),
+		HandleFunc(`GET /png`, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(`Content-Type`, `image/png`)
+			_, _ = io.WriteString(w, text)
+		}),
+	)
+	serve := func(path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(`GET`, path, nil)
+		r.Header.Set(`Accept-Encoding`, accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(`/text`, `deflate;q=0.5, gzip`)
+	if w.Header().Get(`Content-Encoding`) != `gzip` {
+		t.Fatalf(`expected a gzip response, got %v`, w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil || string(body) != text {
+		t.Fatalf(`expected the text to survive compression, got %q (%v)`, body, err)
+	}
+	if w := serve(`/text`, `deflate`); w.Header().Get(`Content-Encoding`) != `deflate` {
+		t.Errorf(`expected a deflate response, got %v`, w.Header())
+	}
+	for path, accept := range map[string]string{`/text`: `gzip;q=0`, `/small`: `gzip`, `/png`: `gzip`} {
+		w := serve(path, accept)
+		if w.Header().Get(`Content-Encoding`) != `` || w.Body.Len() == 0 {
+			t.Errorf(`%v with %q: expected an uncompressed response, got %v`, path, accept, w.Header())
+		}
+	}
+}
+
+func TestSPA(t *testing.T) {
+	h := Handler(SPA(fstest.MapFS{
+		`index.html`: {Data: []byte(`index`)},
+		`app.js`:     {Data: []byte(`app`)},
+	}, `index.html`, `GET /`))
+	for path, expect := range map[string]string{
+		`/app.js`:     `app`,
+		`/users/42`:   `index`,
+		`/`:           `index`,
+		`/styles.css`: ``,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(`GET`, path, nil))
+		switch {
+		case expect == `` && w.Code != http.StatusNotFound:
+			t.Errorf(`%v: expected 404, got %v`, path, w.Code)
+		case expect != `` && (w.Code != http.StatusOK || w.Body.String() != expect):
+			t.Errorf(`%v: expected %q, got %v %q`, path, expect, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSinglePage(t *testing.T) {
+	for _, test := range []struct{ html, expect string }{
+		{`<html><head lang="en"><script src="app.js"></script></head></html>`,
+			`<html><head lang="en"><script>window.__CONFIG__ = {"api":"\u003c/script\u003e"};</script>` +
+				`<script src="app.js"></script></head></html>`},
+		{`<header>no head</header>`,
+			`<script>window.__CONFIG__ = {"api":"\u003c/script\u003e"};</script><header>no head</header>`},
+	} {
+		h := SinglePage([]byte(test.html), func(r *http.Request) any {
+			return map[string]string{`api`: `</script>`}
+		})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(`GET`, `/`, nil))
+		if w.Body.String() != test.expect || w.Header().Get(`Cache-Control`) != `no-cache` {
+			t.Errorf(`expected %q, got %q with %v`, test.expect, w.Body.String(), w.Header())
+		}
+	}
+}
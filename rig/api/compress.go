@@ -0,0 +1,234 @@
+package api
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Compress returns middleware for Use that compresses responses with gzip or deflate, as negotiated with the
+// Accept-Encoding header of the request, at the given compression level from 1 (fastest) to 9 (smallest); other levels
+// use the default of the compress/flate package.
+//
+// Responses are left alone if they are smaller than CompressMinSize, if they already have a Content-Encoding, such as
+// precompressed esbuild outputs, if they are partial content, or if their Content-Type is already compressed, such as
+// most images, audio, video and archives.  Requests that upgrade the connection, such as WebSocket requests for mrpc
+// and jrpc, pass through untouched.
+func Compress(level int) func(http.Handler) http.Handler {
+	if level < flate.BestSpeed || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+	var gzips, deflates sync.Pool
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(`Upgrade`) != `` {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add(`Vary`, `Accept-Encoding`)
+			cw := &compressWriter{ResponseWriter: w}
+			switch negotiateEncoding(r.Header.Get(`Accept-Encoding`)) {
+			case `gzip`:
+				cw.encoding, cw.pool = `gzip`, &gzips
+				cw.open = func(w io.Writer) compressor {
+					gz, _ := gzip.NewWriterLevel(w, level) // the level has been checked.
+					return gz
+				}
+			case `deflate`:
+				cw.encoding, cw.pool = `deflate`, &deflates
+				cw.open = func(w io.Writer) compressor {
+					fw, _ := flate.NewWriter(w, level)
+					return fw
+				}
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// CompressMinSize is the smallest response that Compress compresses, since compressing a small response saves little
+// and may even make it larger.
+var CompressMinSize = 1024
+
+// negotiateEncoding returns the encoding preferred by an Accept-Encoding header, preferring gzip when the client has
+// no preference, or "" if neither gzip nor deflate is acceptable.
+func negotiateEncoding(header string) string {
+	best, bestQ := ``, 0.0
+	for _, item := range strings.Split(header, `,`) {
+		name, params, _ := strings.Cut(item, `;`)
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), `q=`); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		switch {
+		case name != `gzip` && name != `deflate`, q <= 0:
+		case q > bestQ, q == bestQ && name == `gzip`:
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// A compressor is a gzip.Writer or a flate.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// A compressWriter buffers the start of a response until it can decide whether to compress it, see Compress.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	pool     *sync.Pool                 // reuses compressors for the encoding
+	open     func(io.Writer) compressor // creates a compressor when the pool is empty
+
+	status  int        // the status given to WriteHeader, zero until then
+	buf     []byte     // the start of the body, until decided
+	decided bool       // true once the header has been written
+	zw      compressor // nil unless compressing
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < CompressMinSize {
+			return len(p), nil
+		}
+		err := cw.decide()
+		return len(p), err
+	}
+	if cw.zw != nil {
+		return cw.zw.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide writes the header, compressing the response if it is worth it, then writes the buffered start of the body.
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	h := cw.Header()
+	if h.Get(`Content-Type`) == `` && len(cw.buf) > 0 {
+		h.Set(`Content-Type`, http.DetectContentType(cw.buf)) // as the ResponseWriter would.
+	}
+	if len(cw.buf) >= CompressMinSize && status != http.StatusPartialContent && h.Get(`Content-Encoding`) == `` &&
+		h.Get(`Content-Range`) == `` && compressible(h.Get(`Content-Type`)) {
+		h.Set(`Content-Encoding`, cw.encoding)
+		h.Del(`Content-Length`)
+		if etag := h.Get(`ETag`); strings.HasPrefix(etag, `"`) {
+			h.Set(`ETag`, `W/`+etag) // the compressed body is not byte for byte the same.
+		}
+		if zw, ok := cw.pool.Get().(compressor); ok {
+			zw.Reset(cw.ResponseWriter)
+			cw.zw = zw
+		} else {
+			cw.zw = cw.open(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.zw != nil {
+		_, err = cw.zw.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close finishes the response once the handler has returned.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return // nothing was written, the server will respond as usual.
+		}
+		_ = cw.decide()
+	}
+	if cw.zw != nil {
+		_ = cw.zw.Close()
+		cw.zw.Reset(io.Discard)
+		cw.pool.Put(cw.zw)
+		cw.zw = nil
+	}
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		_ = cw.decide()
+	}
+	if cw.zw != nil {
+		_ = cw.zw.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok || cw.decided {
+		return nil, nil, fmt.Errorf(`%T does not support hijacking`, cw.ResponseWriter)
+	}
+	cw.decided = true // the handler writes its own response to the connection.
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+// compressible returns false for content types that are already compressed.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == `image/svg+xml`, mediaType == `image/bmp`:
+		return true
+	case strings.HasPrefix(mediaType, `image/`), strings.HasPrefix(mediaType, `video/`),
+		strings.HasPrefix(mediaType, `audio/`), strings.HasPrefix(mediaType, `font/woff`):
+		return false
+	}
+	switch mediaType {
+	case `application/zip`, `application/gzip`, `application/x-gzip`, `application/zstd`, `application/x-bzip2`,
+		`application/x-xz`, `application/x-7z-compressed`, `application/x-rar-compressed`, `application/pdf`,
+		`application/octet-stream`:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS returns an option that applies Cross-Origin Resource Sharing middleware to subsequent handlers, so they can be
+// used from pages served by other origins.  Like Use, it stacks with other middleware and does not extend outside of
+// the group.
+//
+// Preflight requests, which are OPTIONS requests with an Access-Control-Request-Method header, are answered with 204
+// No Content without calling the handler.  Since http.ServeMux only routes OPTIONS requests to patterns without a
+// method, CORS also adds an "OPTIONS" pattern for the path of each subsequent handler with a method, such as
+// "OPTIONS /users" for "GET /users", so those handlers should not be paired with OPTIONS handlers of their own.
+func CORS(opts CORSOptions) Option {
+	return func(cfg *config) error {
+		cfg.cors = &opts
+		cfg.middleware = append(cfg.middleware, opts.middleware)
+		return nil
+	}
+}
+
+// CORSOptions configures the CORS middleware.
+type CORSOptions struct {
+	Origins     []string      // Allowed origins, such as "https://example.com"; if empty or "*", any origin is allowed.
+	Methods     []string      // Allowed methods for preflight requests; if empty, GET, HEAD and POST are allowed.
+	Headers     []string      // Allowed request headers; if empty, any headers requested by a preflight are allowed.
+	Expose      []string      // Response headers that scripts may read, in addition to the CORS safelisted headers.
+	Credentials bool          // If true, allows requests with cookies and authorization headers.
+	MaxAge      time.Duration // How long browsers may cache the result of a preflight; if zero, browsers decide.
+}
+
+// middleware adds CORS headers to responses for allowed origins and answers preflight requests.
+func (opts *CORSOptions) middleware(next http.Handler) http.Handler {
+	methods := strings.Join(opts.Methods, `, `)
+	if methods == `` {
+		methods = `GET, HEAD, POST`
+	}
+	headers := strings.Join(opts.Headers, `, `)
+	expose := strings.Join(opts.Expose, `, `)
+	anyOrigin := len(opts.Origins) == 0 || slices.Contains(opts.Origins, `*`)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		preflight := r.Method == http.MethodOptions && r.Header.Get(`Access-Control-Request-Method`) != ``
+		origin := r.Header.Get(`Origin`)
+		if !anyOrigin || opts.Credentials {
+			h.Add(`Vary`, `Origin`)
+		}
+		if preflight {
+			h.Add(`Vary`, `Access-Control-Request-Method`)
+			h.Add(`Vary`, `Access-Control-Request-Headers`)
+		}
+		if origin != `` && (anyOrigin || slices.Contains(opts.Origins, origin)) {
+			if anyOrigin && !opts.Credentials {
+				h.Set(`Access-Control-Allow-Origin`, `*`)
+			} else {
+				h.Set(`Access-Control-Allow-Origin`, origin) // browsers reject "*" for requests with credentials.
+			}
+			if opts.Credentials {
+				h.Set(`Access-Control-Allow-Credentials`, `true`)
+			}
+			if preflight {
+				h.Set(`Access-Control-Allow-Methods`, methods)
+				if headers != `` {
+					h.Set(`Access-Control-Allow-Headers`, headers)
+				} else if requested := r.Header.Get(`Access-Control-Request-Headers`); requested != `` {
+					h.Set(`Access-Control-Allow-Headers`, requested)
+				}
+				if opts.MaxAge > 0 {
+					h.Set(`Access-Control-Max-Age`, strconv.Itoa(int(opts.MaxAge/time.Second)))
+				}
+			} else if expose != `` {
+				h.Set(`Access-Control-Expose-Headers`, expose)
+			}
+		}
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// addPreflight adds an OPTIONS pattern for the path of a pattern with a method, so preflight requests for it reach the
+// CORS middleware of the current group.
+func (cfg *config) addPreflight(pattern string) {
+	method, rest, ok := strings.Cut(pattern, ` `)
+	if !ok || method == http.MethodOptions {
+		return
+	}
+	pattern = http.MethodOptions + ` ` + strings.TrimLeft(rest, ` `)
+	for _, it := range cfg.patternHandlers {
+		if it.pattern == pattern {
+			return // already added for another method.
+		}
+	}
+	cfg.patternHandlers = append(cfg.patternHandlers, patternHandler{
+		pattern:     pattern,
+		handler:     cfg.cors.middleware(http.HandlerFunc(notAllowed)),
+		group:       cfg.group,
+		description: `api.CORS`,
+	})
+}
+
+// notAllowed answers OPTIONS requests that are not preflight requests for patterns added by addPreflight.
+func notAllowed(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
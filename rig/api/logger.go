@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/swdunlop/html-go/hog"
+)
+
+// Logger returns middleware for Use that logs each request with its method, path, status, the number of bytes written
+// and how many milliseconds it took, using the hog logger of the request.  Responses with a 5xx status are logged as
+// errors and 4xx as warnings.  Requests that hijack the connection, such as WebSocket upgrades from mrpc and jrpc, are
+// logged with status 101 once the handler returns.
+func Logger() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started := time.Now()
+			rw := record(w)
+			next.ServeHTTP(rw, r)
+			status := rw.Status()
+			var evt *zerolog.Event
+			log := hog.From(r.Context())
+			switch {
+			case status >= 500:
+				evt = log.Error()
+			case status >= 400:
+				evt = log.Warn()
+			default:
+				evt = log.Info()
+			}
+			evt.Str(`method`, r.Method).
+				Str(`path`, r.URL.Path).
+				Int(`status`, status).
+				Int64(`wrote`, rw.wrote).
+				Int64(`took`, time.Since(started).Milliseconds()).
+				Msg(``)
+		})
+	}
+}
+
+// record returns a responseWriter that records the response written to w, reusing w if it already is one so stacked
+// middleware does not wrap it more than once.
+func record(w http.ResponseWriter) *responseWriter {
+	if rw, ok := w.(*responseWriter); ok {
+		return rw
+	}
+	return &responseWriter{ResponseWriter: w}
+}
+
+// A responseWriter records the status and size of a response for middleware like Logger.  It supports flushing and
+// hijacking if the underlying writer does, and http.ResponseController can reach the underlying writer using Unwrap.
+type responseWriter struct {
+	http.ResponseWriter
+	status int   // zero until the header has been written
+	wrote  int64 // bytes written to the body
+}
+
+// Status returns the status of the response, which is 200 if the handler wrote nothing.
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 && status >= 200 {
+		rw.status = status // informational responses like 103 Early Hints are followed by the real status.
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(p)
+	rw.wrote += int64(n)
+	return n, err
+}
+
+func (rw *responseWriter) Flush() {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf(`%T does not support hijacking`, rw.ResponseWriter)
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err == nil && rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols // the handler writes its own response to the connection.
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
+
+// WithFields returns middleware for Use that adds the fields returned by fn to the hog logger of each request, so every
+// line logged while handling the request carries them, including the line logged by Logger if it is used after
+// WithFields.  Fields added by stacked WithFields middleware accumulate, and fn may return nil to add no fields.
+func WithFields(fn func(r *http.Request) map[string]any) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fields := fn(r)
+			if len(fields) > 0 {
+				ctx := hog.With(r.Context(), func(z zerolog.Context) zerolog.Context { return z.Fields(fields) })
+				r = r.WithContext(ctx)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
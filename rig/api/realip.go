@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RealIP returns middleware for Use that replaces the RemoteAddr of each request relayed by a trusted proxy with the
+// address of the client reported by the X-Forwarded-For header, or the Forwarded header if there is none, which makes
+// logs and rate limiters see clients instead of the proxy.  The port of the new RemoteAddr is 0, since proxies do not
+// report it.
+//
+// A request is relayed by a trusted proxy if it arrived over a unix socket, as requests proxied to the worker by the
+// supervisor of a rig do, or from an address in one of the trusted prefixes.  The client is the last address in the
+// header that is not itself a trusted proxy, so clients cannot pose as another address by sending the header
+// themselves; requests from any other address, or with a header that cannot be parsed, are left as they are.  When
+// the rig runs behind a load balancer, trust it here and in rig.TrustForwarded so the supervisor keeps its headers.
+func RealIP(trusted ...netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if client, ok := forwardedClient(r, trusted); ok {
+				r = r.WithContext(r.Context()) // a shallow copy, so the caller's request is left as it was.
+				r.RemoteAddr = netip.AddrPortFrom(client, 0).String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClient returns the address of the client that a trusted proxy relayed the request for, see RealIP.
+func forwardedClient(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err == nil && !trustedAddr(trusted, peer.Addr()) {
+		return netip.Addr{}, false // an untrusted peer; unix sockets have no address, so they are trusted.
+	}
+	var hops []string
+	if values := r.Header.Values(`X-Forwarded-For`); len(values) > 0 {
+		for _, value := range values {
+			hops = append(hops, strings.Split(value, `,`)...)
+		}
+	} else {
+		hops = forwardedFor(r.Header.Values(`Forwarded`))
+	}
+	var client netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			return netip.Addr{}, false
+		}
+		client = addr
+		if !trustedAddr(trusted, addr) {
+			break
+		}
+	}
+	return client, client.IsValid()
+}
+
+// forwardedFor returns the "for" parameters of the elements of Forwarded headers, as described by RFC 7239.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, `,`) {
+			for _, pair := range strings.Split(element, `;`) {
+				name, value, _ := strings.Cut(strings.TrimSpace(pair), `=`)
+				if strings.EqualFold(name, `for`) {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address from a forwarding header, which may have a port, and brackets if it is IPv6.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, `[`), `]`))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// trustedAddr returns true if the address is in one of the trusted prefixes.
+func trustedAddr(trusted []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
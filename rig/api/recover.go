@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/swdunlop/html-go/hog"
+)
+
+// Recover returns middleware for Use that recovers from panics in handlers, logging the panic with its stack using the
+// hog logger of the request and responding with 500 Internal Server Error, instead of letting the server drop the
+// connection.  Panics with http.ErrAbortHandler are passed on, since they are used to abort a response deliberately.
+//
+// Handlers configured by Rig and Handler are already wrapped with Recover, as the outermost middleware, unless
+// RecoverPanics(false) is used.
+func Recover() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				e := recover()
+				if e == nil {
+					return
+				}
+				if e == http.ErrAbortHandler {
+					panic(e)
+				}
+				err, ok := e.(error)
+				if !ok {
+					err = fmt.Errorf(`%v`, e)
+				}
+				err = errors.WithStack(err) // logged using the zerolog.ErrorStackMarshaler set by the rig package.
+				hog.From(r.Context()).Error().Stack().Err(err).Str(`method`, r.Method).Str(`path`, r.URL.Path).
+					Msg(`handler panicked`)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RecoverPanics returns an option that controls whether Rig and Handler wrap every handler with Recover, which is the
+// default.  Unlike middleware, this applies to every handler, regardless of groups and the order of options.
+func RecoverPanics(ok bool) Option {
+	return func(cfg *config) error {
+		cfg.noRecover = !ok
+		return nil
+	}
+}
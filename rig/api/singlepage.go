@@ -0,0 +1,63 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/swdunlop/html-go/hog"
+)
+
+// SinglePage returns a handler that serves a single HTML document, such as an embedded "index.html" for a small admin
+// tool, with the result of calling config for the request injected as window.__CONFIG__, such as the base URL of an
+// API or the version of the build.  This is a lightweight alternative to www and esbuild for pages that need no build.
+//
+// The config is encoded as JSON in a script element placed right after the opening head tag, or at the start of the
+// document if it has none, so it is set before any other script runs.  The JSON is escaped like json.Marshal does by
+// default, so strings containing "</script>" or "<!--" cannot end the script element early.  Since the config may
+// change with every request, responses have "Cache-Control: no-cache".
+func SinglePage(html []byte, config func(r *http.Request) any) http.Handler {
+	at := headEnd(html)
+	before, after := html[:at], html[at:]
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var value any
+		if config != nil {
+			value = config(r)
+		}
+		js, err := json.Marshal(value)
+		if err != nil {
+			hog.For(r).Error().Err(err).Msg(`cannot encode the config of a single page`)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		buf.Grow(len(html) + len(js) + 64)
+		buf.Write(before)
+		buf.WriteString(`<script>window.__CONFIG__ = `)
+		buf.Write(js)
+		buf.WriteString(`;</script>`)
+		buf.Write(after)
+		h := w.Header()
+		h.Set(`Content-Type`, `text/html; charset=utf-8`)
+		h.Set(`Cache-Control`, `no-cache`)
+		_, _ = w.Write(buf.Bytes())
+	})
+}
+
+// headEnd returns the offset just after the opening head tag of an HTML document, or zero if it has none.
+func headEnd(html []byte) int {
+	for i := 0; i+len(`<head>`) <= len(html); i++ {
+		if html[i] != '<' || !bytes.EqualFold(html[i+1:i+5], []byte(`head`)) {
+			continue
+		}
+		switch html[i+5] {
+		case '>', ' ', '\t', '\n', '\r', '\f': // and not <header>.
+			end := bytes.IndexByte(html[i+5:], '>')
+			if end < 0 {
+				return 0
+			}
+			return i + 5 + end + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/swdunlop/html-go/hog"
+)
+
+// SSE returns an option that serves server sent events at the given pattern, calling fn to send events until fn
+// returns or the client disconnects.
+//
+// Browsers using EventSource reconnect automatically when the connection drops, such as when a rig worker restarts
+// behind the supervisor.  To make this resilient, the stream starts with a "retry:" directive telling the browser to
+// reconnect after SSERetry, and the browser sends the ID of the last event it received in the Last-Event-ID header
+// when it reconnects.  Handlers that assign IDs to their events should use EventStream.LastEventID to resume after
+// that event instead of starting over.  Handlers that do not assign IDs will simply start over.
+//
+// The supervisor's reverse proxy flushes event streams as they are written, so events flow through it without delay.
+func SSE(pattern string, fn func(es *EventStream) error) Option {
+	return HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		es := &EventStream{
+			r:      r,
+			w:      w,
+			rc:     http.NewResponseController(w),
+			lastID: r.Header.Get(`Last-Event-ID`),
+		}
+		w.Header().Set(`Content-Type`, `text/event-stream`)
+		w.Header().Set(`Cache-Control`, `no-cache`)
+		w.WriteHeader(http.StatusOK)
+		err := es.Retry(SSERetry)
+		if err == nil {
+			err = fn(es)
+		}
+		if err != nil && r.Context().Err() == nil {
+			hog.For(r).Warn().Err(err).Msg(`SSE error`)
+		}
+	})
+}
+
+// SSERetry is the reconnection delay sent to clients at the start of each event stream.
+var SSERetry = time.Second
+
+// An EventStream sends server sent events to a client.
+type EventStream struct {
+	r      *http.Request
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	lastID string
+}
+
+// An Event is a server sent event.
+type Event struct {
+	ID    string // If not empty, the client will report this ID in Last-Event-ID when it reconnects.
+	Event string // The type of the event, which defaults to "message" in the browser.
+	Data  string // The data of the event, which may contain newlines.
+}
+
+// Context returns the context of the request, which is cancelled when the client disconnects.
+func (es *EventStream) Context() context.Context { return es.r.Context() }
+
+// Request returns the HTTP request that opened the stream.
+func (es *EventStream) Request() *http.Request { return es.r }
+
+// LastEventID returns the ID of the last event sent on this stream or, before any event with an ID has been sent, the
+// ID the client reported in Last-Event-ID when it reconnected.  This is empty for a new client.
+func (es *EventStream) LastEventID() string { return es.lastID }
+
+// Send sends an event to the client and flushes it.
+func (es *EventStream) Send(event Event) error {
+	var buf bytes.Buffer
+	if event.ID != `` {
+		if strings.ContainsAny(event.ID, "\r\n\x00") {
+			return fmt.Errorf(`invalid event ID %q`, event.ID)
+		}
+		fmt.Fprintf(&buf, "id: %s\n", event.ID)
+	}
+	if event.Event != `` {
+		if strings.ContainsAny(event.Event, "\r\n") {
+			return fmt.Errorf(`invalid event type %q`, event.Event)
+		}
+		fmt.Fprintf(&buf, "event: %s\n", event.Event)
+	}
+	for _, line := range strings.Split(event.Data, "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", strings.TrimSuffix(line, "\r"))
+	}
+	buf.WriteByte('\n')
+	err := es.write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if event.ID != `` {
+		es.lastID = event.ID
+	}
+	return nil
+}
+
+// Retry tells the client how long to wait before reconnecting if the connection drops.
+func (es *EventStream) Retry(delay time.Duration) error {
+	return es.write([]byte(fmt.Sprintf("retry: %d\n\n", delay.Milliseconds())))
+}
+
+func (es *EventStream) write(msg []byte) error {
+	_, err := es.w.Write(msg)
+	if err != nil {
+		return err
+	}
+	return es.rc.Flush()
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSE(t *testing.T) {
+	stopped := make(chan error, 1)
+	srv := httptest.NewServer(Handler(SSE(`GET /events`, func(es *EventStream) error {
+		if es.LastEventID() != `6` {
+			t.Errorf(`expected the ID reported by the client, got %q`, es.LastEventID())
+		}
+		err := es.Send(Event{ID: `7`, Event: `greeting`, Data: "hello\r\nworld"})
+		if err != nil {
+			return err
+		}
+		if es.Send(Event{ID: "bad\nid"}) == nil {
+			t.Error(`expected an ID with a newline to be rejected`)
+		}
+		<-es.Context().Done() // the event must have been flushed for the client to see it while this blocks.
+		stopped <- es.Context().Err()
+		return nil
+	})))
+	defer srv.Close()
+	ctx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+	req, err := http.NewRequestWithContext(ctx, `GET`, srv.URL+`/events`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(`Last-Event-ID`, `6`)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	if rsp.Header.Get(`Content-Type`) != `text/event-stream` {
+		t.Fatalf(`unexpected content type %q`, rsp.Header.Get(`Content-Type`))
+	}
+
+	// Events end with a blank line; read the retry directive and the first event.
+	rd := bufio.NewReader(rsp.Body)
+	var lines []string
+	for blanks := 0; blanks < 2; {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatalf(`%v after %q`, err, lines)
+		}
+		if line == "\n" {
+			blanks++
+		}
+		lines = append(lines, line)
+	}
+	expect := "retry: 1000\n\nid: 7\nevent: greeting\ndata: hello\ndata: world\n\n"
+	if got := strings.Join(lines, ``); got != expect {
+		t.Fatalf(`expected %q, got %q`, expect, got)
+	}
+
+	disconnect()
+	select {
+	case err := <-stopped:
+		if err == nil {
+			t.Fatal(`expected the context of the stream to be cancelled`)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`the stream did not stop when the client disconnected`)
+	}
+}
@@ -0,0 +1,157 @@
+// Package autocert provides a rig option that serves HTTPS for public hostnames with certificates obtained
+// automatically from Let's Encrypt, using golang.org/x/crypto/acme/autocert.
+package autocert
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig"
+	"github.com/swdunlop/rig-go/rig/hook"
+	"golang.org/x/crypto/acme"
+	xautocert "golang.org/x/crypto/acme/autocert"
+)
+
+// Rig returns a rig option that serves HTTPS on HTTPSAddress for the given domains, accepting the terms of service of
+// Let's Encrypt, and answers its HTTP-01 challenges on HTTPAddress.  Certificates are requested when clients first ask
+// for one of the domains, cached in CacheDir so they survive restarts, and renewed before they expire.  Requests for
+// other domains fail the TLS handshake.
+//
+// Plain HTTP requests to HTTPAddress that are not challenges are redirected to HTTPS on port 443.  Challenges and
+// redirects are served by a server of their own rather than the rig, so, like a tailscale listener, the listener on
+// HTTPAddress cannot be passed on by rig.Handover; use rig.ACME, which only needs port 443, for a rig that is handed
+// over.  Do not combine Rig with rig.TLS or rig.ACME, which give the server certificates of their own, although
+// rig.TLSMinVersion and rig.TLSCipherSuites apply.
+func Rig(domains ...string) rig.Option {
+	return func(r *rig.Config) error {
+		if len(domains) == 0 {
+			return fmt.Errorf(`autocert needs at least one domain`)
+		}
+		dir := CacheDir
+		if dir == `` {
+			cache, err := os.UserCacheDir()
+			if err != nil {
+				return fmt.Errorf(`%w while choosing a directory for the certificate cache`, err)
+			}
+			dir = filepath.Join(cache, `rig`, `autocert`)
+		}
+		manager := &xautocert.Manager{
+			Prompt:     xautocert.AcceptTOS,
+			Cache:      xautocert.DirCache(dir),
+			HostPolicy: xautocert.HostWhitelist(domains...),
+		}
+		r.Hook(
+			certificates{manager},
+			listener{address: HTTPSAddress},
+			listener{address: HTTPAddress, challenges: manager},
+		)
+		return nil
+	}
+}
+
+// CacheDir is the directory where Rig caches certificates and the key of its Let's Encrypt account, which is created
+// if needed.  If CacheDir is empty, the "rig/autocert" directory under os.UserCacheDir is used.  CacheDir should be
+// set before any rig is configured.
+var CacheDir string
+
+// HTTPSAddress and HTTPAddress are the TCP addresses where Rig serves HTTPS and the HTTP-01 challenges of Let's
+// Encrypt, which only connects to ports 443 and 80, so they should only be changed when those ports are forwarded.
+// They should be set before any rig is configured.
+var HTTPSAddress, HTTPAddress = `:443`, `:80`
+
+// certificates is the server hook added by Rig.
+type certificates struct{ manager *xautocert.Manager }
+
+var _ hook.Server = certificates{}
+
+// RigServer implements hook.Server by giving the server the certificates of the manager, which the rig uses to
+// terminate TLS on its TCP listeners.
+func (c certificates) RigServer(server *http.Server) {
+	if server.TLSConfig == nil {
+		server.TLSConfig = new(tls.Config)
+	}
+	conf := server.TLSConfig
+	conf.GetCertificate = c.manager.GetCertificate
+	if conf.MinVersion == 0 {
+		conf.MinVersion = tls.VersionTLS12
+	}
+	// HTTP/2 is only configured for servers that offer it, and "acme-tls/1" lets Let's Encrypt use TLS-ALPN-01.
+	for _, proto := range []string{`h2`, `http/1.1`, acme.ALPNProto} {
+		if !slices.Contains(conf.NextProtos, proto) {
+			conf.NextProtos = append(conf.NextProtos, proto)
+		}
+	}
+}
+
+// A listener is a listen hook added by Rig, which answers challenges itself if it has a manager.
+type listener struct {
+	address    string
+	challenges *xautocert.Manager // nil for the HTTPS listener
+}
+
+var _ hook.Listen = listener{}
+
+// Listen implements hook.Listen by listening to the address; the listener of the challenges is served in the
+// background by its own server, returning a listener for the rig that accepts nothing.
+func (lr listener) Listen(ctx context.Context) (net.Listener, error) {
+	var lcf net.ListenConfig
+	inner, err := lcf.Listen(ctx, `tcp`, lr.address)
+	if err != nil {
+		return nil, err
+	}
+	if lr.challenges == nil {
+		return inner, nil
+	}
+	return serveChallenges(ctx, inner, lr.challenges.HTTPHandler(nil)), nil
+}
+
+// serveChallenges serves handler on inner in the background, returning a listener for the rig that accepts nothing, so
+// the challenges are not handled by the rig, but stop when the rig closes it.
+func serveChallenges(ctx context.Context, inner net.Listener, handler http.Handler) net.Listener {
+	cl := &challengeListener{
+		Listener: inner,
+		server: &http.Server{
+			Handler:     handler,
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		},
+		closed: make(chan struct{}),
+	}
+	go func() {
+		err := cl.server.Serve(inner)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			hog.From(ctx).Warn().Err(err).Str(`listener`, inner.Addr().String()).Msg(`ACME challenges stopped`)
+		}
+	}()
+	return cl
+}
+
+// A challengeListener stands in for a listener served by its own server, see serveChallenges.
+type challengeListener struct {
+	net.Listener
+	server *http.Server
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (cl *challengeListener) Accept() (net.Conn, error) {
+	<-cl.closed
+	return nil, net.ErrClosed
+}
+
+func (cl *challengeListener) Close() error {
+	var err error
+	cl.once.Do(func() {
+		close(cl.closed)
+		err = cl.server.Close()
+	})
+	return err
+}
@@ -0,0 +1,64 @@
+package autocert
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/swdunlop/rig-go/rig"
+	xautocert "golang.org/x/crypto/acme/autocert"
+)
+
+func TestRig(t *testing.T) {
+	_, err := rig.New(Rig())
+	if err == nil {
+		t.Fatal(`expected an error without domains`)
+	}
+
+	manager := &xautocert.Manager{
+		Prompt:     xautocert.AcceptTOS,
+		Cache:      xautocert.DirCache(t.TempDir()),
+		HostPolicy: xautocert.HostWhitelist(`example.com`),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lr, err := listener{address: `localhost:0`, challenges: manager}.Listen(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	get := func(path string) *http.Response {
+		req, err := http.NewRequest(`GET`, `http://`+lr.Addr().String()+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = `example.com`
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+		return rsp
+	}
+	rsp := get(`/users?id=1`)
+	if rsp.StatusCode != http.StatusFound || rsp.Header.Get(`Location`) != `https://example.com/users?id=1` {
+		t.Fatalf(`expected a redirect to HTTPS, got %v to %q`, rsp.StatusCode, rsp.Header.Get(`Location`))
+	}
+	rsp = get(`/.well-known/acme-challenge/unknown`)
+	if rsp.StatusCode != http.StatusNotFound {
+		t.Fatalf(`expected an unknown challenge to be not found, got %v`, rsp.StatusCode)
+	}
+
+	server := new(http.Server)
+	certificates{manager}.RigServer(server)
+	conf := server.TLSConfig
+	if conf.MinVersion != tls.VersionTLS12 || len(conf.NextProtos) != 3 {
+		t.Fatalf(`unexpected TLS configuration %v %q`, conf.MinVersion, conf.NextProtos)
+	}
+	_, err = conf.GetCertificate(&tls.ClientHelloInfo{ServerName: `other.example`})
+	if err == nil {
+		t.Fatal(`expected no certificate for a domain that was not given to Rig`)
+	}
+}
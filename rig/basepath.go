@@ -0,0 +1,125 @@
+package rig
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// BasePath returns an option that serves the rig under a prefix, such as "/app", for a reverse proxy that routes
+// "/app/" to the rig without removing the prefix.  The patterns added by hooks are matched against the path without
+// the prefix, so "GET /users" answers "/app/users" and the endpoints of the rig, like "/_rig/build", move to
+// "/app/_rig/build".  Requests for "/app" are redirected to "/app/", and requests outside of the prefix are not found.
+// Routes, and therefore ServeRoutes, lists the patterns with the prefix.
+//
+// Handlers see the path without the prefix, so relative URLs work unchanged, but handlers that generate absolute URLs,
+// such as a redirect to "/login" or links in a page, must add the prefix themselves, see BasePathOf.  When the rig is
+// Run, the supervisor serves its own endpoints under the prefix and proxies every other request to the worker with the
+// full path, since the worker removes the prefix itself.  If the reverse proxy removes the prefix before forwarding a
+// request, do not use BasePath, since the rig already receives the paths its patterns expect.
+func BasePath(prefix string) Option {
+	return func(cfg *Config) error {
+		if strings.ContainsAny(prefix, `{}?#`) {
+			return fmt.Errorf(`base path %q must be a plain path`, prefix)
+		}
+		prefix = path.Clean(`/` + prefix)
+		if prefix == `/` {
+			prefix = ``
+		}
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.basePath = prefix
+		return nil
+	}
+}
+
+// BasePathOf returns the prefix removed from the path of a request by BasePath, such as "/app", or "" if the rig is
+// not served under a prefix.
+func BasePathOf(r *http.Request) string {
+	base, _ := r.Context().Value(basePathKey{}).(string)
+	return base
+}
+
+type basePathKey struct{}
+
+// base returns the prefix set by BasePath, or "" if there is none.
+func (cfg *Config) base() string {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	return cfg.basePath
+}
+
+// withBasePath adds the base path to a pattern, after its method and host, if any.
+func withBasePath(base, pattern string) string {
+	if base == `` {
+		return pattern
+	}
+	i := strings.Index(pattern, `/`)
+	if i < 0 {
+		return pattern
+	}
+	return pattern[:i] + base + pattern[i:]
+}
+
+// mount returns a handler that serves the requests under the base path with handler, see BasePath.
+func (cfg *Config) mount(handler http.Handler) http.Handler {
+	base := cfg.base()
+	if base == `` {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == base {
+			target := base + `/`
+			if r.URL.RawQuery != `` {
+				target += `?` + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		r2, ok := stripBase(base, r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r2)
+	})
+}
+
+// overlay returns a handler that serves the requests that match the patterns of the supervisor's mux, and proxies the
+// rest upstream to the worker with their full path, see BasePath.
+func (cfg *Config) overlay(mux *http.ServeMux, upstream http.Handler) http.Handler {
+	base := cfg.base()
+	if base == `` {
+		mux.Handle(`/`, upstream)
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r2, ok := stripBase(base, r); ok {
+			if _, pattern := mux.Handler(r2); pattern != `` {
+				mux.ServeHTTP(w, r2)
+				return
+			}
+		}
+		upstream.ServeHTTP(w, r)
+	})
+}
+
+// stripBase returns a copy of a request with the base path removed from its URL, or false if it is not under the base
+// path.  Like http.StripPrefix, the raw path is trimmed as well.
+func stripBase(base string, r *http.Request) (*http.Request, bool) {
+	p, ok := strings.CutPrefix(r.URL.Path, base)
+	if !ok || !strings.HasPrefix(p, `/`) {
+		return nil, false
+	}
+	rp, ok := strings.CutPrefix(r.URL.RawPath, base)
+	if r.URL.RawPath != `` && !ok {
+		return nil, false
+	}
+	r2 := r.WithContext(context.WithValue(r.Context(), basePathKey{}, base))
+	u := *r.URL
+	u.Path, u.RawPath = p, rp
+	r2.URL = &u
+	return r2, true
+}
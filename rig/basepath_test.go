@@ -0,0 +1,72 @@
+package rig
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestBasePath(t *testing.T) {
+	cfg, err := New(BasePath(`/app/`), func(cfg *Config) error {
+		cfg.Hook(testMux{`users`, `GET /users`}, testMux{`root`, `GET /{$}`}, testMux{`posts`, `/posts/`},
+			testMux{`host`, `example.com/x`})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := cfg.buildHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{`/app/users`, 200, `users`},
+		{`/app/`, 200, `root`},
+		{`/app/posts/1`, 200, `posts`},
+		{`/app`, http.StatusMovedPermanently, ``},
+		{`/users`, 404, ``},
+		{`/application/users`, 404, ``},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(`GET`, test.path, nil))
+		body, _ := io.ReadAll(w.Body)
+		if w.Code != test.status || (test.body != `` && string(body) != test.body) {
+			t.Errorf(`expected %v to answer %v %q, got %v %q`, test.path, test.status, test.body, w.Code, body)
+		}
+	}
+	var patterns []string
+	for _, route := range cfg.Routes() {
+		patterns = append(patterns, route.Method+` `+route.Pattern)
+	}
+	expect := []string{`GET /app/users`, `GET /app/{$}`, ` /app/posts/`, ` example.com/app/x`}
+	if !slices.Equal(patterns, expect) {
+		t.Errorf(`expected routes with the base path %q, got %q`, expect, patterns)
+	}
+
+	// The supervisor serves its own endpoints under the base path and proxies everything else with the full path.
+	mux := http.NewServeMux()
+	mux.HandleFunc(`GET /_rig/logs`, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `logs under `+BasePathOf(r))
+	})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `worker `+r.URL.Path)
+	})
+	handler = cfg.overlay(mux, upstream)
+	for path, expect := range map[string]string{
+		`/app/_rig/logs`:    `logs under /app`,
+		`/app/_rig/workers`: `worker /app/_rig/workers`,
+		`/app/users`:        `worker /app/users`,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(`GET`, path, nil))
+		if body, _ := io.ReadAll(w.Body); string(body) != expect {
+			t.Errorf(`expected %v to answer %q, got %q`, path, expect, body)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package rig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig/watcher"
+)
+
+// A BuildError describes an error from a build reported by ReportBuild, such as a syntax error in a source file.
+type BuildError struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`   // The line of the error in File, starting at 1, or zero if unknown.
+	Column int    `json:"column,omitempty"` // The column of the error in Line, starting at 1, or zero if unknown.
+	Text   string `json:"text"`
+}
+
+// A BuildStatus is the outcome of the latest run of a build reported by ReportBuild.
+type BuildStatus struct {
+	Name   string       `json:"name"`
+	Time   time.Time    `json:"time"`             // When the build was reported.
+	Errors []BuildError `json:"errors,omitempty"` // Empty if the build succeeded.
+}
+
+// ReportBuild records the outcome of the latest run of the named build, such as an esbuild bundle, replacing the outcome
+// of its last run, and notifies clients watching "/_rig/build".  Reporting a build without errors clears the errors of
+// its last run.  This is normally done by options like esbuild after each build.
+func (cfg *Config) ReportBuild(name string, errs ...BuildError) {
+	cfg.buildState().report(BuildStatus{Name: name, Time: time.Now(), Errors: errs})
+}
+
+// Builds returns the outcome of the latest run of each build reported by ReportBuild, ordered by name.
+func (cfg *Config) Builds() []BuildStatus {
+	cfg.control.Lock()
+	builds := cfg.builds
+	cfg.control.Unlock()
+	if builds == nil {
+		return nil
+	}
+	return builds.snapshot().Builds
+}
+
+// buildState returns the state served at "/_rig/build", creating it if necessary.
+func (cfg *Config) buildState() *buildState {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	if cfg.builds == nil {
+		cfg.builds = newBuildState()
+	}
+	return cfg.builds
+}
+
+// buildEndpoint returns the state served at "/_rig/build" and the watches that notify its clients, or nil if nothing
+// has called Watch or ReportBuild, in which case the endpoint is not served.
+func (cfg *Config) buildEndpoint() (*buildState, []watch) {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	if cfg.builds == nil && len(cfg.watch) == 0 {
+		return nil, nil
+	}
+	if cfg.builds == nil {
+		cfg.builds = newBuildState()
+	}
+	return cfg.builds, append([]watch(nil), cfg.watch...)
+}
+
+// A buildState tracks the builds reported by ReportBuild and changes to watched files, serving them at "/_rig/build".
+type buildState struct {
+	control    sync.Mutex
+	builds     map[string]BuildStatus
+	generation int           // incremented by each build and change to a watched file
+	changed    chan struct{} // closed and replaced when generation is incremented
+}
+
+func newBuildState() *buildState {
+	return &buildState{builds: make(map[string]BuildStatus), changed: make(chan struct{})}
+}
+
+// A buildSnapshot is the JSON served at "/_rig/build".
+type buildSnapshot struct {
+	Generation int           `json:"generation"`
+	Builds     []BuildStatus `json:"builds"`
+}
+
+// report records the status of a build and notifies clients.
+func (bs *buildState) report(status BuildStatus) {
+	bs.control.Lock()
+	defer bs.control.Unlock()
+	bs.builds[status.Name] = status
+	bs.notifyLocked()
+}
+
+// notify tells clients that something has changed, such as a watched file.
+func (bs *buildState) notify() {
+	bs.control.Lock()
+	defer bs.control.Unlock()
+	bs.notifyLocked()
+}
+
+func (bs *buildState) notifyLocked() {
+	bs.generation++
+	close(bs.changed)
+	bs.changed = make(chan struct{})
+}
+
+// snapshot returns the current state.
+func (bs *buildState) snapshot() buildSnapshot {
+	snap, _ := bs.watchSnapshot()
+	return snap
+}
+
+// watchSnapshot returns the current state along with a channel that is closed when it changes.
+func (bs *buildState) watchSnapshot() (buildSnapshot, <-chan struct{}) {
+	bs.control.Lock()
+	defer bs.control.Unlock()
+	snap := buildSnapshot{Generation: bs.generation, Builds: make([]BuildStatus, 0, len(bs.builds))}
+	for _, status := range bs.builds {
+		snap.Builds = append(snap.Builds, status)
+	}
+	sort.Slice(snap.Builds, func(i, j int) bool { return snap.Builds[i].Name < snap.Builds[j].Name })
+	return snap, bs.changed
+}
+
+// watch notifies clients when a file matching the patterns of a watch changes, until the context is done.  A
+// directory that cannot be watched is logged and ignored.
+func (bs *buildState) watch(ctx context.Context, watches []watch) {
+	for _, w := range watches {
+		wr, err := watcher.Start(watcher.Directory(w.dir))
+		if err != nil {
+			hog.From(ctx).Warn().Err(err).Str(`dir`, w.dir).Msg(`cannot watch build output`)
+			continue
+		}
+		go func(w watch) {
+			defer wr.Shutdown()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-wr.Events():
+					if matchesAny(filepath.Base(event.Name), w.patterns) {
+						bs.notify()
+					}
+				}
+			}
+		}(w)
+	}
+}
+
+// matchesAny returns true if the name matches any of the patterns, or if there are no patterns.
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return len(patterns) == 0
+}
+
+// RigMux adds the "/_rig/build" endpoint.
+func (bs *buildState) RigMux(mux *http.ServeMux) { mux.Handle(`GET /_rig/build`, bs) }
+
+// ServeHTTP serves the builds as JSON or, if the client accepts "text/event-stream", as a server sent event named
+// "build" each time something changes, starting with the current state.  The ID of each event is its generation.
+func (bs *buildState) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get(`Accept`), `text/event-stream`) {
+		w.Header().Set(`Content-Type`, `application/json`)
+		_ = json.NewEncoder(w).Encode(bs.snapshot())
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set(`Content-Type`, `text/event-stream`)
+	w.Header().Set(`Cache-Control`, `no-cache`)
+	w.WriteHeader(http.StatusOK)
+	for {
+		snap, changed := bs.watchSnapshot()
+		js, err := json.Marshal(snap)
+		if err == nil {
+			_, err = fmt.Fprintf(w, "id: %v\nevent: build\ndata: %s\n\n", snap.Generation, js)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package rig
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReportBuild(t *testing.T) {
+	cfg, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ReportBuild(`bundle`, BuildError{File: `app.ts`, Line: 3, Column: 7, Text: `Expected ";"`})
+	srv := httptest.NewServer(cfg.Handler())
+	defer srv.Close()
+
+	var snap buildSnapshot
+	rsp, err := http.Get(srv.URL + `/_rig/build`)
+	if err == nil {
+		err = json.NewDecoder(rsp.Body).Decode(&snap)
+		rsp.Body.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Builds) != 1 || len(snap.Builds[0].Errors) != 1 || snap.Builds[0].Errors[0].Line != 3 {
+		t.Fatalf(`expected one build with an error, got %+v`, snap)
+	}
+
+	req, _ := http.NewRequest(`GET`, srv.URL+`/_rig/build`, nil)
+	req.Header.Set(`Accept`, `text/event-stream`)
+	rsp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	events := bufio.NewScanner(rsp.Body)
+	next := func() buildSnapshot {
+		for events.Scan() {
+			data, ok := strings.CutPrefix(events.Text(), `data: `)
+			if !ok {
+				continue
+			}
+			var snap buildSnapshot
+			err := json.Unmarshal([]byte(data), &snap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return snap
+		}
+		t.Fatal(`expected another build event`)
+		return buildSnapshot{}
+	}
+	if snap := next(); len(snap.Builds[0].Errors) != 1 {
+		t.Fatalf(`expected the stream to start with the error, got %+v`, snap)
+	}
+	cfg.ReportBuild(`bundle`)
+	if snap := next(); len(snap.Builds[0].Errors) != 0 {
+		t.Fatalf(`expected a successful build to clear the error, got %+v`, snap)
+	}
+}
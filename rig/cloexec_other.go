@@ -0,0 +1,8 @@
+//go:build !unix
+
+package rig
+
+import "os"
+
+// closeOnExec does nothing on platforms without Unix file descriptors.
+func closeOnExec(file *os.File) {}
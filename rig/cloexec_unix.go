@@ -0,0 +1,11 @@
+//go:build unix
+
+package rig
+
+import (
+	"os"
+	"syscall"
+)
+
+// closeOnExec marks an inherited file so it is not inherited in turn by child processes.
+func closeOnExec(file *os.File) { syscall.CloseOnExec(int(file.Fd())) }
@@ -0,0 +1,147 @@
+package rig
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig/hook"
+)
+
+// A ConflictStrategy decides what the rig does when hooks add conflicting patterns to the HTTP multiplexer, such as two
+// api groups that both handle "GET /users", which would make http.ServeMux panic.
+type ConflictStrategy int
+
+const (
+	// FailOnConflict makes the rig fail to serve with a PatternConflictError, and Handler panic with one.
+	FailOnConflict ConflictStrategy = iota
+
+	// LastWins leaves out the earlier of two conflicting hooks, logging a warning, so a hook added later can replace
+	// one added earlier.  Since http.ServeMux cannot replace a single pattern, every pattern of the earlier hook is
+	// left out, not just the conflicting one.
+	LastWins
+)
+
+// PatternConflicts returns an option that sets the strategy for hooks that add conflicting patterns; the default is
+// FailOnConflict.
+func PatternConflicts(strategy ConflictStrategy) Option {
+	return func(cfg *Config) error {
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.conflicts = strategy
+		return nil
+	}
+}
+
+// A PatternConflictError describes hooks that add conflicting patterns to the HTTP multiplexer.
+type PatternConflictError struct {
+	Earlier string // A description of the hook that added its patterns first.
+	Later   string // A description of the hook whose pattern conflicts with them.
+	Reason  string // Why http.ServeMux rejected the pattern, which names both patterns.
+}
+
+func (err *PatternConflictError) Error() string {
+	return fmt.Sprintf(`%v conflicts with %v: %v`, err.Later, err.Earlier, err.Reason)
+}
+
+// buildHandler returns an http.Handler that will serve the configured rig, resolving conflicts between the patterns of
+// the hooks using the strategy set by PatternConflicts.
+func (cfg *Config) buildHandler() (http.Handler, error) {
+	var muxers []hook.Mux
+	for _, it := range cfg.hookList() {
+		if impl, ok := it.(hook.Mux); ok {
+			muxers = append(muxers, impl)
+		}
+	}
+	if builds, _ := cfg.buildEndpoint(); builds != nil {
+		muxers = append(muxers, builds)
+	}
+	cfg.control.Lock()
+	strategy := cfg.conflicts
+	cfg.control.Unlock()
+	for {
+		mux, failed := applyMuxers(muxers)
+		if failed < 0 {
+			return cfg.mount(cfg.preRouted(mux)), nil
+		}
+		err, earlier := describeConflict(muxers, failed)
+		if strategy != LastWins || earlier < 0 {
+			return nil, err
+		}
+		hog.From(context.Background()).Warn().Err(err).Msg(`leaving out the earlier hook`)
+		muxers = slices.Delete(muxers, earlier, earlier+1)
+	}
+}
+
+// applyMuxers applies the mux hooks to a new multiplexer, returning the index of the first hook that added a
+// conflicting pattern, or -1.
+func applyMuxers(muxers []hook.Mux) (*http.ServeMux, int) {
+	mux := new(http.ServeMux)
+	for i, it := range muxers {
+		if applyMuxer(mux, it) != `` {
+			return mux, i
+		}
+	}
+	return mux, -1
+}
+
+// applyMuxer applies a mux hook, returning why http.ServeMux rejected one of its patterns, if it did.  Other panics are
+// not recovered.
+func applyMuxer(mux *http.ServeMux, it hook.Mux) (reason string) {
+	defer func() {
+		if failure := recover(); failure != nil {
+			reason = fmt.Sprint(failure)
+			if !strings.Contains(reason, `conflicts with`) {
+				panic(failure)
+			}
+		}
+	}()
+	it.RigMux(mux)
+	return ``
+}
+
+// describeConflict finds the earlier hook that conflicts with the hook at index later by applying the later hook after
+// each earlier hook on its own, returning the error and the index of the earlier hook, or -1 if it is not found, such
+// as when the later hook conflicts with itself.
+func describeConflict(muxers []hook.Mux, later int) (*PatternConflictError, int) {
+	err := &PatternConflictError{Later: describeHook(muxers[later])}
+	if reason := applyMuxer(new(http.ServeMux), muxers[later]); reason != `` {
+		err.Earlier, err.Reason = err.Later, reason
+		return err, -1
+	}
+	for i := 0; i < later; i++ {
+		mux := new(http.ServeMux)
+		if applyMuxer(mux, muxers[i]) != `` {
+			continue
+		}
+		if reason := applyMuxer(mux, muxers[later]); reason != `` {
+			err.Earlier, err.Reason = describeHook(muxers[i]), reason
+			return err, i
+		}
+	}
+	// The conflict depends on more than one earlier hook, which should not happen since patterns conflict in pairs.
+	err.Earlier, err.Reason = `an earlier hook`, `conflicting patterns`
+	return err, -1
+}
+
+// describeHook describes a hook by its type and the groups of its routes, if it describes them.
+func describeHook(it any) string {
+	desc := fmt.Sprintf(`%T`, it)
+	impl, ok := it.(hook.Routes)
+	if !ok {
+		return desc
+	}
+	var groups []string
+	for _, route := range impl.RigRoutes() {
+		if route.Group != `` && !slices.Contains(groups, route.Group) {
+			groups = append(groups, route.Group)
+		}
+	}
+	if len(groups) > 0 {
+		desc += fmt.Sprintf(` (group %v)`, strings.Join(groups, `, `))
+	}
+	return desc
+}
@@ -0,0 +1,67 @@
+package rig
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/swdunlop/rig-go/rig/hook"
+)
+
+func TestPatternConflicts(t *testing.T) {
+	hooks := func(cfg *Config) error {
+		cfg.Hook(testMux{`admin`, `/admin/`}, testMux{`users`, `GET /users`}, testMux{`accounts`, `GET /users`})
+		return nil
+	}
+	cfg, err := New(hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.buildHandler()
+	var conflict *PatternConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf(`expected a PatternConflictError, got %v`, err)
+	}
+	if !strings.Contains(conflict.Earlier, `users`) || !strings.Contains(conflict.Later, `accounts`) ||
+		!strings.Contains(conflict.Reason, `GET /users`) {
+		t.Fatalf(`expected the conflict to name both hooks and the pattern, got %q`, err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error(`expected Handler to panic`)
+			}
+		}()
+		cfg.Handler()
+	}()
+
+	cfg, err = New(hooks, PatternConflicts(LastWins))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := cfg.buildHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expect := range map[string]string{`/users`: `accounts`, `/admin/`: `admin`} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(`GET`, path, nil))
+		if body, _ := io.ReadAll(w.Body); string(body) != expect {
+			t.Errorf(`expected %v to be served by %v, got %q`, path, expect, body)
+		}
+	}
+}
+
+// A testMux is a hook that answers a pattern with its group.
+type testMux struct{ group, pattern string }
+
+func (tm testMux) RigMux(mux *http.ServeMux) {
+	mux.HandleFunc(tm.pattern, func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, tm.group) })
+}
+
+func (tm testMux) RigRoutes() []hook.Route {
+	return []hook.Route{{Pattern: tm.pattern, Group: tm.group, Handler: `testMux`}}
+}
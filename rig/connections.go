@@ -0,0 +1,164 @@
+package rig
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig/hook"
+)
+
+// ConnectionPolicy returns an option that tunes how the rig's HTTP server treats connections, such as for production
+// behind a load balancer.  Start from DefaultConnectionLimits and change the fields that matter, since the zero value of
+// each field leaves that behavior to net/http.  Like other server hooks, the policy applies to the supervisor, or to a
+// rig that is served directly, but not to the worker behind the supervisor's proxy.
+//
+// WebSocket connections, such as those of mrpc and jrpc, are upgraded and leave the server once they are accepted, so
+// none of the limits apply to them, and long-lived RPC connections do not use up MaxConnections; use the PingInterval
+// of mrpc or jrpc to keep them alive instead of IdleTimeout.
+func ConnectionPolicy(limits ConnectionLimits) Option {
+	return func(cfg *Config) error {
+		policy := &connectionPolicy{limits: limits}
+		if limits.MaxConnections > 0 {
+			policy.slots = make(chan struct{}, limits.MaxConnections)
+		}
+		cfg.Hook(policy)
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.connections = policy
+		return nil
+	}
+}
+
+// ConnectionLimits configures ConnectionPolicy.
+type ConnectionLimits struct {
+	// IdleTimeout is how long an idle keep-alive connection is kept open for the next request.
+	IdleTimeout time.Duration
+
+	// ReadHeaderTimeout is how long a client may take to send the header of a request, which guards against clients
+	// that open connections and trickle bytes to tie them up.
+	ReadHeaderTimeout time.Duration
+
+	// MaxConnections limits how many HTTP connections are served at the same time across every listener; once it is
+	// reached, no more connections are accepted until one closes or is upgraded, so clients wait in the listen backlog.
+	MaxConnections int
+
+	// DisableKeepAlives closes each HTTP/1.1 connection after a single request.
+	DisableKeepAlives bool
+
+	// MaxRequestsPerConnection closes HTTP/1.1 connections after this many requests, so clients reconnect and spread
+	// across the instances behind a load balancer.  Requests that upgrade the connection are not counted.
+	MaxRequestsPerConnection int
+}
+
+// DefaultConnectionLimits are sensible limits for production: idle connections are closed after two minutes, and
+// clients have ten seconds to send the header of a request, while connections and requests are not limited.
+var DefaultConnectionLimits = ConnectionLimits{
+	IdleTimeout:       2 * time.Minute,
+	ReadHeaderTimeout: 10 * time.Second,
+}
+
+// A connectionPolicy is the hook added by ConnectionPolicy.
+type connectionPolicy struct {
+	limits ConnectionLimits
+	slots  chan struct{} // one for each connection being served, nil if connections are not limited
+}
+
+// RigServer implements hook.Server by applying the limits to the server.
+func (policy *connectionPolicy) RigServer(server *http.Server) {
+	limits := policy.limits
+	if limits.IdleTimeout > 0 {
+		server.IdleTimeout = limits.IdleTimeout
+	}
+	if limits.ReadHeaderTimeout > 0 {
+		server.ReadHeaderTimeout = limits.ReadHeaderTimeout
+	}
+	if limits.DisableKeepAlives {
+		server.SetKeepAlivesEnabled(false) // successful upgrades are exempt, see golang.org/issue/36381.
+	}
+	if limits.MaxRequestsPerConnection > 0 {
+		limitRequests(server, limits.MaxRequestsPerConnection)
+	}
+	if policy.slots != nil {
+		connState := server.ConnState
+		server.ConnState = func(conn net.Conn, state http.ConnState) {
+			if connState != nil {
+				connState(conn, state)
+			}
+			if state == http.StateClosed || state == http.StateHijacked {
+				<-policy.slots // claimed by a limitListener when the connection was accepted.
+			}
+		}
+	}
+}
+
+var _ hook.Server = (*connectionPolicy)(nil)
+
+type requestCountKey struct{}
+
+// limitRequests makes the server close HTTP/1.1 connections once they have served max requests, see
+// ConnectionLimits.MaxRequestsPerConnection.
+func limitRequests(server *http.Server, max int) {
+	connContext := server.ConnContext
+	server.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, conn)
+		}
+		return context.WithValue(ctx, requestCountKey{}, new(atomic.Int64))
+	}
+	next := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count, _ := r.Context().Value(requestCountKey{}).(*atomic.Int64)
+		if count != nil && r.ProtoMajor == 1 && r.Header.Get(`Upgrade`) == `` && count.Add(1) >= int64(max) {
+			w.Header().Set(`Connection`, `close`) // net/http closes the connection after the response.
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitListeners makes the listeners wait for a slot before accepting a connection, see
+// ConnectionLimits.MaxConnections.  The server releases the slot when the connection closes or is hijacked, which
+// leaves the connection itself unwrapped so the server still recognizes TLS connections.
+func (cfg *Config) limitListeners(listeners []net.Listener) []net.Listener {
+	cfg.control.Lock()
+	policy := cfg.connections
+	cfg.control.Unlock()
+	if policy == nil || policy.slots == nil {
+		return listeners
+	}
+	limited := make([]net.Listener, len(listeners))
+	for i, lr := range listeners {
+		limited[i] = &limitListener{Listener: lr, slots: policy.slots, closed: make(chan struct{})}
+	}
+	return limited
+}
+
+// A limitListener waits for one of the slots it shares with other listeners before accepting a connection.
+type limitListener struct {
+	net.Listener
+	slots  chan struct{}
+	once   sync.Once
+	closed chan struct{} // closed by Close, so Accept stops waiting for a slot
+}
+
+func (lr *limitListener) Accept() (net.Conn, error) {
+	select {
+	case lr.slots <- struct{}{}:
+	case <-lr.closed:
+		return nil, net.ErrClosed
+	}
+	conn, err := lr.Listener.Accept()
+	if err != nil {
+		<-lr.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (lr *limitListener) Close() error {
+	lr.once.Do(func() { close(lr.closed) })
+	return lr.Listener.Close()
+}
@@ -0,0 +1,89 @@
+package rig
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConnectionPolicy(t *testing.T) {
+	lr, err := net.Listen(`tcp`, `localhost:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := DefaultConnectionLimits
+	limits.MaxConnections, limits.MaxRequestsPerConnection = 1, 2
+	cfg, err := New(ConnectionPolicy(limits), func(cfg *Config) error {
+		cfg.Hook(testMux{`ok`, `/`})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- cfg.ServeListener(ctx, lr) }()
+
+	// get sends a request on a connection and returns true if the response closes the connection.
+	get := func(conn net.Conn, br *bufio.Reader) bool {
+		_, err := fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+		return rsp.Close
+	}
+	first, err := net.Dial(`tcp`, lr.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	br := bufio.NewReader(first)
+	if get(first, br) {
+		t.Fatal(`expected the first request to keep the connection alive`)
+	}
+
+	// The second connection is not served while the first is open.
+	second, err := net.Dial(`tcp`, lr.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		get(second, bufio.NewReader(second))
+	}()
+	select {
+	case <-served:
+		t.Fatal(`expected the second connection to wait for the first`)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if !get(first, br) {
+		t.Fatal(`expected the second request to close the connection`)
+	}
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal(`expected the second connection to be served once the first closed`)
+	}
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`ServeListener did not return after the context was cancelled`)
+	}
+}
@@ -0,0 +1,80 @@
+package rig
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// FromEnv returns an option that applies options derived from environment variables that start with the given prefix,
+// such as "APP_" for "APP_LISTEN" and "APP_LOG_LEVEL".  Each parser is given the variables with the prefix removed,
+// consumes the variables it recognizes and returns an option, or nil.  If the prefix is not empty, any variable with
+// the prefix that is not recognized by a parser is an error, catching typos; the variables that the rig passes to its
+// own processes, like RIG_SOCKET and RIG_GENERATION, are always ignored.
+//
+// Parsers for common settings are provided by this package, like EnvLogLevel, and by the packages that provide the
+// corresponding options, like local.Env and tailscale.Env.
+//
+// Options are applied in order, so place FromEnv before any explicit options to let them override settings from the
+// environment.
+func FromEnv(prefix string, parsers ...EnvParser) Option {
+	return func(cfg *Config) error {
+		env := make(map[string]string)
+		for _, item := range os.Environ() {
+			key, value, _ := strings.Cut(item, `=`)
+			switch key {
+			case `RIG_SOCKET`, `RIG_SOCKET_FD`, `RIG_HANDOVER`, `RIG_GENERATION`, `RIG_FIRST_WORKER`:
+				continue
+			}
+			if key, ok := strings.CutPrefix(key, prefix); ok {
+				env[key] = value
+			}
+		}
+		for _, parse := range parsers {
+			option, err := parse(env)
+			if err != nil {
+				return err
+			}
+			if option == nil {
+				continue
+			}
+			err = option(cfg)
+			if err != nil {
+				return err
+			}
+		}
+		if prefix == `` || len(env) == 0 {
+			return nil
+		}
+		unknown := make([]string, 0, len(env))
+		for key := range env {
+			unknown = append(unknown, prefix+key)
+		}
+		sort.Strings(unknown)
+		return fmt.Errorf(`unknown environment variables %v`, strings.Join(unknown, `, `))
+	}
+}
+
+// An EnvParser consumes the environment variables it recognizes from env, deleting them, and returns an option
+// configured by them, or nil if it recognized none.  See FromEnv.
+type EnvParser func(env map[string]string) (Option, error)
+
+// EnvLogLevel is an EnvParser that sets the global log level from LOG_LEVEL, such as "debug" or "warn".
+func EnvLogLevel(env map[string]string) (Option, error) {
+	value, ok := env[`LOG_LEVEL`]
+	if !ok {
+		return nil, nil
+	}
+	delete(env, `LOG_LEVEL`)
+	level, err := zerolog.ParseLevel(value)
+	if err != nil {
+		return nil, fmt.Errorf(`%w in LOG_LEVEL`, err)
+	}
+	return func(cfg *Config) error {
+		zerolog.SetGlobalLevel(level)
+		return nil
+	}, nil
+}
@@ -0,0 +1,40 @@
+package rig
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestFromEnv(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	t.Setenv(`RIG_SOCKET`, `/tmp/ignored.sock`) // passed by the supervisor, so it is never unknown.
+	t.Setenv(`RIG_LOG_LEVEL`, `warn`)
+	t.Setenv(`RIG_GREETING`, `hello`)
+	var greeting string
+	greet := func(env map[string]string) (Option, error) {
+		value, ok := env[`GREETING`]
+		if !ok {
+			return nil, nil
+		}
+		delete(env, `GREETING`)
+		return func(cfg *Config) error {
+			greeting = value
+			return nil
+		}, nil
+	}
+	_, err := New(FromEnv(`RIG_`, EnvLogLevel, greet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if greeting != `hello` || zerolog.GlobalLevel() != zerolog.WarnLevel {
+		t.Fatalf(`expected the known variables to be applied, got %q and %v`, greeting, zerolog.GlobalLevel())
+	}
+
+	t.Setenv(`RIG_GRETING`, `typo`)
+	_, err = New(FromEnv(`RIG_`, EnvLogLevel, greet))
+	if err == nil || !strings.Contains(err.Error(), `RIG_GRETING`) || strings.Contains(err.Error(), `RIG_SOCKET`) {
+		t.Fatalf(`expected an error for the unknown variable only, got %v`, err)
+	}
+}
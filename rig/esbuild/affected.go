@@ -0,0 +1,152 @@
+package esbuild
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/swdunlop/rig-go/rig"
+)
+
+// RebuildAffected returns a rig option that, when using Debounce, builds each entry point in its own esbuild context
+// and only rebuilds the entry points that use a changed file, such as the stylesheet of a project that also bundles a
+// large script.  The inputs of each entry point are read from the metafile of its last build.  If a changed file is
+// not an input of any entry point, such as a new file, every entry point is rebuilt, and an entry point whose last
+// build failed, so its inputs are unknown, is rebuilt on any change.
+//
+// Since entry points are built separately, RebuildAffected cannot be used with code splitting, and errors are
+// reported at "/_rig/build" for each entry point.  Without Debounce, RebuildAffected does nothing.
+func RebuildAffected(ok bool) Option {
+	return func(cfg *config) { cfg.affected = ok }
+}
+
+// rebuildsAffected returns true if entry points are built separately, see RebuildAffected.
+func (cfg *config) rebuildsAffected() bool { return cfg.affected && cfg.debounce > 0 }
+
+// An entryBuild is the esbuild context of one entry point, see RebuildAffected.
+type entryBuild struct {
+	ctx    esbuild.BuildContext
+	inputs map[string]bool // absolute paths of the inputs of the last build, nil if it failed
+}
+
+// rebuild rebuilds the entry point, updating its inputs from the metafile; dir is the working directory of the build.
+func (eb *entryBuild) rebuild(dir string) {
+	ret := eb.ctx.Rebuild()
+	printErrors(ret.Errors)
+	eb.inputs = metafileInputs(dir, ret.Metafile)
+}
+
+// metafileInputs returns the absolute paths of the inputs listed by an esbuild metafile, or nil if it has none.
+func metafileInputs(dir, metafile string) map[string]bool {
+	var meta struct {
+		Inputs map[string]json.RawMessage `json:"inputs"`
+	}
+	if json.Unmarshal([]byte(metafile), &meta) != nil || len(meta.Inputs) == 0 {
+		return nil
+	}
+	inputs := make(map[string]bool, len(meta.Inputs))
+	for name := range meta.Inputs {
+		name = filepath.FromSlash(name)
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		inputs[filepath.Clean(name)] = true
+	}
+	return inputs
+}
+
+// affectedBuilds returns the builds that use one of the changed files, or whose inputs are unknown.  If the changes
+// are unknown, or a changed file is not used by any build, every build is affected.
+func affectedBuilds(builds []*entryBuild, changed map[string]bool) []*entryBuild {
+	if len(changed) == 0 {
+		return builds
+	}
+	var affected []*entryBuild
+	for name := range changed {
+		used := false
+		for _, eb := range builds {
+			if eb.inputs[name] {
+				used = true
+				if !slices.Contains(affected, eb) {
+					affected = append(affected, eb)
+				}
+			}
+		}
+		if !used {
+			return builds
+		}
+	}
+	for _, eb := range builds {
+		if eb.inputs == nil && !slices.Contains(affected, eb) {
+			affected = append(affected, eb)
+		}
+	}
+	return affected
+}
+
+// rebuildAffected builds each entry point in its own context, then watches the source directories like
+// debounceRebuilds, rebuilding the entry points affected by the changes once they have been quiet for the debounce
+// interval, until doneCh is closed.  Like buildAndWatch, the result of starting the watch is sent to errCh.
+func (cfg *config) rebuildAffected(r *rig.Config, errCh chan<- error, doneCh <-chan struct{}) {
+	dir := cfg.build.AbsWorkingDir
+	if dir == `` {
+		var err error
+		dir, err = os.Getwd()
+		if err != nil {
+			errCh <- fmt.Errorf(`esbuild: %w while starting`, err)
+			return
+		}
+	}
+	builds := make([]*entryBuild, 0, len(cfg.build.EntryPoints))
+	dispose := func() {
+		for _, eb := range builds {
+			eb.ctx.Dispose()
+		}
+	}
+	for _, entryPoint := range cfg.build.EntryPoints {
+		options := cfg.build
+		options.EntryPoints = []string{entryPoint}
+		options.Metafile = true
+		options.Plugins = append(slices.Clip(cfg.build.Plugins), cfg.reportPlugin(r, cfg.reportName()+` `+entryPoint))
+		ctx, ctxErr := cfg.context(options)
+		if ctxErr != nil {
+			printErrors(ctxErr.Errors)
+		}
+		if ctxErr != nil && len(ctxErr.Errors) > 0 {
+			dispose()
+			errCh <- fmt.Errorf(`esbuild failed to start`)
+			return
+		}
+		builds = append(builds, &entryBuild{ctx: ctx})
+	}
+	for _, eb := range builds {
+		eb.rebuild(dir)
+	}
+	wr, err := cfg.startWatcher()
+	if err != nil {
+		dispose()
+		errCh <- fmt.Errorf(`esbuild: %w while starting watch`, err)
+		return
+	}
+	defer dispose()
+	defer wr.Shutdown()
+	errCh <- nil
+
+	changed := make(map[string]bool)
+	for {
+		select {
+		case <-doneCh:
+			return
+		case event := <-wr.Events():
+			changed[filepath.Clean(event.Name)] = true
+		case <-wr.Alert():
+			for _, eb := range affectedBuilds(builds, changed) {
+				eb.rebuild(dir)
+			}
+			clear(changed)
+		}
+	}
+}
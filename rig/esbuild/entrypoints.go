@@ -0,0 +1,81 @@
+package esbuild
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultResolveExtensions are the extensions esbuild tries when an import has none, unless ResolveExtensions is set.
+var defaultResolveExtensions = []string{`.tsx`, `.ts`, `.jsx`, `.js`, `.css`, `.json`}
+
+// checkEntryPoints returns an error for the first entry point that esbuild would not find, so a typo is reported when
+// the rig is configured instead of by each build.  Like esbuild, an entry point is resolved relative to the working
+// directory of the build, with or without one of the resolve extensions, or as the index of a directory.  Entry points
+// that are not relative or absolute paths may also name packages in a "node_modules" directory; since those have
+// their own rules for resolving files, only the package directory is checked.
+func (cfg *config) checkEntryPoints() error {
+	dir := cfg.build.AbsWorkingDir
+	if dir == `` {
+		var err error
+		dir, err = os.Getwd()
+		if err != nil {
+			return fmt.Errorf(`esbuild: %w while checking entry points`, err)
+		}
+	}
+	extensions := cfg.build.ResolveExtensions
+	if len(extensions) == 0 {
+		extensions = defaultResolveExtensions
+	}
+	for _, entryPoint := range cfg.build.EntryPoints {
+		name := entryPoint
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		if resolvesFile(name, extensions) || isPackage(dir, entryPoint) {
+			continue
+		}
+		return fmt.Errorf(`esbuild: entry point %q not found`, entryPoint)
+	}
+	return nil
+}
+
+// resolvesFile returns true if name is a file, with or without one of the extensions, or a directory with an index.
+func resolvesFile(name string, extensions []string) bool {
+	if info, err := os.Stat(name); err == nil && !info.IsDir() {
+		return true
+	}
+	for _, ext := range extensions {
+		if info, err := os.Stat(name + ext); err == nil && !info.IsDir() {
+			return true
+		}
+		if info, err := os.Stat(filepath.Join(name, `index`+ext)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// isPackage returns true if the entry point could name a package in a "node_modules" directory in dir or one of its
+// parents, such as "react" or "@scope/name/file.js".
+func isPackage(dir, entryPoint string) bool {
+	if filepath.IsAbs(entryPoint) || strings.HasPrefix(entryPoint, `.`) {
+		return false
+	}
+	parts := strings.SplitN(filepath.ToSlash(entryPoint), `/`, 3)
+	pkg := parts[0]
+	if strings.HasPrefix(pkg, `@`) && len(parts) > 1 {
+		pkg += `/` + parts[1]
+	}
+	for {
+		if info, err := os.Stat(filepath.Join(dir, `node_modules`, filepath.FromSlash(pkg))); err == nil && info.IsDir() {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
@@ -2,21 +2,31 @@ package esbuild
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/gobwas/glob"
 	"github.com/swdunlop/rig-go/rig"
+	"github.com/swdunlop/rig-go/rig/watcher"
 )
 
 // Rig returns a rig option that configures a rig to build the given esbuild file when it changes.
+//
+// When the rig is Run, only the supervisor builds and watches the entry points; the option does nothing in the worker,
+// which has RIG_SOCKET in its environment, so the bundle is not built twice and is not rebuilt when the worker restarts.
 func Rig(options ...Option) rig.Option {
 	var cfg config
 	cfg.build.LogLevel = esbuild.LogLevelInfo
 	cfg.build.Bundle = true
 	cfg.build.Write = true
+	cfg.context = esbuild.Context
 	for _, option := range options {
 		option(&cfg)
 	}
@@ -27,21 +37,72 @@ func Rig(options ...Option) rig.Option {
 type Option func(*config)
 
 type config struct {
-	build esbuild.BuildOptions
-	watch esbuild.WatchOptions
+	build   esbuild.BuildOptions
+	watch   esbuild.WatchOptions
+	context func(esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError)
+
+	debounce time.Duration // if not zero, the rig watches sources itself and rebuilds after this quiet period
+	sources  []string      // directories watched for changes when debouncing
+
+	affected    bool     // if true, entry points are built separately when debouncing, see RebuildAffected
+	precompress []string // algorithms used to compress outputs after each build
+	target      string   // language target given to Target, resolved when the rig is configured
+	publicEnv   []string // prefixes of environment variables defined by PublicEnv
 }
 
+// rigOption configures the rig using a copy of the config, so the plugins and definitions it adds to the build are not
+// added again if the option is applied more than once.
 func (cfg *config) rigOption(r *rig.Config) error {
-	// BUG: this option should exit if RIG_SOCKET is not set, only the server process should run.
+	c := *cfg
+	c.build.Plugins = slices.Clip(cfg.build.Plugins) // appending copies them instead of sharing the array.
+	c.build.Define = maps.Clone(cfg.build.Define)
+	return c.configure(r)
+}
+
+func (cfg *config) configure(r *rig.Config) error {
 	if cfg.build.Outdir == "" && cfg.build.Outfile == "" {
 		return fmt.Errorf(`esbuild: no output directory or file specified`)
 	}
 	if len(cfg.build.EntryPoints) == 0 {
 		return fmt.Errorf(`esbuild: no entry points specified`)
 	}
+	err := cfg.checkEntryPoints()
+	if err != nil {
+		return err
+	}
+	if cfg.build.Splitting && cfg.build.Format != esbuild.FormatESModule {
+		return fmt.Errorf(`esbuild: code splitting requires Format(FormatESModule)`)
+	}
+	if cfg.rebuildsAffected() && cfg.build.Splitting {
+		return fmt.Errorf(`esbuild: RebuildAffected cannot be used with code splitting`)
+	}
+	if cfg.target != `` {
+		target, ok := targets[strings.ToLower(cfg.target)]
+		if !ok {
+			return fmt.Errorf(`esbuild: unsupported target %q`, cfg.target)
+		}
+		cfg.build.Target = target
+	}
+	cfg.definePublicEnv(os.Environ())
+	if len(cfg.precompress) > 0 {
+		plugin, err := cfg.precompressPlugin()
+		if err != nil {
+			return err
+		}
+		cfg.build.Plugins = append(cfg.build.Plugins, plugin)
+	}
+	if os.Getenv(`RIG_SOCKET`) != `` {
+		return nil // the supervisor is already building, see Rig.
+	}
+	cfg.build.Plugins = append(cfg.build.Plugins, limitPlugin())
 	doneCh := r.Done()
 	errCh := make(chan error)
-	go cfg.buildAndWatch(errCh, doneCh)
+	if cfg.rebuildsAffected() {
+		go cfg.rebuildAffected(r, errCh, doneCh) // which reports each entry point separately.
+	} else {
+		cfg.build.Plugins = append(cfg.build.Plugins, cfg.reportPlugin(r, cfg.reportName()))
+		go cfg.buildAndWatch(errCh, doneCh)
+	}
 	startErr := <-errCh
 	if startErr != nil {
 		return startErr
@@ -61,9 +122,10 @@ func (cfg *config) rigOption(r *rig.Config) error {
 	return nil
 }
 
+// buildAndWatch builds the configured entry points and watches them for changes until doneCh is closed.  The result
+// of starting esbuild, including the watch, is sent to errCh.
 func (cfg *config) buildAndWatch(errCh chan<- error, doneCh <-chan struct{}) {
-	var err error
-	ctx, ctxErr := esbuild.Context(cfg.build)
+	ctx, ctxErr := cfg.context(cfg.build)
 	if ctxErr != nil {
 		printErrors(ctxErr.Errors)
 	}
@@ -71,17 +133,84 @@ func (cfg *config) buildAndWatch(errCh chan<- error, doneCh <-chan struct{}) {
 		errCh <- fmt.Errorf(`esbuild failed to start`)
 		return
 	}
-	errCh <- nil
-	defer ctx.Dispose()
-	ret := esbuild.Build(cfg.build)
+	ret := ctx.Rebuild()
 	printErrors(ret.Errors)
-	err = ctx.Watch(cfg.watch)
+	if cfg.debounce > 0 {
+		cfg.debounceRebuilds(ctx, errCh, doneCh)
+		return
+	}
+	err := ctx.Watch(cfg.watch)
 	if err != nil {
-		panic(err)
+		ctx.Dispose() // before reporting the error, so the rig does not outlive esbuild
+		errCh <- fmt.Errorf(`esbuild: %w while starting watch`, err)
+		return
 	}
+	defer ctx.Dispose()
+	errCh <- nil
 	<-doneCh
 }
 
+// debounceRebuilds watches the source directories and rebuilds once they have been quiet for the debounce interval,
+// until doneCh is closed.  Like buildAndWatch, the result of starting the watch is sent to errCh.
+func (cfg *config) debounceRebuilds(ctx esbuild.BuildContext, errCh chan<- error, doneCh <-chan struct{}) {
+	wr, err := cfg.startWatcher()
+	if err != nil {
+		ctx.Dispose()
+		errCh <- fmt.Errorf(`esbuild: %w while starting watch`, err)
+		return
+	}
+	defer ctx.Dispose()
+	defer wr.Shutdown()
+	errCh <- nil
+
+	for {
+		select {
+		case <-doneCh:
+			return
+		case <-wr.Alert():
+			ret := ctx.Rebuild()
+			printErrors(ret.Errors)
+		}
+	}
+}
+
+// startWatcher starts a watcher for the source directories, which default to the directories of the entry points,
+// excluding hidden files and the output of the build so rebuilds do not trigger more rebuilds.
+func (cfg *config) startWatcher() (watcher.Interface, error) {
+	sources := cfg.sources
+	if len(sources) == 0 {
+		for _, entryPoint := range cfg.build.EntryPoints {
+			sources = append(sources, filepath.Dir(entryPoint))
+		}
+	}
+	dirs := make([]string, 0, len(sources))
+	seen := make(map[string]bool, len(sources))
+	for _, dir := range sources {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sep := string(filepath.Separator)
+	excludes := []string{`**` + sep + `.*`, `**` + sep + `.*` + sep + `**`}
+	for _, output := range []string{cfg.build.Outdir, cfg.build.Outfile} {
+		if output == `` {
+			continue
+		}
+		output, err := filepath.Abs(output)
+		if err != nil {
+			return nil, err
+		}
+		output = glob.QuoteMeta(output)
+		excludes = append(excludes, output, output+sep+`**`)
+	}
+	return watcher.Start(watcher.Directory(dirs...), watcher.Exclude(excludes...), watcher.Debounce(cfg.debounce))
+}
+
 func printErrors(errors []esbuild.Message) {
 	var buf bytes.Buffer
 	for i, err := range errors {
@@ -97,6 +226,38 @@ func printErrors(errors []esbuild.Message) {
 	}
 }
 
+// reportName returns the name used to report the builds of the configuration to the rig, which includes the format, if
+// any, so bundles of different formats written to the same directory are reported separately.
+func (cfg *config) reportName() string {
+	name := `esbuild ` + cfg.build.Outdir
+	if cfg.build.Outdir == `` {
+		name = `esbuild ` + cfg.build.Outfile
+	}
+	if format, ok := formatNames[cfg.build.Format]; ok {
+		name += ` (` + format + `)`
+	}
+	return name
+}
+
+// reportPlugin returns an esbuild plugin that reports the errors of each build to the rig under the given name, so
+// they are served at "/_rig/build" until a build succeeds.
+func (cfg *config) reportPlugin(r *rig.Config, name string) esbuild.Plugin {
+	return esbuild.Plugin{Name: `rig-report`, Setup: func(build esbuild.PluginBuild) {
+		build.OnEnd(func(result *esbuild.BuildResult) (esbuild.OnEndResult, error) {
+			errs := make([]rig.BuildError, 0, len(result.Errors))
+			for _, msg := range result.Errors {
+				err := rig.BuildError{Text: msg.Text}
+				if msg.Location != nil {
+					err.File, err.Line, err.Column = msg.Location.File, msg.Location.Line, msg.Location.Column+1
+				}
+				errs = append(errs, err)
+			}
+			r.ReportBuild(name, errs...)
+			return esbuild.OnEndResult{}, nil
+		})
+	}}
+}
+
 // Output returns a rig option that sets the output directory for the esbuild build.
 func Output(outdir string) Option {
 	return func(cfg *config) { cfg.build.Outdir = outdir }
@@ -107,17 +268,153 @@ func EntryPoint(entryPoints ...string) Option {
 	return func(cfg *config) { cfg.build.EntryPoints = append(cfg.build.EntryPoints, entryPoints...) }
 }
 
+// EntryNames returns a rig option that sets the template used to name the output file for each entry point, such as
+// "[dir]/[name]-[hash]".  Templates may use "[dir]", "[name]", "[hash]" and "[ext]" as described in
+// https://esbuild.github.io/api/#entry-names.  Entry names only apply when an output directory is used.
+//
+// Note that the rig watches the output directory for any change to "*.html", "*.css" and "*.js" files to notify
+// browsers of a rebuild, so hashed names will still trigger a reload.  However, esbuild does not remove outputs from
+// earlier builds, and your pages must discover the new names themselves, e.g. using esbuild's metafile.
+func EntryNames(template string) Option {
+	return func(cfg *config) { cfg.build.EntryNames = template }
+}
+
+// ChunkNames returns a rig option that sets the template used to name shared chunks when code splitting; see
+// EntryNames for how templates interact with the rig.
+func ChunkNames(template string) Option {
+	return func(cfg *config) { cfg.build.ChunkNames = template }
+}
+
+// AssetNames returns a rig option that sets the template used to name assets copied by loaders like "file"; see
+// EntryNames for how templates interact with the rig.
+func AssetNames(template string) Option {
+	return func(cfg *config) { cfg.build.AssetNames = template }
+}
+
 // Bundle returns a rig option that configures esbuild to bundle the output if true, otherwise it will not bundle.
 func Bundle(ok bool) Option {
 	return func(cfg *config) { cfg.build.Bundle = ok }
 }
 
+// Define returns a rig option that replaces global identifiers or property chains in the source with constant
+// expressions, such as "process.env.NODE_ENV" with `"production"`.  Each value is JavaScript, not a plain string, so
+// strings must be quoted as JSON, such as with strconv.Quote or json.Marshal; `"production"` is a string, while
+// `production` refers to a global named production.  Definitions are merged with those of earlier options, replacing
+// any with the same name, and apply to every build and rebuild.
+func Define(definitions map[string]string) Option {
+	return func(cfg *config) {
+		if cfg.build.Define == nil {
+			cfg.build.Define = make(map[string]string, len(definitions))
+		}
+		for name, value := range definitions {
+			cfg.build.Define[name] = value
+		}
+	}
+}
+
+// PublicEnv returns a rig option that defines "process.env.NAME" as a string for each environment variable whose name
+// starts with the given prefix, such as "PUBLIC_" for "PUBLIC_API_URL", so scripts can use them without a bundler
+// plugin.  The environment is read when the rig is configured; changes to it are not noticed by later rebuilds.
+// Variables given to Define take precedence, and anything defined this way ends up in the bundle for anyone to read, so
+// do not use a prefix that matches secrets.
+func PublicEnv(prefix string) Option {
+	return func(cfg *config) { cfg.publicEnv = append(cfg.publicEnv, prefix) }
+}
+
+// definePublicEnv defines the environment variables selected by PublicEnv, unless they have already been defined.
+func (cfg *config) definePublicEnv(environ []string) {
+	for _, item := range environ {
+		name, value, _ := strings.Cut(item, `=`)
+		for _, prefix := range cfg.publicEnv {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			key := `process.env.` + name
+			if _, ok := cfg.build.Define[key]; ok {
+				break
+			}
+			if cfg.build.Define == nil {
+				cfg.build.Define = make(map[string]string)
+			}
+			js, _ := json.Marshal(value)
+			cfg.build.Define[key] = string(js)
+			break
+		}
+	}
+}
+
+// Plugin returns a rig option that adds esbuild plugins to the build, such as a plugin that imports SVG files as
+// components.  Plugins run in the rig's process and are set up once, when the rig starts building, then called for
+// every rebuild, whether the rebuild was started by esbuild's watch or by Debounce.  Plugins run in the order they are
+// given, before the plugins the rig adds for options like Precompress.
+func Plugin(plugins ...esbuild.Plugin) Option {
+	return func(cfg *config) { cfg.build.Plugins = append(cfg.build.Plugins, plugins...) }
+}
+
 // BuildOption returns a rig option that can manipulate the esbuild API build options structure.
 // See https://esbuild.github.io/api for information on how to use esbuild options.
 func BuildOption(fn func(*esbuild.BuildOptions)) Option {
 	return func(cfg *config) { fn(&cfg.build) }
 }
 
+// Minify returns a rig option that configures esbuild to minify whitespace, identifiers and syntax in the output if
+// true.  Minification is off by default so development builds stay readable; it is usually enabled for production
+// builds, perhaps along with SourceMap.
+func Minify(ok bool) Option {
+	return func(cfg *config) {
+		cfg.build.MinifyWhitespace = ok
+		cfg.build.MinifyIdentifiers = ok
+		cfg.build.MinifySyntax = ok
+	}
+}
+
+// Target returns a rig option that sets the version of JavaScript that esbuild writes, such as "es2020", lowering newer
+// syntax so older browsers can run it.  The target may be "esnext" or a year from "es2015" to "es2024", or "es5".  By
+// default, esbuild writes "esnext".  An unsupported target is reported when the rig is configured.
+func Target(target string) Option {
+	return func(cfg *config) { cfg.target = target }
+}
+
+// targets maps the names accepted by Target to esbuild targets.
+var targets = map[string]esbuild.Target{
+	`esnext`: esbuild.ESNext,
+	`es5`:    esbuild.ES5,
+	`es2015`: esbuild.ES2015,
+	`es2016`: esbuild.ES2016,
+	`es2017`: esbuild.ES2017,
+	`es2018`: esbuild.ES2018,
+	`es2019`: esbuild.ES2019,
+	`es2020`: esbuild.ES2020,
+	`es2021`: esbuild.ES2021,
+	`es2022`: esbuild.ES2022,
+	`es2023`: esbuild.ES2023,
+	`es2024`: esbuild.ES2024,
+}
+
+// Source map modes for SourceMap.
+const (
+	SourceMapNone     = esbuild.SourceMapNone
+	SourceMapLinked   = esbuild.SourceMapLinked
+	SourceMapExternal = esbuild.SourceMapExternal
+	SourceMapInline   = esbuild.SourceMapInline
+)
+
+// Debounce returns a rig option that coalesces rapid changes to sources into a single rebuild once no changes have
+// been seen for the given interval, such as when an editor or formatter rewrites several files on save.  Instead of
+// using esbuild's own watch, the rig watches the directories given to Source for changes, which also notices new files
+// that esbuild would not, and the options given to WatchOption are ignored.  An interval of zero, the default, uses
+// esbuild's watch.
+func Debounce(interval time.Duration) Option {
+	return func(cfg *config) { cfg.debounce = interval }
+}
+
+// Source returns a rig option that adds directories to watch recursively for changes when using Debounce.  If no
+// directories are given, the directories containing the entry points are watched.  The output of the build is never
+// watched.
+func Source(dirs ...string) Option {
+	return func(cfg *config) { cfg.sources = append(cfg.sources, dirs...) }
+}
+
 // WatchOption returns a rig option that can manipulate the esbuild API watch options structure.
 // See https://esbuild.github.io/api for information on how to use esbuild options.
 func WatchOption(fn func(*esbuild.WatchOptions)) Option {
@@ -0,0 +1,446 @@
+package esbuild
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/swdunlop/rig-go/rig"
+)
+
+func TestWatchFailure(t *testing.T) {
+	errWatch := errors.New(`watch failed`)
+	fake := &fakeContext{watchErr: errWatch}
+	r, err := rig.New(
+		Rig(
+			Output(t.TempDir()),
+			EntryPoint(entryPoint(t)),
+			func(cfg *config) {
+				cfg.context = func(esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+					return fake, nil
+				}
+			},
+		),
+	)
+	if r != nil {
+		t.Fatal(`expected rig configuration to fail`)
+	}
+	if !errors.Is(err, errWatch) {
+		t.Fatalf(`expected watch error, got %v`, err)
+	}
+	if !fake.disposed {
+		t.Fatal(`expected esbuild context to be disposed`)
+	}
+}
+
+func TestWorkerSkipsBuild(t *testing.T) {
+	for _, test := range []struct {
+		socket   string
+		rebuilds int32
+	}{
+		{``, 1},              // the supervisor, or a rig that is served directly
+		{`/tmp/rig.sock`, 0}, // the worker
+	} {
+		t.Setenv(`RIG_SOCKET`, test.socket)
+		fake := &fakeContext{}
+		_, err := rig.New(Rig(
+			Output(t.TempDir()),
+			EntryPoint(entryPoint(t)),
+			func(cfg *config) {
+				cfg.context = func(esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+					return fake, nil
+				}
+			},
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := fake.rebuilds.Load(); n != test.rebuilds {
+			t.Errorf(`expected %v builds with RIG_SOCKET=%q, got %v`, test.rebuilds, test.socket, n)
+		}
+	}
+}
+
+func TestTarget(t *testing.T) {
+	var build esbuild.BuildOptions
+	_, err := rig.New(Rig(
+		Output(t.TempDir()),
+		EntryPoint(entryPoint(t)),
+		Target(`ES2020`),
+		Minify(true),
+		func(cfg *config) {
+			cfg.context = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+				build = options
+				return &fakeContext{}, nil
+			}
+		},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.Target != esbuild.ES2020 || !build.MinifyWhitespace || !build.MinifyIdentifiers || !build.MinifySyntax {
+		t.Fatalf(`expected an ES2020 target with minification, got %v`, build.Target)
+	}
+	_, err = rig.New(Rig(Output(t.TempDir()), EntryPoint(entryPoint(t)), Target(`es1999`)))
+	if err == nil {
+		t.Fatal(`expected an unsupported target to be rejected`)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	src := t.TempDir()
+	out := filepath.Join(src, `out`) // rebuilds must not be triggered by their own output
+	err := os.Mkdir(out, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeContext{watchErr: errors.New(`esbuild watch should not be used`)}
+	cfg := config{
+		build:    esbuild.BuildOptions{Outdir: out, EntryPoints: []string{filepath.Join(src, `example.ts`)}},
+		debounce: 100 * time.Millisecond,
+		context: func(esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+			return fake, nil
+		},
+	}
+	errCh, doneCh := make(chan error), make(chan struct{})
+	go cfg.buildAndWatch(errCh, doneCh)
+	err = <-errCh
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		err = os.WriteFile(filepath.Join(src, `example.ts`), []byte(`// change`), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(out, `example.js`), []byte(`// output`), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(300 * time.Millisecond)
+	close(doneCh)
+	if n := fake.rebuilds.Load(); n != 2 {
+		t.Fatalf(`expected an initial build and one rebuild, got %v builds`, n)
+	}
+}
+
+func TestRebuildAffected(t *testing.T) {
+	src := t.TempDir()
+	script, style := filepath.Join(src, `app.ts`), filepath.Join(src, `app.css`)
+	for _, name := range []string{script, style} {
+		err := os.WriteFile(name, nil, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fakes := map[string]*fakeContext{
+		script: {metafile: `{"inputs":{"app.ts":{},"util.ts":{}}}`},
+		style:  {metafile: `{"inputs":{"app.css":{}}}`},
+	}
+	cfg := config{
+		build:    esbuild.BuildOptions{AbsWorkingDir: src, Outdir: t.TempDir(), EntryPoints: []string{script, style}},
+		debounce: 50 * time.Millisecond,
+		affected: true,
+		context: func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+			if !options.Metafile || len(options.EntryPoints) != 1 {
+				t.Errorf(`expected one entry point with a metafile, got %v`, options.EntryPoints)
+			}
+			return fakes[options.EntryPoints[0]], nil
+		},
+	}
+	r, err := rig.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh, doneCh := make(chan error), make(chan struct{})
+	go cfg.rebuildAffected(r, errCh, doneCh)
+	err = <-errCh
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(doneCh)
+	change := func(name string, scripts, styles int32) {
+		t.Helper()
+		err := os.WriteFile(name, []byte(`/* change */`), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if n, m := fakes[script].rebuilds.Load(), fakes[style].rebuilds.Load(); n != scripts || m != styles {
+			t.Fatalf(`after changing %v, expected %v and %v builds, got %v and %v`,
+				filepath.Base(name), scripts, styles, n, m)
+		}
+	}
+	change(style, 1, 2)
+	change(script, 2, 2)
+	change(filepath.Join(src, `new.ts`), 3, 3) // unknown files rebuild everything.
+}
+
+type fakeContext struct {
+	watchErr error
+	disposed bool
+	rebuilds atomic.Int32
+	metafile string // returned by each rebuild
+}
+
+func (ctx *fakeContext) Rebuild() esbuild.BuildResult {
+	ctx.rebuilds.Add(1)
+	return esbuild.BuildResult{Metafile: ctx.metafile}
+}
+
+func (ctx *fakeContext) Watch(esbuild.WatchOptions) error { return ctx.watchErr }
+
+func (ctx *fakeContext) Serve(esbuild.ServeOptions) (esbuild.ServeResult, error) {
+	return esbuild.ServeResult{}, errors.New(`not supported`)
+}
+
+func (ctx *fakeContext) Cancel() {}
+
+func (ctx *fakeContext) Dispose() { ctx.disposed = true }
+
+func TestPrecompress(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	entry := filepath.Join(src, `example.ts`)
+	err := os.WriteFile(entry, []byte(`console.log("hello")`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg config
+	Precompress()(&cfg)
+	plugin, err := cfg.precompressPlugin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, ctxErr := esbuild.Context(esbuild.BuildOptions{
+		EntryPoints: []string{entry},
+		Outdir:      out,
+		Write:       true,
+		Plugins:     []esbuild.Plugin{plugin},
+	})
+	if ctxErr != nil {
+		t.Fatal(ctxErr)
+	}
+	defer ctx.Dispose()
+	if ret := ctx.Rebuild(); len(ret.Errors) > 0 {
+		t.Fatal(ret.Errors[0].Text)
+	}
+	for _, name := range []string{`example.js.br`, `example.js.gz`} {
+		info, err := os.Stat(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(time.Hour)
+		err = os.Chtimes(filepath.Join(out, name), later, later)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 {
+			t.Fatalf(`expected %v to have content`, name)
+		}
+	}
+	if ret := ctx.Rebuild(); len(ret.Errors) > 0 {
+		t.Fatal(ret.Errors[0].Text)
+	}
+	info, err := os.Stat(filepath.Join(out, `example.js.gz`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(info.ModTime()) < time.Minute {
+		t.Fatal(`expected unchanged output not to be compressed again`)
+	}
+}
+
+func TestReportErrors(t *testing.T) {
+	src := t.TempDir()
+	entry := filepath.Join(src, `example.ts`)
+	err := os.WriteFile(entry, []byte(`console.log(`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rig.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{build: esbuild.BuildOptions{EntryPoints: []string{entry}, Outdir: t.TempDir(), Write: true}}
+	ctx, ctxErr := esbuild.Context(esbuild.BuildOptions{
+		EntryPoints: cfg.build.EntryPoints,
+		Outdir:      cfg.build.Outdir,
+		Write:       true,
+		Plugins:     []esbuild.Plugin{cfg.reportPlugin(r, cfg.reportName())},
+	})
+	if ctxErr != nil {
+		t.Fatal(ctxErr)
+	}
+	defer ctx.Dispose()
+	ctx.Rebuild()
+	if builds := r.Builds(); len(builds) != 1 || len(builds[0].Errors) == 0 || builds[0].Errors[0].Line != 1 {
+		t.Fatalf(`expected the syntax error to be reported, got %+v`, builds)
+	}
+	err = os.WriteFile(entry, []byte(`console.log("fixed")`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Rebuild()
+	if builds := r.Builds(); len(builds) != 1 || len(builds[0].Errors) != 0 {
+		t.Fatalf(`expected the error to be cleared, got %+v`, builds)
+	}
+}
+
+func TestDefine(t *testing.T) {
+	var cfg config
+	Define(map[string]string{`process.env.NODE_ENV`: `"development"`, `process.env.PUBLIC_MODE`: `"defined"`})(&cfg)
+	PublicEnv(`PUBLIC_`)(&cfg)
+	cfg.definePublicEnv([]string{`PUBLIC_API_URL=https://example.com/"api"`, `PUBLIC_MODE=env`, `SECRET=hunter2`})
+	expect := map[string]string{
+		`process.env.NODE_ENV`:       `"development"`,
+		`process.env.PUBLIC_MODE`:    `"defined"`,
+		`process.env.PUBLIC_API_URL`: `"https://example.com/\"api\""`,
+	}
+	if len(cfg.build.Define) != len(expect) {
+		t.Fatalf(`expected %v definitions, got %v`, len(expect), cfg.build.Define)
+	}
+	for name, value := range expect {
+		if cfg.build.Define[name] != value {
+			t.Errorf(`expected %v to be defined as %v, got %v`, name, value, cfg.build.Define[name])
+		}
+	}
+}
+
+// entryPoint writes an entry point to a temporary directory and returns its path.
+func entryPoint(t *testing.T) string {
+	name := filepath.Join(t.TempDir(), `example.ts`)
+	err := os.WriteFile(name, []byte(`console.log("example")`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestCheckEntryPoints(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{`src/app.ts`, `src/widgets/index.tsx`, `node_modules/@scope/pkg/package.json`} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for entryPoint, ok := range map[string]bool{
+		`src/app.ts`:        true,
+		`./src/app`:         true,
+		`src/widgets`:       true,
+		`@scope/pkg/lib.js`: true,
+		`src/exmaple.ts`:    false,
+		`./missing`:         false,
+		`react`:             false,
+	} {
+		cfg := config{build: esbuild.BuildOptions{AbsWorkingDir: dir, EntryPoints: []string{entryPoint}}}
+		if err := cfg.checkEntryPoints(); (err == nil) != ok {
+			t.Errorf(`expected %q to be found: %v, got %v`, entryPoint, ok, err)
+		}
+	}
+}
+
+func TestMaxBuilds(t *testing.T) {
+	defer func(n int) { MaxBuilds = n }(MaxBuilds)
+	MaxBuilds = 1
+	var running, peak atomic.Int32
+	observe := esbuild.Plugin{Name: `observe`, Setup: func(build esbuild.PluginBuild) {
+		build.OnLoad(esbuild.OnLoadOptions{Filter: `.*`}, func(esbuild.OnLoadArgs) (esbuild.OnLoadResult, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			if n > peak.Load() {
+				peak.Store(n)
+			}
+			time.Sleep(50 * time.Millisecond)
+			return esbuild.OnLoadResult{}, nil
+		})
+	}}
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		ctx, ctxErr := esbuild.Context(esbuild.BuildOptions{
+			EntryPoints: []string{entryPoint(t)},
+			Outdir:      t.TempDir(),
+			Plugins:     []esbuild.Plugin{limitPlugin(), observe},
+		})
+		if ctxErr != nil {
+			t.Fatal(ctxErr)
+		}
+		defer ctx.Dispose()
+		go func() {
+			defer func() { done <- struct{}{} }()
+			ctx.Rebuild()
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	if peak.Load() != 1 {
+		t.Fatalf(`expected one build at a time, got %v`, peak.Load())
+	}
+}
+
+func TestApplyTwice(t *testing.T) {
+	var builds []esbuild.BuildOptions
+	option := Rig(Output(t.TempDir()), EntryPoint(entryPoint(t)), Precompress(`gzip`), func(cfg *config) {
+		cfg.context = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+			builds = append(builds, options)
+			return &fakeContext{}, nil
+		}
+	})
+	for i := 0; i < 2; i++ {
+		_, err := rig.New(option)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, build := range builds {
+		var names []string
+		for _, plugin := range build.Plugins {
+			names = append(names, plugin.Name)
+		}
+		if !slices.Equal(names, []string{`rig-precompress`, `rig-limit`, `rig-report`}) {
+			t.Fatalf(`expected each application to add the plugins once, got %q`, names)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	var builds []esbuild.BuildOptions
+	capture := func(cfg *config) {
+		cfg.context = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+			builds = append(builds, options)
+			return &fakeContext{}, nil
+		}
+	}
+	out, entry := t.TempDir(), entryPoint(t)
+	_, err := rig.New(
+		Rig(Output(out), EntryPoint(entry), Format(FormatESModule), EntryNames(`[name].esm`), capture),
+		Rig(Output(out), EntryPoint(entry), Format(FormatIIFE), capture),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 2 || builds[0].Format != esbuild.FormatESModule || builds[1].Format != esbuild.FormatIIFE {
+		t.Fatalf(`expected an ESM and an IIFE build, got %+v`, builds)
+	}
+	esm := config{build: builds[0]}
+	iife := config{build: builds[1]}
+	if esm.reportName() == iife.reportName() {
+		t.Fatalf(`expected the builds to be reported separately, got %q for both`, esm.reportName())
+	}
+	split := BuildOption(func(options *esbuild.BuildOptions) { options.Splitting = true })
+	_, err = rig.New(Rig(Output(out), EntryPoint(entry), Format(FormatIIFE), split))
+	if err == nil {
+		t.Fatal(`expected code splitting to be rejected without the ESM format`)
+	}
+}
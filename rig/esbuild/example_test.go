@@ -0,0 +1,37 @@
+package esbuild_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig"
+	"github.com/swdunlop/rig-go/rig/esbuild"
+
+	api "github.com/evanw/esbuild/pkg/api"
+)
+
+// This registers a plugin that lets scripts import the time the bundle was built from a virtual module, using
+// `import builtAt from "build:time"`.
+func ExamplePlugin() {
+	buildTime := api.Plugin{
+		Name: `build-time`,
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{Filter: `^build:time$`},
+				func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					return api.OnResolveResult{Path: args.Path, Namespace: `build`}, nil
+				})
+			build.OnLoad(api.OnLoadOptions{Filter: `.*`, Namespace: `build`},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					contents := fmt.Sprintf(`export default %q`, time.Now().Format(time.RFC3339))
+					return api.OnLoadResult{Contents: &contents, Loader: api.LoaderJS}, nil
+				})
+		},
+	}
+	_ = rig.Apply(
+		esbuild.Rig(
+			esbuild.Output(`www`),
+			esbuild.EntryPoint(`example.ts`),
+			esbuild.Plugin(buildTime),
+		),
+	)
+}
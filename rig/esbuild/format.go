@@ -0,0 +1,35 @@
+package esbuild
+
+import esbuild "github.com/evanw/esbuild/pkg/api"
+
+// Format returns a rig option that sets the format of the output:
+//
+//   - FormatDefault, the default, lets esbuild choose, which is FormatIIFE when bundling for the browser.
+//   - FormatIIFE wraps the output in a function for a classic "<script>" tag or older browsers.
+//   - FormatCommonJS writes a CommonJS module, such as for Node.
+//   - FormatESModule writes an ES module for "<script type=module>", and is the only format that allows code splitting.
+//
+// To produce several formats from the same entry point, give the rig an esbuild.Rig option for each, with its own
+// Output or EntryNames so the bundles do not overwrite each other, such as "[name].esm" for the ES module.  Each
+// esbuild.Rig builds, watches and reports its bundle independently: a change to a source shared by both rebuilds both,
+// each in its own esbuild context, errors are reported under the output and format of the bundle that failed, and
+// since the rig watches the outputs of both, browsers reload once either bundle is rebuilt.  MaxBuilds limits how many
+// of the bundles build at the same time.
+func Format(format esbuild.Format) Option {
+	return func(cfg *config) { cfg.build.Format = format }
+}
+
+// Output formats for Format.
+const (
+	FormatDefault  = esbuild.FormatDefault
+	FormatIIFE     = esbuild.FormatIIFE
+	FormatCommonJS = esbuild.FormatCommonJS
+	FormatESModule = esbuild.FormatESModule
+)
+
+// formatNames names the formats in reports, see reportName.
+var formatNames = map[esbuild.Format]string{
+	esbuild.FormatIIFE:     `iife`,
+	esbuild.FormatCommonJS: `cjs`,
+	esbuild.FormatESModule: `esm`,
+}
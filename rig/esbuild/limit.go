@@ -0,0 +1,55 @@
+package esbuild
+
+import (
+	"runtime"
+	"sync"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+// MaxBuilds limits how many esbuild builds run at the same time across every esbuild rig option in the process, so a
+// rig with many bundles does not exhaust the memory of a small machine when a change rebuilds all of them.  Builds
+// beyond the limit wait for another build to finish.  This includes rebuilds started by esbuild's watch and by
+// Debounce.  The default is the number of CPUs; zero or less imposes no limit.  MaxBuilds should be set before any
+// rig is configured.
+var MaxBuilds = runtime.NumCPU()
+
+// builds counts the builds running in the process, see MaxBuilds.
+var builds struct {
+	sync.Mutex
+	cond    *sync.Cond
+	running int
+}
+
+func init() { builds.cond = sync.NewCond(&builds.Mutex) }
+
+// limitPlugin returns an esbuild plugin that waits for room under MaxBuilds at the start of each build and makes room
+// at the end of it; esbuild calls OnEnd for every build, including failed and canceled builds.
+func limitPlugin() esbuild.Plugin {
+	return esbuild.Plugin{Name: `rig-limit`, Setup: func(build esbuild.PluginBuild) {
+		build.OnStart(func() (esbuild.OnStartResult, error) {
+			acquireBuild()
+			return esbuild.OnStartResult{}, nil
+		})
+		build.OnEnd(func(*esbuild.BuildResult) (esbuild.OnEndResult, error) {
+			releaseBuild()
+			return esbuild.OnEndResult{}, nil
+		})
+	}}
+}
+
+func acquireBuild() {
+	builds.Lock()
+	defer builds.Unlock()
+	for MaxBuilds > 0 && builds.running >= MaxBuilds {
+		builds.cond.Wait()
+	}
+	builds.running++
+}
+
+func releaseBuild() {
+	builds.Lock()
+	defer builds.Unlock()
+	builds.running--
+	builds.cond.Signal()
+}
@@ -0,0 +1,105 @@
+package esbuild
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/andybalholm/brotli"
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+// Precompress returns a rig option that writes compressed copies of the JavaScript and CSS outputs next to them after
+// each successful build, such as "app.js.br" and "app.js.gz", so www.Rig can serve them without compressing them for
+// every request.  The supported algorithms are "br" and "gzip"; if none are given, both are used.  Only outputs whose
+// content changed since they were last compressed are compressed again.
+func Precompress(algorithms ...string) Option {
+	if len(algorithms) == 0 {
+		algorithms = []string{`br`, `gzip`}
+	}
+	return func(cfg *config) { cfg.precompress = append(cfg.precompress, algorithms...) }
+}
+
+// compressors maps precompression algorithms to the extension they append and a function that compresses with them.
+var compressors = map[string]struct {
+	ext      string
+	compress func(io.Writer) io.WriteCloser
+}{
+	`br`: {`.br`, func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotli.BestCompression)
+	}},
+	`gzip`: {`.gz`, func(w io.Writer) io.WriteCloser {
+		zw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return zw
+	}},
+}
+
+// precompressPlugin returns an esbuild plugin that compresses the outputs at the end of each successful build.
+func (cfg *config) precompressPlugin() (esbuild.Plugin, error) {
+	for _, algorithm := range cfg.precompress {
+		if _, ok := compressors[algorithm]; !ok {
+			return esbuild.Plugin{}, fmt.Errorf(`esbuild: unsupported precompression algorithm %q`, algorithm)
+		}
+	}
+	hashes := make(map[string]string) // the hash of each output when it was last compressed, by path
+	return esbuild.Plugin{
+		Name: `rig-precompress`,
+		Setup: func(build esbuild.PluginBuild) {
+			build.OnEnd(func(result *esbuild.BuildResult) (esbuild.OnEndResult, error) {
+				if len(result.Errors) > 0 {
+					return esbuild.OnEndResult{}, nil
+				}
+				for _, file := range result.OutputFiles {
+					if !precompressible(file.Path) || hashes[file.Path] == file.Hash {
+						continue
+					}
+					for _, algorithm := range cfg.precompress {
+						err := precompressFile(file, algorithm)
+						if err != nil {
+							return esbuild.OnEndResult{}, err
+						}
+					}
+					hashes[file.Path] = file.Hash
+				}
+				return esbuild.OnEndResult{}, nil
+			})
+		},
+	}, nil
+}
+
+// precompressible returns true if the output is JavaScript or CSS.
+func precompressible(path string) bool {
+	switch filepath.Ext(path) {
+	case `.js`, `.mjs`, `.css`:
+		return true
+	}
+	return false
+}
+
+// precompressFile writes a compressed copy of an output, replacing any earlier copy atomically so it is never served
+// partially written.
+func precompressFile(file esbuild.OutputFile, algorithm string) error {
+	compressor := compressors[algorithm]
+	var buf bytes.Buffer
+	w := compressor.compress(&buf)
+	_, err := w.Write(file.Contents)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return fmt.Errorf(`%w while compressing %v with %v`, err, file.Path, algorithm)
+	}
+	path := file.Path + compressor.ext
+	err = os.WriteFile(path+`.tmp`, buf.Bytes(), 0o644)
+	if err == nil {
+		err = os.Rename(path+`.tmp`, path)
+	}
+	if err != nil {
+		_ = os.Remove(path + `.tmp`)
+		return fmt.Errorf(`%w while writing %v`, err, path)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build deploy
+// +build deploy
+
+package esbuild
+
+import esbuild "github.com/evanw/esbuild/pkg/api"
+
+// SourceMap does nothing in builds with the deploy tag; see the development build for details.
+func SourceMap(mode esbuild.SourceMap) Option {
+	return func(cfg *config) { cfg.build.Sourcemap = esbuild.SourceMapNone }
+}
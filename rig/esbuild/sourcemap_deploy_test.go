@@ -0,0 +1,28 @@
+//go:build deploy
+// +build deploy
+
+package esbuild
+
+import (
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/swdunlop/rig-go/rig"
+)
+
+func TestSourceMap(t *testing.T) {
+	var build esbuild.BuildOptions
+	capture := func(cfg *config) {
+		cfg.context = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+			build = options
+			return &fakeContext{}, nil
+		}
+	}
+	_, err := rig.New(Rig(Output(t.TempDir()), EntryPoint(entryPoint(t)), SourceMap(SourceMapLinked), capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.Sourcemap != SourceMapNone {
+		t.Fatalf(`expected deploy builds to write no source maps, got %v`, build.Sourcemap)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !deploy
+// +build !deploy
+
+package esbuild
+
+import esbuild "github.com/evanw/esbuild/pkg/api"
+
+// SourceMap returns a rig option that controls whether esbuild writes source maps for the output, and how they are
+// found by browsers:
+//
+//   - SourceMapNone, the default, writes no source maps.
+//   - SourceMapLinked writes a ".map" file next to each output with a comment linking to it.
+//   - SourceMapExternal writes a ".map" file without linking to it.
+//   - SourceMapInline embeds the source map in a comment at the end of each output.
+//
+// This option does nothing in builds with the deploy tag, which never write source maps.
+func SourceMap(mode esbuild.SourceMap) Option {
+	return func(cfg *config) { cfg.build.Sourcemap = mode }
+}
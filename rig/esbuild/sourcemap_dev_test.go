@@ -0,0 +1,58 @@
+//go:build !deploy
+// +build !deploy
+
+package esbuild
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/swdunlop/rig-go/rig"
+)
+
+func TestSourceMap(t *testing.T) {
+	for _, test := range []struct {
+		mode    esbuild.SourceMap
+		mapFile bool // true if a ".map" file is written
+		inline  bool // true if the output embeds the source map
+		linked  bool // true if the output links to the ".map" file
+	}{
+		{SourceMapNone, false, false, false},
+		{SourceMapLinked, true, false, true},
+		{SourceMapExternal, true, false, false},
+		{SourceMapInline, false, true, false},
+	} {
+		var build esbuild.BuildOptions
+		capture := func(cfg *config) {
+			cfg.context = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+				build = options
+				return &fakeContext{}, nil
+			}
+		}
+		out := t.TempDir()
+		_, err := rig.New(Rig(Output(out), EntryPoint(entryPoint(t)), SourceMap(test.mode), capture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := esbuild.Build(build)
+		if len(ret.Errors) > 0 {
+			t.Fatalf(`unexpected errors %v`, ret.Errors)
+		}
+		js, err := os.ReadFile(filepath.Join(out, `example.js`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = os.Stat(filepath.Join(out, `example.js.map`))
+		switch {
+		case (err == nil) != test.mapFile:
+			t.Errorf(`expected a map file for mode %v: %v, got %v`, test.mode, test.mapFile, err)
+		case strings.Contains(string(js), `sourceMappingURL=data:`) != test.inline:
+			t.Errorf(`expected an inline source map for mode %v: %v, got %s`, test.mode, test.inline, js)
+		case strings.Contains(string(js), `sourceMappingURL=example.js.map`) != test.linked:
+			t.Errorf(`expected a link to the map file for mode %v: %v, got %s`, test.mode, test.linked, js)
+		}
+	}
+}
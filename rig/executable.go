@@ -0,0 +1,121 @@
+package rig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/swdunlop/html-go/hog"
+)
+
+// An ExecutableError reports that the worker executable given to Spawn could not be started, distinguishing an
+// executable that does not exist from one that may not be executed.
+type ExecutableError struct {
+	Path string // The path of the executable.
+	Err  error  // The error from starting it.
+}
+
+func (err *ExecutableError) Error() string {
+	switch {
+	case errors.Is(err.Err, fs.ErrNotExist):
+		return fmt.Sprintf(`worker executable %q not found`, err.Path)
+	case errors.Is(err.Err, fs.ErrPermission):
+		return fmt.Sprintf(`worker executable %q is not executable, permission denied`, err.Path)
+	default:
+		return fmt.Sprintf(`cannot start worker executable %q: %v`, err.Path, err.Err)
+	}
+}
+
+func (err *ExecutableError) Unwrap() error { return err.Err }
+
+// missing returns true if the executable does not exist or is not executable, which may be fixed while waiting.
+func (err *ExecutableError) missing() bool {
+	return errors.Is(err.Err, fs.ErrNotExist) || errors.Is(err.Err, fs.ErrPermission)
+}
+
+// AwaitExecutable returns an option that keeps the supervisor serving when the worker executable given to Spawn does
+// not exist or is not executable, such as when the path of a build output is wrong, instead of returning an
+// ExecutableError.  Until the executable can be started, the supervisor answers requests it would proxy to the worker
+// with 503 Service Unavailable and the reason, and tries to start the worker again every interval.  Endpoints the
+// supervisor serves itself, like the log buffer, keep working.  An interval of zero, the default, returns the error.
+func AwaitExecutable(interval time.Duration) Option {
+	return func(cfg *Config) error {
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.awaitExecutable = interval
+		return nil
+	}
+}
+
+// awaitWorker returns a worker that calls start every interval until it starts the worker process, then stands in for
+// that process until it exits or the worker is stopped.  The returned handler serves the maintenance response for
+// Spawn until the process has started, then passes requests to next.
+func awaitWorker(
+	ctx context.Context, interval time.Duration, cause error, start func(context.Context) (*worker, error), next http.Handler,
+) (*worker, http.Handler) {
+	ctx, cancel := context.WithCancel(ctx)
+	wk := &worker{cancel: cancel, doneCh: make(chan struct{})}
+	mh := &maintenanceHandler{next: next, cause: cause, retry: interval}
+	hog.From(ctx).Error().Err(cause).Dur(`interval`, interval).Msg(`waiting for the worker executable`)
+	go func() {
+		defer close(wk.doneCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			started, err := start(ctx)
+			if err != nil {
+				if err.Error() != mh.reason().Error() {
+					hog.From(ctx).Error().Err(err).Msg(`waiting for the worker executable`)
+				}
+				mh.setReason(err)
+				continue
+			}
+			hog.From(ctx).Info().Msg(`started the worker executable`)
+			mh.setReason(nil)
+			<-started.done() // the process is killed by its context when the worker is stopped.
+			wk.err = started.err
+			return
+		}
+	}()
+	return wk, mh
+}
+
+// A maintenanceHandler answers requests with 503 Service Unavailable while the worker executable cannot be started.
+type maintenanceHandler struct {
+	next  http.Handler
+	retry time.Duration
+
+	control sync.Mutex
+	cause   error // nil once the worker has started
+}
+
+func (mh *maintenanceHandler) reason() error {
+	mh.control.Lock()
+	defer mh.control.Unlock()
+	return mh.cause
+}
+
+func (mh *maintenanceHandler) setReason(err error) {
+	mh.control.Lock()
+	defer mh.control.Unlock()
+	mh.cause = err
+}
+
+func (mh *maintenanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := mh.reason()
+	if err == nil {
+		mh.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set(`Retry-After`, strconv.Itoa(max(1, int(mh.retry/time.Second))))
+	w.Header().Set(`Cache-Control`, `no-store`)
+	http.Error(w, fmt.Sprintf("%v, waiting for it to be fixed", err), http.StatusServiceUnavailable)
+}
@@ -0,0 +1,112 @@
+package rig
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecutableError(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, `plain`)
+	err := os.WriteFile(plain, []byte("#!/bin/sh\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]string{
+		filepath.Join(dir, `missing`): `not found`,
+		plain:                         `permission denied`,
+	} {
+		_, err := startWorker(context.Background(), filepath.Join(dir, `socket`), nil, name, nil, io.Discard, io.Discard)
+		var execErr *ExecutableError
+		if !errors.As(err, &execErr) || !execErr.missing() {
+			t.Fatalf(`expected an ExecutableError for %v, got %v`, name, err)
+		}
+		if !strings.Contains(err.Error(), name) || !strings.Contains(err.Error(), expect) {
+			t.Errorf(`expected %q to mention %v and %q`, err, name, expect)
+		}
+	}
+
+	cfg, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Spawn(context.Background(), filepath.Join(dir, `missing`))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf(`expected Spawn to fail with a missing executable, got %v`, err)
+	}
+}
+
+func TestAwaitExecutable(t *testing.T) {
+	lr := &testListener{addr: make(chan string, 1)}
+	cfg, err := New(
+		func(cfg *Config) error { cfg.Hook(lr); return nil },
+		AwaitExecutable(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	spawned := make(chan error, 1)
+	go func() { spawned <- cfg.Spawn(ctx, filepath.Join(t.TempDir(), `missing`)) }()
+	addr := <-lr.addr
+	rsp, err := http.Get(`http://` + addr + `/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), `not found`) {
+		t.Fatalf(`expected 503 explaining the missing executable, got %v %q`, rsp.StatusCode, body)
+	}
+	cancel()
+	err = <-spawned
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAwaitWorker(t *testing.T) {
+	sh, err := shellPath(`/bin/sh`)
+	if err != nil {
+		t.Skip(err)
+	}
+	var calls atomic.Int32
+	start := func(ctx context.Context) (*worker, error) {
+		if calls.Add(1) < 3 {
+			return nil, &ExecutableError{Path: `missing`, Err: fs.ErrNotExist}
+		}
+		return startWorker(ctx, ``, nil, sh, []string{`-c`, `exec sleep 60`}, io.Discard, io.Discard)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	cause := &ExecutableError{Path: `missing`, Err: fs.ErrNotExist}
+	wk, handler := awaitWorker(context.Background(), 10*time.Millisecond, cause, start, next)
+	status := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(`GET`, `/`, nil))
+		return w.Code
+	}
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Fatalf(`expected 503 before the worker starts, got %v`, code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for status() != http.StatusNoContent {
+		if time.Now().After(deadline) {
+			t.Fatal(`expected requests to reach the worker once it started`)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	wk.stop()
+	if calls.Load() != 3 {
+		t.Fatalf(`expected three attempts to start the worker, got %v`, calls.Load())
+	}
+}
@@ -0,0 +1,99 @@
+package rig
+
+import (
+	"net"
+	"net/http/httputil"
+	"net/netip"
+	"strings"
+)
+
+// TrustForwarded returns an option that makes the supervisor keep the X-Forwarded-For, X-Forwarded-Proto,
+// X-Forwarded-Host and Forwarded headers of requests from the given proxies, such as a load balancer in front of the
+// rig, adding to them instead of replacing them, so the worker can find the client behind both proxies.  Requests from
+// any other address have those headers replaced with what the supervisor saw, so clients cannot forge them.  The
+// default is to trust no proxies.  This has no effect on rigs that are served directly; use api.RealIP in the worker
+// to read the client's address back from the headers.
+func TrustForwarded(proxies ...netip.Prefix) Option {
+	return func(cfg *Config) error {
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.trustedProxies = append(cfg.trustedProxies, proxies...)
+		return nil
+	}
+}
+
+// forward sets the forwarding headers of a request proxied to the worker, see workerProxy and TrustForwarded.
+func forward(pr *httputil.ProxyRequest, trusted []netip.Prefix) {
+	in, out := pr.In.Header, pr.Out.Header
+	relayed := trustsAddr(trusted, pr.In.RemoteAddr)
+	if relayed {
+		// ReverseProxy removes these from the outbound request before Rewrite, and SetXForwarded adds to the first.
+		out[`X-Forwarded-For`] = in[`X-Forwarded-For`]
+	}
+	pr.SetXForwarded()
+	if relayed {
+		for _, name := range []string{`X-Forwarded-Proto`, `X-Forwarded-Host`} {
+			if value := in.Get(name); value != `` {
+				out.Set(name, value)
+			}
+		}
+	}
+	element := forwardedElement(pr)
+	if prior := strings.Join(in.Values(`Forwarded`), `, `); relayed && prior != `` {
+		element = prior + `, ` + element
+	}
+	out.Set(`Forwarded`, element)
+}
+
+// forwardedElement describes the request as an element of a Forwarded header, as described by RFC 7239.
+func forwardedElement(pr *httputil.ProxyRequest) string {
+	proto := `http`
+	if pr.In.TLS != nil {
+		proto = `https`
+	}
+	element := `proto=` + proto + `;host=` + forwardedValue(pr.In.Host)
+	if host, _, err := net.SplitHostPort(pr.In.RemoteAddr); err == nil {
+		if strings.Contains(host, `:`) {
+			host = `[` + host + `]` // IPv6 addresses are bracketed, see RFC 7239 section 6.
+		}
+		element = `for=` + forwardedValue(host) + `;` + element
+	}
+	return element
+}
+
+// forwardedValue returns a value for a Forwarded header, quoting it unless it is a token.
+func forwardedValue(value string) string {
+	for _, ch := range value {
+		if !isTokenChar(ch) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+		}
+	}
+	return value
+}
+
+// isTokenChar returns true if ch may appear in a token, as described by RFC 7230 section 3.2.6.
+func isTokenChar(ch rune) bool {
+	switch {
+	case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", ch)
+}
+
+// trustsAddr returns true if the address, such as the RemoteAddr of a request, is in one of the trusted prefixes.
+func trustsAddr(trusted []netip.Prefix, addr string) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package rig
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+)
+
+// LogGeneration returns an option that adds a "gen" field with the Generation of the worker to every line logged by
+// the global zerolog logger of the worker, so the output of successive workers can be told apart when it interleaves,
+// such as while a worker is restarted after every change.  Since it tags the global logger in place, it should be
+// given after any option that replaces the global logger.  It has no effect in a supervisor or when the rig is served
+// directly, which have no generation.
+func LogGeneration() Option {
+	return func(cfg *Config) error {
+		gen := Generation()
+		if gen == 0 {
+			return nil
+		}
+		log := zlog.Logger.With().Uint64(`gen`, gen).Logger()
+		zlog.Logger = log
+		if zerolog.DefaultContextLogger != nil {
+			log := zerolog.DefaultContextLogger.With().Uint64(`gen`, gen).Logger()
+			zerolog.DefaultContextLogger = &log
+		}
+		return nil
+	}
+}
+
+// Generation returns the generation of the worker, which the supervisor passes in RIG_GENERATION, or zero if the
+// process is not a worker.  Each worker started by a supervisor has a generation one higher than the last, starting
+// at 1.  A new supervisor that takes over with Handover continues counting from the generation of the old supervisor,
+// but a supervisor that is simply restarted starts again at 1.
+func Generation() uint64 {
+	gen, _ := strconv.ParseUint(os.Getenv(`RIG_GENERATION`), 10, 64)
+	return gen
+}
+
+// generations counts the workers started by this supervisor, see Generation.
+var generations atomic.Uint64
+
+// startedWorker is true once this supervisor has started a worker, see nextGeneration.
+var startedWorker atomic.Bool
+
+// nextGeneration returns the environment variables that pass the generation of a new worker, and mark the first worker
+// started by this supervisor with RIG_FIRST_WORKER, see Migrate.
+func nextGeneration() []string {
+	env := []string{`RIG_GENERATION=` + strconv.FormatUint(generations.Add(1), 10)}
+	if !startedWorker.Swap(true) {
+		env = append(env, `RIG_FIRST_WORKER=1`)
+	}
+	return env
+}
+
+// WorkerStarts returns the number of workers this supervisor has started, including those counted by the supervisor it
+// took over from with Handover, so every start after the first is a restart.  It is zero in a worker or when the rig is
+// served directly.
+func WorkerStarts() uint64 { return generations.Load() }
@@ -0,0 +1,35 @@
+package rig
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+)
+
+func TestLogGeneration(t *testing.T) {
+	saved, savedDefault := zlog.Logger, zerolog.DefaultContextLogger
+	defer func() { zlog.Logger, zerolog.DefaultContextLogger = saved, savedDefault }()
+	var buf bytes.Buffer
+	zlog.Logger = zerolog.New(&buf)
+	zerolog.DefaultContextLogger = nil
+
+	_, err := New(LogGeneration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	zlog.Info().Msg(`supervisor`)
+	t.Setenv(`RIG_GENERATION`, `3`)
+	_, err = New(LogGeneration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	zlog.Info().Msg(`worker`)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], `"gen"`) || !strings.Contains(lines[1], `"gen":3`) {
+		t.Fatalf(`expected only the worker line to have a generation, got %q`, lines)
+	}
+}
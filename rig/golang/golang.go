@@ -4,11 +4,322 @@
 // in production builds.
 package golang
 
-import "github.com/swdunlop/rig-go/rig"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig"
+	"github.com/swdunlop/rig-go/rig/hook"
+	"github.com/swdunlop/rig-go/rig/watcher"
+)
 
 // Rig returns a rig option that configures a rig to proxy any unhandled requests to a subprocess running the given Go package.
 // This subprocess should listen for Unix domain socket connections on the path specified by the RIG_SOCKET environment variable.
 // When any file in the package changes, the subprocess will be rebuilt and restarted.
-func Rig(pkg string) rig.Option {
-	panic(`TODO`)
+//
+// The package is built with "go build" in the current directory when the rig starts serving, and its directory is
+// watched recursively for changes to Go source files and modules, coalescing bursts of changes into a single rebuild.
+// Changes to dependencies outside of that directory are not noticed.  While the package is building, requests fail
+// with 503 Service Unavailable, and if the build fails, requests fail with 502 Bad Gateway and the output of the build
+// until it is fixed, unless a Maintenance site is provided.
+func Rig(pkg string, options ...Option) rig.Option {
+	return func(r *rig.Config) error {
+		rn := &runner{pkg: pkg}
+		for _, option := range options {
+			err := option(rn)
+			if err != nil {
+				return err
+			}
+		}
+		r.Hook(rn)
+		return nil
+	}
+}
+
+// An Option adjusts how a Go package is built and run.
+type Option func(*runner) error
+
+// Maintenance returns an option that serves a static site from fsys while there is no subprocess to proxy to because
+// the package failed to build or start, instead of a plain 502 Bad Gateway.  Files in fsys are served as they are, and
+// any other request gets a 502 Bad Gateway response rendered from the "index.html" template in fsys, which must exist.
+//
+// The template is executed with a MaintenanceInfo, so it can explain what went wrong, such as:
+//
+//	<h1>{{.Package}} is being fixed</h1>
+//	<pre>{{.Failure}}</pre>
+func Maintenance(fsys fs.FS) Option {
+	return func(rn *runner) error {
+		page, err := template.ParseFS(fsys, `index.html`)
+		if err != nil {
+			return fmt.Errorf(`%w in maintenance site`, err)
+		}
+		rn.maintenance, rn.maintenancePage = fsys, page
+		return nil
+	}
+}
+
+// MaintenanceInfo is provided to the "index.html" template of a Maintenance site.
+type MaintenanceInfo struct {
+	Package string // The package that could not be built or started.
+	Failure string // The output of the build, or why the subprocess could not start.
+}
+
+// A runner builds and runs a Go package, proxying requests to it.
+type runner struct {
+	pkg             string
+	maintenance     fs.FS              // served instead of failures, if not nil
+	maintenancePage *template.Template // parsed from "index.html" in maintenance
+
+	control sync.Mutex
+	dir     string                 // temporary directory for the binary and socket
+	proxy   *httputil.ReverseProxy // nil until the subprocess has started
+	process *exec.Cmd              // nil if the subprocess is not running
+	exited  chan struct{}          // closed when process has exited and been reaped
+	failure string                 // output of the last build, if it failed
+	started uint64                 // how many subprocesses have been started, see rig.Generation
+}
+
+var (
+	_ hook.BeforeServe = (*runner)(nil)
+	_ hook.Mux         = (*runner)(nil)
+	_ hook.Routes      = (*runner)(nil)
+)
+
+// RigBeforeServe implements hook.BeforeServe by starting to build the package and watching it for changes.
+func (rn *runner) RigBeforeServe(ctx context.Context) error {
+	src, err := packageDir(ctx, rn.pkg)
+	if err != nil {
+		return err
+	}
+	wr, err := watcher.Start(
+		watcher.Directory(src),
+		watcher.Include(`**.go`, `**go.mod`, `**go.sum`),
+		watcher.Debounce(100*time.Millisecond),
+	)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(``, `rig-golang`)
+	if err != nil {
+		wr.Shutdown()
+		return err
+	}
+	rn.control.Lock()
+	rn.dir = dir
+	rn.control.Unlock()
+	go rn.run(ctx, wr)
+	return nil
+}
+
+// RigMux implements hook.Mux by proxying any requests not handled by other hooks to the subprocess.
+func (rn *runner) RigMux(mux *http.ServeMux) { mux.Handle(`/`, rn) }
+
+// RigRoutes implements hook.Routes.
+func (rn *runner) RigRoutes() []hook.Route {
+	return []hook.Route{{Pattern: `/`, Handler: fmt.Sprintf(`golang.Rig(%q)`, rn.pkg)}}
+}
+
+// ServeHTTP proxies the request to the subprocess, or explains why it cannot.
+func (rn *runner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rn.control.Lock()
+	proxy, failure := rn.proxy, rn.failure
+	rn.control.Unlock()
+	switch {
+	case failure != `` && rn.maintenance != nil:
+		rn.serveMaintenance(w, r, failure)
+	case failure != ``:
+		http.Error(w, fmt.Sprintf("failed to build %v:\n\n%v", rn.pkg, failure), http.StatusBadGateway)
+	case proxy == nil:
+		http.Error(w, fmt.Sprintf(`building %v, please try again`, rn.pkg), http.StatusServiceUnavailable)
+	default:
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// serveMaintenance serves a file from the maintenance site, or its "index.html" template explaining the failure.
+func (rn *runner) serveMaintenance(w http.ResponseWriter, r *http.Request, failure string) {
+	w.Header().Set(`Cache-Control`, `no-store`) // so the site is not confused with the package once it is fixed.
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), `/`)
+	if info, err := fs.Stat(rn.maintenance, name); err == nil && !info.IsDir() && name != `index.html` {
+		http.ServeFileFS(w, r, rn.maintenance, name)
+		return
+	}
+	var buf bytes.Buffer
+	err := rn.maintenancePage.Execute(&buf, MaintenanceInfo{Package: rn.pkg, Failure: failure})
+	if err != nil {
+		hog.For(r).Error().Err(err).Msg(`failed to render maintenance page`)
+		http.Error(w, fmt.Sprintf("failed to build %v:\n\n%v", rn.pkg, failure), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set(`Content-Type`, `text/html; charset=utf-8`)
+	w.WriteHeader(http.StatusBadGateway)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// run builds and starts the subprocess, rebuilding it when the watcher alerts, until the context is done.
+func (rn *runner) run(ctx context.Context, wr watcher.Interface) {
+	defer func() {
+		wr.Shutdown()
+		rn.stop()
+		rn.control.Lock()
+		defer rn.control.Unlock()
+		_ = os.RemoveAll(rn.dir)
+	}()
+	for {
+		rn.rebuild(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-wr.Alert():
+		}
+	}
+}
+
+// rebuild builds the package and, if that succeeds, replaces the subprocess with the new build.
+func (rn *runner) rebuild(ctx context.Context) {
+	rn.control.Lock()
+	dir := rn.dir
+	rn.control.Unlock()
+	bin := filepath.Join(dir, `bin`)
+	start := time.Now()
+	out, err := exec.CommandContext(ctx, `go`, `build`, `-o`, bin, rn.pkg).CombinedOutput()
+	if ctx.Err() != nil {
+		return
+	}
+	rn.stop()
+	if err != nil {
+		hog.From(ctx).Error().Err(err).Str(`pkg`, rn.pkg).Msg(`build failed`)
+		failure := strings.TrimSpace(string(out))
+		if failure == `` {
+			failure = err.Error()
+		}
+		rn.control.Lock()
+		rn.failure = failure
+		rn.control.Unlock()
+		return
+	}
+	hog.From(ctx).Info().Str(`pkg`, rn.pkg).Dur(`elapsed`, time.Since(start)).Msg(`built`)
+	err = rn.start(ctx, bin, filepath.Join(dir, `socket`))
+	if err != nil {
+		hog.From(ctx).Error().Err(err).Str(`pkg`, rn.pkg).Msg(`failed to start`)
+		rn.control.Lock()
+		rn.failure = err.Error()
+		rn.control.Unlock()
+	}
+}
+
+// start starts the subprocess listening to the given socket and begins proxying requests to it.
+func (rn *runner) start(ctx context.Context, bin, socket string) error {
+	_ = os.Remove(socket) // in case the last subprocess did not clean up.
+	rn.control.Lock()
+	rn.started++
+	gen := rn.started
+	rn.control.Unlock()
+	cmd := exec.CommandContext(ctx, bin)
+	cmd.Env = append(workerEnv(), `RIG_SOCKET=`+socket, `RIG_GENERATION=`+strconv.FormatUint(gen, 10))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		err := cmd.Wait()
+		if ctx.Err() == nil {
+			hog.From(ctx).Info().Err(err).Str(`pkg`, rn.pkg).Msg(`exited`)
+		}
+	}()
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: `http`, Host: `rig`})
+	proxy.Transport = &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialSocket(ctx, socket, exited)
+	}}
+	rn.control.Lock()
+	defer rn.control.Unlock()
+	rn.process, rn.exited, rn.proxy, rn.failure = cmd, exited, proxy, ``
+	return nil
+}
+
+// stop kills the subprocess, if it is running, and waits for it to be reaped.
+func (rn *runner) stop() {
+	rn.control.Lock()
+	process, exited := rn.process, rn.exited
+	rn.process, rn.exited, rn.proxy = nil, nil, nil
+	rn.control.Unlock()
+	if process == nil {
+		return
+	}
+	_ = process.Process.Kill()
+	<-exited
+}
+
+// dialSocket connects to the socket of a subprocess, waiting for the subprocess to listen if it has just started.
+func dialSocket(ctx context.Context, socket string, exited <-chan struct{}) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, `unix`, socket)
+		if err == nil {
+			return conn, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf(`%w while waiting for %v`, ctx.Err(), socket)
+		case <-exited:
+			return nil, fmt.Errorf(`subprocess exited without listening to %v`, socket)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// workerEnv returns the environment for the subprocess, without the variables that describe our own socket and
+// generation.
+func workerEnv() []string {
+	env := os.Environ()
+	ret := env[:0:0]
+	for _, item := range env {
+		if strings.HasPrefix(item, `RIG_SOCKET=`) || strings.HasPrefix(item, `RIG_SOCKET_FD=`) ||
+			strings.HasPrefix(item, `RIG_GENERATION=`) {
+			continue
+		}
+		ret = append(ret, item)
+	}
+	return ret
+}
+
+// packageDir returns the directory of the given package.
+func packageDir(ctx context.Context, pkg string) (string, error) {
+	out, err := exec.CommandContext(ctx, `go`, `list`, `-f`, `{{.Dir}}`, pkg).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return ``, fmt.Errorf(`%v while locating %v`, strings.TrimSpace(string(exitErr.Stderr)), pkg)
+		}
+		return ``, fmt.Errorf(`%w while locating %v`, err, pkg)
+	}
+	return strings.TrimSpace(string(out)), nil
 }
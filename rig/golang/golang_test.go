@@ -0,0 +1,146 @@
+package golang
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig"
+)
+
+func TestMaintenance(t *testing.T) {
+	rn := &runner{pkg: `example.com/app`, failure: `undefined: oops`}
+	err := Maintenance(fstest.MapFS{
+		`index.html`: {Data: []byte(`<h1>{{.Package}}</h1><pre>{{.Failure}}</pre>`)},
+		`style.css`:  {Data: []byte(`h1 { color: red }`)},
+	})(rn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{`/`, http.StatusBadGateway, `<h1>example.com/app</h1><pre>undefined: oops</pre>`},
+		{`/some/page`, http.StatusBadGateway, `<h1>example.com/app</h1>`},
+		{`/style.css`, http.StatusOK, `color: red`},
+	} {
+		w := httptest.NewRecorder()
+		rn.ServeHTTP(w, httptest.NewRequest(`GET`, test.path, nil))
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf(`expected %v to get %v with %q, got %v with %q`, test.path, test.status, test.body, w.Code, w.Body)
+		}
+	}
+}
+
+func TestMaintenanceRequiresIndex(t *testing.T) {
+	err := Maintenance(fstest.MapFS{`style.css`: {}})(&runner{})
+	if err == nil {
+		t.Fatal(`expected a maintenance site without an index.html to be rejected`)
+	}
+}
+
+func TestRig(t *testing.T) {
+	if testing.Short() {
+		t.Skip(`builds a package with the Go toolchain`)
+	}
+	if _, err := exec.LookPath(`go`); err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	write := func(name, content string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := func(reply string) string {
+		return `package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+)
+
+func main() {
+	lr, err := net.Listen("unix", os.Getenv("RIG_SOCKET"))
+	if err != nil {
+		panic(err)
+	}
+	panic(http.Serve(lr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("` + reply + ` " + os.Getenv("RIG_GENERATION")))
+	})))
+}
+`
+	}
+	write(`go.mod`, "module example.com/app\n\ngo 1.22\n")
+	write(`main.go`, app(`first`))
+
+	// The package is built in the current directory, like the rig of a project would be.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	cfg, err := rig.New(Rig(`.`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr, err := net.Listen(`tcp`, `localhost:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- cfg.ServeListener(ctx, lr) }()
+	defer func() {
+		cancel()
+		<-errCh
+	}()
+
+	// await polls the rig until it responds with the given status and a body containing expect.
+	await := func(status int, expect string) {
+		t.Helper()
+		var code int
+		var body []byte
+		for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+			rsp, err := http.Get(`http://` + lr.Addr().String() + `/`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ = io.ReadAll(rsp.Body)
+			rsp.Body.Close()
+			code = rsp.StatusCode
+			if code == status && strings.Contains(string(body), expect) {
+				return
+			}
+		}
+		t.Fatalf(`expected %v with %q, got %v with %q`, status, expect, code, body)
+	}
+	await(http.StatusOK, `first 1`)
+	write(`main.go`, app(`second`))
+	await(http.StatusOK, `second 2`)
+	write(`main.go`, `package main
+
+func main() { undefined() }
+`)
+	await(http.StatusBadGateway, `undefined: undefined`)
+	write(`main.go`, app(`fixed`))
+	await(http.StatusOK, `fixed 3`)
+}
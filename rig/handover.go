@@ -0,0 +1,230 @@
+package rig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/swdunlop/html-go/hog"
+)
+
+// Handover returns an option that lets a supervisor be replaced without closing its listeners, such as after its
+// executable has been upgraded.  When a supervisor started by Run receives SIGHUP, it starts its executable again with
+// the same arguments as a new supervisor that inherits its listening sockets.  Once the new supervisor has started its
+// own worker, the old supervisor stops accepting connections, waits up to drain for the requests in flight to finish,
+// then stops its worker and returns.  If drain is zero, it waits for as long as the requests take.  If the new
+// supervisor fails before it is ready, the old supervisor logs why and keeps serving.
+//
+// The new supervisor is started with RIG_HANDOVER in its environment, which is the number of listeners it inherits.
+// The listening sockets are passed as file descriptors starting at 3, in the order the Listen hooks were applied,
+// followed by the write end of a pipe; the new supervisor writes a byte to the pipe once it is ready.  A new supervisor
+// uses the inherited sockets in place of its own Listen hooks, so the listeners must not change between versions.  A
+// handover is refused if any listener cannot be passed as a file descriptor, such as a tailscale listener.
+//
+// Handover has no effect on rigs that are served directly, or on workers.
+func Handover(drain time.Duration) Option {
+	return func(cfg *Config) error {
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.handover, cfg.drain = true, drain
+		return nil
+	}
+}
+
+// handoverDrain returns whether Handover was given, and its drain period.
+func (cfg *Config) handoverDrain() (bool, time.Duration) {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	return cfg.handover, cfg.drain
+}
+
+// handoverTimeout limits how long an old supervisor waits for a new supervisor to be ready.
+const handoverTimeout = time.Minute
+
+// inherit takes the listeners passed by an old supervisor if RIG_HANDOVER is set, returning the pipe used to tell the
+// old supervisor that we are ready.  The listeners are used by listen, in place of the Listen hooks.
+func (cfg *Config) inherit() (ready *os.File, err error) {
+	spec, ok := os.LookupEnv(`RIG_HANDOVER`)
+	if !ok {
+		return nil, nil
+	}
+	_ = os.Unsetenv(`RIG_HANDOVER`) // so the worker and any later supervisor do not think they inherit it too.
+	if gen, err := strconv.ParseUint(os.Getenv(`RIG_GENERATION`), 10, 64); err == nil {
+		generations.Store(gen)
+		_ = os.Unsetenv(`RIG_GENERATION`) // our workers get their own.
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil {
+		return nil, fmt.Errorf(`%w in RIG_HANDOVER`, err)
+	}
+	ready = os.NewFile(uintptr(3+n), `handover`)
+	if ready == nil {
+		return nil, fmt.Errorf(`invalid RIG_HANDOVER %v`, n)
+	}
+	closeOnExec(ready)
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		file := os.NewFile(uintptr(3+i), `listener`)
+		var lr net.Listener
+		if file != nil {
+			lr, err = net.FileListener(file) // a duplicate that will not be inherited by the worker.
+			file.Close()
+		}
+		if file == nil || err != nil {
+			for _, lr := range listeners {
+				_ = lr.Close()
+			}
+			ready.Close() // which tells the old supervisor that we failed.
+			return nil, fmt.Errorf(`cannot inherit listener %v: %v`, i, err)
+		}
+		listeners = append(listeners, lr)
+	}
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	cfg.inherited = listeners
+	return ready, nil
+}
+
+// takeInherited returns the listeners inherited from an old supervisor, if any, so they are only used once.
+func (cfg *Config) takeInherited() []net.Listener {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	inherited := cfg.inherited
+	cfg.inherited = nil
+	return inherited
+}
+
+// awaitHandover hands the listeners over to a new supervisor when the supervisor receives SIGHUP, until a handover
+// succeeds or the context is done.  After a handover, the server stops accepting connections and drains the requests
+// in flight; the returned function waits for this to finish, and returns immediately if no handover has begun.
+func (cfg *Config) awaitHandover(
+	ctx context.Context, server *http.Server, listeners []net.Listener, executable string, args []string,
+) (drained func()) {
+	handedOver, done := make(chan struct{}), make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+			}
+			hog.From(ctx).Info().Msg(`handing over listeners to a new supervisor`)
+			err := handOver(ctx, listeners, executable, args)
+			if err != nil {
+				hog.From(ctx).Error().Err(err).Msg(`handover failed, still serving`)
+				continue
+			}
+			close(handedOver)
+			defer close(done)
+			cfg.drainServer(ctx, server, listeners)
+			return
+		}
+	}()
+	return func() {
+		select {
+		case <-handedOver:
+			<-done
+		default:
+		}
+	}
+}
+
+// drainServer stops the server from accepting connections without removing Unix domain sockets that have been handed
+// over, then waits for the requests in flight to finish, up to the drain period of the rig.
+func (cfg *Config) drainServer(ctx context.Context, server *http.Server, listeners []net.Listener) {
+	for _, lr := range listeners {
+		if ul, ok := lr.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	drainCtx := context.Background() // not ctx, which would stop draining along with the supervisor.
+	if _, drain := cfg.handoverDrain(); drain > 0 {
+		var cancel context.CancelFunc
+		drainCtx, cancel = context.WithTimeout(drainCtx, drain)
+		defer cancel()
+	}
+	start := time.Now()
+	err := server.Shutdown(drainCtx)
+	if err != nil {
+		hog.From(ctx).Warn().Err(err).Msg(`closing requests that did not finish draining`)
+		_ = server.Close()
+		return
+	}
+	hog.From(ctx).Info().Dur(`elapsed`, time.Since(start)).Msg(`drained requests after handover`)
+}
+
+// handOver starts a new supervisor that inherits the listeners, and waits for it to report that it is ready.
+func handOver(ctx context.Context, listeners []net.Listener, executable string, args []string) error {
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, lr := range listeners {
+		impl, ok := lr.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf(`cannot hand over %T listening to %v`, lr, lr.Addr())
+		}
+		file, err := impl.File()
+		if err != nil {
+			return fmt.Errorf(`%w while handing over %v`, err, lr.Addr())
+		}
+		files = append(files, file)
+	}
+	ready, notify, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, notify)
+
+	// The new supervisor is not bound to ctx, since it must outlive us.
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(),
+		`RIG_HANDOVER=`+strconv.Itoa(len(listeners)),
+		`RIG_GENERATION=`+strconv.FormatUint(generations.Load(), 10), // so its workers continue counting from ours.
+	)
+	cmd.ExtraFiles = files
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	go func() { _ = cmd.Wait() }() // reaps the new supervisor if it exits before we do.
+	notify.Close()                 // so reading the pipe fails if the new supervisor exits before it is ready.
+	files = files[:len(files)-1]
+
+	readyCh := make(chan error, 1)
+	go func() {
+		var buf [1]byte
+		_, err := ready.Read(buf[:])
+		readyCh <- err
+	}()
+	timeout := time.NewTimer(handoverTimeout)
+	defer timeout.Stop()
+	select {
+	case err = <-readyCh:
+		if err != nil {
+			return errors.New(`the new supervisor exited before it was ready`)
+		}
+		return nil
+	case <-timeout.C:
+		err = fmt.Errorf(`the new supervisor was not ready after %v`, handoverTimeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	_ = cmd.Process.Kill()
+	return err
+}
@@ -0,0 +1,31 @@
+package rig
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestHandOver(t *testing.T) {
+	sh, err := shellPath(`/bin/sh`)
+	if err != nil {
+		t.Skip(err)
+	}
+	lr, err := net.Listen(`tcp`, `localhost:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close()
+	listeners := []net.Listener{lr}
+	ctx := context.Background()
+
+	// The listener is passed as descriptor 3, followed by the pipe used to report that the new supervisor is ready.
+	err = handOver(ctx, listeners, sh, []string{`-c`, `test "$RIG_HANDOVER" = 1 && test -S /dev/fd/3 && printf x >&4`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = handOver(ctx, listeners, sh, []string{`-c`, `exit 1`})
+	if err == nil {
+		t.Fatal(`expected a handover to a supervisor that exits to fail`)
+	}
+}
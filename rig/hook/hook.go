@@ -4,9 +4,11 @@ package hook
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
 	"sort"
+	"strings"
 )
 
 // Listen hooks are called when the rig is setting up a new listener.
@@ -24,10 +26,44 @@ type Mux interface {
 	RigMux(*http.ServeMux)
 }
 
+// Routes hooks describe the routes they add to the HTTP multiplexer so that they can be listed for documentation and
+// debugging, since http.ServeMux does not expose its patterns.
+type Routes interface {
+	RigRoutes() []Route
+}
+
+// A Route describes a pattern that a hook added to the HTTP multiplexer.
+type Route struct {
+	Pattern string // The pattern, as passed to http.ServeMux.Handle.
+	Group   string // The name of the group that registered the route, if any.
+	Handler string // A description of the handler, such as its type or function name.
+}
+
+// BeforeServe hooks are called before the rig starts serving its handlers, either in the worker process or when the rig
+// is served directly.  They are not called by the supervisor.  If any returns an error, the rig will not serve.
+type BeforeServe interface {
+	RigBeforeServe(ctx context.Context) error
+}
+
+// Started hooks are called once the rig is serving, with the addresses of its listeners, such as to print the final
+// URL, register with service discovery or warm a cache.  They are called by the supervisor or when the rig is served
+// directly, since those own the listeners, but not by the worker.  If any returns an error, the rig stops serving and
+// returns it.
+type Started interface {
+	RigStarted(ctx context.Context, addrs []net.Addr) error
+}
+
 // Order will return the provided hooks in the order they were provided with adjustments made so that all dependent
 // hooks are run after their dependencies.  Note that cyclic dependencies will not produce an error, the order will
-// simply be best effort.
+// simply be best effort; use StrictOrder to find out about them.
 func Order(hooks ...interface{}) []any {
+	order, _ := StrictOrder(hooks...)
+	return order
+}
+
+// StrictOrder is like Order, but also returns a CycleError describing the first cyclic dependency it finds, along with
+// the best effort order.
+func StrictOrder(hooks ...any) ([]any, error) {
 	dependencies := make(map[string][]int, len(hooks))
 	for i, hook := range hooks {
 		if dependency, ok := hook.(Provider); ok {
@@ -38,32 +74,80 @@ func Order(hooks ...interface{}) []any {
 	}
 	order := make([]interface{}, 0, len(hooks))
 	placed := make([]bool, len(hooks))
-	var place func(int)
-	place = func(i int) {
+	placing := make([]bool, len(hooks)) // true for the hooks on the current path
+	var path []int                      // the hooks being placed, each depending on the next
+	var via []string                    // the name each hook in the path was reached through
+	var cycle *CycleError
+	var place func(i int, name string)
+	place = func(i int, name string) {
+		if placing[i] && cycle == nil {
+			start := len(path) - 1
+			for path[start] != i {
+				start--
+			}
+			cycle = &CycleError{}
+			for k, j := range path[start:] {
+				cycle.Hooks = append(cycle.Hooks, hooks[j])
+				if k > 0 {
+					cycle.Names = append(cycle.Names, via[start+k])
+				}
+			}
+			cycle.Names = append(cycle.Names, name)
+		}
 		if placed[i] {
 			return
 		}
-		placed[i] = true
+		placed[i], placing[i] = true, true
+		path, via = append(path, i), append(via, name)
 		if dependent, ok := hooks[i].(Dependent); ok {
-			names := dependent.DependsOn()
-			items := make([]int, 0, len(names))
-			for _, name := range names {
-				items = append(items, dependencies[name]...)
+			type item struct {
+				index int
+				name  string
 			}
-			sort.Ints(items) // try to preserve the original order as much as possible
-			for _, j := range items {
-				place(j)
+			var items []item
+			for _, name := range dependent.DependsOn() {
+				for _, j := range dependencies[name] {
+					items = append(items, item{j, name})
+				}
+			}
+			// try to preserve the original order as much as possible
+			sort.SliceStable(items, func(a, b int) bool { return items[a].index < items[b].index })
+			for _, it := range items {
+				place(it.index, it.name)
 			}
 		}
+		path, via = path[:len(path)-1], via[:len(via)-1]
+		placing[i] = false
 		order = append(order, hooks[i])
 	}
 	for i := range hooks {
-		place(i)
+		place(i, ``)
 	}
-	return order
+	if cycle != nil {
+		return order, cycle
+	}
+	return order, nil
+}
+
+// A CycleError describes hooks that depend on each other, so they cannot all run after their dependencies.
+type CycleError struct {
+	Hooks []any    // The hooks in the cycle, each depending on the next and the last depending on the first.
+	Names []string // The names through which each hook depends on the next, in the same order as Hooks.
+}
+
+// Error implements the error interface.
+func (err *CycleError) Error() string {
+	var buf strings.Builder
+	buf.WriteString(`cyclic hook dependency: `)
+	for i, it := range err.Hooks {
+		fmt.Fprintf(&buf, `%T depends on %q provided by `, it, err.Names[i])
+	}
+	fmt.Fprintf(&buf, `%T`, err.Hooks[0])
+	return buf.String()
 }
 
-// A Provider provides a name so that it can be referenced by a Dependent.
+// A Provider provides a name so that it can be referenced by a Dependent.  A Provider that is also a Setup hook may
+// publish a value under the name for its dependents to get from the Registry.
 type Provider interface {
 	Provides() []string
 }
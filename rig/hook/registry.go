@@ -0,0 +1,59 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Setup hooks are called with the registry of the rig before the BeforeServe hooks, in the same order, so a Provider
+// can publish the values it provides and a Dependent can get them, such as a database pool that an API hook uses.
+// Like BeforeServe hooks, they are not called by the supervisor.  If any returns an error, the rig will not serve.
+type Setup interface {
+	RigSetup(ctx context.Context, reg *Registry) error
+}
+
+// A Registry holds the values published by hooks under the names they provide, see Setup.  The zero value is an empty
+// registry, and a registry is safe for concurrent use.
+type Registry struct {
+	control sync.Mutex
+	values  map[string]any
+}
+
+// Publish publishes a value under the given name, returning an error if the name has already been published.
+func (reg *Registry) Publish(name string, value any) error {
+	reg.control.Lock()
+	defer reg.control.Unlock()
+	if _, dup := reg.values[name]; dup {
+		return fmt.Errorf(`%q has already been published`, name)
+	}
+	if reg.values == nil {
+		reg.values = make(map[string]any)
+	}
+	reg.values[name] = value
+	return nil
+}
+
+// Lookup returns the value published under the given name, if any.
+func (reg *Registry) Lookup(name string) (any, bool) {
+	reg.control.Lock()
+	defer reg.control.Unlock()
+	value, ok := reg.values[name]
+	return value, ok
+}
+
+// Get returns the value published under the given name as a T, returning an error if nothing has been published
+// under the name or the value is not a T, such as when the hook that provides it has not been set up yet because
+// the hook calling Get does not depend on the name.
+func Get[T any](reg *Registry, name string) (T, error) {
+	var ret T
+	value, ok := reg.Lookup(name)
+	if !ok {
+		return ret, fmt.Errorf(`nothing has been published as %q`, name)
+	}
+	ret, ok = value.(T)
+	if !ok {
+		return ret, fmt.Errorf(`%q is a %T, not a %T`, name, value, ret)
+	}
+	return ret, nil
+}
@@ -0,0 +1,291 @@
+package jrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig/jrpc/internal/protocol"
+	"github.com/swdunlop/rig-go/rig/tracing"
+	"nhooyr.io/websocket"
+)
+
+// Dial connects to a JRPC service at the given ws:// or wss:// URL.  The context only limits the time spent
+// connecting, use Close to disconnect the client.  If the context carries a tracing.Parent, it is sent to the service
+// in the "traceparent" header.  Options register handlers for the requests and notifications that the service sends
+// to the client, such as with Scope.Call and Scope.Notify.
+func Dial(ctx context.Context, url string, options ...ClientOption) (*Client, error) {
+	header := make(http.Header)
+	tracing.Inject(ctx, header)
+	c, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: header})
+	if err != nil {
+		return nil, err
+	}
+	c.SetReadLimit(-1)
+	cl := &Client{
+		conn:         c,
+		pending:      make(map[string]chan message),
+		doneCh:       make(chan struct{}),
+		procHandlers: make(map[string]func(context.Context, json.RawMessage)),
+		callHandlers: make(map[string]func(context.Context, json.RawMessage) (any, error)),
+	}
+	for _, opt := range options {
+		opt(cl)
+	}
+	go cl.process()
+	return cl, nil
+}
+
+// A ClientOption registers a handler for requests sent by the service to a Client, see Dial.
+type ClientOption func(*Client)
+
+// OnNotify handles notifications with the given method sent by the service, such as with Scope.Notify or Scope.Call,
+// which does not wait for a response.  Notifications are handled in the order they arrive, and responses are not read
+// while fn runs, so fn should not block or call the service.  Notifications with params that cannot be decoded as I
+// are logged and ignored.
+func OnNotify[I any](method string, fn func(ctx context.Context, params I)) ClientOption {
+	return func(cl *Client) {
+		cl.procHandlers[method] = func(ctx context.Context, params json.RawMessage) {
+			in := new(I)
+			err := json.Unmarshal(params, in)
+			if err != nil {
+				hog.From(ctx).Warn().Err(err).Str(`method`, method).Msg(`JRPC client could not decode notification`)
+				return
+			}
+			fn(ctx, *in)
+		}
+	}
+}
+
+// OnCall handles requests with the given method and an ID sent by the service, responding with the output of fn, or an
+// Error describing why it failed.  Unlike OnNotify, each request is handled in its own goroutine, so fn may call the
+// service.
+func OnCall[I, O any](method string, fn func(ctx context.Context, params I) (O, error)) ClientOption {
+	return func(cl *Client) {
+		cl.callHandlers[method] = func(ctx context.Context, params json.RawMessage) (any, error) {
+			in := new(I)
+			err := json.Unmarshal(params, in)
+			if err != nil {
+				return nil, &Error{Code: InvalidParams, Message: fmt.Sprintf(`%v while decoding input`, err)}
+			}
+			return fn(ctx, *in)
+		}
+	}
+}
+
+// A Client calls methods on a JRPC service.  Use Call and Notify to send requests using the client.
+type Client struct {
+	conn         *websocket.Conn
+	seq          atomic.Uint64
+	control      sync.Mutex
+	pending      map[string]chan message // responses awaited by Call, by request ID
+	err          error                   // set when the client stops processing messages
+	doneCh       chan struct{}           // closed when the client stops processing messages
+	procHandlers map[string]func(context.Context, json.RawMessage)
+	callHandlers map[string]func(context.Context, json.RawMessage) (any, error)
+}
+
+// A message is a request, notification or response received from the service.
+type message struct {
+	ID     string          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// Close disconnects the client, any pending requests will fail.
+func (cl *Client) Close() error {
+	err := cl.conn.Close(websocket.StatusNormalClosure, ``)
+	<-cl.doneCh
+	return err
+}
+
+// Done returns a channel that is closed when the client is disconnected.
+func (cl *Client) Done() <-chan struct{} { return cl.doneCh }
+
+// Err returns the reason the client was disconnected, or nil if it is still connected.
+func (cl *Client) Err() error {
+	cl.control.Lock()
+	defer cl.control.Unlock()
+	return cl.err
+}
+
+// An Error is returned by a client when the service fails a request, and may be returned by OnCall handlers to fail
+// a request from the service with a specific code.
+type Error struct {
+	Code    int             `json:"code"`           // A standard JSON-RPC 2.0 code, like InvalidParams, or an application code.
+	Message string          `json:"message"`        // Message describing the failure.
+	Data    json.RawMessage `json:"data,omitempty"` // Additional information about the failure, if any.
+}
+
+// Error implements the error interface.
+func (err *Error) Error() string { return fmt.Sprintf(`jrpc: %v %v`, err.Code, err.Message) }
+
+// ErrClosed is returned for pending requests when the client is disconnected.
+var ErrClosed = errors.New(`jrpc: client closed`)
+
+// Call calls a method on the service and waits for its result.  The result type must usually be given explicitly,
+// such as `jrpc.Call[Greeting](ctx, client, "greet", name)`.  If the service fails the request, the error is an *Error.
+func Call[O any](ctx context.Context, cl *Client, method string, params any) (O, error) {
+	var out O
+	id := strconv.FormatUint(cl.seq.Add(1), 36)
+	responseCh := make(chan message, 1)
+	cl.control.Lock()
+	if cl.err != nil {
+		cl.control.Unlock()
+		return out, cl.err
+	}
+	cl.pending[id] = responseCh
+	cl.control.Unlock()
+	defer cl.forget(id)
+	err := cl.write(ctx, id, method, params)
+	if err != nil {
+		return out, err
+	}
+	select {
+	case <-ctx.Done():
+		return out, ctx.Err()
+	case rsp, ok := <-responseCh:
+		switch {
+		case !ok:
+			return out, cl.closedErr()
+		case rsp.Error != nil:
+			return out, rsp.Error
+		}
+		err = json.Unmarshal(rsp.Result, &out)
+		if err != nil {
+			return out, fmt.Errorf(`%w while decoding result`, err)
+		}
+		return out, nil
+	}
+}
+
+// Notify sends a notification to the service, which does not respond to it.
+func (cl *Client) Notify(ctx context.Context, method string, params any) error {
+	return cl.write(ctx, ``, method, params)
+}
+
+// write sends a request to the service, which is a notification if id is empty.
+func (cl *Client) write(ctx context.Context, id, method string, params any) error {
+	js, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf(`%w while encoding params`, err)
+	}
+	msg, err := json.Marshal(protocol.Request{JSONRPC: protocol.Version, ID: id, Method: method, Params: js})
+	if err != nil {
+		return fmt.Errorf(`%w while encoding request`, err)
+	}
+	return cl.conn.Write(ctx, websocket.MessageText, msg)
+}
+
+// forget stops waiting for the response to a request.
+func (cl *Client) forget(id string) {
+	cl.control.Lock()
+	defer cl.control.Unlock()
+	delete(cl.pending, id)
+}
+
+// process reads messages from the service and dispatches them until the connection is closed.
+func (cl *Client) process() {
+	ctx, cancel := context.WithCancel(context.Background())
+	err := cl.processMessages(ctx)
+	cancel()
+	if websocket.CloseStatus(err) >= 0 {
+		err = ErrClosed
+	}
+	cl.control.Lock()
+	cl.err = err
+	for id, responseCh := range cl.pending {
+		delete(cl.pending, id)
+		close(responseCh)
+	}
+	cl.control.Unlock()
+	close(cl.doneCh)
+}
+
+func (cl *Client) processMessages(ctx context.Context) error {
+	for {
+		mt, msg, err := cl.conn.Read(ctx)
+		if err != nil {
+			return err
+		}
+		if mt != websocket.MessageText {
+			continue
+		}
+		var batch []message
+		if isBatch(msg) {
+			err = json.Unmarshal(msg, &batch)
+		} else {
+			batch = make([]message, 1)
+			err = json.Unmarshal(msg, &batch[0])
+		}
+		if err != nil {
+			return err
+		}
+		for _, it := range batch {
+			cl.dispatch(ctx, it)
+		}
+	}
+}
+
+// dispatch delivers a response to the pending call, or handles a request or notification from the service.
+func (cl *Client) dispatch(ctx context.Context, msg message) {
+	switch {
+	case msg.Method == ``:
+		cl.control.Lock()
+		responseCh := cl.pending[msg.ID]
+		delete(cl.pending, msg.ID)
+		cl.control.Unlock()
+		if responseCh != nil {
+			responseCh <- msg // buffered, and each call receives only one response.
+		}
+	case msg.ID == ``:
+		if fn := cl.procHandlers[msg.Method]; fn != nil {
+			fn(ctx, msg.Params)
+		}
+	default:
+		go cl.respond(ctx, msg)
+	}
+}
+
+// respond handles a request from the service with the OnCall handler for its method.
+func (cl *Client) respond(ctx context.Context, req message) {
+	rsp := protocol.Response{JSONRPC: protocol.Version, ID: protocol.ID(req.ID)}
+	fn := cl.callHandlers[req.Method]
+	if fn == nil {
+		rsp.Error = &protocol.Error{Code: MethodNotFound, Message: fmt.Sprintf(`function %q not found`, req.Method)}
+	} else if result, err := fn(ctx, req.Params); err != nil {
+		var ret *Error
+		if !errors.As(err, &ret) {
+			ret = &Error{Code: InternalError, Message: err.Error()}
+		}
+		rsp.Error = &protocol.Error{Code: ret.Code, Message: ret.Message}
+		if ret.Data != nil {
+			rsp.Error.Data = ret.Data
+		}
+	} else {
+		rsp.Result = result
+	}
+	msg, err := json.Marshal(rsp)
+	if err == nil {
+		err = cl.conn.Write(ctx, websocket.MessageText, msg)
+	}
+	if err != nil && ctx.Err() == nil {
+		hog.From(ctx).Warn().Err(err).Str(`method`, req.Method).Msg(`JRPC client could not respond to request`)
+	}
+}
+
+func (cl *Client) closedErr() error {
+	err := cl.Err()
+	if err == nil {
+		err = ErrClosed
+	}
+	return err
+}
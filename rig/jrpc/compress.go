@@ -0,0 +1,49 @@
+package jrpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+)
+
+// Compress lets clients ask for messages of at least threshold bytes to be compressed, for clients that cannot use the
+// permessage-deflate WebSocket extension.  A client asks by connecting with "compress=gzip" in the query of the URL,
+// such as "wss://example.com/jrpc?compress=gzip".  Messages sent to such a client that are at least threshold bytes
+// long are sent as binary WebSocket messages containing the JSON text compressed with gzip, while smaller messages
+// are sent as text, as usual.  Clients that do not ask only receive text messages, so standard clients are unaffected.
+// The default of zero never compresses messages.
+func Compress(threshold int) Option {
+	return func(cfg *config) { cfg.compressAt = threshold }
+}
+
+// wantsCompression returns true if the client asked for compressed messages, see Compress.
+func wantsCompression(r *http.Request) bool {
+	for _, value := range r.URL.Query()[`compress`] {
+		if value == `gzip` {
+			return true
+		}
+	}
+	return false
+}
+
+// A compressor compresses the messages sent on a connection, reusing its buffer and gzip writer, so it must only be
+// used by one goroutine at a time, such as the writer of a sendQueue.
+type compressor struct {
+	buf bytes.Buffer
+	zw  *gzip.Writer
+}
+
+// compress returns the message compressed with gzip; the result is only valid until the next call.
+func (cz *compressor) compress(msg []byte) ([]byte, error) {
+	cz.buf.Reset()
+	if cz.zw == nil {
+		cz.zw = gzip.NewWriter(&cz.buf)
+	} else {
+		cz.zw.Reset(&cz.buf)
+	}
+	_, err := cz.zw.Write(msg)
+	if err == nil {
+		err = cz.zw.Close()
+	}
+	return cz.buf.Bytes(), err
+}
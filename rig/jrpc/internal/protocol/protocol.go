@@ -6,30 +6,66 @@ import (
 	"encoding/json"
 )
 
-// A Request is a message sent from a client to a service.
+// Version is the value of the "jsonrpc" member of every message.
+const Version = `2.0`
+
+// A Request is a message sent from a client to a service.  For compatibility with older clients, JSONRPC may be empty.
 type Request struct {
-	ID     string          `json:"id,omitempty"`
-	Method string          `json:"method"`
-	Params json.RawMessage `json:"params"`
+	JSONRPC string          `json:"jsonrpc,omitempty"`
+	ID      string          `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
 }
 
 // A Response is a message sent from a service to a client in response to a
 // request that had an ID.
 type Response struct {
-	ID     string `json:"id"`
-	Result any    `json:"result"`
-	Error  *Error `json:"error"`
-	End    bool   `json:"end"`
+	JSONRPC string `json:"jsonrpc"`
+	ID      ID     `json:"id"`
+	Result  any    `json:"result"`
+	Error   *Error `json:"error"`
+	End     bool   `json:"end"`
+}
+
+// An ID identifies the request of a Response.  An empty ID is encoded as null, which JSON-RPC 2.0 requires when the ID
+// of the request could not be read, such as when the request could not be parsed.
+type ID string
+
+// MarshalJSON implements json.Marshaler
+func (id ID) MarshalJSON() ([]byte, error) {
+	if id == `` {
+		return []byte(`null`), nil
+	}
+	return json.Marshal(string(id))
 }
 
 // A Notification is a message sent from a client to a service in JSON-RPC 2.0
 // without an ID.  We also support sending notifications back to the client
 // which is not normally tolerated by JSON-RPC 2.0 clients.
 type Notification struct {
-	Method string `json:"method"`
-	Params any    `json:"params"`
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
 }
 
+// Standard JSON-RPC 2.0 error codes.
+const (
+	ParseError     = -32700 // The message could not be parsed as JSON.
+	InvalidRequest = -32600 // The message is not a valid request.
+	MethodNotFound = -32601 // The method does not exist.
+	InvalidParams  = -32602 // The parameters could not be decoded for the method.
+	InternalError  = -32603 // The method failed without a more specific code.
+)
+
+// Server error codes, from the range that JSON-RPC 2.0 reserves for errors defined by the implementation.
+const (
+	ServiceBusy     = -32000 // The request was shed because the budget of the service was exhausted.
+	TooManyRequests = -32001 // The request arrived while the connection had as many requests in flight as allowed.
+	NotFound        = -32002 // Something the request refers to does not exist.
+	Unauthorized    = -32003 // The client is not permitted to make the request.
+)
+
+// An Error describes why a request failed.
 type Error struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
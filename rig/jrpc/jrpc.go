@@ -1,21 +1,54 @@
 package jrpc
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/swdunlop/html-go/hog"
 	"github.com/swdunlop/rig-go/rig/api"
 	"github.com/swdunlop/rig-go/rig/jrpc/internal/protocol"
+	"github.com/swdunlop/rig-go/rig/rpcerr"
+	"github.com/swdunlop/rig-go/rig/tracing"
 	"nhooyr.io/websocket"
 )
 
+// Standard JSON-RPC 2.0 error codes, see Scope.Fail.
+const (
+	ParseError     = protocol.ParseError     // The message could not be parsed as JSON.
+	InvalidRequest = protocol.InvalidRequest // The message is not a valid request.
+	MethodNotFound = protocol.MethodNotFound // The method does not exist.
+	InvalidParams  = protocol.InvalidParams  // The parameters could not be decoded for the method.
+	InternalError  = protocol.InternalError  // The method failed without a more specific code.
+)
+
+// Server error codes used by the service itself, from the range that JSON-RPC 2.0 reserves for errors defined by the
+// implementation.  Both mean the request was not handled and may succeed if the client sends it again later.
+const (
+	ServiceBusy     = protocol.ServiceBusy     // The request was shed by Budget.
+	TooManyRequests = protocol.TooManyRequests // The request exceeded MaxConcurrent.
+)
+
+// Server error codes sent by Scope.FailWith for the errors returned by rpcerr.NotFound and rpcerr.Unauthorized, which
+// have no standard JSON-RPC 2.0 code.
+const (
+	NotFound     = protocol.NotFound     // Something the request refers to does not exist.
+	Unauthorized = protocol.Unauthorized // The client is not permitted to make the request.
+)
+
 // API returns an api.Option that supports RPC requests at the specified route.
 func API(route string, options ...Option) api.Option {
-	return api.Handle(route, Handle(options...))
+	handler, err := New(options...)
+	if err != nil {
+		return api.Fail(err)
+	}
+	return api.Handle(route, handler)
 }
 
 // ReadLimit specifies the maximum size of a read message.  Defaults to -1 which imposes no limit.
@@ -24,12 +57,148 @@ func ReadLimit(limit int64) Option {
 	return func(cfg *config) { cfg.readLimit = limit }
 }
 
-// Handle returns a http.Handler that upgrades the connection to a WebSocket and handles RPC requests
-// until the connection is closed.
+// Handle returns a http.Handler that upgrades the connection to a WebSocket and handles RPC requests until the
+// connection is closed, or handles a posted request as server sent events if SSE is enabled.  Since every request fails
+// if no functions are registered, Handle logs a warning if there are none and no NotFoundHandler, such as when only Use
+// is given.  If the options do not fit together, Handle logs the error from New and the handler fails every request
+// with 500 Internal Server Error.
 func Handle(options ...Option) http.Handler {
+	handler, err := New(options...)
+	if err != nil {
+		hog.From(context.Background()).Error().Err(err).Msg(`JRPC service is misconfigured, every request will fail`)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		})
+	}
+	return handler
+}
+
+// New returns a http.Handler like Handle, or an error if the options do not fit together, such as an Alias that
+// refers to a method that is not registered.
+func New(options ...Option) (http.Handler, error) {
 	var cfg config
-	cfg.init(options...)
-	return &cfg
+	err := cfg.init(options...)
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// MaxConcurrent limits the number of requests that may be handled at the same time on each connection.  Requests that
+// arrive while the limit is reached are not queued, they fail immediately with TooManyRequests so a client that floods
+// the service cannot stall the connection.  Each request in a batch counts against the limit, as does each request in
+// a batch posted for SSE.  The default of zero imposes no limit.
+func MaxConcurrent(n int) Option {
+	return func(cfg *config) { cfg.maxConcurrent = n }
+}
+
+// Budget limits the total cost of the requests in flight across every connection to the service, so a flood of
+// expensive requests cannot saturate the service.  Requests that would exceed the budget are shed with ServiceBusy
+// after waiting for as long as Shed allows.  The cost function returns the cost of a method, which should be between 1
+// and the limit, and may be nil to count every request as 1.  Each request in a batch is budgeted separately.  This is
+// distinct from MaxConcurrent, which limits the number of requests on each connection.  The default of zero imposes no
+// budget.
+func Budget(limit int, cost func(method string) int) Option {
+	return func(cfg *config) { cfg.budgetLimit, cfg.budgetCost = limit, cost }
+}
+
+// Shed sets how long a request may wait for room in the Budget before it is shed.  While a request waits, no more
+// messages are read from its connection, which pushes back on clients that flood the service.  The default of zero
+// sheds requests immediately.
+func Shed(wait time.Duration) Option {
+	return func(cfg *config) { cfg.shedWait = wait }
+}
+
+// Workers keeps up to n goroutines waiting to handle requests, reusing them for later requests on every connection
+// instead of starting a goroutine for each request, which reduces the overhead of services that handle many short
+// requests.  Requests never wait for a goroutine, so this does not limit how many are handled at the same time; use
+// MaxConcurrent or Budget for that.  Idle goroutines are kept for as long as the service is.  The default of zero
+// starts a goroutine for each request.
+func Workers(n int) Option {
+	return func(cfg *config) { cfg.workers = n }
+}
+
+// Observe specifies a function that is called with the timing of each request once it has been handled or shed, such
+// as to record metrics or log slow requests.  It is called from the goroutine that handled the request, so it should
+// not block.
+func Observe(fn func(Observation)) Option {
+	return func(cfg *config) { cfg.observe = fn }
+}
+
+// An Observation describes the timing of a request, see Observe.
+type Observation struct {
+	Method string        // The method of the request.
+	Decode time.Duration // Time spent decoding the request.
+	Wait   time.Duration // Time spent waiting for room in the Budget.
+	Handle time.Duration // Time spent handling the request, which is zero if it was shed.
+	Shed   bool          // True if the request was shed because the Budget was exceeded.
+	Failed bool          // True if the request failed, including when it was shed.
+}
+
+// PingInterval sends a WebSocket ping on each connection at the given interval, closing the connection if the pong
+// does not arrive before the next ping is due.  This keeps idle connections alive through proxies that drop them and
+// detects clients that vanish without closing the connection.  The default of zero sends no pings.
+func PingInterval(interval time.Duration) Option {
+	return func(cfg *config) { cfg.pingInterval = interval }
+}
+
+// CloseTimeout sets how long the service waits for a client to acknowledge the close frame sent when the service
+// closes a connection, such as when it is shutting down, so the client learns why the connection closed instead of
+// seeing it drop.  If the client does not acknowledge in time, the service stops waiting, although the WebSocket
+// library may take a few more seconds to give up on the handshake in the background.  A timeout of zero closes
+// connections immediately without a close frame.  The default is DefaultCloseTimeout.
+func CloseTimeout(timeout time.Duration) Option {
+	return func(cfg *config) { cfg.closeTimeout = timeout }
+}
+
+// DefaultCloseTimeout is the default for CloseTimeout, which is short so that closing connections does not hold up
+// shutting down.
+var DefaultCloseTimeout = time.Second
+
+// Authorize specifies a function that authenticates each connection before it is upgraded to a WebSocket, such as
+// by checking a token passed in a header or query parameter.  If fn returns an error, the upgrade is rejected with
+// 401 Unauthorized if the error wraps ErrUnauthorized, or 403 Forbidden otherwise.  The principal returned by fn is
+// available to handlers using Scope.Principal.
+func Authorize(fn func(r *http.Request) (principal any, err error)) Option {
+	return func(cfg *config) { cfg.authorize = fn }
+}
+
+// ErrUnauthorized may be wrapped by errors returned by an Authorize function to reject the connection with 401
+// Unauthorized instead of 403 Forbidden.
+var ErrUnauthorized = errors.New(`unauthorized`)
+
+// Debug logs each request and response at the trace level if enabled.  This is disabled by default, since messages may
+// be large or contain sensitive information.
+func Debug(enabled bool) Option {
+	return func(cfg *config) { cfg.debug = enabled }
+}
+
+// Marshal specifies the function used to encode responses, notifications and calls sent to clients, which defaults to
+// json.Marshal.
+func Marshal(fn func(v any) ([]byte, error)) Option {
+	return func(cfg *config) { cfg.marshal = fn }
+}
+
+// EscapeHTML controls whether "<", ">" and "&" in strings are escaped as "\u003c", "\u003e" and "\u0026" when encoding
+// messages sent to clients, as json.Marshal does by default.  Escaping is enabled by default so that messages are safe
+// to embed in HTML documents, but it makes payloads containing markup or URLs larger and harder to read.  Any JSON
+// parser decodes the same value either way, so it is usually safe to disable escaping for a WebSocket API.
+func EscapeHTML(enabled bool) Option {
+	if enabled {
+		return Marshal(json.Marshal)
+	}
+	return Marshal(marshalWithoutEscapingHTML)
+}
+
+func marshalWithoutEscapingHTML(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
 }
 
 // Use specifies middleware that is applied to all requests.
@@ -39,6 +208,13 @@ func Use(fn func(Handler) Handler) Option {
 	}
 }
 
+// NotFoundHandler specifies a handler for requests to functions that were not registered with Fn or Proc, such as
+// to forward them to another service, instead of failing them with "not found".  Like the handlers of registered
+// functions, it is called through the middleware given to Use.
+func NotFoundHandler(fn Handler) Option {
+	return func(cfg *config) { cfg.notFound = fn }
+}
+
 // For creates a new context for the given request and send function.  Generally this is not necessary but it can
 // be useful for testing.
 func For(ctx context.Context, req protocol.Request, send func(bin []byte) error) *Scope {
@@ -56,31 +232,81 @@ func From(ctx context.Context) *Scope {
 
 type ctxKey struct{}
 
+type principalKey struct{}
+
 // A Scope describes the scope of an RPC request.
 type Scope struct {
 	context.Context
 	protocol.Request
-	send func(bin []byte) error
+	send    func(bin []byte) error
+	reply   func(bin []byte) error      // if not nil, used instead of send for the response, e.g. for batches.
+	marshal func(v any) ([]byte, error) // if not nil, used instead of json.Marshal to encode messages.
+	failure *protocol.Error             // nil until a failure has been sent
 }
 
+// Principal returns the principal returned by the Authorize function when the connection was accepted, or nil if
+// there is no Authorize function.
+func (ctx *Scope) Principal() any { return ctx.Value(principalKey{}) }
+
 // Succ sends a success response to the client.
 func (ctx *Scope) Succ(result any) error { return ctx.respond(protocol.Response{Result: result}) }
 
-// Fail sends an error response to the client.
-func (ctx *Scope) Fail(code int, msg string) error {
-	err := ctx.respond(protocol.Response{Error: &protocol.Error{Code: code, Message: msg}})
-	ctx.send = nil
+// Fail sends an error response to the client.  The code may be one of the standard JSON-RPC 2.0 codes, like
+// InvalidParams, or an application specific code.
+func (ctx *Scope) Fail(code int, msg string) error { return ctx.FailData(code, msg, nil) }
+
+// FailData sends an error response to the client with data describing the error in more detail, such as which fields
+// of the input were invalid.  The data is omitted from the response if it is nil.
+func (ctx *Scope) FailData(code int, msg string, data any) error {
+	failure := &protocol.Error{Code: code, Message: msg, Data: data}
+	err := ctx.respond(protocol.Response{Error: failure})
+	ctx.send, ctx.failure = nil, failure
 	return err
 }
 
+// FailWith sends an error response describing err to the client, using the code, message and data of an
+// rpcerr.Error if err wraps one, or InternalError and the message of err otherwise.  The HTTP status codes used by
+// rpcerr are sent as the nearest JSON-RPC code, see errorCode, while other codes are sent as is, so handlers can use
+// the standard codes or their own application codes.
+func (ctx *Scope) FailWith(err error) error {
+	var ret *rpcerr.Error
+	if !errors.As(err, &ret) {
+		ret = rpcerr.New(InternalError, err.Error())
+	}
+	return ctx.FailData(errorCode(ret.Code), ret.Message, ret.Data)
+}
+
+// errorCode returns the JSON-RPC code for the code of an rpcerr.Error, such as InvalidParams for 400 Bad Request from
+// rpcerr.Invalid and ServiceBusy for 503 Service Unavailable from rpcerr.Unavailable.  Other client errors are sent as
+// InvalidParams, other server errors as InternalError, and codes outside of the HTTP error range are sent as is.
+func errorCode(code int) int {
+	switch {
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return Unauthorized
+	case code == http.StatusNotFound:
+		return NotFound
+	case code == http.StatusTooManyRequests:
+		return TooManyRequests
+	case code == http.StatusServiceUnavailable:
+		return ServiceBusy
+	case code >= 400 && code < 500:
+		return InvalidParams
+	case code >= 500 && code < 600:
+		return InternalError
+	default:
+		return code
+	}
+}
+
 // Notify sends a notification to the client.  This is not normally tolerated by
 // JSON-RPC 2.0 clients.
 func (ctx *Scope) Notify(method string, params any) error {
 	msg := protocol.Notification{
-		Method: method,
-		Params: params,
+		JSONRPC: protocol.Version,
+		Method:  method,
+		Params:  params,
 	}
-	js, err := json.Marshal(msg)
+	js, err := ctx.encode(msg)
 	if err != nil {
 		return err
 	}
@@ -90,13 +316,14 @@ func (ctx *Scope) Notify(method string, params any) error {
 
 // Call sends a request to the client.
 func (ctx *Scope) Call(method string, params any) error {
-	js, err := json.Marshal(params)
+	js, err := ctx.encode(params)
 	if err != nil {
 		return err
 	}
-	js, err = json.Marshal(protocol.Request{
-		Method: method,
-		Params: js,
+	js, err = ctx.encode(protocol.Request{
+		JSONRPC: protocol.Version,
+		Method:  method,
+		Params:  js,
 	})
 	if err != nil {
 		return err
@@ -112,58 +339,180 @@ func (ctx *Scope) respond(ret protocol.Response) error {
 		// nil send function.  This is a programming error.
 		return fmt.Errorf(`response not supported`)
 	}
-	ret.ID = ctx.ID
-	msg, err := json.Marshal(&ret)
+	ret.JSONRPC = protocol.Version
+	ret.ID = protocol.ID(ctx.ID)
+	msg, err := ctx.encode(&ret)
 	if err != nil {
 		return fmt.Errorf(`%w while encoding response`, err)
 	}
+	if ctx.reply != nil {
+		return ctx.reply(msg)
+	}
 	return ctx.send(msg)
 }
 
+// encode encodes a message to send to the client.
+func (ctx *Scope) encode(v any) ([]byte, error) {
+	if ctx.marshal != nil {
+		return ctx.marshal(v)
+	}
+	return json.Marshal(v)
+}
+
 // An Option affects the rigging of an RPC API.
 type Option func(*config)
 
 type config struct {
-	handler      Handler
-	readLimit    int64
-	procHandlers map[string]Handler
-	callHandlers map[string]Handler
+	handler       Handler
+	readLimit     int64
+	procHandlers  map[string]Handler
+	callHandlers  map[string]Handler
+	maxConcurrent int                              // zero if unlimited
+	pingInterval  time.Duration                    // zero if no pings are sent
+	authorize     func(*http.Request) (any, error) // nil if connections are not authorized
+	debug         bool                             // true if requests and responses are logged
+	marshal       func(any) ([]byte, error)        // nil if json.Marshal is used
+	budgetLimit   int                              // zero if there is no budget
+	budgetCost    func(string) int                 // nil if every request costs 1
+	shedWait      time.Duration                    // zero if requests are shed immediately
+	observe       func(Observation)                // nil if requests are not observed
+	budget        *budget                          // shared by every connection, nil if there is no budget
+	workers       int                              // zero if each request has its own goroutine
+	pool          *pool                            // shared by every connection, nil if there are no workers
+	notFound      Handler                          // nil if unknown functions fail with "not found"
+	closeTimeout  time.Duration                    // zero if connections are closed without a handshake
+	compressAt    int                              // zero if messages are never compressed
+	sse           bool                             // true if requests may be posted for server sent events
+	metrics       *FunctionMetrics                 // nil if metrics are not aggregated
+	tracer        tracing.Tracer                   // nil if requests are not traced
+	aliases       [][2]string                      // added by Alias, resolved by init
 }
 
-func (cfg *config) init(options ...Option) {
+func (cfg *config) init(options ...Option) error {
 	cfg.readLimit = -1
+	cfg.closeTimeout = DefaultCloseTimeout
 	cfg.handler = cfg.handleRequest
 	cfg.procHandlers = make(map[string]Handler, len(options))
 	cfg.callHandlers = make(map[string]Handler, len(options))
 	for _, opt := range options {
 		opt(cfg)
 	}
+	err := cfg.resolveAliases()
+	if err != nil {
+		return err
+	}
+	if cfg.budgetLimit > 0 {
+		cfg.budget = newBudget(cfg.budgetLimit, cfg.budgetCost, cfg.shedWait)
+	}
+	if cfg.workers > 0 {
+		cfg.pool = newPool(cfg.workers)
+	}
+	if len(cfg.procHandlers) == 0 && len(cfg.callHandlers) == 0 && cfg.notFound == nil {
+		hog.From(context.Background()).Warn().Msg(`JRPC service has no functions, every request will fail with "not found"`)
+	}
+	return nil
+}
+
+// scope returns the scope of a request received by the service.
+func (cfg *config) scope(ctx context.Context, req protocol.Request, send func([]byte) error) *Scope {
+	scope := For(ctx, req, send)
+	scope.marshal = cfg.marshal
+	return scope
 }
 
 // ServeHTTP implements http.Handler.
 func (cfg *config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := cfg.serveHTTP(w, r)
 	if err != nil {
-		hog.For(r).Error().Err(err).Msg(`MRPC error`)
+		hog.For(r).Error().Err(err).Msg(`JRPC error`)
+	}
+}
+
+// isUpgrade returns true if the request asks to upgrade the connection to a WebSocket, so requests that do not can be
+// told so instead of failing as a broken WebSocket handshake.
+func isUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, `Connection`, `upgrade`) && headerHasToken(r.Header, `Upgrade`, `websocket`)
+}
+
+// headerHasToken returns true if a comma separated header contains the token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, item := range strings.Split(value, `,`) {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
 	}
+	return false
 }
 
 func (cfg *config) serveHTTP(w http.ResponseWriter, r *http.Request) error {
+	if cfg.authorize != nil {
+		principal, err := cfg.authorize(r)
+		switch {
+		case errors.Is(err, ErrUnauthorized):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return nil
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return nil
+		}
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+	}
+	if cfg.tracer != nil {
+		r = r.WithContext(tracing.Extract(r))
+	}
+	if !isUpgrade(r) && cfg.sse && r.Method == http.MethodPost {
+		return cfg.serveSSE(w, r)
+	}
+	if !isUpgrade(r) {
+		// Browsers, crawlers and health checks that wander in are not worth an error in the logs.
+		hog.For(r).Debug().Msg(`rejected a request without a WebSocket upgrade`)
+		w.Header().Set(`Upgrade`, `websocket`)
+		w.Header().Set(`Connection`, `Upgrade`)
+		http.Error(w, `this endpoint requires a WebSocket connection`, http.StatusUpgradeRequired)
+		return nil
+	}
 	c, err := websocket.Accept(w, r, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return err
+		return err // Accept has already responded.
+	}
+	defer cfg.close(r.Context(), c)
+	if cfg.metrics != nil {
+		cfg.metrics.connections.Add(1)
+		defer cfg.metrics.connections.Add(-1)
 	}
-	defer func() { _ = c.CloseNow() }()
 	c.SetReadLimit(cfg.readLimit)
-	send := func(bin []byte) error {
-		return c.Write(r.Context(), websocket.MessageText, bin)
+	var cz *compressor
+	if cfg.compressAt > 0 && wantsCompression(r) {
+		cz = new(compressor)
 	}
+	queue := newSendQueue(r.Context(), func(ctx context.Context, bin []byte) error {
+		if cfg.debug {
+			hog.From(ctx).Trace().RawJSON(`response`, bin).Msg(`JRPC response`)
+		}
+		if cz != nil && len(bin) >= cfg.compressAt {
+			gz, err := cz.compress(bin)
+			if err != nil {
+				return err
+			}
+			return c.Write(ctx, websocket.MessageBinary, gz)
+		}
+		return c.Write(ctx, websocket.MessageText, bin)
+	})
+	defer queue.close() // after the requests in flight have finished sending.
+	send := queue.send
 	handle := cfg.handler
 
 	ctx := r.Context()
 	var group sync.WaitGroup
 	defer group.Wait()
+	if cfg.pingInterval > 0 {
+		pingCtx, stopPings := context.WithCancel(ctx)
+		defer stopPings()
+		go keepAlive(pingCtx, c, cfg.pingInterval)
+	}
+	slots := cfg.newSlots()
 	for {
 		mt, msg, err := c.Read(ctx)
 		if err != nil {
@@ -175,16 +524,250 @@ func (cfg *config) serveHTTP(w http.ResponseWriter, r *http.Request) error {
 		if mt != websocket.MessageText {
 			continue
 		}
+		if cfg.debug {
+			hog.From(ctx).Trace().RawJSON(`request`, msg).Msg(`JRPC request`)
+		}
+		if isBatch(msg) {
+			// Each request in the batch claims its own slot, so a batch cannot exceed MaxConcurrent.
+			group.Add(1)
+			cfg.pool.run(func() {
+				defer group.Done()
+				err := cfg.handleBatch(ctx, msg, send, handle, slots)
+				if err != nil {
+					hog.From(ctx).Error().Err(err).Msg(`JRPC batch error`)
+				}
+			})
+			continue
+		}
+		started := time.Now()
 		var req protocol.Request
 		err = json.Unmarshal(msg, &req)
 		if err != nil {
-			return err
+			// The ID of the request cannot be trusted, so the response has a null ID, as JSON-RPC 2.0 requires.
+			_ = cfg.scope(ctx, protocol.Request{}, send).Fail(ParseError, err.Error())
+			continue
+		}
+		if !validVersion(req) {
+			_ = cfg.scope(ctx, req, send).Fail(InvalidRequest, fmt.Sprintf(`unsupported version %q`, req.JSONRPC))
+			continue
+		}
+		obs := Observation{Method: req.Method, Decode: time.Since(started)}
+		if !acquireSlot(slots) {
+			_ = cfg.scope(ctx, req, send).Fail(TooManyRequests, `too many concurrent requests`)
+			continue
+		}
+		cost, ok := cfg.acquireBudget(ctx, &obs)
+		if !ok {
+			releaseSlot(slots)
+			_ = cfg.scope(ctx, req, send).Fail(ServiceBusy, `service busy`)
+			continue
+		}
+		group.Add(1)
+		cfg.pool.run(func() {
+			defer group.Done()
+			defer releaseSlot(slots)
+			defer cfg.budget.release(cost)
+			cfg.handleObserved(cfg.scope(ctx, req, send), handle, obs)
+		})
+	}
+}
+
+// validVersion returns false if the request specifies a version other than 2.0.  Requests that do not specify a version
+// are accepted for compatibility with older clients.
+func validVersion(req protocol.Request) bool {
+	return req.JSONRPC == `` || req.JSONRPC == protocol.Version
+}
+
+// isBatch returns true if the message is a JSON array, which JSON-RPC 2.0 uses for a batch of requests.
+func isBatch(msg []byte) bool {
+	msg = bytes.TrimLeft(msg, " \t\r\n")
+	return len(msg) > 0 && msg[0] == '['
+}
+
+// handleBatch handles a batch of requests concurrently, sending their responses as a single array once they have all
+// been handled.  Notifications do not have responses, so nothing is sent if the batch only contains notifications.
+// Like separate requests, each request claims one of the slots of the connection, failing with TooManyRequests if
+// none are free.
+func (cfg *config) handleBatch(
+	ctx context.Context, msg []byte, send func([]byte) error, handle Handler, slots chan struct{},
+) error {
+	var items []json.RawMessage
+	err := json.Unmarshal(msg, &items)
+	if err != nil {
+		return cfg.scope(ctx, protocol.Request{}, send).Fail(ParseError, err.Error())
+	}
+	if len(items) == 0 {
+		return cfg.scope(ctx, protocol.Request{}, send).Fail(InvalidRequest, `empty batch`)
+	}
+
+	var control sync.Mutex
+	replies := make([][]byte, 0, len(items))
+	reply := func(bin []byte) error {
+		control.Lock()
+		defer control.Unlock()
+		replies = append(replies, bin)
+		return nil
+	}
+	var group sync.WaitGroup
+	for _, item := range items {
+		started := time.Now()
+		var req protocol.Request
+		err := json.Unmarshal(item, &req)
+		scope := cfg.scope(ctx, req, send)
+		scope.reply = reply
+		if err != nil {
+			_ = scope.Fail(InvalidRequest, err.Error())
+			continue
+		}
+		if !validVersion(req) {
+			_ = scope.Fail(InvalidRequest, fmt.Sprintf(`unsupported version %q`, req.JSONRPC))
+			continue
+		}
+		if req.ID == `` {
+			scope.reply = discardReply // notifications have no response, even if they fail.
+		}
+		obs := Observation{Method: req.Method, Decode: time.Since(started)}
+		if !acquireSlot(slots) {
+			_ = scope.Fail(TooManyRequests, `too many concurrent requests`)
+			continue
+		}
+		cost, ok := cfg.acquireBudget(ctx, &obs)
+		if !ok {
+			releaseSlot(slots)
+			_ = scope.Fail(ServiceBusy, `service busy`)
+			continue
 		}
 		group.Add(1)
-		go func() {
+		cfg.pool.run(func() {
 			defer group.Done()
-			handle(For(ctx, req, send))
-		}()
+			defer releaseSlot(slots)
+			defer cfg.budget.release(cost)
+			cfg.handleObserved(scope, handle, obs)
+		})
+	}
+	group.Wait()
+
+	if len(replies) == 0 {
+		return nil
+	}
+	// The replies have already been encoded, so we join them ourselves instead of encoding them again.
+	var bin bytes.Buffer
+	bin.WriteByte('[')
+	bin.Write(bytes.Join(replies, []byte{','}))
+	bin.WriteByte(']')
+	return send(bin.Bytes())
+}
+
+// discardReply is the reply of a notification in a batch, which JSON-RPC 2.0 does not answer.
+func discardReply([]byte) error { return nil }
+
+// acquireBudget claims the cost of a request from the budget, observing the time spent waiting and whether the request
+// was shed.
+func (cfg *config) acquireBudget(ctx context.Context, obs *Observation) (int, bool) {
+	started := time.Now()
+	cost, ok := cfg.budget.acquire(ctx, obs.Method)
+	obs.Wait = time.Since(started)
+	if !ok {
+		obs.Shed, obs.Failed = true, true
+		cfg.observeRequest(*obs)
+	}
+	return cost, ok
+}
+
+// handleObserved handles a request, observing the time spent handling it and tracing it with a span, see Trace.
+func (cfg *config) handleObserved(scope *Scope, handle Handler, obs Observation) {
+	started := time.Now()
+	span := cfg.startSpan(scope)
+	handle(scope)
+	endSpan(span, scope)
+	obs.Handle, obs.Failed = time.Since(started), scope.failure != nil
+	cfg.observeRequest(obs)
+}
+
+// observeRequest records the observation in the Metrics and calls the Observe function, if any.
+func (cfg *config) observeRequest(obs Observation) {
+	cfg.recordMetrics(obs)
+	if cfg.observe != nil {
+		cfg.observe(obs)
+	}
+}
+
+// close closes the connection with a close handshake, giving up on the handshake after the CloseTimeout.  The status
+// is StatusGoingAway if the request has been cancelled, such as when the server is shutting down.
+func (cfg *config) close(ctx context.Context, c *websocket.Conn) {
+	if cfg.closeTimeout <= 0 {
+		_ = c.CloseNow()
+		return
+	}
+	status := websocket.StatusNormalClosure
+	if ctx.Err() != nil {
+		status = websocket.StatusGoingAway
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		_ = c.Close(status, ``) // a no-op if the client closed the connection first.
+	}()
+	timer := time.NewTimer(cfg.closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-closed:
+	case <-timer.C:
+		hog.From(ctx).Debug().Dur(`timeout`, cfg.closeTimeout).Msg(`client did not acknowledge closing the connection`)
+	}
+}
+
+// keepAlive pings the connection at the given interval until the context is done, closing the connection if a pong
+// does not arrive within the interval.
+func keepAlive(ctx context.Context, c *websocket.Conn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := c.Ping(pingCtx)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			_ = c.Close(websocket.StatusGoingAway, `ping timeout`)
+			return
+		}
+	}
+}
+
+// newSlots returns the slots limiting the concurrent requests of a connection, see MaxConcurrent, or nil if there is
+// no limit.
+func (cfg *config) newSlots() chan struct{} {
+	if cfg.maxConcurrent <= 0 {
+		return nil
+	}
+	return make(chan struct{}, cfg.maxConcurrent)
+}
+
+// acquireSlot claims one of the slots limiting concurrent requests, returning false if none are free.  A nil set of
+// slots imposes no limit.
+func acquireSlot(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseSlot releases a slot claimed by acquireSlot.
+func releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
 	}
 }
 
@@ -196,8 +779,11 @@ func (cfg *config) handleRequest(ctx *Scope) {
 		table = cfg.callHandlers
 	}
 	handler := table[ctx.Method]
+	if handler == nil && cfg.notFound != nil {
+		handler = cfg.notFound
+	}
 	if handler == nil {
-		ctx.Fail(404, fmt.Sprintf(`function %q not found`, ctx.Method))
+		ctx.Fail(MethodNotFound, fmt.Sprintf(`function %q not found`, ctx.Method))
 		return
 	}
 	handler(ctx)
@@ -210,7 +796,7 @@ func Proc[I any](function string, fn func(*Scope, I)) Option {
 			in := new(I)
 			err := json.Unmarshal(ctx.Params, in)
 			if err != nil {
-				_ = ctx.Fail(406, fmt.Sprintf(`%v while decoding input`, err))
+				_ = ctx.Fail(InvalidParams, fmt.Sprintf(`%v while decoding input`, err))
 				return
 			}
 			fn(ctx, *in)
@@ -227,12 +813,12 @@ func Fn[I, O any](
 			in := new(I)
 			err := json.Unmarshal(ctx.Params, in)
 			if err != nil {
-				_ = ctx.Fail(406, fmt.Sprintf(`%v while decoding input`, err))
+				_ = ctx.Fail(InvalidParams, fmt.Sprintf(`%v while decoding input`, err))
 				return
 			}
 			out, err := fn(ctx, *in)
 			if err != nil {
-				_ = ctx.Fail(500, err.Error())
+				_ = ctx.FailWith(err)
 				return
 			}
 			_ = ctx.Succ(out)
@@ -0,0 +1,901 @@
+package jrpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/swdunlop/rig-go/rig/rpcerr"
+	"github.com/swdunlop/rig-go/rig/tracing"
+	"nhooyr.io/websocket"
+)
+
+func TestConcurrentResponses(t *testing.T) {
+	srv := httptest.NewServer(Handle(
+		Fn(`echo`, func(ctx *Scope, in int) (int, error) { return in, nil }),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	c, _, err := websocket.Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseNow()
+
+	const n = 500
+	for i := 0; i < n; i++ {
+		err = c.Write(ctx, websocket.MessageText, []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":"%v","method":"echo","params":%v}`, i, i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := make(map[string]bool, n)
+	for len(seen) < n {
+		_, msg, err := c.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rsp struct {
+			ID     string `json:"id"`
+			Result int    `json:"result"`
+		}
+		err = json.Unmarshal(msg, &rsp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rsp.ID != fmt.Sprint(rsp.Result) || seen[rsp.ID] {
+			t.Fatalf(`unexpected response %s`, msg)
+		}
+		seen[rsp.ID] = true
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	for _, test := range []struct {
+		options []Option
+		expect  string
+	}{
+		{nil, `"error":{"code":-32601,"message":"function \"missing\" not found"}`},
+		{[]Option{NotFoundHandler(func(ctx *Scope) { _ = ctx.Succ(`caught ` + ctx.Method) })}, `"result":"caught missing"`},
+	} {
+		srv := httptest.NewServer(Handle(test.options...))
+		ctx := context.Background()
+		c, _, err := websocket.Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Write(ctx, websocket.MessageText, []byte(`{"jsonrpc":"2.0","id":"1","method":"missing"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, msg, err := c.Read(ctx)
+		c.CloseNow()
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(msg), test.expect) {
+			t.Fatalf(`expected %s in %s`, test.expect, msg)
+		}
+	}
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		pool *pool
+	}{
+		{`goroutines`, nil},
+		{`workers`, newPool(64)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var group sync.WaitGroup
+			for i := 0; i < b.N; i++ {
+				group.Add(1)
+				bench.pool.run(group.Done)
+			}
+			group.Wait()
+		})
+	}
+}
+
+func TestRejectPlainRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handle().ServeHTTP(w, httptest.NewRequest(`GET`, `/`, nil))
+	if w.Code != http.StatusUpgradeRequired || w.Header().Get(`Upgrade`) != `websocket` {
+		t.Fatalf(`expected 426 Upgrade Required, got %v %v`, w.Code, w.Header())
+	}
+}
+
+func TestCompress(t *testing.T) {
+	srv := httptest.NewServer(Handle(
+		Compress(100),
+		Fn(`echo`, func(ctx *Scope, in string) (string, error) { return in, nil }),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	long := strings.Repeat(`x`, 200)
+	for _, test := range []struct {
+		query, in string
+		expect    websocket.MessageType
+	}{
+		{`?compress=gzip`, long, websocket.MessageBinary},
+		{`?compress=gzip`, `short`, websocket.MessageText},
+		{``, long, websocket.MessageText},
+	} {
+		c, _, err := websocket.Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`)+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Write(ctx, websocket.MessageText, []byte(`{"jsonrpc":"2.0","id":"1","method":"echo","params":"`+test.in+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mt, msg, err := c.Read(ctx)
+		c.CloseNow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mt != test.expect {
+			t.Fatalf(`%q with %d bytes: expected %v, got %v`, test.query, len(test.in), test.expect, mt)
+		}
+		if mt == websocket.MessageBinary {
+			zr, err := gzip.NewReader(bytes.NewReader(msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err = io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		var rsp struct {
+			Result string `json:"result"`
+		}
+		if err := json.Unmarshal(msg, &rsp); err != nil || rsp.Result != test.in {
+			t.Fatalf(`expected %q to be echoed, got %s (%v)`, test.in, msg, err)
+		}
+	}
+}
+
+func TestSSE(t *testing.T) {
+	srv := httptest.NewServer(Handle(
+		SSE(true),
+		Fn(`count`, func(ctx *Scope, n int) (int, error) {
+			for i := 1; i < n; i++ {
+				err := ctx.Succ(i)
+				if err != nil {
+					return 0, err
+				}
+			}
+			return n, nil
+		}),
+	))
+	defer srv.Close()
+	req := `{"jsonrpc":"2.0","id":"7","method":"count","params":3}`
+	rsp, err := http.Post(srv.URL, `application/json`, strings.NewReader(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	if rsp.Header.Get(`Content-Type`) != `text/event-stream` {
+		t.Fatalf(`expected an event stream, got %v`, rsp.Header.Get(`Content-Type`))
+	}
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":\"7\",\"result\":1,\"error\":null,\"end\":false}\n\n" +
+		"event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":\"7\",\"result\":2,\"error\":null,\"end\":false}\n\n" +
+		"event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":\"7\",\"result\":3,\"error\":null,\"end\":false}\n\n" +
+		"event: end\ndata: {\"jsonrpc\":\"2.0\",\"id\":\"7\",\"result\":null,\"error\":null,\"end\":true}\n\n"
+	if string(body) != expect {
+		t.Fatalf(`expected %q, got %q`, expect, body)
+	}
+
+	w := httptest.NewRecorder()
+	Handle().ServeHTTP(w, httptest.NewRequest(`POST`, `/`, strings.NewReader(`{}`)))
+	if w.Code != http.StatusUpgradeRequired {
+		t.Fatalf(`expected posts to be rejected without SSE, got %v`, w.Code)
+	}
+}
+
+func TestPingInterval(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	srv := httptest.NewServer(Handle(
+		PingInterval(interval),
+		Fn(`echo`, func(ctx *Scope, in int) (int, error) { return in, nil }),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	url := `ws` + strings.TrimPrefix(srv.URL, `http`)
+	cl, err := Dial(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	time.Sleep(5 * interval) // the client answers pings while it waits for responses.
+	_, err = Call[int](ctx, cl, `echo`, 7)
+	if err != nil {
+		t.Fatalf(`expected a live connection to survive the pings, got %v`, err)
+	}
+
+	// A peer that stops reading never answers the pings, like one that vanished without closing the connection.
+	c, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseNow()
+	time.Sleep(5 * interval)
+	_, _, err = c.Read(ctx)
+	if websocket.CloseStatus(err) != websocket.StatusGoingAway {
+		t.Fatalf(`expected the service to close the connection when the pong did not arrive, got %v`, err)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	srv := httptest.NewServer(Handle(CloseTimeout(time.Second), ReadLimit(64)))
+	defer srv.Close()
+	ctx := context.Background()
+	c, _, err := websocket.Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseNow()
+	err = c.Write(ctx, websocket.MessageText, []byte(strings.Repeat(` `, 128))) // which makes the service close.
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = c.Read(ctx)
+	if websocket.CloseStatus(err) != websocket.StatusMessageTooBig {
+		t.Fatalf(`expected the service to close with a reason, got %v`, err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	notes := make(chan string, 1)
+	srv := httptest.NewServer(Handle(
+		Fn(`greet`, func(ctx *Scope, name string) (string, error) {
+			err := ctx.Notify(`greeting`, name)
+			if err != nil {
+				return ``, err
+			}
+			return `hello, ` + name, nil
+		}),
+		Fn(`fail`, func(ctx *Scope, in int) (int, error) {
+			return 0, &rpcerr.Error{Code: 42, Message: `no luck`, Data: in}
+		}),
+		Proc(`note`, func(ctx *Scope, note string) { notes <- note }),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	greetings := make(chan string, 1)
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`),
+		OnNotify(`greeting`, func(ctx context.Context, name string) { greetings <- name }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	out, err := Call[string](ctx, cl, `greet`, `world`)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case out != `hello, world`:
+		t.Fatalf(`unexpected result %q`, out)
+	case <-greetings != `world`:
+		t.Fatal(`expected a greeting notification for world`)
+	}
+
+	_, err = Call[int](ctx, cl, `fail`, 7)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != 42 || rpcErr.Message != `no luck` || string(rpcErr.Data) != `7` {
+		t.Fatalf(`expected a jrpc error with code 42 and data 7, got %v`, err)
+	}
+
+	_, err = Call[int](ctx, cl, `missing`, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != MethodNotFound {
+		t.Fatalf(`expected a method not found error, got %v`, err)
+	}
+
+	err = cl.Notify(ctx, `note`, `noted`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if <-notes != `noted` {
+		t.Fatal(`expected the service to receive the notification`)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(Handle(
+		MaxConcurrent(2),
+		Fn(`wait`, func(ctx *Scope, in int) (int, error) {
+			started <- struct{}{}
+			<-release
+			return in, nil
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := Call[int](ctx, cl, `wait`, i)
+			errs <- err
+		}()
+		<-started
+	}
+	_, err = Call[int](ctx, cl, `wait`, 2)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != TooManyRequests {
+		t.Fatalf(`expected the third request to fail with TooManyRequests, got %v`, err)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf(`expected the requests within the limit to complete, got %v`, err)
+		}
+	}
+
+	// A batch cannot get around the limit, since each of its requests claims a slot.
+	var control sync.Mutex
+	running, most := 0, 0
+	srv2 := httptest.NewServer(Handle(
+		MaxConcurrent(2),
+		Fn(`sleep`, func(ctx *Scope, in int) (int, error) {
+			control.Lock()
+			running++
+			most = max(most, running)
+			control.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			control.Lock()
+			running--
+			control.Unlock()
+			return in, nil
+		}),
+	))
+	defer srv2.Close()
+	batch := make([]string, 10)
+	for i := range batch {
+		batch[i] = fmt.Sprintf(`{"jsonrpc":"2.0","id":"%d","method":"sleep","params":%d}`, i, i)
+	}
+	rsp := exchange(t, dialRaw(t, srv2), `[`+strings.Join(batch, `,`)+`]`)
+	var replies []json.RawMessage
+	err = json.Unmarshal([]byte(rsp), &replies)
+	if err != nil || len(replies) != len(batch) {
+		t.Fatalf(`expected a reply to each request in the batch, got %s (%v)`, rsp, err)
+	}
+	control.Lock()
+	defer control.Unlock()
+	if most > 2 {
+		t.Fatalf(`expected at most 2 requests of the batch to run at once, got %v`, most)
+	}
+	if !strings.Contains(rsp, fmt.Sprintf(`"code":%d`, TooManyRequests)) {
+		t.Fatalf(`expected the requests beyond the limit to fail with TooManyRequests, got %s`, rsp)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	observed := make(chan Observation, 10)
+	srv := httptest.NewServer(Handle(
+		Budget(2, nil),
+		Observe(func(obs Observation) { observed <- obs }),
+		Fn(`wait`, func(ctx *Scope, in int) (int, error) {
+			started <- struct{}{}
+			<-release
+			return in, nil
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := Call[int](ctx, cl, `wait`, i)
+			errs <- err
+		}()
+		<-started
+	}
+	_, err = Call[int](ctx, cl, `wait`, 2)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != ServiceBusy {
+		t.Fatalf(`expected the request over the budget to be shed with ServiceBusy, got %v`, err)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf(`expected the requests within the budget to complete, got %v`, err)
+		}
+	}
+
+	shed := 0
+	for i := 0; i < 3; i++ {
+		obs := <-observed
+		if obs.Method != `wait` || obs.Shed != obs.Failed || (obs.Shed && obs.Handle != 0) {
+			t.Fatalf(`unexpected observation %+v`, obs)
+		}
+		if obs.Shed {
+			shed++
+		}
+	}
+	if shed != 1 {
+		t.Fatalf(`expected one request to be shed, got %v`, shed)
+	}
+	select {
+	case obs := <-observed:
+		t.Fatalf(`expected one observation per request, got another %+v`, obs)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	handled := make(chan any, 10)
+	srv := httptest.NewServer(Handle(
+		Authorize(func(r *http.Request) (any, error) {
+			switch r.Header.Get(`Authorization`) {
+			case ``:
+				return nil, ErrUnauthorized
+			case `Bearer good`:
+				return `alice`, nil
+			default:
+				return nil, errors.New(`bad token`)
+			}
+		}),
+		Fn(`whoami`, func(ctx *Scope, in int) (any, error) {
+			handled <- ctx.Principal()
+			return ctx.Principal(), nil
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	url := `ws` + strings.TrimPrefix(srv.URL, `http`)
+	for token, status := range map[string]int{``: http.StatusUnauthorized, `Bearer bad`: http.StatusForbidden} {
+		header := make(http.Header)
+		if token != `` {
+			header.Set(`Authorization`, token)
+		}
+		_, rsp, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: header})
+		if err == nil || rsp == nil || rsp.StatusCode != status {
+			t.Fatalf(`expected %q to be rejected with %v, got %v`, token, status, err)
+		}
+	}
+
+	header := make(http.Header)
+	header.Set(`Authorization`, `Bearer good`)
+	c, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: header})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseNow()
+	rsp := exchange(t, c, `{"jsonrpc":"2.0","id":"1","method":"whoami","params":0}`)
+	if !strings.Contains(rsp, `"result":"alice"`) {
+		t.Fatalf(`expected the principal in the result, got %s`, rsp)
+	}
+	if len(handled) != 1 {
+		t.Fatalf(`expected only the authorized request to be handled, got %v`, len(handled))
+	}
+}
+
+func TestDebug(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		var buf bytes.Buffer
+		log := zerolog.New(zerolog.SyncWriter(&buf))
+		h := Handle(Debug(enabled), Fn(`echo`, func(ctx *Scope, in int) (int, error) { return in, nil }))
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(log.WithContext(r.Context())))
+		}))
+		c := dialRaw(t, srv)
+		rsp := exchange(t, c, `{"jsonrpc":"2.0","id":"1","method":"echo","params":7}`)
+		c.Close(websocket.StatusNormalClosure, ``)
+		srv.Close()
+		if rsp != `{"jsonrpc":"2.0","id":"1","result":7,"error":null,"end":false}` {
+			t.Fatalf(`expected Debug(%v) to leave the response alone, got %s`, enabled, rsp)
+		}
+		logged := buf.String()
+		for _, expect := range []string{
+			`"request":{"jsonrpc":"2.0","id":"1","method":"echo","params":7},"message":"JRPC request"`,
+			`"response":{"jsonrpc":"2.0","id":"1","result":7,"error":null,"end":false},"message":"JRPC response"`,
+		} {
+			if strings.Contains(logged, expect) != enabled {
+				t.Fatalf(`expected Debug(%v) to decide whether %s is logged, got %s`, enabled, expect, logged)
+			}
+		}
+	}
+}
+
+func TestFailData(t *testing.T) {
+	srv := httptest.NewServer(Handle(
+		Fn(`plain`, func(ctx *Scope, in int) (int, error) { return 0, rpcerr.New(42, `no luck`) }),
+		NotFoundHandler(func(ctx *Scope) { _ = ctx.FailData(InvalidParams, `bad input`, map[string]string{`name`: `missing`}) }),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	_, err = Call[int](ctx, cl, `detailed`, 1)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != InvalidParams || string(rpcErr.Data) != `{"name":"missing"}` {
+		t.Fatalf(`expected the data in the error, got %v`, err)
+	}
+	_, err = Call[int](ctx, cl, `plain`, 1)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != 42 || rpcErr.Data != nil {
+		t.Fatalf(`expected an error without data, got %v`, err)
+	}
+
+	c := dialRaw(t, srv)
+	rsp := exchange(t, c, `{"jsonrpc":"2.0","id":"1","method":"plain","params":1}`)
+	if !strings.Contains(rsp, `"error":{"code":42,"message":"no luck"}`) {
+		t.Fatalf(`expected the data to be omitted, got %s`, rsp)
+	}
+}
+
+func TestFailWith(t *testing.T) {
+	failures := map[string]error{
+		`notFound`:     rpcerr.NotFound(`no such user`),
+		`unauthorized`: rpcerr.Unauthorized(`no token`),
+		`invalid`:      rpcerr.Invalid(`bad input`),
+		`unavailable`:  rpcerr.Unavailable(`try later`),
+		`conflict`:     rpcerr.New(http.StatusConflict, `already exists`),
+		`from`:         rpcerr.From(errors.New(`oops`)),
+		`plain`:        errors.New(`oops`),
+		`standard`:     rpcerr.New(MethodNotFound, `gone`),
+		`application`:  rpcerr.New(42, `no luck`),
+	}
+	options := make([]Option, 0, len(failures))
+	for name, err := range failures {
+		options = append(options, Fn(name, func(ctx *Scope, in int) (int, error) { return 0, err }))
+	}
+	srv := httptest.NewServer(Handle(options...))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	for name, code := range map[string]int{
+		`notFound`:     NotFound,
+		`unauthorized`: Unauthorized,
+		`invalid`:      InvalidParams,
+		`unavailable`:  ServiceBusy,
+		`conflict`:     InvalidParams,
+		`from`:         InternalError,
+		`plain`:        InternalError,
+		`standard`:     MethodNotFound,
+		`application`:  42,
+	} {
+		_, err := Call[int](ctx, cl, name, 1)
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) || rpcErr.Code != code {
+			t.Errorf(`expected %v to fail with code %v, got %v`, name, code, err)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	var metrics FunctionMetrics
+	srv := httptest.NewServer(Handle(
+		Metrics(&metrics),
+		Fn(`echo`, func(ctx *Scope, in int) (int, error) { return in, nil }),
+		Fn(`fail`, func(ctx *Scope, in int) (int, error) { return 0, errors.New(`no luck`) }),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	for _, method := range []string{`echo`, `echo`, `fail`, `invented`} {
+		_, _ = Call[int](ctx, cl, method, 1)
+	}
+	if n := metrics.Connections(); n != 1 {
+		t.Fatalf(`expected 1 open connection, got %v`, n)
+	}
+	functions := metrics.Functions()
+	for deadline := time.Now().Add(time.Second); functions[``].Requests == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond) // requests are observed after the response is sent.
+		functions = metrics.Functions()
+	}
+	for name, expect := range map[string][2]int64{`echo`: {2, 0}, `fail`: {1, 1}, ``: {1, 1}} {
+		st := functions[name]
+		if st.Requests != expect[0] || st.Failed != expect[1] {
+			t.Fatalf(`expected %v requests and %v failures for %q, got %+v`, expect[0], expect[1], name, st)
+		}
+	}
+	if len(functions) != 3 {
+		t.Fatalf(`expected stats for 3 methods, got %v`, functions)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	spans := make(testTracer, 2)
+	srv := httptest.NewServer(Handle(
+		Trace(spans),
+		Fn(`echo`, func(ctx *Scope, in int) (int, error) { return in, nil }),
+		Fn(`fail`, func(ctx *Scope, in int) (int, error) {
+			return 0, &rpcerr.Error{Code: 42, Message: `no luck`}
+		}),
+	))
+	defer srv.Close()
+	parent, err := tracing.ParseParent(`00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := tracing.WithParent(context.Background(), parent)
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	_, err = Call[int](ctx, cl, `echo`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Call[int](ctx, cl, `fail`, 1)
+	if err == nil {
+		t.Fatal(`expected fail to fail`)
+	}
+	ended := spans.await(t, 2)
+	for _, expect := range []testSpan{{name: `echo`, parent: parent}, {name: `fail`, parent: parent, code: 42}} {
+		if span := ended[expect.name]; span != expect {
+			t.Fatalf(`expected span %+v, got %+v`, expect, span)
+		}
+	}
+}
+
+// A testTracer sends each span to the channel once it ends.
+type testTracer chan testSpan
+
+// await returns the next n spans to end by name, since spans may end after their responses are sent.
+func (tr testTracer) await(t *testing.T, n int) map[string]testSpan {
+	ended := make(map[string]testSpan, n)
+	for range n {
+		select {
+		case span := <-tr:
+			ended[span.name] = span
+		case <-time.After(5 * time.Second):
+			t.Fatalf(`expected %v spans to end, got %v`, n, len(ended))
+		}
+	}
+	return ended
+}
+
+func (tr testTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	parent, _ := tracing.ParentOf(ctx)
+	return ctx, &testSpan{tracer: tr, name: name, parent: parent}
+}
+
+type testSpan struct {
+	tracer testTracer
+	name   string
+	parent tracing.Parent
+	code   int
+}
+
+func (span *testSpan) Fail(code int, msg string) { span.code = code }
+
+func (span *testSpan) End() {
+	cp := *span
+	cp.tracer = nil
+	span.tracer <- cp
+}
+
+func TestAlias(t *testing.T) {
+	notes := make(chan string, 1)
+	srv := httptest.NewServer(Handle(
+		Fn(`users.get`, func(ctx *Scope, id int) (int, error) { return id, nil }),
+		Proc(`users.note`, func(ctx *Scope, note string) { notes <- note }),
+		Alias(`users.get`, `getUser`),
+		Alias(`users.note`, `noteUser`),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	out, err := Call[int](ctx, cl, `getUser`, 7)
+	if err != nil || out != 7 {
+		t.Fatalf(`expected the alias to call users.get, got %v, %v`, out, err)
+	}
+	err = cl.Notify(ctx, `noteUser`, `noted`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case note := <-notes:
+		if note != `noted` {
+			t.Fatalf(`unexpected note %q`, note)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`expected the alias to notify users.note`)
+	}
+
+	echo := func(ctx *Scope, in int) (int, error) { return in, nil }
+	_, err = New(Fn(`a`, echo), Fn(`b`, echo), Alias(`a`, `b`))
+	if err == nil || !strings.Contains(err.Error(), `already the name`) {
+		t.Fatalf(`expected an alias that shadows a method to fail, got %v`, err)
+	}
+	_, err = New(Alias(`missing`, `b`))
+	if err == nil || !strings.Contains(err.Error(), `not registered`) {
+		t.Fatalf(`expected an alias of a missing method to fail, got %v`, err)
+	}
+	w := httptest.NewRecorder()
+	Handle(Alias(`missing`, `b`)).ServeHTTP(w, httptest.NewRequest(`POST`, `/`, nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf(`expected a misconfigured service to fail requests with 500, got %v`, w.Code)
+	}
+}
+
+// dialRaw connects a WebSocket to the service without a Client, so tests can send messages the Client would not.
+func dialRaw(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	c, _, err := websocket.Dial(context.Background(), `ws`+strings.TrimPrefix(srv.URL, `http`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.CloseNow() })
+	return c
+}
+
+// exchange sends a message on a raw connection and returns the next message from the service.
+func exchange(t *testing.T, c *websocket.Conn, msg string) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := c.Write(ctx, websocket.MessageText, []byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rsp, err := c.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(rsp)
+}
+
+func TestParseError(t *testing.T) {
+	srv := httptest.NewServer(Handle(
+		Fn(`echo`, func(ctx *Scope, in int) (int, error) { return in, nil }),
+	))
+	defer srv.Close()
+	c := dialRaw(t, srv)
+	rsp := exchange(t, c, `not a request`)
+	if !strings.Contains(rsp, `"id":null`) || !strings.Contains(rsp, `"code":-32700`) {
+		t.Fatalf(`expected a parse error with a null ID, got %s`, rsp)
+	}
+	// The connection survives, so later requests are still handled.
+	rsp = exchange(t, c, `{"jsonrpc":"2.0","id":"1","method":"echo","params":7}`)
+	if !strings.Contains(rsp, `"id":"1","result":7`) {
+		t.Fatalf(`expected the echo to be handled, got %s`, rsp)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	noted := make(chan int, 10)
+	srv := httptest.NewServer(Handle(
+		Fn(`echo`, func(ctx *Scope, in int) (int, error) { return in, nil }),
+		Proc(`note`, func(ctx *Scope, in int) { noted <- in }),
+	))
+	defer srv.Close()
+	c := dialRaw(t, srv)
+
+	rsp := exchange(t, c, `[
+		{"jsonrpc":"2.0","id":"1","method":"echo","params":1},
+		{"jsonrpc":"2.0","method":"note","params":2},
+		{"jsonrpc":"2.0","method":"missing"},
+		{"jsonrpc":"2.0","id":"3","method":"echo","params":3}
+	]`)
+	var replies []json.RawMessage
+	err := json.Unmarshal([]byte(rsp), &replies)
+	if err != nil || len(replies) != 2 || !strings.Contains(rsp, `"id":"1","result":1`) ||
+		!strings.Contains(rsp, `"id":"3","result":3`) {
+		t.Fatalf(`expected replies to the two requests and none to the notifications, got %s`, rsp)
+	}
+	if n := <-noted; n != 2 {
+		t.Fatalf(`expected the notification to be handled with 2, got %v`, n)
+	}
+
+	rsp = exchange(t, c, `[]`)
+	if !strings.Contains(rsp, `"id":null`) || !strings.Contains(rsp, `"code":-32600`) {
+		t.Fatalf(`expected an empty batch to be an invalid request, got %s`, rsp)
+	}
+
+	// A batch of notifications has no reply, so the next message is the response to the request that follows it.
+	ctx := context.Background()
+	err = c.Write(ctx, websocket.MessageText,
+		[]byte(`[{"jsonrpc":"2.0","method":"note","params":4},{"jsonrpc":"2.0","method":"missing"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp = exchange(t, c, `{"jsonrpc":"2.0","id":"6","method":"echo","params":6}`)
+	if !strings.Contains(rsp, `"id":"6","result":6`) {
+		t.Fatalf(`expected no reply to a batch of notifications, got %s`, rsp)
+	}
+
+	rsp = exchange(t, c, `[1,{"jsonrpc":"2.0","id":"7","method":"echo","params":7}]`)
+	err = json.Unmarshal([]byte(rsp), &replies)
+	if err != nil || len(replies) != 2 || !strings.Contains(rsp, `"id":null`) || !strings.Contains(rsp, `"code":-32600`) ||
+		!strings.Contains(rsp, `"id":"7","result":7`) {
+		t.Fatalf(`expected an invalid request for the invalid element and a reply to the other, got %s`, rsp)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	srv := httptest.NewServer(Handle(
+		Fn(`echo`, func(ctx *Scope, in int) (int, error) { return in, nil }),
+	))
+	defer srv.Close()
+	c := dialRaw(t, srv)
+	for msg, expect := range map[string]string{
+		`{"jsonrpc":"1.0","id":"1","method":"echo","params":1}`: `"id":"1","result":null,"error":{"code":-32600,"message":"unsupported version \"1.0\""}`,
+		`{"jsonrpc":"2.0","id":"2","method":"echo","params":2}`: `"id":"2","result":2`,
+		`{"id":"3","method":"echo","params":3}`:                 `"id":"3","result":3`,
+	} {
+		rsp := exchange(t, c, msg)
+		if !strings.Contains(rsp, expect) {
+			t.Errorf(`expected %s in the response to %s, got %s`, expect, msg, rsp)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	echo := Fn(`echo`, func(ctx *Scope, in string) (string, error) { return in, nil })
+	req := `{"jsonrpc":"2.0","id":"1","method":"echo","params":"<a href=\"?x&y\">"}`
+	for _, test := range []struct {
+		options []Option
+		expect  string
+	}{
+		{nil, `"result":"\u003ca href=\"?x\u0026y\"\u003e"`},
+		{[]Option{EscapeHTML(true)}, `"result":"\u003ca href=\"?x\u0026y\"\u003e"`},
+		{[]Option{EscapeHTML(false)}, `"result":"<a href=\"?x&y\">"`},
+	} {
+		srv := httptest.NewServer(Handle(append(test.options, echo)...))
+		rsp := exchange(t, dialRaw(t, srv), req)
+		srv.Close()
+		if !strings.Contains(rsp, test.expect) {
+			t.Errorf(`expected %s in %s`, test.expect, rsp)
+		}
+	}
+
+	marshal := func(v any) ([]byte, error) {
+		js, err := json.Marshal(v)
+		return bytes.Replace(js, []byte(`"result":`), []byte(`"marshaled":true,"result":`), 1), err
+	}
+	srv := httptest.NewServer(Handle(Marshal(marshal), echo))
+	defer srv.Close()
+	rsp := exchange(t, dialRaw(t, srv), `{"jsonrpc":"2.0","id":"1","method":"echo","params":"hi"}`)
+	if !strings.Contains(rsp, `"marshaled":true,"result":"hi"`) {
+		t.Fatalf(`expected the result to be encoded by the Marshal function, got %s`, rsp)
+	}
+}
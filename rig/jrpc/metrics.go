@@ -0,0 +1,103 @@
+package jrpc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics aggregates the Observations of each method registered with the service into m, counting its requests and
+// failures and keeping a histogram of how long it took to handle them, along with the number of open connections.
+// Requests for methods that are not registered with Fn or Proc, such as those handled by a NotFoundHandler, are counted
+// together under the empty name, so clients cannot grow the metrics by inventing names.  Like Observe, this is opt in, and the
+// same FunctionMetrics may be given to several services to aggregate all of them.
+func Metrics(m *FunctionMetrics) Option {
+	return func(cfg *config) { cfg.metrics = m }
+}
+
+// LatencyBuckets are the upper bounds of the latency histogram kept for each method by FunctionMetrics, which also
+// counts the requests slower than the last bound.  LatencyBuckets should be set before any service is configured.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 25 * time.Millisecond, 100 * time.Millisecond,
+	500 * time.Millisecond, 2500 * time.Millisecond, 10 * time.Second,
+}
+
+// FunctionMetrics holds the metrics aggregated by Metrics.  The zero value is ready to use.
+type FunctionMetrics struct {
+	connections atomic.Int64
+	control     sync.Mutex
+	functions   map[string]*FunctionStats
+}
+
+// FunctionStats describes the requests for a method, see FunctionMetrics.Functions.
+type FunctionStats struct {
+	Requests int64         // Requests handled or shed.
+	Failed   int64         // Requests that failed, including those that were shed.
+	Shed     int64         // Requests shed because the Budget was exceeded.
+	Handle   time.Duration // Total time spent handling requests.
+	Latency  []int64       // Requests by the time spent handling them, see LatencyBuckets; shed requests are not included.
+}
+
+// ErrorRate returns the fraction of requests that failed, or zero if there were none.
+func (st FunctionStats) ErrorRate() float64 {
+	if st.Requests == 0 {
+		return 0
+	}
+	return float64(st.Failed) / float64(st.Requests)
+}
+
+// Connections returns the number of connections that are currently open.
+func (m *FunctionMetrics) Connections() int64 { return m.connections.Load() }
+
+// Functions returns a copy of the stats of each method that has received a request.
+func (m *FunctionMetrics) Functions() map[string]FunctionStats {
+	m.control.Lock()
+	defer m.control.Unlock()
+	ret := make(map[string]FunctionStats, len(m.functions))
+	for name, st := range m.functions {
+		cp := *st
+		cp.Latency = append([]int64(nil), st.Latency...)
+		ret[name] = cp
+	}
+	return ret
+}
+
+// record adds an observation to the stats of a method.
+func (m *FunctionMetrics) record(method string, obs Observation) {
+	m.control.Lock()
+	defer m.control.Unlock()
+	st := m.functions[method]
+	if st == nil {
+		if m.functions == nil {
+			m.functions = make(map[string]*FunctionStats)
+		}
+		st = &FunctionStats{Latency: make([]int64, len(LatencyBuckets)+1)}
+		m.functions[method] = st
+	}
+	st.Requests++
+	if obs.Failed {
+		st.Failed++
+	}
+	if obs.Shed {
+		st.Shed++
+		return
+	}
+	st.Handle += obs.Handle
+	i := 0
+	for i < len(st.Latency)-1 && i < len(LatencyBuckets) && obs.Handle > LatencyBuckets[i] {
+		i++
+	}
+	st.Latency[i]++
+}
+
+// recordMetrics adds an observation to the metrics, if any.
+func (cfg *config) recordMetrics(obs Observation) {
+	if cfg.metrics == nil {
+		return
+	}
+	method := obs.Method
+	if cfg.callHandlers[method] == nil && cfg.procHandlers[method] == nil {
+		method = ``
+	}
+	cfg.metrics.record(method, obs)
+}
@@ -0,0 +1,124 @@
+package jrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig/jrpc/internal/protocol"
+)
+
+// SSE lets clients that cannot use WebSockets, such as those behind proxies that block them, send a single request as
+// the body of a POST and receive the messages sent for it as server sent events.  The body may be a request or a
+// batch, limited by ReadLimit, and the response is a "text/event-stream" with a "message" event for each message sent
+// to the client, such as each response from a handler that calls Succ more than once, followed by an "end" event once
+// the handler has returned, whose data is a response with "end" set to true and the ID of the request.  The stream is
+// closed after the "end" event; a client that disconnects cancels the scope of the request.
+//
+// A notification, which has no ID, is handled as it is over a WebSocket, with no response, but the stream still
+// carries any messages the handler sends and the "end" event, with an empty ID, so clients can tell when it has been
+// handled.  Since the client cannot answer over an event stream, calls sent with Scope.Call go unanswered.
+// MaxConcurrent, PingInterval and Compress do not apply to event streams, which carry one request each, while
+// Authorize, Budget and Observe do.  The default is to reject requests without a WebSocket upgrade.
+func SSE(enabled bool) Option {
+	return func(cfg *config) { cfg.sse = enabled }
+}
+
+// serveSSE handles a request posted without a WebSocket upgrade, see SSE.
+func (cfg *config) serveSSE(w http.ResponseWriter, r *http.Request) error {
+	body := io.Reader(r.Body)
+	if cfg.readLimit >= 0 {
+		body = http.MaxBytesReader(w, r.Body, cfg.readLimit)
+	}
+	msg, err := io.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil
+	case err != nil:
+		return err
+	}
+	ctx := r.Context()
+	if cfg.debug {
+		hog.From(ctx).Trace().RawJSON(`request`, msg).Msg(`JRPC request`)
+	}
+	es := &eventSender{ctx: ctx, w: w, rc: http.NewResponseController(w), debug: cfg.debug}
+	w.Header().Set(`Content-Type`, `text/event-stream`)
+	w.Header().Set(`Cache-Control`, `no-cache`)
+	w.WriteHeader(http.StatusOK)
+	send := es.send
+	started := time.Now()
+	var req protocol.Request
+	switch {
+	case isBatch(msg):
+		err = cfg.handleBatch(ctx, msg, send, cfg.handler, cfg.newSlots())
+		if err != nil {
+			return err
+		}
+	case json.Unmarshal(msg, &req) != nil:
+		_ = cfg.scope(ctx, protocol.Request{}, send).Fail(ParseError, `invalid request`)
+	case !validVersion(req):
+		_ = cfg.scope(ctx, req, send).Fail(InvalidRequest, fmt.Sprintf(`unsupported version %q`, req.JSONRPC))
+	default:
+		obs := Observation{Method: req.Method, Decode: time.Since(started)}
+		cost, ok := cfg.acquireBudget(ctx, &obs)
+		if !ok {
+			_ = cfg.scope(ctx, req, send).Fail(ServiceBusy, `service busy`)
+			break
+		}
+		func() {
+			defer cfg.budget.release(cost)
+			cfg.handleObserved(cfg.scope(ctx, req, send), cfg.handler, obs)
+		}()
+	}
+	end, err := json.Marshal(protocol.Response{JSONRPC: protocol.Version, ID: protocol.ID(req.ID), End: true})
+	if err != nil {
+		return err
+	}
+	return es.write(`end`, end)
+}
+
+// An eventSender sends messages to a client as server sent events, see SSE.
+type eventSender struct {
+	ctx   context.Context
+	w     http.ResponseWriter
+	rc    *http.ResponseController
+	debug bool
+
+	control sync.Mutex // since handlers in a batch send concurrently.
+}
+
+// send sends a message as a "message" event.
+func (es *eventSender) send(bin []byte) error {
+	if es.debug {
+		hog.From(es.ctx).Trace().RawJSON(`response`, bin).Msg(`JRPC response`)
+	}
+	return es.write(`message`, bin)
+}
+
+// write sends data as an event of the given type and flushes it.
+func (es *eventSender) write(event string, data []byte) error {
+	es.control.Lock()
+	defer es.control.Unlock()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: %s\n", event)
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		buf.WriteString(`data: `)
+		buf.Write(bytes.TrimSuffix(line, []byte{'\r'}))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	_, err := es.w.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return es.rc.Flush()
+}
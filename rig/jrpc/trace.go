@@ -0,0 +1,34 @@
+package jrpc
+
+import "github.com/swdunlop/rig-go/rig/tracing"
+
+// Trace starts a span with the tracer for each request handled by the service, named by its method, and records the
+// code and message of the error if the request fails.  Each request in a batch has its own span.  The spans share the
+// parent extracted from the "traceparent" header or query parameter of the request that opened the connection, or
+// posted the request if SSE is enabled, see tracing.Extract.  Requests refused by MaxConcurrent or shed by the Budget
+// are never handled, so they are not traced; use Observe or Metrics to count them.  The default is to trace nothing.
+func Trace(tracer tracing.Tracer) Option {
+	return func(cfg *config) { cfg.tracer = tracer }
+}
+
+// startSpan starts the span of a request, making it the parent of the scope, or returns nil if the service is not
+// traced.
+func (cfg *config) startSpan(scope *Scope) tracing.Span {
+	if cfg.tracer == nil {
+		return nil
+	}
+	ctx, span := cfg.tracer.Start(scope.Context, scope.Method)
+	scope.Context = ctx // From still finds the scope, since ctx is derived from its context.
+	return span
+}
+
+// endSpan ends the span of a request once it has been handled, recording its error, if any.  A nil span is ignored.
+func endSpan(span tracing.Span, scope *Scope) {
+	if span == nil {
+		return
+	}
+	if scope.failure != nil {
+		span.Fail(scope.failure.Code, scope.failure.Message)
+	}
+	span.End()
+}
@@ -0,0 +1,116 @@
+package rig
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig/hook"
+)
+
+// BeforeServe returns an option that calls fn before the rig starts serving its handlers.  See hook.BeforeServe for
+// when this is called; if fn returns an error, the rig will not serve.
+func BeforeServe(fn func(ctx context.Context) error) Option {
+	return func(cfg *Config) error {
+		cfg.Hook(beforeServeFunc(fn))
+		return nil
+	}
+}
+
+type beforeServeFunc func(ctx context.Context) error
+
+// RigBeforeServe implements hook.BeforeServe.
+func (fn beforeServeFunc) RigBeforeServe(ctx context.Context) error { return fn(ctx) }
+
+var _ hook.BeforeServe = beforeServeFunc(nil)
+
+// Started returns an option that calls fn with the addresses of the listeners once the rig is serving.  See
+// hook.Started for when this is called; if fn returns an error, the rig stops serving.
+func Started(fn func(ctx context.Context, addrs []net.Addr) error) Option {
+	return func(cfg *Config) error {
+		cfg.Hook(startedFunc(fn))
+		return nil
+	}
+}
+
+type startedFunc func(ctx context.Context, addrs []net.Addr) error
+
+// RigStarted implements hook.Started.
+func (fn startedFunc) RigStarted(ctx context.Context, addrs []net.Addr) error { return fn(ctx, addrs) }
+
+var _ hook.Started = startedFunc(nil)
+
+// Migrate returns an option that runs fn once before the rig starts serving its handlers, such as to apply database
+// schema migrations.  Serving is blocked until fn returns, and if fn returns an error the rig will not serve.
+//
+// When the rig is Run without RIG_SOCKET in the environment, the process is only a supervisor that proxies requests
+// to a worker, and migrations are left to the first worker it starts; workers that replace it, which have a
+// Generation above 1, skip fn since the supervisor has already migrated.  A supervisor that takes over with Handover
+// continues counting generations, but marks the first worker it starts so migrations run again, since the handover
+// usually brings a new executable with migrations of its own.  When the rig is served directly with Serve,
+// migrations run in that process.  In every case, fn is called at most once per process, even if the option is
+// applied more than once.
+func Migrate(fn func(ctx context.Context) error) Option {
+	var m migration
+	m.fn = fn
+	return BeforeServe(m.run)
+}
+
+type migration struct {
+	once sync.Once
+	fn   func(ctx context.Context) error
+	err  error
+}
+
+func (m *migration) run(ctx context.Context) error {
+	if Generation() > 1 && os.Getenv(`RIG_FIRST_WORKER`) == `` {
+		return nil // a restarted worker, see Migrate.
+	}
+	m.once.Do(func() {
+		err := m.fn(ctx)
+		if err != nil {
+			m.err = fmt.Errorf(`%w while migrating`, err)
+		}
+	})
+	return m.err
+}
+
+// OnShutdown returns an option that calls fn when the rig stops serving its handlers, such as to close a database or
+// flush a buffer, without implementing a hook.  Like hook.BeforeServe, this happens in the worker process or when the
+// rig is served directly, but not in the supervisor.
+//
+// Functions run in the reverse of the order they were added, once the server has stopped and background workers added
+// by Worker have returned, even if serving failed or a BeforeServe hook returned an error.  Each is given a context
+// that expires after ShutdownTimeout.  The hook package has no interface for cleanup, so options that add hooks which
+// need one should use OnShutdown as well.
+func OnShutdown(fn func(ctx context.Context)) Option {
+	return func(cfg *Config) error {
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.onShutdown = append(cfg.onShutdown, fn)
+		return nil
+	}
+}
+
+// ShutdownTimeout limits how long each function given to OnShutdown may take before its context expires.
+var ShutdownTimeout = 10 * time.Second
+
+// shutdown calls the functions given to OnShutdown in reverse order; ctx provides values, such as the logger, but its
+// cancellation is ignored since it is usually cancelled by now.
+func (cfg *Config) shutdown(ctx context.Context) {
+	cfg.control.Lock()
+	fns := slices.Clone(cfg.onShutdown)
+	cfg.control.Unlock()
+	ctx = context.WithoutCancel(ctx)
+	for i := len(fns) - 1; i >= 0; i-- {
+		func() {
+			ctx, cancel := context.WithTimeout(ctx, ShutdownTimeout)
+			defer cancel()
+			fns[i](ctx)
+		}()
+	}
+}
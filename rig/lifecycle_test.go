@@ -0,0 +1,147 @@
+package rig
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOnShutdown(t *testing.T) {
+	var order []int
+	cleanup := func(n int) Option {
+		return OnShutdown(func(ctx context.Context) {
+			if _, ok := ctx.Deadline(); !ok || ctx.Err() != nil {
+				t.Errorf(`expected cleanup %v to have a live context with a deadline`, n)
+			}
+			order = append(order, n)
+		})
+	}
+	errFailed := errors.New(`failed`)
+	cfg, err := New(
+		cleanup(1),
+		cleanup(2),
+		BeforeServe(func(ctx context.Context) error { return errFailed }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = cfg.Serve(ctx)
+	if !errors.Is(err, errFailed) {
+		t.Fatalf(`expected Serve to fail, got %v`, err)
+	}
+	if !slices.Equal(order, []int{2, 1}) {
+		t.Fatalf(`expected cleanups to run in reverse order, got %v`, order)
+	}
+}
+
+func TestBeforeServe(t *testing.T) {
+	var order []string
+	step := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	errFailed := errors.New(`failed`)
+	var started atomic.Bool
+	cfg, err := New(
+		BeforeServe(step(`first`)),
+		func(cfg *Config) error {
+			cfg.Hook(orderedHook{name: `cache`, dependsOn: []string{`db`}, order: &order})
+			cfg.Hook(orderedHook{name: `db`, order: &order})
+			return nil
+		},
+		Migrate(func(ctx context.Context) error {
+			order = append(order, `migrate`)
+			return errFailed
+		}),
+		BeforeServe(step(`after migrate`)),
+		Started(func(ctx context.Context, addrs []net.Addr) error {
+			started.Store(true)
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr, err := net.Listen(`tcp`, `localhost:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close()
+	counted := &countingListener{Listener: lr}
+	err = cfg.ServeListener(context.Background(), counted)
+	if !errors.Is(err, errFailed) {
+		t.Fatalf(`expected the migration to stop Serve, got %v`, err)
+	}
+	if counted.accepts.Load() != 0 || started.Load() {
+		t.Fatal(`expected the rig to stop before accepting connections`)
+	}
+	if !slices.Equal(order, []string{`first`, `db`, `cache`, `migrate`}) {
+		t.Fatalf(`expected the hooks to run in order until the migration failed, got %v`, order)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	for _, test := range []struct {
+		generation, first string
+		migrates          bool
+	}{
+		{``, ``, true},   // served directly
+		{`1`, `1`, true}, // the first worker of a supervisor
+		{`2`, ``, false}, // a restarted worker
+		{`5`, `1`, true}, // the first worker of a supervisor that took over with Handover
+	} {
+		t.Setenv(`RIG_GENERATION`, test.generation)
+		t.Setenv(`RIG_FIRST_WORKER`, test.first)
+		calls := 0
+		cfg, err := New(Migrate(func(ctx context.Context) error {
+			calls++
+			return nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = cfg.Serve(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migrated := calls == 1; migrated != test.migrates {
+			t.Errorf(`expected generation %q with RIG_FIRST_WORKER %q to migrate: %v, got %v calls`,
+				test.generation, test.first, test.migrates, calls)
+		}
+	}
+}
+
+// An orderedHook is a BeforeServe hook that records its name and provides it to hooks that depend on it.
+type orderedHook struct {
+	name      string
+	dependsOn []string
+	order     *[]string
+}
+
+func (oh orderedHook) Provides() []string  { return []string{oh.name} }
+func (oh orderedHook) DependsOn() []string { return oh.dependsOn }
+
+func (oh orderedHook) RigBeforeServe(ctx context.Context) error {
+	*oh.order = append(*oh.order, oh.name)
+	return nil
+}
+
+// A countingListener counts the calls to Accept.
+type countingListener struct {
+	net.Listener
+	accepts atomic.Int32
+}
+
+func (cl *countingListener) Accept() (net.Conn, error) {
+	cl.accepts.Add(1)
+	return cl.Listener.Accept()
+}
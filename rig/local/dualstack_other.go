@@ -0,0 +1,10 @@
+//go:build !unix
+
+package local
+
+import "syscall"
+
+// dualStack returns the given control function, relying on Go to clear IPV6_V6ONLY for unspecified addresses.
+func dualStack(next func(network, address string, c syscall.RawConn) error) func(string, string, syscall.RawConn) error {
+	return next
+}
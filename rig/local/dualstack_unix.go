@@ -0,0 +1,28 @@
+//go:build unix
+
+package local
+
+import "syscall"
+
+// dualStack returns a control function for net.ListenConfig that clears IPV6_V6ONLY on IPv6 sockets before calling
+// the given control function, if any.
+func dualStack(next func(network, address string, c syscall.RawConn) error) func(string, string, syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if network == `tcp6` {
+			var err error
+			ctlErr := c.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 0)
+			})
+			if ctlErr != nil {
+				return ctlErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if next != nil {
+			return next(network, address, c)
+		}
+		return nil
+	}
+}
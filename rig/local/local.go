@@ -3,14 +3,19 @@ package local
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/swdunlop/rig-go/rig"
 	"github.com/swdunlop/rig-go/rig/hook"
 )
 
-// Rig returns a rig.Option that configures a network listener.
+// Rig returns a rig.Option that configures network listeners, one for each address given by Listen, TCP, Unix or
+// RandomPort.  Options that adjust a listener, such as IPv4Only, Report and ListenConfig, apply to the address given
+// before them, or to every address if they are given before the first one; IPv4Only, IPv6Only and DualStack only
+// apply to TCP addresses that way, and SocketMode, SocketOwner and RemoveStaleSocket only to Unix addresses.
 func Rig(options ...Option) rig.Option {
 	return func(r *rig.Config) error {
 		var cfg config
@@ -24,45 +29,148 @@ func Rig(options ...Option) rig.Option {
 	}
 }
 
-// An Option is a function that configures a local listener.
+// An Option is a function that configures local listeners.
 type Option func(*config) error
 
 type config struct {
-	listen struct {
-		network string
-		address string
-		config  net.ListenConfig
+	defaults  listener    // adjusted by options given before the first address
+	listeners []*listener // one for each address, starting with the defaults
+	systemd   bool        // if true, sockets passed by systemd are used instead, see SystemdActivation
+}
+
+// A listener is a hook.Listen for one of the addresses of a config.
+type listener struct {
+	network string
+	address string
+	config  net.ListenConfig
+	family  string         // if not empty, replaces the network of a TCP listener
+	report  func(net.Addr) // nil if the address of the listener is not reported
+	socket  socketOptions  // only used for Unix sockets
+}
+
+// current returns the listener adjusted by options, which is the last one added, or the defaults before any are.
+func (cfg *config) current() *listener {
+	if len(cfg.listeners) == 0 {
+		return &cfg.defaults
 	}
+	return cfg.listeners[len(cfg.listeners)-1]
 }
 
-// TCP returns an Option that sets the listener to a TCP socket on the provided address.
+// TCP returns an Option that adds a listener for a TCP socket on the provided address.
 func TCP(address string) Option {
 	return Listen("tcp", address)
 }
 
-// Unix returns an Option that sets the listener to a Unix socket on the provided path.
+// Unix returns an Option that adds a listener for a Unix socket on the provided path.
 func Unix(path string) Option {
 	return Listen("unix", path)
 }
 
-// Listen returns an Option that sets the listener to the provided network and address.
+// Listen returns an Option that adds a listener for the provided network and address.
 func Listen(network, address string) Option {
 	return func(cfg *config) error {
-		cfg.listen.network = network
-		cfg.listen.address = address
+		lr := cfg.defaults
+		lr.network, lr.address = network, address
+		if !strings.HasPrefix(network, `tcp`) {
+			lr.family = `` // the defaults may restrict the family of TCP listeners alongside this one.
+		}
+		if !isUnix(network) {
+			lr.socket = socketOptions{} // likewise, the defaults may adjust Unix sockets alongside this one.
+		}
+		cfg.listeners = append(cfg.listeners, &lr)
+		return nil
+	}
+}
+
+// RandomPort returns an Option that sets the listener to a port on the loopback interface chosen by the system, which
+// is useful for tests and ephemeral services.  The network must be "tcp", "tcp4" or "tcp6".  Use Report to find the
+// port that was chosen.
+func RandomPort(network string) Option {
+	return func(cfg *config) error {
+		switch network {
+		case `tcp`, `tcp4`, `tcp6`:
+		default:
+			return fmt.Errorf(`cannot choose a random port for a %v listener`, network)
+		}
+		return Listen(network, `localhost:0`)(cfg)
+	}
+}
+
+// Report returns an Option that calls fn with the address of the listener once it has been bound, before it accepts
+// any connections, such as to find the port chosen by RandomPort.
+func Report(fn func(net.Addr)) Option {
+	return func(cfg *config) error {
+		cfg.current().report = fn
+		return nil
+	}
+}
+
+// IPv4Only returns an Option that restricts a TCP listener to IPv4, so names like "localhost" resolve to IPv4
+// addresses.
+func IPv4Only() Option {
+	return family(`tcp4`)
+}
+
+// IPv6Only returns an Option that restricts a TCP listener to IPv6, so names like "localhost" resolve to IPv6
+// addresses and a listener on an unspecified address like "[::]:8080" does not accept IPv4 connections.
+func IPv6Only() Option {
+	return family(`tcp6`)
+}
+
+// DualStack returns an Option that lets a TCP listener on an unspecified address like "[::]:8080" accept both IPv4 and
+// IPv6 connections by clearing IPV6_V6ONLY on its socket, regardless of the system default, such as
+// net.ipv6.bindv6only on Linux.  This is the default for unspecified addresses in Go, but it cannot make a listener on
+// a specific address like "localhost:8080" accept both, since that resolves to a single address.
+func DualStack() Option {
+	return family(`tcp`)
+}
+
+func family(network string) Option {
+	return func(cfg *config) error {
+		cfg.current().family = network
 		return nil
 	}
 }
 
+// isUnix returns true for networks that bind a Unix socket to a file.
+func isUnix(network string) bool { return network == `unix` || network == `unixpacket` }
+
 // Listen implements hook.Listen by returning a net.Listener for the configured network and address.
-func (cfg *config) Listen(ctx context.Context) (net.Listener, error) {
-	return cfg.listen.config.Listen(ctx, cfg.listen.network, cfg.listen.address)
+func (lr *listener) Listen(ctx context.Context) (net.Listener, error) {
+	network, lc := lr.network, lr.config
+	if lr.family != `` {
+		network = lr.family
+		if network == `tcp` {
+			lc.Control = dualStack(lc.Control)
+		}
+	}
+	if lr.socket.removeStale {
+		err := lr.socket.removeStaleSocket(lr.address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ln, err := lc.Listen(ctx, network, lr.address)
+	if err != nil {
+		return nil, err
+	}
+	if isUnix(network) {
+		err = lr.socket.apply(lr.address)
+		if err != nil {
+			_ = ln.Close()
+			return nil, fmt.Errorf(`%w while adjusting socket %v`, err, lr.address)
+		}
+	}
+	if lr.report != nil {
+		lr.report(ln.Addr())
+	}
+	return ln, nil
 }
 
 // KeepAlive specifies the keepalive duration for connections accepted by the listener.
 func (cfg *config) KeepAlive(keepalive time.Duration) Option {
 	return func(cfg *config) error {
-		cfg.listen.config.KeepAlive = keepalive
+		cfg.current().config.KeepAlive = keepalive
 		return nil
 	}
 }
@@ -71,19 +179,73 @@ func (cfg *config) KeepAlive(keepalive time.Duration) Option {
 func ListenConfig(options ...func(*net.ListenConfig)) Option {
 	return func(cfg *config) error {
 		for _, option := range options {
-			option(&cfg.listen.config)
+			option(&cfg.current().config)
 		}
 		return nil
 	}
 
 }
 
-var _ hook.Listen = (*config)(nil)
+var _ hook.Listen = (*listener)(nil)
 
 func (cfg *config) rig(r *rig.Config) error {
-	if cfg.listen.network == `` || cfg.listen.address == `` {
+	if cfg.systemd {
+		adopted, err := cfg.adopt()
+		if err != nil || len(adopted) > 0 {
+			for _, it := range adopted {
+				r.Hook(it)
+			}
+			return err
+		}
+		if len(cfg.listeners) == 0 {
+			return errors.New(`not socket activated by systemd, and no local address to listen to`)
+		}
+	}
+	if len(cfg.listeners) == 0 {
 		return errors.New(`local listeners must configure both network and address`)
 	}
-	r.Hook(cfg)
+	for _, lr := range cfg.listeners {
+		if lr.network == `` || lr.address == `` {
+			return errors.New(`local listeners must configure both network and address`)
+		}
+		if lr.family != `` && !strings.HasPrefix(lr.network, `tcp`) {
+			return fmt.Errorf(`cannot restrict the address family of a %v listener`, lr.network)
+		}
+		if lr.socket.configured() && !isUnix(lr.network) {
+			return fmt.Errorf(`cannot adjust the socket file of a %v listener`, lr.network)
+		}
+	}
+	for _, lr := range cfg.listeners {
+		r.Hook(lr)
+	}
 	return nil
 }
+
+// adopt returns hooks for the sockets passed by systemd, if any, see SystemdActivation.
+func (cfg *config) adopt() ([]adopted, error) {
+	listeners, err := systemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]adopted, 0, len(listeners))
+	for _, lr := range listeners {
+		ret = append(ret, adopted{lr: lr, report: cfg.defaults.report})
+	}
+	return ret, nil
+}
+
+// Env is a rig.EnvParser that configures a listener from LISTEN, which is interpreted as a Unix domain socket if it
+// starts with "." or "/", and as a TCP address otherwise.
+func Env(env map[string]string) (rig.Option, error) {
+	address, ok := env[`LISTEN`]
+	if !ok {
+		return nil, nil
+	}
+	delete(env, `LISTEN`)
+	if strings.HasPrefix(address, `.`) || strings.HasPrefix(address, `/`) {
+		return Rig(Unix(address)), nil
+	}
+	return Rig(TCP(address)), nil
+}
+
+var _ rig.EnvParser = Env
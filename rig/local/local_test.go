@@ -0,0 +1,227 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIPv4Only(t *testing.T) {
+	lr := listen(t, IPv4Only(), TCP(`localhost:0`))
+	if addr := lr.Addr().(*net.TCPAddr); addr.IP.To4() == nil {
+		t.Fatalf(`expected an IPv4 address, got %v`, addr)
+	}
+}
+
+func TestIPv6Only(t *testing.T) {
+	requireIPv6(t)
+	lr := listen(t, TCP(`[::]:0`), IPv6Only())
+	port := lr.Addr().(*net.TCPAddr).Port
+	if !accepts(lr, `tcp6`, `::1`, port) {
+		t.Fatal(`expected an IPv6 connection to be accepted`)
+	}
+	if accepts(lr, `tcp4`, `127.0.0.1`, port) {
+		t.Fatal(`expected an IPv4 connection to be refused`)
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	requireIPv6(t)
+	lr := listen(t, TCP(`[::]:0`), DualStack())
+	port := lr.Addr().(*net.TCPAddr).Port
+	if !accepts(lr, `tcp6`, `::1`, port) {
+		t.Fatal(`expected an IPv6 connection to be accepted`)
+	}
+	if !accepts(lr, `tcp4`, `127.0.0.1`, port) {
+		t.Fatal(`expected an IPv4 connection to be accepted`)
+	}
+}
+
+func TestRandomPort(t *testing.T) {
+	var reported net.Addr
+	lr := listen(t, RandomPort(`tcp4`), Report(func(addr net.Addr) { reported = addr }))
+	addr := lr.Addr().(*net.TCPAddr)
+	if addr.Port == 0 || !addr.IP.IsLoopback() {
+		t.Fatalf(`expected a loopback address with a port, got %v`, addr)
+	}
+	if reported == nil || reported.String() != addr.String() {
+		t.Fatalf(`expected %v to be reported, got %v`, addr, reported)
+	}
+	if RandomPort(`unix`)(new(config)) == nil {
+		t.Fatal(`expected a random port for a Unix listener to fail`)
+	}
+}
+
+func TestFamilyRequiresTCP(t *testing.T) {
+	var cfg config
+	for _, option := range []Option{Unix(`/tmp/rig.sock`), IPv4Only()} {
+		_ = option(&cfg)
+	}
+	if cfg.rig(nil) == nil {
+		t.Fatal(`expected restricting a Unix listener to IPv4 to fail`)
+	}
+}
+
+func TestMultipleListeners(t *testing.T) {
+	var cfg config
+	var reported []net.Addr
+	socket := filepath.Join(t.TempDir(), `rig.sock`)
+	for _, option := range []Option{
+		IPv4Only(), // applies to both TCP listeners.
+		TCP(`localhost:0`),
+		Report(func(addr net.Addr) { reported = append(reported, addr) }),
+		Unix(socket),
+		RandomPort(`tcp`),
+	} {
+		err := option(&cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cfg.listeners) != 3 {
+		t.Fatalf(`expected three listeners, got %v`, len(cfg.listeners))
+	}
+	for _, it := range cfg.listeners {
+		lr, err := it.Listen(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lr.Close()
+		if addr, ok := lr.Addr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+			t.Errorf(`expected an IPv4 address, got %v`, addr)
+		}
+	}
+	if len(reported) != 1 || reported[0].Network() != `tcp` {
+		t.Fatalf(`expected only the first TCP listener to be reported, got %v`, reported)
+	}
+	if _, err := os.Stat(socket); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenFDs(t *testing.T) {
+	for _, test := range []struct {
+		env   map[string]string
+		n     int
+		names []string
+		fails bool
+	}{
+		{env: map[string]string{}},
+		{env: map[string]string{`LISTEN_PID`: `42`, `LISTEN_FDS`: `2`}, n: 2},
+		{env: map[string]string{`LISTEN_PID`: `7`, `LISTEN_FDS`: `2`}}, // meant for another process.
+		{env: map[string]string{`LISTEN_PID`: `42`, `LISTEN_FDS`: `2`, `LISTEN_FDNAMES`: `web:admin`}, n: 2,
+			names: []string{`web`, `admin`}},
+		{env: map[string]string{`LISTEN_PID`: `42`, `LISTEN_FDS`: `two`}, fails: true},
+		{env: map[string]string{`LISTEN_PID`: `me`, `LISTEN_FDS`: `2`}, fails: true},
+	} {
+		n, names, err := listenFDs(func(key string) string { return test.env[key] }, 42)
+		switch {
+		case test.fails && err == nil:
+			t.Errorf(`expected %v to fail`, test.env)
+		case !test.fails && err != nil:
+			t.Errorf(`%v while parsing %v`, err, test.env)
+		case n != test.n || strings.Join(names, `:`) != strings.Join(test.names, `:`):
+			t.Errorf(`expected %v %v from %v, got %v %v`, test.n, test.names, test.env, n, names)
+		}
+	}
+}
+
+func TestSocketMode(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), `rig.sock`)
+	lr := listen(t, Unix(socket), SocketMode(0o600))
+	defer lr.Close()
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf(`expected mode 0600, got %v`, info.Mode().Perm())
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), `rig.sock`)
+	live := listen(t, Unix(socket))
+	defer live.Close()
+	_, err := listenErr(Unix(socket), RemoveStaleSocket())
+	if err == nil {
+		t.Fatal(`expected a live socket to be left alone`)
+	}
+
+	live.(*net.UnixListener).SetUnlinkOnClose(false)
+	live.Close() // leaves a stale socket behind.
+	_, err = listenErr(Unix(socket))
+	if err == nil {
+		t.Fatal(`expected a stale socket to fail without RemoveStaleSocket`)
+	}
+	lr := listen(t, Unix(socket), RemoveStaleSocket())
+	lr.Close()
+}
+
+func TestSocketOptionsRequireUnix(t *testing.T) {
+	var cfg config
+	for _, option := range []Option{SocketMode(0o600), TCP(`localhost:0`), Unix(`/tmp/rig.sock`)} {
+		_ = option(&cfg)
+	}
+	if cfg.listeners[0].socket.configured() || !cfg.listeners[1].socket.configured() {
+		t.Fatal(`expected socket options given before the first address to only apply to Unix sockets`)
+	}
+	cfg.listeners = cfg.listeners[:1]
+	_ = SocketMode(0o600)(&cfg) // now applies to the TCP listener.
+	if cfg.rig(nil) == nil {
+		t.Fatal(`expected adjusting the socket file of a TCP listener to fail`)
+	}
+}
+
+func listen(t *testing.T, options ...Option) net.Listener {
+	lr, err := listenErr(options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lr.Close() })
+	return lr
+}
+
+// listenErr listens to the one address given by the options.
+func listenErr(options ...Option) (net.Listener, error) {
+	var cfg config
+	for _, option := range options {
+		err := option(&cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.listeners) != 1 {
+		return nil, fmt.Errorf(`expected one listener, got %v`, len(cfg.listeners))
+	}
+	return cfg.listeners[0].Listen(context.Background())
+}
+
+// accepts returns true if a connection to the port on the given host is accepted by the listener.
+func accepts(lr net.Listener, network, host string, port int) bool {
+	conn, err := net.DialTimeout(network, net.JoinHostPort(host, strconv.Itoa(port)), time.Second)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	accepted, err := lr.Accept()
+	if err != nil {
+		return false
+	}
+	accepted.Close()
+	return true
+}
+
+func requireIPv6(t *testing.T) {
+	lr, err := net.Listen(`tcp6`, `[::1]:0`)
+	if err != nil {
+		t.Skip(`IPv6 is not available:`, err)
+	}
+	lr.Close()
+}
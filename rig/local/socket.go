@@ -0,0 +1,98 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// SocketMode returns an Option that changes the permissions of a Unix socket to mode once it has been bound, such as
+// 0660 to let a proxy like nginx in the same group connect to it, instead of leaving them to the umask.  The socket
+// has the permissions given by the umask until it is changed, before it accepts any connections.
+func SocketMode(mode os.FileMode) Option {
+	return func(cfg *config) error {
+		cfg.current().socket.mode = mode.Perm()
+		return nil
+	}
+}
+
+// SocketOwner returns an Option that changes the owner and group of a Unix socket once it has been bound, which
+// usually requires the process to be privileged.  Like os.Chown, a uid or gid of -1 leaves it unchanged.
+func SocketOwner(uid, gid int) Option {
+	return func(cfg *config) error {
+		cfg.current().socket.owner = true
+		cfg.current().socket.uid, cfg.current().socket.gid = uid, gid
+		return nil
+	}
+}
+
+// RemoveStaleSocket returns an Option that removes a Unix socket left behind by a process that did not close it, such
+// as one that crashed, instead of failing with "address already in use".  The socket is only removed if nothing
+// accepts connections on it, so a live service is never clobbered; files that are not sockets are never removed.
+func RemoveStaleSocket() Option {
+	return func(cfg *config) error {
+		cfg.current().socket.removeStale = true
+		return nil
+	}
+}
+
+// socketOptions adjust the file of a Unix socket, see SocketMode, SocketOwner and RemoveStaleSocket.
+type socketOptions struct {
+	mode        os.FileMode // zero if the permissions are left to the umask
+	owner       bool        // if true, the owner is changed to uid and gid
+	uid, gid    int
+	removeStale bool
+}
+
+// configured returns true if any option was given for the socket.
+func (opts *socketOptions) configured() bool { return *opts != socketOptions{} }
+
+// StaleSocketTimeout is how long RemoveStaleSocket waits for a connection to an existing socket before deciding that
+// it is stale; a live service under load may be slow to accept.
+var StaleSocketTimeout = time.Second
+
+// removeStaleSocket removes the socket at path if it exists and nothing accepts connections on it.
+func (opts *socketOptions) removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	case info.Mode()&os.ModeSocket == 0:
+		return nil // let Listen fail, rather than removing something that is not ours.
+	}
+	conn, err := net.DialTimeout(`unix`, path, StaleSocketTimeout)
+	if err == nil {
+		_ = conn.Close()
+		return nil // alive, let Listen fail with "address already in use".
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil // could not tell, so leave it alone.
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf(`%w while removing stale socket %v`, err, path)
+	}
+	return nil
+}
+
+// apply changes the permissions and owner of the socket at path.
+func (opts *socketOptions) apply(path string) error {
+	if opts.mode != 0 {
+		err := os.Chmod(path, opts.mode)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.owner {
+		err := os.Chown(path, opts.uid, opts.gid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SystemdActivation returns an Option that adopts the listening sockets passed by systemd socket activation, using the
+// LISTEN_PID and LISTEN_FDS environment variables, instead of listening to the addresses given by other options.  This
+// lets systemd hold the sockets while the service restarts, so connections wait instead of being refused.  If the
+// process was not socket activated, such as when it is run by hand, the addresses given by other options are used
+// instead.
+//
+// Every socket passed by systemd is adopted, in the order given by the Sockets setting of the service or its socket
+// units, and the environment variables are removed so child processes like the worker do not adopt them too.  Options
+// that adjust listeners only apply to adopted sockets through Report given before the first address.
+func SystemdActivation() Option {
+	return func(cfg *config) error {
+		cfg.systemd = true
+		return nil
+	}
+}
+
+// systemd holds the listeners adopted from systemd, which are only adopted once per process.
+var systemd struct {
+	once      sync.Once
+	listeners []net.Listener
+	err       error
+}
+
+// systemdListeners returns the listeners passed by systemd socket activation, if any.
+func systemdListeners() ([]net.Listener, error) {
+	systemd.once.Do(func() {
+		n, names, err := listenFDs(os.Getenv, os.Getpid())
+		for _, key := range []string{`LISTEN_PID`, `LISTEN_FDS`, `LISTEN_FDNAMES`} {
+			_ = os.Unsetenv(key)
+		}
+		if err != nil {
+			systemd.err = err
+			return
+		}
+		for i := 0; i < n; i++ {
+			name := `systemd`
+			if i < len(names) && names[i] != `` {
+				name = names[i]
+			}
+			file := os.NewFile(uintptr(listenFDsStart+i), name)
+			if file == nil {
+				systemd.err = fmt.Errorf(`invalid file descriptor %v from systemd`, listenFDsStart+i)
+				return
+			}
+			lr, err := net.FileListener(file) // a duplicate that will not be inherited by the worker.
+			file.Close()
+			if err != nil {
+				systemd.err = fmt.Errorf(`%w while adopting %v from systemd`, err, name)
+				return
+			}
+			systemd.listeners = append(systemd.listeners, lr)
+		}
+	})
+	return systemd.listeners, systemd.err
+}
+
+// listenFDsStart is the first file descriptor passed by systemd, after stdin, stdout and stderr.
+const listenFDsStart = 3
+
+// listenFDs returns the number and names of the file descriptors that systemd passed to the process with the given
+// PID, or zero if it was not socket activated, such as when LISTEN_PID names another process.
+func listenFDs(getenv func(string) string, pid int) (int, []string, error) {
+	spec := getenv(`LISTEN_PID`)
+	if spec == `` {
+		return 0, nil, nil
+	}
+	target, err := strconv.Atoi(spec)
+	if err != nil {
+		return 0, nil, fmt.Errorf(`%w in LISTEN_PID`, err)
+	}
+	if target != pid {
+		return 0, nil, nil // meant for another process, such as our parent.
+	}
+	n, err := strconv.Atoi(getenv(`LISTEN_FDS`))
+	if err != nil || n < 0 {
+		return 0, nil, fmt.Errorf(`invalid LISTEN_FDS %q`, getenv(`LISTEN_FDS`))
+	}
+	var names []string
+	if spec := getenv(`LISTEN_FDNAMES`); spec != `` {
+		names = strings.Split(spec, `:`)
+	}
+	return n, names, nil
+}
+
+// An adopted listener is a hook.Listen for a socket passed by systemd.
+type adopted struct {
+	lr     net.Listener
+	report func(net.Addr)
+}
+
+func (ad adopted) Listen(context.Context) (net.Listener, error) {
+	if ad.report != nil {
+		ad.report(ad.lr.Addr())
+	}
+	return ad.lr, nil
+}
@@ -0,0 +1,120 @@
+package rig
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig/hook"
+)
+
+// maxLogLine limits the length of a buffered log line, so large log fields are not retained by the buffer.
+const maxLogLine = 4096
+
+// A logRing is a ring buffer of recent log lines served at "/_rig/logs", see LogBuffer.
+type logRing struct {
+	control sync.Mutex
+	entries []logEntry
+	next    int  // index of the next entry to replace
+	full    bool // true once entries has wrapped
+}
+
+// A logEntry is a single line of log output.
+type logEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"` // "worker" for the output of a spawned worker, otherwise "rig".
+	Line   string    `json:"line"`
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{entries: make([]logEntry, size)}
+}
+
+// add appends a line to the buffer, replacing the oldest line if the buffer is full.
+func (lr *logRing) add(source string, line []byte) {
+	line = ansiEscapes.ReplaceAll(line, nil)
+	if len(line) > maxLogLine {
+		line = line[:maxLogLine]
+	}
+	entry := logEntry{Time: time.Now(), Source: source, Line: string(line)} // string(line) copies the line.
+	lr.control.Lock()
+	defer lr.control.Unlock()
+	lr.entries[lr.next] = entry
+	lr.next++
+	if lr.next == len(lr.entries) {
+		lr.next, lr.full = 0, true
+	}
+}
+
+var ansiEscapes = regexp.MustCompile("\x1b\\[[0-9;]*[A-Za-z]")
+
+// lines returns the buffered lines, oldest first.
+func (lr *logRing) lines() []logEntry {
+	lr.control.Lock()
+	defer lr.control.Unlock()
+	if !lr.full {
+		return append([]logEntry(nil), lr.entries[:lr.next]...)
+	}
+	lines := make([]logEntry, 0, len(lr.entries))
+	lines = append(lines, lr.entries[lr.next:]...)
+	return append(lines, lr.entries[:lr.next]...)
+}
+
+// writer returns a writer that adds each line written to it to the buffer.
+func (lr *logRing) writer(source string) *logWriter {
+	return &logWriter{ring: lr, source: source}
+}
+
+// ServeHTTP serves the buffered lines as a JSON array.
+func (lr *logRing) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	_ = json.NewEncoder(w).Encode(lr.lines())
+}
+
+// RigMux implements hook.Mux by adding the "/_rig/logs" endpoint.
+func (lr *logRing) RigMux(mux *http.ServeMux) { mux.Handle(`GET /_rig/logs`, lr) }
+
+// RigRoutes implements hook.Routes by describing the "/_rig/logs" endpoint.
+func (lr *logRing) RigRoutes() []hook.Route {
+	return []hook.Route{{Pattern: `GET /_rig/logs`, Handler: `rig.LogBuffer`}}
+}
+
+var (
+	_ hook.Mux    = (*logRing)(nil)
+	_ hook.Routes = (*logRing)(nil)
+)
+
+// A logWriter splits its output into lines for a logRing.
+type logWriter struct {
+	control sync.Mutex
+	ring    *logRing
+	source  string
+	partial []byte // an incomplete line from the last write
+}
+
+// Write implements io.Writer.
+func (lw *logWriter) Write(p []byte) (int, error) {
+	lw.control.Lock()
+	defer lw.control.Unlock()
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			break
+		}
+		if len(lw.partial) > 0 {
+			lw.ring.add(lw.source, append(lw.partial, p[:i]...))
+			lw.partial = lw.partial[:0]
+		} else {
+			lw.ring.add(lw.source, p[:i])
+		}
+		p = p[i+1:]
+	}
+	if len(lw.partial)+len(p) <= maxLogLine {
+		lw.partial = append(lw.partial, p...)
+	}
+	return n, nil
+}
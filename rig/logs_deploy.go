@@ -0,0 +1,9 @@
+//go:build deploy
+// +build deploy
+
+package rig
+
+// LogBuffer does nothing in builds with the deploy tag; see the development build for details.
+func LogBuffer(size int) Option {
+	return func(cfg *Config) error { return nil }
+}
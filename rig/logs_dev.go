@@ -0,0 +1,40 @@
+//go:build !deploy
+// +build !deploy
+
+package rig
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+)
+
+// LogBuffer returns an option that keeps the last size lines of log output in memory and serves them as JSON at
+// "/_rig/logs" for development dashboards.  This replaces the global zerolog logger with one that writes both to
+// stderr and the buffer.
+//
+// When the rig spawns a worker, the supervisor buffers its own logs and the output of the worker and serves the
+// endpoint itself, so the buffer survives worker restarts; the worker does not keep a buffer of its own.  Lines are
+// copied into the buffer and truncated to a few kilobytes, so large log fields are not retained.
+//
+// This option does nothing in builds with the deploy tag.
+func LogBuffer(size int) Option {
+	return func(cfg *Config) error {
+		if size < 1 || os.Getenv(`RIG_SOCKET`) != `` {
+			return nil
+		}
+		logs := newLogRing(size)
+		log := zerolog.New(zerolog.MultiLevelWriter(
+			zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: `2006-01-02 15:04:05`},
+			zerolog.ConsoleWriter{Out: logs.writer(`rig`), TimeFormat: `2006-01-02 15:04:05`, NoColor: true},
+		)).With().Timestamp().Logger()
+		zlog.Logger = log
+		zerolog.DefaultContextLogger = &log
+		cfg.control.Lock()
+		cfg.logs = logs
+		cfg.control.Unlock()
+		cfg.Hook(logs)
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package rig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestLogRing(t *testing.T) {
+	logs := newLogRing(3)
+	lines := func() []string {
+		var ret []string
+		for _, entry := range logs.lines() {
+			ret = append(ret, entry.Source+`: `+entry.Line)
+		}
+		return ret
+	}
+	w := logs.writer(`worker`)
+	_, _ = io.WriteString(w, "one\ntw")
+	_, _ = io.WriteString(w, "o\n\x1b[31mthree\x1b[0m\npartial")
+	if got := lines(); !slices.Equal(got, []string{`worker: one`, `worker: two`, `worker: three`}) {
+		t.Fatalf(`expected complete lines without escapes, got %q`, got)
+	}
+	for i := 4; i <= 5; i++ {
+		logs.add(`rig`, []byte(fmt.Sprint(i)))
+	}
+	expect := []string{`worker: three`, `rig: 4`, `rig: 5`}
+	if got := lines(); !slices.Equal(got, expect) {
+		t.Fatalf(`expected the buffer to wrap and keep the newest lines, oldest first, got %q`, got)
+	}
+
+	rec := httptest.NewRecorder()
+	logs.ServeHTTP(rec, httptest.NewRequest(`GET`, `/_rig/logs`, nil))
+	var served []logEntry
+	err := json.Unmarshal(rec.Body.Bytes(), &served)
+	if err != nil || len(served) != 3 || served[0].Line != `three` || served[2].Line != `5` {
+		t.Fatalf(`expected the lines to be served oldest first, got %s (%v)`, rec.Body.Bytes(), err)
+	}
+}
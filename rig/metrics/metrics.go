@@ -0,0 +1,268 @@
+// Package metrics provides a rig option that counts the requests served by a rig and exposes them, along with the
+// restarts of its workers, in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig"
+	"github.com/swdunlop/rig-go/rig/hook"
+)
+
+// Rig returns a rig option that counts the requests served by the rig and serves the metrics at "/_rig/metrics" in the
+// Prometheus text format, see Path.  The metrics are:
+//
+//   - rig_http_requests_total, the requests served by route, method and status code;
+//   - rig_http_request_duration_seconds, a histogram of how long requests took by route, see LatencyBuckets;
+//   - rig_http_requests_in_flight, the requests being served;
+//   - rig_websocket_connections, the WebSocket connections that are open by route, such as those of mrpc and jrpc;
+//   - rig_worker_starts_total, the worker processes started by the supervisor;
+//   - rig_background_worker_restarts_total, the restarts of each background worker added by rig.Worker.
+//
+// Routes are labeled with the patterns listed by rig.Routes, so the labels do not grow with the paths that clients
+// request; requests that match no listed pattern are labeled "other".  WebSocket connections are counted as requests
+// with status 101 once they close, but are left out of the duration histogram, since they last as long as the client
+// stays connected.
+//
+// Like other server hooks, this middleware is applied by the supervisor, which sees every request it proxies to the
+// worker, or by a rig that is served directly, but not by rig.Config.Handler.  Background workers run in the worker
+// process, so their restarts are only reported when the rig is served directly.
+func Rig(options ...Option) rig.Option {
+	return func(r *rig.Config) error {
+		c := &collector{
+			rig:       r,
+			path:      `/_rig/metrics`,
+			requests:  make(map[requestKey]uint64),
+			durations: make(map[string]*histogram),
+			websocket: make(map[string]int64),
+		}
+		for _, option := range options {
+			err := option(c)
+			if err != nil {
+				return err
+			}
+		}
+		r.Hook(c)
+		return nil
+	}
+}
+
+// An Option adjusts how metrics are collected.
+type Option func(*collector) error
+
+// Path returns an option that serves the metrics at the given path instead of "/_rig/metrics".  Like the other
+// endpoints of the rig, the path moves under the prefix given to rig.BasePath.
+func Path(p string) Option {
+	return func(c *collector) error {
+		if !strings.HasPrefix(p, `/`) || strings.ContainsAny(p, `{}?# `) {
+			return fmt.Errorf(`metrics path %q must be a plain absolute path`, p)
+		}
+		c.path = path.Clean(p)
+		return nil
+	}
+}
+
+// LatencyBuckets are the upper bounds of the request duration histogram, which also counts the requests slower than
+// the last bound.  LatencyBuckets should be set before any rig is configured.
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// otherRoute labels requests that match none of the routes of the rig.
+const otherRoute = `other`
+
+// A collector is the hook added by Rig.
+type collector struct {
+	rig  *rig.Config
+	path string
+
+	control   sync.Mutex
+	requests  map[requestKey]uint64 // requests served, by route, method and status
+	durations map[string]*histogram // request durations by route, not including WebSocket connections
+	websocket map[string]int64      // open WebSocket connections by route
+	inFlight  int64
+}
+
+// A requestKey identifies a series of rig_http_requests_total.
+type requestKey struct {
+	route  string
+	method string
+	code   int
+}
+
+// A histogram counts durations by LatencyBuckets, with the last count for durations beyond every bucket.
+type histogram struct {
+	counts []uint64
+	sum    time.Duration
+}
+
+var (
+	_ http.Handler = (*collector)(nil)
+	_ hook.Server  = (*collector)(nil)
+	_ hook.Routes  = (*collector)(nil)
+)
+
+// RigServer implements hook.Server by wrapping the handler of the server with middleware that counts requests and
+// serves the metrics.
+func (c *collector) RigServer(server *http.Server) {
+	routes := c.router()
+	next := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, route := routes.Handler(r)
+		if h == http.Handler(c) {
+			c.ServeHTTP(w, r)
+			return
+		}
+		if route == `` {
+			route = otherRoute
+		}
+		c.serve(next, route, w, r)
+	})
+}
+
+// RigRoutes implements hook.Routes by describing the metrics endpoint.
+func (c *collector) RigRoutes() []hook.Route {
+	return []hook.Route{{Pattern: `GET ` + c.path, Handler: `metrics.Rig`}}
+}
+
+// router returns a mux with the routes of the rig, which is only used to find the pattern that matches a request.  The
+// mux returns the collector itself for requests for the metrics.
+func (c *collector) router() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range c.rig.Routes() {
+		pattern := route.Pattern
+		if route.Method != `` {
+			pattern = route.Method + ` ` + pattern
+		}
+		var h http.Handler = http.NotFoundHandler()
+		if route.Handler == `metrics.Rig` && route.Hook == fmt.Sprintf(`%T`, c) {
+			h = c // the pattern includes the prefix of rig.BasePath, if any.
+		}
+		handle(mux, pattern, h)
+	}
+	return mux
+}
+
+// handle adds a pattern to the mux, ignoring patterns that conflict with those already added, which the rig reports
+// itself when it builds its own mux.
+func handle(mux *http.ServeMux, pattern string, h http.Handler) {
+	defer func() { _ = recover() }()
+	mux.Handle(pattern, h)
+}
+
+// serve serves a request with next, counting it under the given route.
+func (c *collector) serve(next http.Handler, route string, w http.ResponseWriter, r *http.Request) {
+	websocket := strings.EqualFold(r.Header.Get(`Upgrade`), `websocket`)
+	c.control.Lock()
+	c.inFlight++
+	if websocket {
+		c.websocket[route]++
+	}
+	c.control.Unlock()
+
+	rw := &responseWriter{ResponseWriter: w}
+	start := time.Now()
+	defer func() {
+		elapsed := time.Since(start)
+		c.control.Lock()
+		defer c.control.Unlock()
+		c.inFlight--
+		c.requests[requestKey{route: route, method: method(r.Method), code: rw.Status()}]++
+		if websocket {
+			c.websocket[route]--
+		}
+		if websocket && rw.Status() == http.StatusSwitchingProtocols {
+			return
+		}
+		c.observe(route, elapsed)
+	}()
+	next.ServeHTTP(rw, r)
+}
+
+// observe adds the duration of a request to the histogram of its route.
+func (c *collector) observe(route string, elapsed time.Duration) {
+	hist := c.durations[route]
+	if hist == nil {
+		hist = &histogram{counts: make([]uint64, len(LatencyBuckets)+1)}
+		c.durations[route] = hist
+	}
+	hist.sum += elapsed
+	i := 0
+	for i < len(hist.counts)-1 && i < len(LatencyBuckets) && elapsed > LatencyBuckets[i] {
+		i++
+	}
+	hist.counts[i]++
+}
+
+// method returns the method of a request, or "OTHER" for methods that are not standard, so clients cannot grow the
+// metrics by inventing methods.
+func method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return m
+	}
+	return `OTHER`
+}
+
+// A responseWriter records the status of a response.  It supports flushing and hijacking if the underlying writer
+// does, and http.ResponseController can reach the underlying writer using Unwrap.
+type responseWriter struct {
+	http.ResponseWriter
+	status int // zero until the header has been written
+}
+
+// Status returns the status of the response, which is 200 if the handler wrote nothing.
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 && status >= 200 {
+		rw.status = status // informational responses like 103 Early Hints are followed by the real status.
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *responseWriter) Flush() {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf(`%T does not support hijacking`, rw.ResponseWriter)
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err == nil && rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols // the handler writes its own response to the connection.
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
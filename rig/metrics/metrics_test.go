@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/swdunlop/rig-go/rig"
+	"github.com/swdunlop/rig-go/rig/api"
+)
+
+func TestMetrics(t *testing.T) {
+	cfg, err := rig.New(
+		rig.BasePath(`/app`),
+		api.Rig(api.HandleFunc(`GET /users/{id}`, func(w http.ResponseWriter, r *http.Request) {
+			if r.PathValue(`id`) == `0` {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = io.WriteString(w, r.PathValue(`id`))
+		})),
+		Rig(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(cfg.Server(ctx, cfg.Handler()).Handler)
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		rsp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		body, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rsp.StatusCode, string(body)
+	}
+	for _, path := range []string{`/app/users/1`, `/app/users/2`, `/app/users/0`, `/app/nowhere`} {
+		get(path)
+	}
+	code, text := get(`/app/_rig/metrics`)
+	if code != http.StatusOK {
+		t.Fatalf(`expected 200 for the metrics, got %v`, code)
+	}
+	for _, line := range []string{
+		`rig_http_requests_total{code="200",method="GET",route="GET /app/users/{id}"} 2`,
+		`rig_http_requests_total{code="404",method="GET",route="GET /app/users/{id}"} 1`,
+		`rig_http_requests_total{code="404",method="GET",route="other"} 1`,
+		`rig_http_request_duration_seconds_bucket{le="+Inf",route="GET /app/users/{id}"} 3`,
+		`rig_http_request_duration_seconds_count{route="other"} 1`,
+		`rig_http_requests_in_flight 0`,
+		`rig_worker_starts_total 0`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf(`expected %q in the metrics:\n%s`, line, text)
+		}
+	}
+	if strings.Contains(text, `_rig/metrics"`) {
+		t.Errorf(`expected requests for the metrics to not be counted:\n%s`, text)
+	}
+}
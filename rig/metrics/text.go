@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig"
+)
+
+// ServeHTTP serves the metrics in the Prometheus text format, with series sorted by their labels so successive scrapes
+// are easy to compare.
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	c.write(&buf)
+	w.Header().Set(`Content-Type`, `text/plain; version=0.0.4; charset=utf-8`)
+	w.Header().Set(`Cache-Control`, `no-store`)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// write writes every metric to buf.
+func (c *collector) write(buf *bytes.Buffer) {
+	c.control.Lock()
+	requests := make([]requestKey, 0, len(c.requests))
+	for key := range c.requests {
+		requests = append(requests, key)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	header(buf, `rig_http_requests_total`, `counter`, `HTTP requests served by route, method and status code.`)
+	for _, key := range requests {
+		fmt.Fprintf(buf, "rig_http_requests_total{code=\"%d\",method=%s,route=%s} %d\n",
+			key.code, quote(key.method), quote(key.route), c.requests[key])
+	}
+
+	header(buf, `rig_http_request_duration_seconds`, `histogram`,
+		`How long HTTP requests took to serve by route, not including WebSocket connections.`)
+	for _, route := range sortedKeys(c.durations) {
+		hist := c.durations[route]
+		var count uint64
+		for i, bound := range LatencyBuckets {
+			if i < len(hist.counts) {
+				count += hist.counts[i]
+			}
+			fmt.Fprintf(buf, "rig_http_request_duration_seconds_bucket{le=%s,route=%s} %d\n",
+				quote(seconds(bound)), quote(route), count)
+		}
+		count += hist.counts[len(hist.counts)-1]
+		fmt.Fprintf(buf, "rig_http_request_duration_seconds_bucket{le=\"+Inf\",route=%s} %d\n", quote(route), count)
+		fmt.Fprintf(buf, "rig_http_request_duration_seconds_sum{route=%s} %s\n", quote(route), seconds(hist.sum))
+		fmt.Fprintf(buf, "rig_http_request_duration_seconds_count{route=%s} %d\n", quote(route), count)
+	}
+
+	header(buf, `rig_http_requests_in_flight`, `gauge`, `HTTP requests being served.`)
+	fmt.Fprintf(buf, "rig_http_requests_in_flight %d\n", c.inFlight)
+
+	header(buf, `rig_websocket_connections`, `gauge`, `WebSocket connections that are open by route.`)
+	for _, route := range sortedKeys(c.websocket) {
+		fmt.Fprintf(buf, "rig_websocket_connections{route=%s} %d\n", quote(route), c.websocket[route])
+	}
+	c.control.Unlock()
+
+	header(buf, `rig_worker_starts_total`, `counter`, `Worker processes started by the supervisor.`)
+	fmt.Fprintf(buf, "rig_worker_starts_total %d\n", rig.WorkerStarts())
+
+	if rig.WorkerStarts() > 0 {
+		return // background workers run in the worker process, so the supervisor knows nothing of their restarts.
+	}
+	workers := c.rig.Workers()
+	if len(workers) == 0 {
+		return
+	}
+	header(buf, `rig_background_worker_restarts_total`, `counter`, `Restarts of each background worker.`)
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Name < workers[j].Name })
+	for _, st := range workers {
+		fmt.Fprintf(buf, "rig_background_worker_restarts_total{worker=%s} %d\n", quote(st.Name), st.Restarts)
+	}
+}
+
+// header writes the HELP and TYPE lines of a metric.
+func header(buf *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// seconds formats a duration as seconds.
+func seconds(d time.Duration) string { return strconv.FormatFloat(d.Seconds(), 'g', -1, 64) }
+
+// labelEscaper escapes label values as the Prometheus text format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote returns a label value escaped and quoted.
+func quote(value string) string { return `"` + labelEscaper.Replace(value) + `"` }
@@ -0,0 +1,38 @@
+package mrpc
+
+import "fmt"
+
+// Alias registers another name for a function registered with CallFn, StartFn or DuplexFn, such as a deprecated name
+// kept while clients migrate to a new one.  The alias shares the handler of the function for every method it is
+// registered for, so a function registered with both CallFn and StartFn may be called and started by either name.
+// Requests for the alias are observed, counted by Metrics and traced under the alias, so its remaining use can be
+// watched before it is removed.
+//
+// Aliases are resolved once every option has been applied, in the order they were given, so an alias may be given
+// before its function, or refer to an earlier alias.  New and API fail if the function is not registered by then, or if
+// the alias is already the name of a function, since either is a mistake in how the service is put together.
+func Alias(existing, alias string) Option {
+	return func(cfg *config) { cfg.aliases = append(cfg.aliases, [2]string{existing, alias}) }
+}
+
+// resolveAliases adds the aliases given to Alias to the tables of handlers.
+func (cfg *config) resolveAliases() error {
+	for _, it := range cfg.aliases {
+		existing, alias := it[0], it[1]
+		found := false
+		for _, table := range []map[string]Handler{cfg.callHandlers, cfg.startHandlers, cfg.duplexHandlers} {
+			handler := table[existing]
+			if handler == nil {
+				continue
+			}
+			if table[alias] != nil {
+				return fmt.Errorf(`mrpc: alias %q is already the name of a function`, alias)
+			}
+			table[alias], found = handler, true
+		}
+		if !found {
+			return fmt.Errorf(`mrpc: alias %q refers to function %q, which is not registered`, alias, existing)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package mrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A budget limits the total cost of the requests in flight, see Budget.  A nil budget imposes no limit.
+type budget struct {
+	limit int
+	cost  func(string) int
+	wait  time.Duration
+
+	control sync.Mutex
+	used    int
+	freed   chan struct{} // closed and replaced whenever part of the budget is released
+}
+
+func newBudget(limit int, cost func(string) int, wait time.Duration) *budget {
+	return &budget{limit: limit, cost: cost, wait: wait, freed: make(chan struct{})}
+}
+
+// acquire claims the cost of calling a function from the budget, waiting for up to the shed wait for room, and
+// returns the cost that must be released, or false if the request should be shed.
+func (b *budget) acquire(ctx context.Context, function string) (int, bool) {
+	if b == nil {
+		return 0, true
+	}
+	cost := 1
+	if b.cost != nil {
+		cost = max(1, min(b.cost(function), b.limit)) // so the most expensive request can run alone.
+	}
+	var timeout <-chan time.Time
+	if b.wait > 0 {
+		timer := time.NewTimer(b.wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		b.control.Lock()
+		if b.used+cost <= b.limit {
+			b.used += cost
+			b.control.Unlock()
+			return cost, true
+		}
+		freed := b.freed
+		b.control.Unlock()
+		if timeout == nil {
+			return 0, false
+		}
+		select {
+		case <-freed:
+		case <-timeout:
+			return 0, false
+		case <-ctx.Done():
+			return 0, false
+		}
+	}
+}
+
+// release returns the cost of a request to the budget once it has been handled.
+func (b *budget) release(cost int) {
+	if b == nil {
+		return
+	}
+	b.control.Lock()
+	defer b.control.Unlock()
+	b.used -= cost
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
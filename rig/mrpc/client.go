@@ -0,0 +1,414 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/swdunlop/rig-go/rig/mrpc/internal/protocol"
+	"github.com/swdunlop/rig-go/rig/tracing"
+	"github.com/tinylib/msgp/msgp"
+	"nhooyr.io/websocket"
+)
+
+// Dial connects to an MRPC service at the given ws:// or wss:// URL.  The context only limits the time spent
+// connecting, use Close to disconnect the client.  If the context carries a tracing.Parent, it is sent to the service
+// in the "traceparent" header.
+func Dial(ctx context.Context, url string) (*Client, error) {
+	header := make(http.Header)
+	header.Set(protocol.RetryableHeader, `1`)
+	tracing.Inject(ctx, header)
+	c, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: header})
+	if err != nil {
+		return nil, err
+	}
+	c.SetReadLimit(-1)
+	cl := &Client{
+		conn:    c,
+		pending: make(map[string]*pending),
+		doneCh:  make(chan struct{}),
+	}
+	go cl.process()
+	return cl, nil
+}
+
+// A Client calls functions on an MRPC service.  Use Call and Start to send requests using the client.
+type Client struct {
+	conn    *websocket.Conn
+	seq     atomic.Uint64
+	control sync.Mutex
+	pending map[string]*pending
+	err     error         // set when the client stops processing responses
+	doneCh  chan struct{} // closed when the client stops processing responses
+
+	onDeprecated func(function, notice string) // nil if deprecation notices are ignored, see OnDeprecated
+}
+
+// pending tracks a request that is waiting for responses.
+type pending struct {
+	id         string
+	function   string
+	responseCh chan protocol.Response
+	quitCh     chan struct{} // closed when the caller is no longer interested in responses
+}
+
+// Close disconnects the client, any pending requests will fail.
+func (cl *Client) Close() error {
+	err := cl.conn.Close(websocket.StatusNormalClosure, ``)
+	<-cl.doneCh
+	return err
+}
+
+// OnDeprecated calls fn with the notice the service sends when a request is for a deprecated function, such as to log
+// it so the caller can be updated.  Services only send notices when configured with DeprecationNotices, and the client
+// ignores them unless OnDeprecated is used.  The function is called while processing responses, so it should not
+// block.
+func (cl *Client) OnDeprecated(fn func(function, notice string)) {
+	cl.control.Lock()
+	defer cl.control.Unlock()
+	cl.onDeprecated = fn
+}
+
+// Done returns a channel that is closed when the client is disconnected.
+func (cl *Client) Done() <-chan struct{} { return cl.doneCh }
+
+// Err returns the reason the client was disconnected, or nil if it is still connected.
+func (cl *Client) Err() error {
+	cl.control.Lock()
+	defer cl.control.Unlock()
+	return cl.err
+}
+
+// An Error is returned by a client when the service fails a request.
+type Error struct {
+	Code      int    // Status code, generally analogous to HTTP status codes.
+	Msg       string // Message describing the failure.
+	Retryable bool   // True if the request may succeed if it is sent again, see rpcerr.Error.
+}
+
+// Error implements the error interface.
+func (err *Error) Error() string { return fmt.Sprintf(`mrpc: %v %v`, err.Code, err.Msg) }
+
+// ErrClosed is returned for pending requests when the client is disconnected.
+var ErrClosed = errors.New(`mrpc: client closed`)
+
+// Call calls a function on the service and waits for its output.  The output type must usually be given
+// explicitly, such as `mrpc.Call[printf.Response](ctx, client, "printf", &req)`.
+func Call[O any, PO interface {
+	*O
+	msgp.Unmarshaler
+}](ctx context.Context, cl *Client, function string, input msgp.Marshaler) (O, error) {
+	var out O
+	p, err := cl.send(ctx, `call`, function, input)
+	if err != nil {
+		return out, err
+	}
+	defer cl.forget(p)
+	select {
+	case <-ctx.Done():
+		cl.cancel(ctx, p)
+		return out, ctx.Err()
+	case rsp, ok := <-p.responseCh:
+		if !ok {
+			return out, cl.closedErr()
+		}
+		switch rsp.Method {
+		case `succ`:
+			_, err = PO(&out).UnmarshalMsg(rsp.Output.(msgp.Raw))
+			if err != nil {
+				return out, fmt.Errorf(`%w while decoding output`, err)
+			}
+			return out, nil
+		case `fail`:
+			return out, decodeFail(rsp.Output.(msgp.Raw))
+		default:
+			return out, fmt.Errorf(`mrpc: unexpected %q response to call`, rsp.Method)
+		}
+	}
+}
+
+// Start starts a function on the service that yields a stream of outputs.  Like Call, the output type must usually
+// be given explicitly.  Cancelling the context will stop the stream and ask the service to cancel the function.
+func Start[O any, PO interface {
+	*O
+	msgp.Unmarshaler
+}](ctx context.Context, cl *Client, function string, input msgp.Marshaler) (*Stream[O], error) {
+	p, err := cl.send(ctx, `start`, function, input)
+	if err != nil {
+		return nil, err
+	}
+	return stream[O, PO](ctx, cl, p), nil
+}
+
+// stream returns a Stream of the outputs yielded for a pending request.
+func stream[O any, PO interface {
+	*O
+	msgp.Unmarshaler
+}](ctx context.Context, cl *Client, p *pending) *Stream[O] {
+	st := &Stream[O]{yieldCh: make(chan O)}
+	go func() {
+		defer close(st.yieldCh)
+		defer cl.forget(p)
+		st.err = streamResponses(ctx, cl, p, func(output msgp.Raw) error {
+			var out O
+			_, err := PO(&out).UnmarshalMsg(output)
+			if err != nil {
+				return fmt.Errorf(`%w while decoding output`, err)
+			}
+			select {
+			case st.yieldCh <- out:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return st
+}
+
+// Open starts a function registered with DuplexFn on the service, which receives the inputs sent with Send until
+// CloseSend is called, while it yields a stream of outputs.  Like Start, the output type must usually be given
+// explicitly, and cancelling the context will stop the stream and ask the service to cancel the function.  The stream
+// ends when the function returns, which may be before CloseSend is called.
+func Open[O any, PO interface {
+	*O
+	msgp.Unmarshaler
+}](ctx context.Context, cl *Client, function string) (*Duplex[O], error) {
+	p, err := cl.send(ctx, `duplex`, function, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Duplex[O]{Stream: stream[O, PO](ctx, cl, p), cl: cl, id: p.id}, nil
+}
+
+// A Duplex sends inputs to a function started with Open and receives the outputs it yields.
+type Duplex[O any] struct {
+	*Stream[O]
+	cl *Client
+	id string
+}
+
+// Send sends an input to the function.  Inputs sent after the function has returned are ignored by the service.
+func (dx *Duplex[O]) Send(ctx context.Context, input msgp.Marshaler) error {
+	req := protocol.Request{ID: dx.id, Method: `send`}
+	var err error
+	req.Input, err = input.MarshalMsg(nil)
+	if err != nil {
+		return fmt.Errorf(`%w while encoding input`, err)
+	}
+	return dx.cl.write(ctx, req)
+}
+
+// CloseSend tells the function that there are no more inputs, so it can finish the stream.
+func (dx *Duplex[O]) CloseSend(ctx context.Context) error {
+	return dx.cl.write(ctx, protocol.Request{ID: dx.id, Method: `close`})
+}
+
+// write sends a request that has no response.
+func (cl *Client) write(ctx context.Context, req protocol.Request) error {
+	msg, err := req.MarshalMsg(nil)
+	if err != nil {
+		return fmt.Errorf(`%w while encoding request`, err)
+	}
+	return cl.conn.Write(ctx, websocket.MessageBinary, msg)
+}
+
+// streamResponses calls yield with each output yielded for a pending request until the stream ends or fails.
+func streamResponses(ctx context.Context, cl *Client, p *pending, yield func(msgp.Raw) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			cl.cancel(ctx, p)
+			return ctx.Err()
+		case rsp, ok := <-p.responseCh:
+			if !ok {
+				return cl.closedErr()
+			}
+			switch rsp.Method {
+			case `yield`:
+				err := yield(rsp.Output.(msgp.Raw))
+				if err != nil {
+					cl.cancel(ctx, p)
+					return err
+				}
+			case `end`:
+				return nil
+			case `fail`:
+				return decodeFail(rsp.Output.(msgp.Raw))
+			default:
+				return fmt.Errorf(`mrpc: unexpected %q response to start`, rsp.Method)
+			}
+		}
+	}
+}
+
+// A Stream receives the outputs yielded by a function started with Start.
+type Stream[O any] struct {
+	yieldCh chan O
+	err     error
+}
+
+// Yields returns a channel of outputs that is closed when the stream ends, fails or is cancelled.
+func (st *Stream[O]) Yields() <-chan O { return st.yieldCh }
+
+// Err returns the reason the stream stopped, or nil if it ended normally.  This is only meaningful after the
+// channel returned by Yields has been closed.
+func (st *Stream[O]) Err() error { return st.err }
+
+// send registers a pending request and sends it to the service.
+func (cl *Client) send(ctx context.Context, method, function string, input msgp.Marshaler) (*pending, error) {
+	req := protocol.Request{
+		ID:       strconv.FormatUint(cl.seq.Add(1), 36),
+		Method:   method,
+		Function: function,
+	}
+	if input != nil {
+		var err error
+		req.Input, err = input.MarshalMsg(nil)
+		if err != nil {
+			return nil, fmt.Errorf(`%w while encoding input`, err)
+		}
+	}
+	msg, err := req.MarshalMsg(nil)
+	if err != nil {
+		return nil, fmt.Errorf(`%w while encoding request`, err)
+	}
+	p := &pending{
+		id:         req.ID,
+		function:   function,
+		responseCh: make(chan protocol.Response),
+		quitCh:     make(chan struct{}),
+	}
+	cl.control.Lock()
+	if cl.err != nil {
+		cl.control.Unlock()
+		return nil, cl.err
+	}
+	cl.pending[req.ID] = p
+	cl.control.Unlock()
+	err = cl.conn.Write(ctx, websocket.MessageBinary, msg)
+	if err != nil {
+		cl.forget(p)
+		return nil, err
+	}
+	return p, nil
+}
+
+// cancel asks the service to cancel a pending request; the service does not respond to cancellation.
+func (cl *Client) cancel(ctx context.Context, p *pending) {
+	_ = cl.write(context.WithoutCancel(ctx), protocol.Request{ID: p.id, Method: `cancel`})
+}
+
+// forget stops delivering responses to a pending request.
+func (cl *Client) forget(p *pending) {
+	cl.control.Lock()
+	defer cl.control.Unlock()
+	if cl.pending[p.id] == p {
+		delete(cl.pending, p.id)
+	}
+	select {
+	case <-p.quitCh:
+	default:
+		close(p.quitCh)
+	}
+}
+
+// process reads responses from the service and delivers them to pending requests until the connection is closed.
+func (cl *Client) process() {
+	err := cl.processResponses()
+	if websocket.CloseStatus(err) >= 0 {
+		err = ErrClosed
+	}
+	cl.control.Lock()
+	cl.err = err
+	for id, p := range cl.pending {
+		delete(cl.pending, id)
+		close(p.responseCh)
+	}
+	cl.control.Unlock()
+	close(cl.doneCh)
+}
+
+func (cl *Client) processResponses() error {
+	ctx := context.Background()
+	parts := make(map[string][]byte) // outputs being reassembled, by request ID, see ChunkYields
+	for {
+		mt, msg, err := cl.conn.Read(ctx)
+		if err != nil {
+			return err
+		}
+		if mt != websocket.MessageBinary {
+			continue
+		}
+		var rsp protocol.Response
+		_, err = rsp.UnmarshalMsg(msg)
+		if err != nil {
+			return err
+		}
+		switch rsp.Method {
+		case `depr`:
+			var notice protocol.Notice
+			_, err = notice.UnmarshalMsg(rsp.Output.(msgp.Raw))
+			if err != nil {
+				return fmt.Errorf(`%w while decoding deprecation notice`, err)
+			}
+			cl.deprecated(rsp.ID, string(notice))
+			continue
+		case `part`, `last`:
+			var part protocol.Part
+			_, err = part.UnmarshalMsg(rsp.Output.(msgp.Raw))
+			if err != nil {
+				return fmt.Errorf(`%w while decoding part of an output`, err)
+			}
+			parts[rsp.ID] = append(parts[rsp.ID], part...)
+			if rsp.Method == `part` {
+				continue
+			}
+			rsp.Method, rsp.Output = `yield`, msgp.Raw(parts[rsp.ID])
+			delete(parts, rsp.ID)
+		default:
+			delete(parts, rsp.ID) // the stream ended or failed before the last part.
+		}
+		cl.control.Lock()
+		p := cl.pending[rsp.ID]
+		cl.control.Unlock()
+		if p == nil {
+			continue // the caller is no longer interested in this request
+		}
+		select {
+		case p.responseCh <- rsp:
+		case <-p.quitCh:
+		}
+	}
+}
+
+// deprecated passes the notice that a pending request is for a deprecated function to the OnDeprecated function.
+func (cl *Client) deprecated(id, notice string) {
+	cl.control.Lock()
+	p, fn := cl.pending[id], cl.onDeprecated
+	cl.control.Unlock()
+	if p != nil && fn != nil {
+		fn(p.function, notice)
+	}
+}
+
+func (cl *Client) closedErr() error {
+	err := cl.Err()
+	if err == nil {
+		err = ErrClosed
+	}
+	return err
+}
+
+func decodeFail(output msgp.Raw) error {
+	var fail protocol.Fail
+	_, err := fail.UnmarshalMsg(output)
+	if err != nil {
+		return fmt.Errorf(`%w while decoding failure`, err)
+	}
+	return &Error{Code: fail.Code, Msg: fail.Msg, Retryable: fail.Retryable}
+}
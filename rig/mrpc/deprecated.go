@@ -0,0 +1,96 @@
+package mrpc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig/mrpc/internal/protocol"
+)
+
+// Deprecated marks a function registered with CallFn, StartFn, DuplexFn or Alias as deprecated, with a notice that
+// explains what clients should use instead, such as `mrpc.Deprecated("oldFn", "use newFn instead")`.  Requests for the
+// function are still handled, but the service logs a warning with the notice, at most once per DeprecationLogInterval
+// for each function, along with how many requests arrived since the last warning.
+//
+// Deprecating an alias leaves the function it refers to alone, so Alias and Deprecated together keep an old name
+// working while its use is tracked.  Like Alias, Deprecated is resolved once every option has been applied, and New and
+// API fail if the function is not registered by then.  Clients are only told with DeprecationNotices.
+func Deprecated(function, notice string) Option {
+	return func(cfg *config) {
+		cfg.deprecated = append(cfg.deprecated, deprecation{function: function, notice: notice})
+	}
+}
+
+// DeprecationNotices sends clients a "depr" response with the notice of each deprecated function they request, before
+// any other response to the request, see Client.OnDeprecated.  This is not the default because clients that predate
+// the "depr" response fail the request when they receive it.
+func DeprecationNotices() Option {
+	return func(cfg *config) { cfg.notices = true }
+}
+
+// DeprecationLogInterval limits how often a warning is logged for each deprecated function, see Deprecated.
+// DeprecationLogInterval should be set before any service is configured.
+var DeprecationLogInterval = time.Minute
+
+// A deprecation describes a function given to Deprecated.
+type deprecation struct {
+	function, notice string
+}
+
+// resolveDeprecated wraps the handlers of the functions given to Deprecated.
+func (cfg *config) resolveDeprecated() error {
+	for _, dep := range cfg.deprecated {
+		log := &deprecationLog{deprecation: dep}
+		found := false
+		for _, table := range []map[string]Handler{cfg.callHandlers, cfg.startHandlers, cfg.duplexHandlers} {
+			handler := table[dep.function]
+			if handler == nil {
+				continue
+			}
+			table[dep.function], found = cfg.deprecate(log, handler), true
+		}
+		if !found {
+			return fmt.Errorf(`mrpc: deprecated function %q is not registered`, dep.function)
+		}
+	}
+	return nil
+}
+
+// deprecate returns a handler that warns about a deprecated function, and notifies the client if asked to, before
+// handling the request.
+func (cfg *config) deprecate(log *deprecationLog, handler Handler) Handler {
+	return func(ctx *Scope) {
+		log.warn(ctx)
+		if cfg.notices {
+			_ = ctx.Respond(`depr`, protocol.Notice(log.notice))
+		}
+		handler(ctx)
+	}
+}
+
+// A deprecationLog limits the warnings logged for a deprecated function, which is shared by every method it is
+// registered for.
+type deprecationLog struct {
+	deprecation
+	control sync.Mutex
+	logged  time.Time // when the last warning was logged, zero if none has been
+	calls   int       // requests since the last warning was logged
+}
+
+// warn counts a request for the function and logs a warning unless one was logged within DeprecationLogInterval.
+func (log *deprecationLog) warn(ctx *Scope) {
+	log.control.Lock()
+	log.calls++
+	now := time.Now()
+	if !log.logged.IsZero() && now.Sub(log.logged) < DeprecationLogInterval {
+		log.control.Unlock()
+		return
+	}
+	calls := log.calls
+	log.logged, log.calls = now, 0
+	log.control.Unlock()
+	hog.From(ctx).Warn().Str(`function`, ctx.Function).Str(`notice`, log.notice).Int(`calls`, calls).
+		Msg(`deprecated MRPC function requested`)
+}
@@ -0,0 +1,74 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// A DuplexFn is a function that handles a "duplex" request, which streams inputs from the client while the function
+// yields outputs, such as for a chat or an incremental upload.  The function calls recv to receive each input, which
+// returns io.EOF once the client has closed its inputs, or the error of the scope if it is cancelled.  Like a
+// StartFn, the function must call ctx.Yield for each output, and the framework calls ctx.End or ctx.Fail when the
+// function returns.
+//
+// Only the function ends the stream, by returning, which it may do before the client has closed its inputs; any
+// inputs sent after that are ignored.  Inputs are buffered up to DuplexBuffer for each request.  If the client sends
+// more before the function receives them, the request is cancelled and recv returns a retryable error with code 503,
+// instead of the connection waiting for the function to catch up.
+func DuplexFn[I any, PI interface {
+	*I
+	msgp.Unmarshaler
+}, O any, PO interface {
+	*O
+	msgp.MarshalSizer
+}](function string, fn func(ctx *Scope, recv func() (I, error)) error) Option {
+	return func(cfg *config) {
+		cfg.duplexHandlers[function] = func(ctx *Scope) {
+			recv := func() (I, error) {
+				var in I
+				raw, err := ctx.Recv()
+				if err != nil {
+					return in, err
+				}
+				_, err = PI(&in).UnmarshalMsg(raw)
+				if err != nil {
+					return in, fmt.Errorf(`%w while decoding input`, err)
+				}
+				return in, nil
+			}
+			err := fn(ctx, recv)
+			if err != nil {
+				_ = ctx.FailWith(err)
+			} else {
+				_ = ctx.End()
+			}
+		}
+	}
+}
+
+// DuplexBuffer is how many inputs are buffered for each duplex request before it fails, see DuplexFn.
+var DuplexBuffer = 16
+
+// Recv returns the next input sent by the client for a duplex request, io.EOF once the client has closed its inputs,
+// or the cause of the cancellation of the scope if it is cancelled, such as a retryable rpcerr.Error if the client
+// sent more than DuplexBuffer inputs that were not received.  It returns an error for other requests.
+func (ctx *Scope) Recv() (msgp.Raw, error) {
+	if ctx.inputs == nil {
+		return nil, errNotDuplex
+	}
+	select {
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	case input, ok := <-ctx.inputs:
+		if !ok {
+			return nil, io.EOF
+		}
+		return input, nil
+	}
+}
+
+var errNotDuplex = errors.New(`not a duplex request`)
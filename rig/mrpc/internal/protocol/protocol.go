@@ -4,15 +4,24 @@ package protocol
 import "github.com/tinylib/msgp/msgp"
 
 //go:generate go run github.com/tinylib/msgp
-//msgp:tuple Request Fail
-//msgp:ignore Response
+//msgp:tuple Request
+//msgp:ignore Response Fail Part Notice
 
 // A Request is a message sent from a client to a server.
 type Request struct {
 	// ID is a unique identifier for this request used to coordinate responses.
 	ID string
 
-	// Method is currently one of "call" or "start" but may be used for other purposes in the future.
+	// Method is currently one of "call", "start", "duplex", "send", "close" or "cancel" but may be used for other
+	// purposes in the future.  A "cancel" request cancels the context of the request in flight with the same ID and
+	// has no response.
+	//
+	// A "duplex" request starts a function like "start" does, but without an input.  Instead, the client sends each
+	// input as a "send" request with the same ID, and a "close" request once it has no more, which the function sees
+	// as the end of its inputs.  Neither has a response, and both are ignored once the function has ended.  The
+	// function yields outputs as it likes and ends the stream with an "end" or "fail" response when it returns,
+	// whether or not the client has closed its inputs, so only the server ends a stream; a client that wants to stop
+	// early sends "cancel".
 	Method string
 
 	// Function is the name of the function to call or start.  This may be an empty string if unused by other
@@ -29,7 +38,17 @@ type Response struct {
 	// ID is the ID of the request to which this is a response.
 	ID string
 
-	// Method is currently one of "succ", "fail" or "yield" but may be used for other purposes in the future.
+	// Method is currently one of "succ", "fail", "yield", "end", "part", "last" or "depr" but may be used for other
+	// purposes in the future.
+	//
+	// A "part" or "last" response carries a Part of a yielded output that was too large for one message.  The output
+	// is split into a series of "part" responses ending with a "last" response, which are sent in order and without
+	// any other response to the same request between them, although responses to other requests may be.  The client
+	// concatenates the parts, keyed by ID, and treats the result as the output of a "yield".  If a "fail" or "end"
+	// arrives before the "last" part, the parts received so far are discarded.
+	//
+	// A "depr" response carries a Notice that the requested function is deprecated, and is sent before any other
+	// response to the request.  Services only send it when asked to, since clients that predate it fail the request.
 	Method string
 
 	// Output contains the result of the request, which may be nil.  The actual underlying type depends on the method.
@@ -59,8 +78,125 @@ func (rs *Response) MarshalMsg(b []byte) ([]byte, error) {
 	return b, nil
 }
 
+// UnmarshalMsg implements msgp.Unmarshaler.  Since the type of the output depends on the method and function, the
+// output is decoded as a msgp.Raw that the client must unmarshal.
+func (rs *Response) UnmarshalMsg(b []byte) ([]byte, error) {
+	sz, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return b, err
+	}
+	if sz != 3 {
+		return b, msgp.ArrayError{Wanted: 3, Got: sz}
+	}
+	rs.ID, b, err = msgp.ReadStringBytes(b)
+	if err != nil {
+		return b, msgp.WrapError(err, `ID`)
+	}
+	rs.Method, b, err = msgp.ReadStringBytes(b)
+	if err != nil {
+		return b, msgp.WrapError(err, `Method`)
+	}
+	var output msgp.Raw
+	b, err = output.UnmarshalMsg(b)
+	if err != nil {
+		return b, msgp.WrapError(err, `Output`)
+	}
+	rs.Output = output
+	return b, nil
+}
+
+// A Part is a piece of the encoded output of a "yield", encoded as MessagePack binary; see Response.Method.
+type Part []byte
+
+// Msgsize implements msgp.MarshalSizer
+func (p Part) Msgsize() int { return msgp.BytesPrefixSize + len(p) }
+
+// MarshalMsg implements msgp.Marshaler
+func (p Part) MarshalMsg(b []byte) ([]byte, error) { return msgp.AppendBytes(b, p), nil }
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (p *Part) UnmarshalMsg(b []byte) ([]byte, error) {
+	var err error
+	*p, b, err = msgp.ReadBytesBytes(b, (*p)[:0])
+	return b, err
+}
+
+// A Notice explains that a function is deprecated, such as what to use instead, encoded as a MessagePack string; see
+// Response.Method.
+type Notice string
+
+// Msgsize implements msgp.MarshalSizer
+func (n Notice) Msgsize() int { return msgp.StringPrefixSize + len(n) }
+
+// MarshalMsg implements msgp.Marshaler
+func (n Notice) MarshalMsg(b []byte) ([]byte, error) { return msgp.AppendString(b, string(n)), nil }
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (n *Notice) UnmarshalMsg(b []byte) ([]byte, error) {
+	s, b, err := msgp.ReadStringBytes(b)
+	*n = Notice(s)
+	return b, err
+}
+
+// RetryableHeader is the name of the header that a client sets to any non-empty value when it connects to accept a
+// Fail with three elements, see Fail.  Browsers, which cannot set headers when they open a WebSocket, may use a query
+// parameter of the same name instead.
+const RetryableHeader = `mrpc-retryable`
+
 // A Fail is a response that indicates an error occurred.
+//
+// A Fail is encoded as a tuple of the code and message, with a third element if it is retryable.  Clients that predate
+// Retryable require exactly two elements, so services only send the third to clients that ask for it with
+// RetryableHeader, and treat failures as not retryable for other clients.
 type Fail struct {
-	Code int // Status code, generally analogous to HTTP status codes.
-	Msg  string
+	Code      int // Status code, generally analogous to HTTP status codes.
+	Msg       string
+	Retryable bool // True if the request may succeed if it is sent again, such as when the service is busy.
+}
+
+// Msgsize implements msgp.MarshalSizer
+func (f Fail) Msgsize() int {
+	return msgp.ArrayHeaderSize + msgp.IntSize + msgp.StringPrefixSize + len(f.Msg) + msgp.BoolSize
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (f Fail) MarshalMsg(b []byte) ([]byte, error) {
+	if !f.Retryable {
+		b = msgp.AppendArrayHeader(b, 2)
+	} else {
+		b = msgp.AppendArrayHeader(b, 3)
+	}
+	b = msgp.AppendInt(b, f.Code)
+	b = msgp.AppendString(b, f.Msg)
+	if f.Retryable {
+		b = msgp.AppendBool(b, true)
+	}
+	return b, nil
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (f *Fail) UnmarshalMsg(b []byte) ([]byte, error) {
+	sz, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return b, err
+	}
+	if sz != 2 && sz != 3 {
+		return b, msgp.ArrayError{Wanted: 3, Got: sz}
+	}
+	f.Code, b, err = msgp.ReadIntBytes(b)
+	if err != nil {
+		return b, msgp.WrapError(err, `Code`)
+	}
+	f.Msg, b, err = msgp.ReadStringBytes(b)
+	if err != nil {
+		return b, msgp.WrapError(err, `Msg`)
+	}
+	f.Retryable = false
+	if sz == 3 {
+		f.Retryable, b, err = msgp.ReadBoolBytes(b)
+		if err != nil {
+			return b, msgp.WrapError(err, `Retryable`)
+		}
+	}
+	return b, nil
 }
@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestFailCompatibility(t *testing.T) {
+	for _, fail := range []Fail{
+		{Code: 400, Msg: `invalid`},
+		{Code: 503, Msg: `busy`, Retryable: true},
+	} {
+		b, err := fail.MarshalMsg(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sz, _, err := msgp.ReadArrayHeaderBytes(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Clients that predate Retryable expect exactly two elements.
+		want := uint32(2)
+		if fail.Retryable {
+			want = 3
+		}
+		if sz != want {
+			t.Fatalf(`expected %+v to be encoded as %v elements, got %v`, fail, want, sz)
+		}
+		var decoded Fail
+		_, err = decoded.UnmarshalMsg(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != fail {
+			t.Fatalf(`expected %+v, got %+v`, fail, decoded)
+		}
+	}
+}
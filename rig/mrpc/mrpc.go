@@ -4,12 +4,17 @@ package mrpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/swdunlop/html-go/hog"
 	"github.com/swdunlop/rig-go/rig/mrpc/internal/protocol"
+	"github.com/swdunlop/rig-go/rig/rpcerr"
+	"github.com/swdunlop/rig-go/rig/tracing"
 
 	"github.com/swdunlop/rig-go/rig/api"
 	"github.com/tinylib/msgp/msgp"
@@ -18,15 +23,170 @@ import (
 
 // API returns an api.Option that supports RPC requests at the specified route.
 func API(route string, options ...Option) api.Option {
-	return api.Handle(route, Handle(options...))
+	handler, err := New(options...)
+	if err != nil {
+		return api.Fail(err)
+	}
+	return api.Handle(route, handler)
 }
 
 // Handle returns a http.Handler that upgrades the connection to a WebSocket and handles RPC requests
-// until the connection is closed.
+// until the connection is closed.  Since every request fails if no functions are registered, Handle logs a warning if
+// there are none and no NotFoundHandler, such as when only Use is given.  If the options do not fit together, Handle
+// logs the error from New and the handler fails every request with 500 Internal Server Error.
 func Handle(options ...Option) http.Handler {
+	handler, err := New(options...)
+	if err != nil {
+		hog.From(context.Background()).Error().Err(err).Msg(`MRPC service is misconfigured, every request will fail`)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		})
+	}
+	return handler
+}
+
+// New returns a http.Handler like Handle, or an error if the options do not fit together, such as an Alias that
+// refers to a function that is not registered.
+func New(options ...Option) (http.Handler, error) {
 	var cfg config
-	cfg.init(options...)
-	return &cfg
+	err := cfg.init(options...)
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// MaxConcurrent limits the number of requests that may be handled at the same time on each connection.  Requests that
+// arrive while the limit is reached are not queued, they fail immediately with code 429 so a client that floods the
+// service cannot stall the connection.  The default of zero imposes no limit.
+func MaxConcurrent(n int) Option {
+	return func(cfg *config) { cfg.maxConcurrent = n }
+}
+
+// Budget limits the total cost of the requests in flight across every connection to the service, so a flood of
+// expensive requests cannot saturate the service.  Requests that would exceed the budget are shed with code 503 after
+// waiting for as long as Shed allows.  The cost function returns the cost of calling or starting a function, which
+// should be between 1 and the limit, and may be nil to count every request as 1.  This is distinct from
+// MaxConcurrent, which limits the number of requests on each connection.  The default of zero imposes no budget.
+func Budget(limit int, cost func(function string) int) Option {
+	return func(cfg *config) { cfg.budgetLimit, cfg.budgetCost = limit, cost }
+}
+
+// Shed sets how long a request may wait for room in the Budget before it is shed.  While a request waits, no more
+// messages are read from its connection, which pushes back on clients that flood the service.  The default of zero
+// sheds requests immediately.
+func Shed(wait time.Duration) Option {
+	return func(cfg *config) { cfg.shedWait = wait }
+}
+
+// Workers keeps up to n goroutines waiting to handle requests, reusing them for later requests on every connection
+// instead of starting a goroutine for each request, which reduces the overhead of services that handle many short
+// requests.  Requests never wait for a goroutine, so this does not limit how many are handled at the same time; use
+// MaxConcurrent or Budget for that.  Idle goroutines are kept for as long as the service is.  The default of zero
+// starts a goroutine for each request.
+func Workers(n int) Option {
+	return func(cfg *config) { cfg.workers = n }
+}
+
+// Observe specifies a function that is called with the timing of each request once it has been handled or shed, such
+// as to record metrics or log slow requests.  It is called from the goroutine that handled the request, so it should
+// not block.
+func Observe(fn func(Observation)) Option {
+	return func(cfg *config) { cfg.observe = fn }
+}
+
+// An Observation describes the timing of a request, see Observe.
+type Observation struct {
+	Method   string        // The method of the request, such as "call" or "start".
+	Function string        // The function that was called or started.
+	Decode   time.Duration // Time spent decoding the request.
+	Wait     time.Duration // Time spent waiting for room in the Budget.
+	Handle   time.Duration // Time spent handling the request, which is zero if it was shed.
+	Shed     bool          // True if the request was shed because the Budget was exceeded.
+	Failed   bool          // True if the request failed, including when it was shed.
+}
+
+// PingInterval sends a WebSocket ping on each connection at the given interval, closing the connection if the pong
+// does not arrive before the next ping is due.  This keeps idle connections alive through proxies that drop them and
+// detects clients that vanish without closing the connection.  The default of zero sends no pings.
+func PingInterval(interval time.Duration) Option {
+	return func(cfg *config) { cfg.pingInterval = interval }
+}
+
+// CloseTimeout sets how long the service waits for a client to acknowledge the close frame sent when the service
+// closes a connection, such as when it is shutting down, so the client learns why the connection closed instead of
+// seeing it drop.  If the client does not acknowledge in time, the service stops waiting, although the WebSocket
+// library may take a few more seconds to give up on the handshake in the background.  A timeout of zero closes
+// connections immediately without a close frame.  The default is DefaultCloseTimeout.
+func CloseTimeout(timeout time.Duration) Option {
+	return func(cfg *config) { cfg.closeTimeout = timeout }
+}
+
+// DefaultCloseTimeout is the default for CloseTimeout, which is short so that closing connections does not hold up
+// shutting down.
+var DefaultCloseTimeout = time.Second
+
+// Authorize specifies a function that authenticates each connection before it is upgraded to a WebSocket, such as
+// by checking a token passed in a header or query parameter.  If fn returns an error, the upgrade is rejected with
+// 401 Unauthorized if the error wraps ErrUnauthorized, or 403 Forbidden otherwise.  The principal returned by fn is
+// available to handlers using Scope.Principal.
+func Authorize(fn func(r *http.Request) (principal any, err error)) Option {
+	return func(cfg *config) { cfg.authorize = fn }
+}
+
+// ErrUnauthorized may be wrapped by errors returned by an Authorize function to reject the connection with 401
+// Unauthorized instead of 403 Forbidden.
+var ErrUnauthorized = errors.New(`unauthorized`)
+
+// OnConnect specifies a function that is called for each connection before it is upgraded to a WebSocket, after
+// Authorize, such as to set up a rate limiter or a logger for the connection.  The context returned by fn becomes the
+// parent of the scope of every request on the connection, so handlers can find what fn added without redoing it for
+// each request.  If fn returns an error, the upgrade is rejected with the code of an rpcerr.Error if the error wraps
+// one, 401 Unauthorized if it wraps ErrUnauthorized, or 403 Forbidden otherwise.  Functions given to multiple OnConnect
+// options are called in order, each with the context returned by the last.
+func OnConnect(fn func(ctx context.Context, r *http.Request) (context.Context, error)) Option {
+	return func(cfg *config) { cfg.onConnect = append(cfg.onConnect, fn) }
+}
+
+// OnClose specifies a function that is called once a connection accepted by the OnConnect functions has closed and the
+// requests in flight on it have finished, such as to release what OnConnect set up.  The context is the one returned
+// by the OnConnect functions, which may already be cancelled.  Functions given to multiple OnClose options are called
+// in the reverse order.
+func OnClose(fn func(ctx context.Context)) Option {
+	return func(cfg *config) { cfg.onClose = append(cfg.onClose, fn) }
+}
+
+// connect calls the OnConnect functions, returning the context for the connection.
+func (cfg *config) connect(r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	for _, fn := range cfg.onConnect {
+		var err error
+		ctx, err = fn(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+// disconnect calls the OnClose functions in the reverse order.
+func (cfg *config) disconnect(ctx context.Context) {
+	for i := len(cfg.onClose) - 1; i >= 0; i-- {
+		cfg.onClose[i](ctx)
+	}
+}
+
+// rejectStatus returns the status for rejecting a connection because of an error from an OnConnect function.
+func rejectStatus(err error) int {
+	var ret *rpcerr.Error
+	switch {
+	case errors.As(err, &ret) && ret.Code >= 400 && ret.Code < 600:
+		return ret.Code
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusForbidden
+	}
 }
 
 // Use specifies middleware that is applied to all requests.
@@ -36,6 +196,22 @@ func Use(fn func(Handler) Handler) Option {
 	}
 }
 
+// NotFoundHandler specifies a handler for requests to functions that were not registered with CallFn or StartFn, such
+// as to forward them to another service, instead of failing them with "not found".  Like the handlers of registered
+// functions, it is called through the middleware given to Use.
+func NotFoundHandler(fn Handler) Option {
+	return func(cfg *config) { cfg.notFound = fn }
+}
+
+// ChunkYields splits outputs yielded by StartFn functions that encode to more than size bytes across several
+// WebSocket messages of at most size bytes each, so a single large output does not exceed the read limit of a client
+// or make either side buffer one huge message.  Clients reassemble the output before decoding it, as described by the
+// "part" and "last" responses of the protocol, which clients must support; the Client returned by Dial does.  Outputs
+// of calls are never split.  The default of zero never splits outputs.
+func ChunkYields(size int) Option {
+	return func(cfg *config) { cfg.chunkSize = size }
+}
+
 // For creates a new context for the given request and send function.  Generally this is not necessary but it can
 // be useful for testing.
 func For(ctx context.Context, req protocol.Request, send func(bin []byte) error) *Scope {
@@ -53,18 +229,49 @@ func From(ctx context.Context) *Scope {
 
 type ctxKey struct{}
 
+type principalKey struct{}
+
 // A Scope describes the scope of an RPC request.
 type Scope struct {
 	context.Context
 	protocol.Request
-	send func(bin []byte) error
+	send      func(bin []byte) error
+	chunkSize int            // zero if yielded outputs are not split, see ChunkYields
+	inputs    chan msgp.Raw  // inputs sent by the client, nil unless this is a duplex request
+	failure   *protocol.Fail // nil until a failure has been sent
+	deadline  *deadline      // nil if the request has no time limit, see Timeout
+	retryable bool           // true if the client accepts retryable failures, see protocol.RetryableHeader
 }
 
+// Principal returns the principal returned by the Authorize function when the connection was accepted, or nil if
+// there is no Authorize function.
+func (ctx *Scope) Principal() any { return ctx.Value(principalKey{}) }
+
 // Succ sends a success response to the client.
 func (ctx *Scope) Succ(output msgp.MarshalSizer) error { return ctx.Respond(`succ`, output) }
 
-// Yield yields a response to the client.
-func (ctx *Scope) Yield(output msgp.MarshalSizer) error { return ctx.Respond(`yield`, output) }
+// Yield yields a response to the client.  If the output is larger than the size given to ChunkYields, it is sent in
+// parts that the client reassembles.
+func (ctx *Scope) Yield(output msgp.MarshalSizer) error {
+	if ctx.chunkSize <= 0 || output == nil || output.Msgsize() <= ctx.chunkSize {
+		return ctx.Respond(`yield`, output)
+	}
+	bin, err := output.MarshalMsg(nil)
+	if err != nil {
+		return fmt.Errorf(`%w while encoding response`, err)
+	}
+	if len(bin) <= ctx.chunkSize {
+		return ctx.Respond(`yield`, msgp.Raw(bin)) // Msgsize overestimated it.
+	}
+	for len(bin) > ctx.chunkSize {
+		err = ctx.Respond(`part`, protocol.Part(bin[:ctx.chunkSize]))
+		if err != nil {
+			return err
+		}
+		bin = bin[ctx.chunkSize:]
+	}
+	return ctx.Respond(`last`, protocol.Part(bin))
+}
 
 // End sends an end response to the client.  You may not send any more responses after this.
 func (ctx *Scope) End() error {
@@ -73,10 +280,25 @@ func (ctx *Scope) End() error {
 	return err
 }
 
-// Fail sends a failure response to the client.
+// Fail sends a failure response to the client that is not retryable.
 func (ctx *Scope) Fail(code int, msg string) error {
-	err := ctx.Respond(`fail`, protocol.Fail{Code: code, Msg: msg})
-	ctx.send = nil
+	return ctx.fail(protocol.Fail{Code: code, Msg: msg})
+}
+
+// FailWith sends a failure response describing err to the client, using the code, message and retryable flag of an
+// rpcerr.Error if err wraps one, or code 500 and the message of err otherwise.  The data of an rpcerr.Error is not
+// sent.  Clients see whether a failure is retryable in Error.Retryable, although the flag is only sent to clients that
+// ask for it, like those from Dial, since older clients cannot decode it, see protocol.RetryableHeader.
+func (ctx *Scope) FailWith(err error) error {
+	ret := rpcerr.From(err)
+	return ctx.fail(protocol.Fail{Code: ret.Code, Msg: ret.Message, Retryable: ret.Retryable})
+}
+
+func (ctx *Scope) fail(fail protocol.Fail) error {
+	sent := fail
+	sent.Retryable = fail.Retryable && ctx.retryable
+	err := ctx.Respond(`fail`, sent)
+	ctx.send, ctx.failure = nil, &fail
 	return err
 }
 
@@ -94,6 +316,9 @@ func (ctx *Scope) Respond(method string, output msgp.MarshalSizer) error {
 	if err != nil {
 		return fmt.Errorf(`%w while encoding response`, err)
 	}
+	if ctx.deadline != nil {
+		return ctx.deadline.respond(method, msg)
+	}
 	return ctx.send(msg)
 }
 
@@ -101,18 +326,65 @@ func (ctx *Scope) Respond(method string, output msgp.MarshalSizer) error {
 type Option func(*config)
 
 type config struct {
-	handler       Handler
-	startHandlers map[string]Handler
-	callHandlers  map[string]Handler
+	handler        Handler
+	startHandlers  map[string]Handler
+	callHandlers   map[string]Handler
+	duplexHandlers map[string]Handler
+	maxConcurrent  int                              // zero if unlimited
+	pingInterval   time.Duration                    // zero if no pings are sent
+	authorize      func(*http.Request) (any, error) // nil if connections are not authorized
+	budgetLimit    int                              // zero if there is no budget
+	budgetCost     func(string) int                 // nil if every request costs 1
+	shedWait       time.Duration                    // zero if requests are shed immediately
+	observe        func(Observation)                // nil if requests are not observed
+	budget         *budget                          // shared by every connection, nil if there is no budget
+	workers        int                              // zero if each request has its own goroutine
+	pool           *pool                            // shared by every connection, nil if there are no workers
+	notFound       Handler                          // nil if unknown functions fail with "not found"
+	closeTimeout   time.Duration                    // zero if connections are closed without a handshake
+	chunkSize      int                              // zero if yielded outputs are not split
+	metrics        *FunctionMetrics                 // nil if metrics are not aggregated
+	timeout        time.Duration                    // zero if requests have no time limit
+	streamTimeout  time.Duration                    // negative if streams are limited by timeout
+	perYield       bool                             // true if streamTimeout is reset by each yield
+	tracer         tracing.Tracer                   // nil if requests are not traced
+	aliases        [][2]string                      // added by Alias, resolved by init
+	deprecated     []deprecation                    // added by Deprecated, resolved by init
+	notices        bool                             // true if clients are sent notices of deprecated functions
+
+	onConnect []func(context.Context, *http.Request) (context.Context, error) // called before upgrading connections
+	onClose   []func(context.Context)                                         // called once connections have closed
 }
 
-func (cfg *config) init(options ...Option) {
+func (cfg *config) init(options ...Option) error {
 	cfg.handler = cfg.handleRequest
+	cfg.closeTimeout = DefaultCloseTimeout
+	cfg.streamTimeout = -1
 	cfg.startHandlers = make(map[string]Handler, len(options))
 	cfg.callHandlers = make(map[string]Handler, len(options))
+	cfg.duplexHandlers = make(map[string]Handler, len(options))
 	for _, opt := range options {
 		opt(cfg)
 	}
+	err := cfg.resolveAliases()
+	if err != nil {
+		return err
+	}
+	err = cfg.resolveDeprecated()
+	if err != nil {
+		return err
+	}
+	if cfg.budgetLimit > 0 {
+		cfg.budget = newBudget(cfg.budgetLimit, cfg.budgetCost, cfg.shedWait)
+	}
+	if cfg.workers > 0 {
+		cfg.pool = newPool(cfg.workers)
+	}
+	if len(cfg.startHandlers) == 0 && len(cfg.callHandlers) == 0 && len(cfg.duplexHandlers) == 0 &&
+		cfg.notFound == nil {
+		hog.From(context.Background()).Warn().Msg(`MRPC service has no functions, every request will fail with "not found"`)
+	}
+	return nil
 }
 
 // ServeHTTP implements http.Handler.
@@ -123,21 +395,93 @@ func (cfg *config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// isUpgrade returns true if the request asks to upgrade the connection to a WebSocket, so requests that do not can be
+// told so instead of failing as a broken WebSocket handshake.
+func isUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, `Connection`, `upgrade`) && headerHasToken(r.Header, `Upgrade`, `websocket`)
+}
+
+// acceptsRetryable returns true if the client asked for retryable failures with protocol.RetryableHeader, as a header
+// or a query parameter.
+func acceptsRetryable(r *http.Request) bool {
+	return r.Header.Get(protocol.RetryableHeader) != `` || r.URL.Query().Get(protocol.RetryableHeader) != ``
+}
+
+// headerHasToken returns true if a comma separated header contains the token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, item := range strings.Split(value, `,`) {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (cfg *config) serveHTTP(w http.ResponseWriter, r *http.Request) error {
+	if cfg.authorize != nil {
+		principal, err := cfg.authorize(r)
+		switch {
+		case errors.Is(err, ErrUnauthorized):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return nil
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return nil
+		}
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+	}
+	if !isUpgrade(r) {
+		// Browsers, crawlers and health checks that wander in are not worth an error in the logs.
+		hog.For(r).Debug().Msg(`rejected a request without a WebSocket upgrade`)
+		w.Header().Set(`Upgrade`, `websocket`)
+		w.Header().Set(`Connection`, `Upgrade`)
+		http.Error(w, `this endpoint requires a WebSocket connection`, http.StatusUpgradeRequired)
+		return nil
+	}
+	if cfg.tracer != nil {
+		r = r.WithContext(tracing.Extract(r))
+	}
+	connCtx, err := cfg.connect(r)
+	if err != nil {
+		hog.For(r).Debug().Err(err).Msg(`rejected a connection`)
+		http.Error(w, err.Error(), rejectStatus(err))
+		return nil
+	}
+	defer cfg.disconnect(connCtx)
+	r = r.WithContext(connCtx)
 	c, err := websocket.Accept(w, r, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return err
+		return err // Accept has already responded.
 	}
-	defer func() { _ = c.CloseNow() }()
-	send := func(bin []byte) error {
-		return c.Write(r.Context(), websocket.MessageBinary, bin)
+	defer cfg.close(r.Context(), c)
+	if cfg.metrics != nil {
+		cfg.metrics.connections.Add(1)
+		defer cfg.metrics.connections.Add(-1)
 	}
+	queue := newSendQueue(r.Context(), func(ctx context.Context, bin []byte) error {
+		return c.Write(ctx, websocket.MessageBinary, bin)
+	})
+	defer queue.close() // after the requests in flight have finished sending.
+	send := queue.send
 	handle := cfg.handler
+	retryable := acceptsRetryable(r)
 
-	ctx := r.Context()
+	ctx, cancel := context.WithCancel(r.Context())
 	var group sync.WaitGroup
 	defer group.Wait()
+	defer cancel() // cancels any requests still in flight when the connection closes.
+	var inflight flights
+	if cfg.pingInterval > 0 {
+		pingCtx, stopPings := context.WithCancel(ctx)
+		defer stopPings()
+		go keepAlive(pingCtx, c, cfg.pingInterval)
+	}
+	var slots chan struct{}
+	if cfg.maxConcurrent > 0 {
+		slots = make(chan struct{}, cfg.maxConcurrent)
+	}
 	for {
 		mt, msg, err := c.Read(ctx)
 		if err != nil {
@@ -149,16 +493,226 @@ func (cfg *config) serveHTTP(w http.ResponseWriter, r *http.Request) error {
 		if mt != websocket.MessageBinary {
 			continue
 		}
+		started := time.Now()
 		var req protocol.Request
 		_, err = req.UnmarshalMsg(msg)
 		if err != nil {
 			return err
 		}
+		switch req.Method {
+		case `cancel`:
+			inflight.cancel(req.ID)
+			continue
+		case `send`:
+			inflight.feed(req.ID, req.Input)
+			continue
+		case `close`:
+			inflight.closeInputs(req.ID)
+			continue
+		}
+		obs := Observation{Method: req.Method, Function: req.Function, Decode: time.Since(started)}
+		if !acquireSlot(slots) {
+			scope := For(ctx, req, send)
+			scope.retryable = retryable
+			_ = scope.fail(protocol.Fail{Code: 429, Msg: `too many concurrent requests`, Retryable: true})
+			continue
+		}
+		started = time.Now()
+		cost, ok := cfg.budget.acquire(ctx, req.Function)
+		obs.Wait = time.Since(started)
+		if !ok {
+			releaseSlot(slots)
+			scope := For(ctx, req, send)
+			scope.retryable = retryable
+			_ = scope.FailWith(rpcerr.Unavailable(`service busy`))
+			obs.Shed, obs.Failed = true, true
+			cfg.observeRequest(obs)
+			continue
+		}
+		var inputs chan msgp.Raw
+		if req.Method == `duplex` {
+			inputs = make(chan msgp.Raw, DuplexBuffer)
+		}
+		reqCtx, reqCancel := inflight.start(ctx, req.ID, inputs)
 		group.Add(1)
-		go func() {
+		cfg.pool.run(func() {
 			defer group.Done()
-			handle(For(ctx, req, send))
-		}()
+			defer releaseSlot(slots)
+			defer cfg.budget.release(cost)
+			defer inflight.stop(req.ID, reqCancel)
+			started := time.Now()
+			ctx, span := cfg.startSpan(reqCtx, req.Function)
+			ctx, dl := cfg.deadline(ctx, req, send)
+			scope := For(ctx, req, send)
+			scope.chunkSize, scope.inputs, scope.deadline, scope.retryable = cfg.chunkSize, inputs, dl, retryable
+			handle(scope)
+			expired := dl.finish()
+			endSpan(span, scope, expired)
+			obs.Handle, obs.Failed = time.Since(started), scope.failure != nil || expired
+			cfg.observeRequest(obs)
+		})
+	}
+}
+
+// observeRequest records the observation in the Metrics and calls the Observe function, if any.
+func (cfg *config) observeRequest(obs Observation) {
+	cfg.recordMetrics(obs)
+	if cfg.observe != nil {
+		cfg.observe(obs)
+	}
+}
+
+// flights tracks the requests that are in flight on a connection, so the client can cancel them with a "cancel"
+// request and feed inputs to duplex requests with "send" and "close" requests.
+type flights struct {
+	control sync.Mutex
+	flights map[string]*flight
+}
+
+// A flight is a request in flight.
+type flight struct {
+	cancel context.CancelCauseFunc
+	inputs chan msgp.Raw // nil unless this is a duplex request whose inputs are open
+}
+
+// start returns a context for a request that is cancelled when the client cancels the request, or when its inputs
+// overflow, see feed.  Inputs fed to the request are sent to inputs, if it is not nil.
+func (fs *flights) start(ctx context.Context, id string, inputs chan msgp.Raw) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	fs.control.Lock()
+	defer fs.control.Unlock()
+	if fs.flights == nil {
+		fs.flights = make(map[string]*flight)
+	}
+	fs.flights[id] = &flight{cancel: cancel, inputs: inputs}
+	return ctx, func() { cancel(nil) }
+}
+
+// stop cancels the context of a request once it has been handled.
+func (fs *flights) stop(id string, cancel context.CancelFunc) {
+	cancel()
+	fs.control.Lock()
+	defer fs.control.Unlock()
+	delete(fs.flights, id)
+}
+
+// cancel cancels the context of a request in flight, if any.
+func (fs *flights) cancel(id string) {
+	if f := fs.lookup(id); f != nil {
+		f.cancel(nil)
+	}
+}
+
+// feed sends an input to a duplex request in flight.  If its buffer is full, the request is cancelled with
+// errInputsOverflowed instead of waiting for room, since waiting would stop the connection from being read, including
+// the "cancel" request the client might send for it.  Inputs for other requests are ignored.  This must only be called
+// by the goroutine reading the connection.
+func (fs *flights) feed(id string, input msgp.Raw) {
+	f := fs.lookup(id)
+	if f == nil || f.inputs == nil {
+		return
+	}
+	select {
+	case f.inputs <- input:
+	default:
+		f.cancel(errInputsOverflowed)
+	}
+}
+
+// errInputsOverflowed is the cause of the cancellation of a duplex request whose client sent more inputs than
+// DuplexBuffer before the function received them, which Recv returns.  It is retryable, since the client may succeed
+// by sending its inputs more slowly.
+var errInputsOverflowed = &rpcerr.Error{
+	Code:      http.StatusServiceUnavailable,
+	Message:   `too many inputs sent before the function received them`,
+	Retryable: true,
+}
+
+// closeInputs closes the inputs of a duplex request in flight, if any.  Like feed, this must only be called by the
+// goroutine reading the connection.
+func (fs *flights) closeInputs(id string) {
+	fs.control.Lock()
+	defer fs.control.Unlock()
+	if f := fs.flights[id]; f != nil && f.inputs != nil {
+		close(f.inputs)
+		f.inputs = nil
+	}
+}
+
+// lookup returns the request in flight with the given ID, or nil.
+func (fs *flights) lookup(id string) *flight {
+	fs.control.Lock()
+	defer fs.control.Unlock()
+	return fs.flights[id]
+}
+
+// close closes the connection with a close handshake, giving up on the handshake after the CloseTimeout.  The status
+// is StatusGoingAway if the request has been cancelled, such as when the server is shutting down.
+func (cfg *config) close(ctx context.Context, c *websocket.Conn) {
+	if cfg.closeTimeout <= 0 {
+		_ = c.CloseNow()
+		return
+	}
+	status := websocket.StatusNormalClosure
+	if ctx.Err() != nil {
+		status = websocket.StatusGoingAway
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		_ = c.Close(status, ``) // a no-op if the client closed the connection first.
+	}()
+	timer := time.NewTimer(cfg.closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-closed:
+	case <-timer.C:
+		hog.From(ctx).Debug().Dur(`timeout`, cfg.closeTimeout).Msg(`client did not acknowledge closing the connection`)
+	}
+}
+
+// keepAlive pings the connection at the given interval until the context is done, closing the connection if a pong
+// does not arrive within the interval.
+func keepAlive(ctx context.Context, c *websocket.Conn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := c.Ping(pingCtx)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			_ = c.Close(websocket.StatusGoingAway, `ping timeout`)
+			return
+		}
+	}
+}
+
+// acquireSlot claims one of the slots limiting concurrent requests, returning false if none are free.  A nil set of
+// slots imposes no limit.
+func acquireSlot(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseSlot releases a slot claimed by acquireSlot.
+func releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
 	}
 }
 
@@ -169,11 +723,16 @@ func (cfg *config) handleRequest(ctx *Scope) {
 		table = cfg.callHandlers
 	case "start":
 		table = cfg.startHandlers
+	case "duplex":
+		table = cfg.duplexHandlers
 	default:
 		ctx.Fail(404, `method not found`)
 		return
 	}
 	handler := table[ctx.Function]
+	if handler == nil && cfg.notFound != nil {
+		handler = cfg.notFound
+	}
 	if handler == nil {
 		ctx.Fail(404, fmt.Sprintf(`function %q not found`, ctx.Function))
 		return
@@ -199,7 +758,7 @@ func CallFn[I any, PI interface {
 			}
 			out, err := fn(ctx, *in)
 			if err != nil {
-				_ = ctx.Fail(500, err.Error())
+				_ = ctx.FailWith(err)
 				return
 			}
 			_ = ctx.Succ(PO(&out))
@@ -210,6 +769,9 @@ func CallFn[I any, PI interface {
 // A StartFn is a function that handles a "start" request.  This function must call ctx.Yield for each
 // response and should not call ctx.Succ, ctx.Fail, ctx.End or ctx.Respond directly.  The framework
 // will call either ctx.End or ctx.Fail when the function returns.
+//
+// The scope is cancelled when the client cancels the request or disconnects, so functions that yield in a loop should
+// stop when ctx.Done() is closed.
 func StartFn[I any, PI interface {
 	*I
 	msgp.Unmarshaler
@@ -227,7 +789,7 @@ func StartFn[I any, PI interface {
 			}
 			err = fn(ctx)
 			if err != nil {
-				_ = ctx.Fail(500, err.Error())
+				_ = ctx.FailWith(err)
 			} else {
 				_ = ctx.End()
 			}
@@ -0,0 +1,881 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig"
+	"github.com/swdunlop/rig-go/rig/api"
+	"github.com/swdunlop/rig-go/rig/mrpc/internal/protocol"
+	"github.com/swdunlop/rig-go/rig/rpcerr"
+	"github.com/swdunlop/rig-go/rig/tracing"
+	"github.com/tinylib/msgp/msgp"
+	"nhooyr.io/websocket"
+)
+
+func TestConcurrentResponses(t *testing.T) {
+	srv := httptest.NewServer(Handle(
+		CallFn[msgp.Raw, *msgp.Raw, msgp.Raw, *msgp.Raw](`echo`, func(ctx *Scope, in msgp.Raw) (msgp.Raw, error) {
+			return in, nil
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 500; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			in := msgp.Raw(msgp.AppendInt(nil, i))
+			out, err := Call[msgp.Raw](ctx, cl, `echo`, in)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n, _, err := msgp.ReadIntBytes(out)
+			if err != nil || n != i {
+				t.Errorf(`expected %v, got %v (%v)`, i, n, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	for _, test := range []struct {
+		options []Option
+		found   bool
+	}{
+		{nil, false},
+		{[]Option{NotFoundHandler(func(ctx *Scope) {
+			_ = ctx.Succ(msgp.Raw(msgp.AppendString(nil, `caught `+ctx.Function)))
+		})}, true},
+	} {
+		srv := httptest.NewServer(Handle(test.options...))
+		ctx := context.Background()
+		cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := Call[msgp.Raw](ctx, cl, `missing`, msgp.Raw(msgp.AppendNil(nil)))
+		cl.Close()
+		srv.Close()
+		if !test.found {
+			if err == nil || !strings.Contains(err.Error(), `not found`) {
+				t.Fatalf(`expected the call to fail with "not found", got %v`, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if str, _, _ := msgp.ReadStringBytes(out); str != `caught missing` {
+			t.Fatalf(`expected the not found handler to answer, got %q`, str)
+		}
+	}
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		pool *pool
+	}{
+		{`goroutines`, nil},
+		{`workers`, newPool(64)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var group sync.WaitGroup
+			for i := 0; i < b.N; i++ {
+				group.Add(1)
+				bench.pool.run(group.Done)
+			}
+			group.Wait()
+		})
+	}
+}
+
+func TestRejectPlainRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handle().ServeHTTP(w, httptest.NewRequest(`GET`, `/`, nil))
+	if w.Code != http.StatusUpgradeRequired || w.Header().Get(`Upgrade`) != `websocket` {
+		t.Fatalf(`expected 426 Upgrade Required, got %v %v`, w.Code, w.Header())
+	}
+}
+
+func TestChunkYields(t *testing.T) {
+	big := strings.Repeat(`0123456789`, 100)
+	srv := httptest.NewServer(Handle(
+		ChunkYields(64),
+		StartFn[msgp.Raw, *msgp.Raw, msgp.Raw, *msgp.Raw](`repeat`, func(ctx *Scope) error {
+			for _, str := range []string{`small`, big, `small`} {
+				err := ctx.Yield(msgp.Raw(msgp.AppendString(nil, str)))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	st, err := Start[msgp.Raw](ctx, cl, `repeat`, msgp.Raw(msgp.AppendInt(nil, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for out := range st.Yields() {
+		str, _, err := msgp.ReadStringBytes(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, str)
+	}
+	if st.Err() != nil {
+		t.Fatal(st.Err())
+	}
+	if len(got) != 3 || got[0] != `small` || got[1] != big || got[2] != `small` {
+		t.Fatalf(`expected the large output to be reassembled between the small ones, got %q`, got)
+	}
+}
+
+func TestPingInterval(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	srv := httptest.NewServer(Handle(
+		PingInterval(interval),
+		CallFn[msgp.Raw, *msgp.Raw, msgp.Raw, *msgp.Raw](`echo`, func(ctx *Scope, in msgp.Raw) (msgp.Raw, error) {
+			return in, nil
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	url := `ws` + strings.TrimPrefix(srv.URL, `http`)
+	cl, err := Dial(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	in := msgp.Raw(msgp.AppendInt(nil, 7))
+	time.Sleep(5 * interval) // the client answers pings while it waits for responses.
+	_, err = Call[msgp.Raw](ctx, cl, `echo`, in)
+	if err != nil {
+		t.Fatalf(`expected a live connection to survive the pings, got %v`, err)
+	}
+
+	// A peer that stops reading never answers the pings, like one that vanished without closing the connection.
+	c, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseNow()
+	time.Sleep(5 * interval)
+	_, _, err = c.Read(ctx)
+	if websocket.CloseStatus(err) != websocket.StatusGoingAway {
+		t.Fatalf(`expected the service to close the connection when the pong did not arrive, got %v`, err)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	for _, test := range []struct {
+		timeout time.Duration
+		status  websocket.StatusCode
+	}{
+		{time.Second, websocket.StatusNormalClosure},
+		{0, -1}, // closed without a close frame.
+	} {
+		srv := httptest.NewServer(Handle(CloseTimeout(test.timeout)))
+		ctx := context.Background()
+		c, _, err := websocket.Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Write(ctx, websocket.MessageBinary, []byte(`not a request`)) // which makes the service close.
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = c.Read(ctx)
+		c.CloseNow()
+		srv.Close()
+		if websocket.CloseStatus(err) != test.status {
+			t.Fatalf(`expected close status %v with a timeout of %v, got %v`, test.status, test.timeout, err)
+		}
+	}
+}
+
+func TestDuplex(t *testing.T) {
+	srv := httptest.NewServer(Handle(
+		DuplexFn[msgp.Raw, *msgp.Raw, msgp.Raw, *msgp.Raw](`sum`, func(ctx *Scope, recv func() (msgp.Raw, error)) error {
+			total := 0
+			for {
+				in, err := recv()
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				n, _, err := msgp.ReadIntBytes(in)
+				if err != nil {
+					return err
+				}
+				total += n
+				err = ctx.Yield(msgp.Raw(msgp.AppendInt(nil, total)))
+				if err != nil {
+					return err
+				}
+			}
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	dx, err := Open[msgp.Raw](ctx, cl, `sum`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		err = dx.Send(ctx, msgp.Raw(msgp.AppendInt(nil, i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := <-dx.Yields()
+		if n, _, _ := msgp.ReadIntBytes(out); n != i*(i+1)/2 {
+			t.Fatalf(`expected a running total of %v, got %v`, i*(i+1)/2, n)
+		}
+	}
+	err = dx.CloseSend(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range dx.Yields() {
+		t.Fatal(`expected no more outputs after closing the inputs`)
+	}
+	if dx.Err() != nil {
+		t.Fatal(dx.Err())
+	}
+}
+
+func TestDuplexOverflow(t *testing.T) {
+	type raw = msgp.Raw
+	release := make(chan struct{})
+	srv := httptest.NewServer(Handle(
+		DuplexFn[raw, *raw, raw, *raw](`slow`, func(ctx *Scope, recv func() (raw, error)) error {
+			<-release
+			for {
+				_, err := recv()
+				if err != nil {
+					return err
+				}
+			}
+		}),
+		CallFn[raw, *raw, raw, *raw](`echo`, func(ctx *Scope, in raw) (raw, error) { return in, nil }),
+	))
+	defer srv.Close()
+	defer close(release)
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	dx, err := Open[raw](ctx, cl, `slow`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= DuplexBuffer; i++ {
+		err = dx.Send(ctx, raw(msgp.AppendInt(nil, i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The connection is still read while the function is stuck, so other requests are handled.
+	callCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	out, err := Call[raw](callCtx, cl, `echo`, raw(msgp.AppendInt(nil, 7)))
+	if n, _, _ := msgp.ReadIntBytes(out); err != nil || n != 7 {
+		t.Fatalf(`expected other requests to be handled while the duplex request is stuck, got %v (%v)`, n, err)
+	}
+
+	release <- struct{}{}
+	for range dx.Yields() {
+	}
+	var failure *Error
+	if !errors.As(dx.Err(), &failure) || failure.Code != 503 || !failure.Retryable {
+		t.Fatalf(`expected the overflowing request to fail with a retryable 503, got %#v`, dx.Err())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	var metrics FunctionMetrics
+	srv := httptest.NewServer(Handle(
+		Metrics(&metrics),
+		CallFn[msgp.Raw, *msgp.Raw, msgp.Raw, *msgp.Raw](`echo`, func(ctx *Scope, in msgp.Raw) (msgp.Raw, error) {
+			return in, nil
+		}),
+		CallFn[msgp.Raw, *msgp.Raw, msgp.Raw, *msgp.Raw](`fail`, func(ctx *Scope, in msgp.Raw) (msgp.Raw, error) {
+			return nil, errors.New(`no luck`)
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	in := msgp.Raw(msgp.AppendInt(nil, 1))
+	for _, function := range []string{`echo`, `echo`, `fail`, `invented`} {
+		_, _ = Call[msgp.Raw](ctx, cl, function, in)
+	}
+	if n := metrics.Connections(); n != 1 {
+		t.Fatalf(`expected 1 open connection, got %v`, n)
+	}
+	functions := metrics.Functions()
+	for deadline := time.Now().Add(time.Second); functions[``].Requests == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond) // requests are observed after the response is sent.
+		functions = metrics.Functions()
+	}
+	for name, expect := range map[string][2]int64{`echo`: {2, 0}, `fail`: {1, 1}, ``: {1, 1}} {
+		st := functions[name]
+		if st.Requests != expect[0] || st.Failed != expect[1] {
+			t.Fatalf(`expected %v requests and %v failures for %q, got %+v`, expect[0], expect[1], name, st)
+		}
+		var total int64
+		for _, n := range st.Latency {
+			total += n
+		}
+		if total != st.Requests {
+			t.Fatalf(`expected %v requests in the latency histogram of %q, got %v`, st.Requests, name, total)
+		}
+	}
+	if len(functions) != 3 {
+		t.Fatalf(`expected stats for 3 functions, got %v`, functions)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	type raw = msgp.Raw
+	ticks := func(delays ...time.Duration) func(ctx *Scope) error {
+		return func(ctx *Scope) error {
+			for _, delay := range delays {
+				time.Sleep(delay)
+				err := ctx.Yield(raw(msgp.AppendInt(nil, 0)))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	srv := httptest.NewServer(Handle(
+		Timeout(50*time.Millisecond),
+		StreamTimeout(50*time.Millisecond, true),
+		CallFn[raw, *raw, raw, *raw](`stuck`, func(ctx *Scope, in raw) (raw, error) {
+			<-release // ignores the scope
+			return in, nil
+		}),
+		CallFn[raw, *raw, raw, *raw](`deadline`, func(ctx *Scope, in raw) (raw, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				return nil, errors.New(`expected the scope to have a deadline`)
+			}
+			return in, nil
+		}),
+		StartFn[raw, *raw, raw, *raw](`steady`, ticks(20*time.Millisecond, 20*time.Millisecond, 20*time.Millisecond)),
+		StartFn[raw, *raw, raw, *raw](`stalled`, ticks(10*time.Millisecond, 200*time.Millisecond)),
+	))
+	defer srv.Close()
+	defer close(release)
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	in := raw(msgp.AppendInt(nil, 1))
+
+	started := time.Now()
+	_, err = Call[raw](ctx, cl, `stuck`, in)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != 504 {
+		t.Fatalf(`expected the stuck call to time out with 504, got %v`, err)
+	}
+	if took := time.Since(started); took > time.Second {
+		t.Fatalf(`expected the stuck call to fail at its deadline, took %v`, took)
+	}
+	_, err = Call[raw](ctx, cl, `deadline`, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for function, expect := range map[string]int{`steady`: 0, `stalled`: 504} {
+		st, err := Start[raw](ctx, cl, function, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range st.Yields() {
+		}
+		code := 0
+		if errors.As(st.Err(), &rpcErr) {
+			code = rpcErr.Code
+		} else if st.Err() != nil {
+			t.Fatal(st.Err())
+		}
+		if code != expect {
+			t.Errorf(`expected %v to fail with %v, got %v`, function, expect, st.Err())
+		}
+	}
+}
+
+func TestOnConnect(t *testing.T) {
+	type userKey struct{}
+	closed := make(chan string, 1)
+	srv := httptest.NewServer(Handle(
+		OnConnect(func(ctx context.Context, r *http.Request) (context.Context, error) {
+			user := r.URL.Query().Get(`user`)
+			if user == `` {
+				return nil, &rpcerr.Error{Code: http.StatusTooManyRequests, Message: `slow down`}
+			}
+			return context.WithValue(ctx, userKey{}, user), nil
+		}),
+		OnClose(func(ctx context.Context) { closed <- ctx.Value(userKey{}).(string) }),
+		CallFn[msgp.Raw, *msgp.Raw, msgp.Raw, *msgp.Raw](`whoami`, func(ctx *Scope, in msgp.Raw) (msgp.Raw, error) {
+			return msgp.AppendString(nil, ctx.Value(userKey{}).(string)), nil
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	url := `ws` + strings.TrimPrefix(srv.URL, `http`)
+	_, err := Dial(ctx, url)
+	if err == nil || !strings.Contains(err.Error(), `429`) {
+		t.Fatalf(`expected the connection to be rejected with 429, got %v`, err)
+	}
+	cl, err := Dial(ctx, url+`?user=alice`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Call[msgp.Raw](ctx, cl, `whoami`, msgp.Raw(msgp.AppendInt(nil, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, _, _ := msgp.ReadStringBytes(out); user != `alice` {
+		t.Fatalf(`expected the scope to carry the user from OnConnect, got %q`, user)
+	}
+	cl.Close()
+	select {
+	case user := <-closed:
+		if user != `alice` {
+			t.Fatalf(`expected OnClose to see the context from OnConnect, got %q`, user)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`expected OnClose to be called once the connection closed`)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	spans := make(testTracer, 2)
+	srv := httptest.NewServer(Handle(
+		Trace(spans),
+		CallFn[msgp.Raw, *msgp.Raw, msgp.Raw, *msgp.Raw](`echo`, func(ctx *Scope, in msgp.Raw) (msgp.Raw, error) {
+			return in, nil
+		}),
+		CallFn[msgp.Raw, *msgp.Raw, msgp.Raw, *msgp.Raw](`fail`, func(ctx *Scope, in msgp.Raw) (msgp.Raw, error) {
+			return nil, &rpcerr.Error{Code: http.StatusTeapot, Message: `short and stout`}
+		}),
+	))
+	defer srv.Close()
+	parent, err := tracing.ParseParent(`00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := tracing.WithParent(context.Background(), parent)
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	input := msgp.Raw(msgp.AppendInt(nil, 1))
+	_, err = Call[msgp.Raw](ctx, cl, `echo`, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Call[msgp.Raw](ctx, cl, `fail`, input)
+	if err == nil {
+		t.Fatal(`expected fail to fail`)
+	}
+	ended := spans.await(t, 2)
+	for _, expect := range []testSpan{{name: `echo`, parent: parent}, {name: `fail`, parent: parent, code: 418}} {
+		if span := ended[expect.name]; span != expect {
+			t.Fatalf(`expected span %+v, got %+v`, expect, span)
+		}
+	}
+}
+
+// A testTracer sends each span to the channel once it ends.
+type testTracer chan testSpan
+
+// await returns the next n spans to end by name, since spans may end after their responses are sent.
+func (tr testTracer) await(t *testing.T, n int) map[string]testSpan {
+	ended := make(map[string]testSpan, n)
+	for range n {
+		select {
+		case span := <-tr:
+			ended[span.name] = span
+		case <-time.After(5 * time.Second):
+			t.Fatalf(`expected %v spans to end, got %v`, n, len(ended))
+		}
+	}
+	return ended
+}
+
+func (tr testTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	parent, _ := tracing.ParentOf(ctx)
+	return ctx, &testSpan{tracer: tr, name: name, parent: parent}
+}
+
+type testSpan struct {
+	tracer testTracer
+	name   string
+	parent tracing.Parent
+	code   int
+}
+
+func (span *testSpan) Fail(code int, msg string) { span.code = code }
+
+func (span *testSpan) End() {
+	cp := *span
+	cp.tracer = nil
+	span.tracer <- cp
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	type raw = msgp.Raw
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(Handle(
+		MaxConcurrent(2),
+		CallFn[raw, *raw, raw, *raw](`wait`, func(ctx *Scope, in raw) (raw, error) {
+			started <- struct{}{}
+			<-release
+			return in, nil
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	in := raw(msgp.AppendInt(nil, 7))
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := Call[raw](ctx, cl, `wait`, in)
+			errs <- err
+		}()
+		<-started
+	}
+	_, err = Call[raw](ctx, cl, `wait`, in)
+	var failure *Error
+	if !errors.As(err, &failure) || failure.Code != 429 || !failure.Retryable {
+		t.Fatalf(`expected the third request to fail with a retryable 429, got %#v`, err)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf(`expected the requests within the limit to complete, got %v`, err)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	type raw = msgp.Raw
+	started, cancelled := make(chan struct{}), make(chan error, 1)
+	srv := httptest.NewServer(Handle(
+		MaxConcurrent(1),
+		CallFn[raw, *raw, raw, *raw](`wait`, func(ctx *Scope, in raw) (raw, error) {
+			close(started)
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+			return nil, ctx.Err()
+		}),
+		CallFn[raw, *raw, raw, *raw](`echo`, func(ctx *Scope, in raw) (raw, error) { return in, nil }),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	in := raw(msgp.AppendInt(nil, 7))
+	callCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err = Call[raw](callCtx, cl, `wait`, in)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf(`expected the call to be cancelled, got %v`, err)
+	}
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf(`expected the handler to see the cancellation, got %v`, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`the context of the handler was not cancelled`)
+	}
+
+	// The slot is released once the handler returns, which may be just after the next request arrives.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		_, err = Call[raw](ctx, cl, `echo`, in)
+		var failure *Error
+		if !errors.As(err, &failure) || failure.Code != 429 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf(`expected the cancelled request to free its slot, got %v`, err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	type raw = msgp.Raw
+	started, release := make(chan struct{}), make(chan struct{})
+	observed := make(chan Observation, 10)
+	srv := httptest.NewServer(Handle(
+		Budget(2, nil),
+		Observe(func(obs Observation) { observed <- obs }),
+		CallFn[raw, *raw, raw, *raw](`wait`, func(ctx *Scope, in raw) (raw, error) {
+			started <- struct{}{}
+			<-release
+			return in, nil
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	in := raw(msgp.AppendInt(nil, 7))
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := Call[raw](ctx, cl, `wait`, in)
+			errs <- err
+		}()
+		<-started
+	}
+	_, err = Call[raw](ctx, cl, `wait`, in)
+	var failure *Error
+	if !errors.As(err, &failure) || failure.Code != 503 || !failure.Retryable {
+		t.Fatalf(`expected the request over the budget to be shed with a retryable 503, got %#v`, err)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf(`expected the requests within the budget to complete, got %v`, err)
+		}
+	}
+
+	shed := 0
+	for i := 0; i < 3; i++ {
+		obs := <-observed
+		if obs.Function != `wait` || obs.Shed != obs.Failed || (obs.Shed && obs.Handle != 0) {
+			t.Fatalf(`unexpected observation %+v`, obs)
+		}
+		if obs.Shed {
+			shed++
+		}
+	}
+	if shed != 1 {
+		t.Fatalf(`expected one request to be shed, got %v`, shed)
+	}
+	select {
+	case obs := <-observed:
+		t.Fatalf(`expected one observation per request, got another %+v`, obs)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	type raw = msgp.Raw
+	srv := httptest.NewServer(Handle(
+		CallFn[raw, *raw, raw, *raw](`busy`, func(ctx *Scope, in raw) (raw, error) {
+			return nil, rpcerr.Unavailable(`try again later`)
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	url := `ws` + strings.TrimPrefix(srv.URL, `http`)
+	cl, err := Dial(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	_, err = Call[raw](ctx, cl, `busy`, raw(msgp.AppendInt(nil, 7)))
+	var failure *Error
+	if !errors.As(err, &failure) || failure.Code != 503 || !failure.Retryable {
+		t.Fatalf(`expected a retryable failure with code 503, got %#v`, err)
+	}
+
+	// A client that predates Retryable does not ask for it, and decodes a failure as exactly two elements.
+	c, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseNow()
+	req := protocol.Request{ID: `1`, Method: `call`, Function: `busy`, Input: raw(msgp.AppendInt(nil, 7))}
+	msg, err := req.MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Write(ctx, websocket.MessageBinary, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err = c.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rsp protocol.Response
+	_, err = rsp.UnmarshalMsg(msg)
+	if err != nil || rsp.Method != `fail` {
+		t.Fatalf(`expected a failure, got %v (%v)`, rsp.Method, err)
+	}
+	output := rsp.Output.(msgp.Raw)
+	sz, output, err := msgp.ReadArrayHeaderBytes(output)
+	if err != nil || sz != 2 {
+		t.Fatalf(`expected a failure with two elements, got %v (%v)`, sz, err)
+	}
+	code, output, err := msgp.ReadIntBytes(output)
+	if err != nil || code != 503 {
+		t.Fatalf(`expected code 503, got %v (%v)`, code, err)
+	}
+	text, _, err := msgp.ReadStringBytes(output)
+	if err != nil || text != `try again later` {
+		t.Fatalf(`expected the message, got %q (%v)`, text, err)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	type raw = msgp.Raw
+	srv := httptest.NewServer(Handle(
+		Alias(`users.get`, `getUser`),
+		CallFn[raw, *raw, raw, *raw](`users.get`, func(ctx *Scope, in raw) (raw, error) { return in, nil }),
+		StartFn[raw, *raw, raw, *raw](`users.get`, func(ctx *Scope) error {
+			return ctx.Yield(raw(msgp.AppendString(nil, `streamed`)))
+		}),
+	))
+	defer srv.Close()
+	ctx := context.Background()
+	cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	in := raw(msgp.AppendInt(nil, 7))
+	out, err := Call[raw](ctx, cl, `getUser`, in)
+	if err != nil || string(out) != string(in) {
+		t.Fatalf(`expected the alias to call users.get, got %v, %v`, out, err)
+	}
+	st, err := Start[raw](ctx, cl, `getUser`, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yields := 0
+	for range st.Yields() {
+		yields++
+	}
+	if st.Err() != nil || yields != 1 {
+		t.Fatalf(`expected the alias to start users.get, got %v yields and %v`, yields, st.Err())
+	}
+
+	_, err = New(Alias(`users.missing`, `getMissing`))
+	if err == nil || !strings.Contains(err.Error(), `users.missing`) {
+		t.Fatalf(`expected an alias of a missing function to fail, got %v`, err)
+	}
+	echo := CallFn[raw, *raw, raw, *raw]
+	handle := func(ctx *Scope, in raw) (raw, error) { return in, nil }
+	_, err = rig.New(api.Rig(API(`/rpc`, echo(`a`, handle), echo(`b`, handle), Alias(`a`, `b`))))
+	if err == nil || !strings.Contains(err.Error(), `already the name`) {
+		t.Fatalf(`expected the rig to fail with an alias that shadows a function, got %v`, err)
+	}
+	w := httptest.NewRecorder()
+	Handle(Alias(`users.missing`, `getMissing`)).ServeHTTP(w, httptest.NewRequest(`GET`, `/`, nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf(`expected a misconfigured service to fail requests with 500, got %v`, w.Code)
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	type raw = msgp.Raw
+	echo := CallFn[raw, *raw, raw, *raw](`users.get`, func(ctx *Scope, in raw) (raw, error) { return in, nil })
+	for _, notices := range []bool{false, true} {
+		options := []Option{echo, Alias(`users.get`, `getUser`), Deprecated(`getUser`, `use users.get instead`)}
+		if notices {
+			options = append(options, DeprecationNotices())
+		}
+		srv := httptest.NewServer(Handle(options...))
+		ctx := context.Background()
+		cl, err := Dial(ctx, `ws`+strings.TrimPrefix(srv.URL, `http`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		cl.OnDeprecated(func(function, notice string) { got = append(got, function+`: `+notice) })
+		in := raw(msgp.AppendInt(nil, 7))
+		for _, function := range []string{`getUser`, `users.get`, `getUser`} {
+			out, err := Call[raw](ctx, cl, function, in)
+			if err != nil || string(out) != string(in) {
+				t.Fatalf(`expected %v to be handled, got %v, %v`, function, out, err)
+			}
+		}
+		cl.Close()
+		srv.Close()
+		want := 0
+		if notices {
+			want = 2
+		}
+		if len(got) != want {
+			t.Fatalf(`expected %v notices with notices %v, got %q`, want, notices, got)
+		}
+		if want > 0 && got[0] != `getUser: use users.get instead` {
+			t.Fatalf(`unexpected notice %q`, got[0])
+		}
+	}
+
+	_, err := New(Deprecated(`users.missing`, `gone`))
+	if err == nil || !strings.Contains(err.Error(), `users.missing`) {
+		t.Fatalf(`expected deprecating a missing function to fail, got %v`, err)
+	}
+	_, err = rig.New(api.Rig(API(`/rpc`, Deprecated(`users.missing`, `gone`))))
+	if err == nil {
+		t.Fatal(`expected the rig to fail when a missing function is deprecated`)
+	}
+}
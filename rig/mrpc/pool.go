@@ -0,0 +1,41 @@
+package mrpc
+
+// A pool runs the requests on a connection using goroutines that are reused for later requests, instead of starting
+// a goroutine for each request.  A request never waits for a goroutine to be free: if none are idle, a new one is
+// started, and goroutines beyond the size of the pool exit once they are done instead of waiting for more requests.
+// A nil pool starts a goroutine for each request.
+type pool struct {
+	jobs chan func()   // receives a job for each idle goroutine
+	idle chan struct{} // holds a token for each idle goroutine, limiting them to the size of the pool
+}
+
+func newPool(size int) *pool {
+	return &pool{jobs: make(chan func()), idle: make(chan struct{}, size)}
+}
+
+// run runs job using an idle goroutine, or a new goroutine if none are idle.
+func (p *pool) run(job func()) {
+	if p == nil {
+		go job()
+		return
+	}
+	select {
+	case p.jobs <- job:
+	default:
+		go p.work(job)
+	}
+}
+
+// work runs job, then waits for more jobs as long as there is room for another idle goroutine in the pool.
+func (p *pool) work(job func()) {
+	for {
+		job()
+		select {
+		case p.idle <- struct{}{}:
+		default:
+			return
+		}
+		job = <-p.jobs
+		<-p.idle
+	}
+}
@@ -0,0 +1,54 @@
+package mrpc
+
+import (
+	"context"
+	"net"
+)
+
+// A sendQueue serializes the messages sent on a connection, so the responses of requests handled concurrently are
+// written one at a time, in the order they were sent, by a single goroutine.
+type sendQueue struct {
+	frames  chan frame
+	stop    chan struct{} // closed to stop the queue
+	stopped chan struct{} // closed once the queue has stopped
+}
+
+// A frame is a message waiting to be written, along with a channel that receives the result of writing it.
+type frame struct {
+	bin    []byte
+	result chan error
+}
+
+// newSendQueue starts a queue that writes messages using write until it is stopped.
+func newSendQueue(ctx context.Context, write func(context.Context, []byte) error) *sendQueue {
+	q := &sendQueue{frames: make(chan frame), stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(q.stopped)
+		for {
+			select {
+			case <-q.stop:
+				return
+			case f := <-q.frames:
+				f.result <- write(ctx, f.bin)
+			}
+		}
+	}()
+	return q
+}
+
+// send queues a message and waits for it to be written, returning the result of writing it.
+func (q *sendQueue) send(bin []byte) error {
+	f := frame{bin: bin, result: make(chan error, 1)}
+	select {
+	case q.frames <- f:
+		return <-f.result
+	case <-q.stopped:
+		return net.ErrClosed
+	}
+}
+
+// close stops the queue once any message being written has been written.
+func (q *sendQueue) close() {
+	close(q.stop)
+	<-q.stopped
+}
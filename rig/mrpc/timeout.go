@@ -0,0 +1,124 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig/mrpc/internal/protocol"
+)
+
+// Timeout limits how long each request may be handled by giving its scope a deadline, so handlers that pass the scope
+// to other calls, such as database queries, stop waiting once it passes.  If the handler has not finished by then, the
+// service fails the request with code 504 right away, even if the handler ignores its scope, and discards anything the
+// handler responds after that.  Unless StreamTimeout is given, this also limits the whole of each stream started by a
+// StartFn or DuplexFn.  The default of zero imposes no limit.
+func Timeout(d time.Duration) Option {
+	return func(cfg *config) { cfg.timeout = d }
+}
+
+// StreamTimeout limits streams started by a StartFn or DuplexFn separately from Timeout, which then only limits calls.
+// If perYield is false, d limits the whole stream, like Timeout; otherwise d limits how long the stream may go without
+// yielding an output, so a stream may run for as long as it keeps making progress.  Since that deadline moves, the
+// scope of a stream limited per yield has no deadline, it is cancelled when the stream fails.  A d of zero imposes no
+// limit on streams.
+func StreamTimeout(d time.Duration, perYield bool) Option {
+	return func(cfg *config) { cfg.streamTimeout, cfg.perYield = d, perYield }
+}
+
+// errTimedOut is returned to handlers that respond after their request has timed out.
+var errTimedOut = errors.New(`request timed out`)
+
+// limit returns the timeout of a request and whether it is reset by each yield, see Timeout and StreamTimeout.
+func (cfg *config) limit(method string) (time.Duration, bool) {
+	if method == `call` || cfg.streamTimeout < 0 {
+		return cfg.timeout, false
+	}
+	return cfg.streamTimeout, cfg.perYield
+}
+
+// A deadline fails a request that is not handled in time, see Timeout.
+type deadline struct {
+	control  sync.Mutex
+	id       string
+	send     func(bin []byte) error
+	cancel   context.CancelFunc
+	stop     func() bool   // stops watching the context or timer
+	timer    *time.Timer   // nil unless the deadline is reset by each yield
+	perYield time.Duration // zero unless the deadline is reset by each yield
+	ended    bool          // true once the handler has sent a final response or returned
+	expired  bool          // true once the request has been failed for taking too long
+}
+
+// deadline returns the context for handling a request with its deadline, or the context as is and nil if the request
+// has no time limit.
+func (cfg *config) deadline(ctx context.Context, req protocol.Request, send func([]byte) error) (context.Context, *deadline) {
+	d, perYield := cfg.limit(req.Method)
+	if d <= 0 {
+		return ctx, nil
+	}
+	dl := &deadline{id: req.ID, send: send}
+	if perYield {
+		ctx, dl.cancel = context.WithCancel(ctx)
+		dl.perYield = d
+		dl.timer = time.AfterFunc(d, dl.expire)
+		dl.stop = dl.timer.Stop
+		return ctx, dl
+	}
+	ctx, dl.cancel = context.WithTimeout(ctx, d)
+	dl.stop = context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			dl.expire()
+		}
+	})
+	return ctx, dl
+}
+
+// respond sends a response unless the request has expired, resetting a deadline that is reset by each yield.
+func (dl *deadline) respond(method string, msg []byte) error {
+	dl.control.Lock()
+	defer dl.control.Unlock()
+	if dl.expired {
+		return errTimedOut
+	}
+	switch method {
+	case `succ`, `fail`, `end`:
+		dl.ended = true
+	default:
+		if dl.timer != nil {
+			dl.timer.Reset(dl.perYield)
+		}
+	}
+	return dl.send(msg)
+}
+
+// expire fails the request with code 504 and cancels its context, unless it has already ended.
+func (dl *deadline) expire() {
+	dl.control.Lock()
+	defer dl.control.Unlock()
+	if dl.ended || dl.expired {
+		return
+	}
+	dl.expired = true
+	dl.cancel()
+	ret := protocol.Response{ID: dl.id, Method: `fail`, Output: protocol.Fail{Code: 504, Msg: errTimedOut.Error()}}
+	msg, err := ret.MarshalMsg(nil)
+	if err == nil {
+		_ = dl.send(msg)
+	}
+}
+
+// finish stops the deadline once the handler has returned, returning true if the request expired.  A nil deadline
+// never expires.
+func (dl *deadline) finish() bool {
+	if dl == nil {
+		return false
+	}
+	dl.stop()
+	dl.control.Lock()
+	defer dl.control.Unlock()
+	dl.ended = true
+	dl.cancel()
+	return dl.expired
+}
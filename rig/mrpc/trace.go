@@ -0,0 +1,39 @@
+package mrpc
+
+import (
+	"context"
+
+	"github.com/swdunlop/rig-go/rig/tracing"
+)
+
+// Trace starts a span with the tracer for each request handled by the service, named by the function requested, and
+// records the code and message of the failure if the request fails, including when it times out.  The spans of a
+// connection share the parent extracted from the "traceparent" header or query parameter of the request that opened
+// it, see tracing.Extract, which OnConnect functions can find with tracing.ParentOf.  Requests refused by
+// MaxConcurrent or shed by the Budget are never handled, so they are not traced; use Observe or Metrics to count them.
+// The default is to trace nothing.
+func Trace(tracer tracing.Tracer) Option {
+	return func(cfg *config) { cfg.tracer = tracer }
+}
+
+// startSpan starts the span of a request, returning a nil span if the service is not traced.
+func (cfg *config) startSpan(ctx context.Context, function string) (context.Context, tracing.Span) {
+	if cfg.tracer == nil {
+		return ctx, nil
+	}
+	return cfg.tracer.Start(ctx, function)
+}
+
+// endSpan ends the span of a request once it has been handled, recording its failure, if any.  A nil span is ignored.
+func endSpan(span tracing.Span, scope *Scope, expired bool) {
+	if span == nil {
+		return
+	}
+	switch {
+	case expired:
+		span.Fail(504, errTimedOut.Error()) // what the client saw, whatever the handler sent afterward.
+	case scope.failure != nil:
+		span.Fail(scope.failure.Code, scope.failure.Msg)
+	}
+	span.End()
+}
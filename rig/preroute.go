@@ -0,0 +1,39 @@
+package rig
+
+import "net/http"
+
+// PreRoute returns an option that calls fn with each request before the mux of the rig chooses its route, so fn can
+// rewrite the request to change which route matches, such as by removing a tenant prefix from the path or a trailing
+// slash that no pattern expects.  Middleware given to api.Use cannot do this, since it only runs once its route has
+// been chosen.  fn changes the request in place; it is given a clone, so the server's request is left as it was.
+// Functions given to multiple PreRoute options are called in order.
+//
+// The functions run in the handler returned by Handler, which is the worker's handler when the rig is Run, after the
+// server hooks of the supervisor or of a rig that is served directly, such as ConnectionPolicy, and after the prefix
+// given to BasePath has been removed from the path, but before the route is chosen and before any middleware of the
+// route runs.  The endpoints the supervisor serves itself, like "/_rig/logs", are not affected.
+func PreRoute(fn func(r *http.Request)) Option {
+	return func(cfg *Config) error {
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.preRoute = append(cfg.preRoute, fn)
+		return nil
+	}
+}
+
+// preRouted returns a handler that calls the PreRoute functions before serving requests with mux.
+func (cfg *Config) preRouted(mux http.Handler) http.Handler {
+	cfg.control.Lock()
+	fns := cfg.preRoute
+	cfg.control.Unlock()
+	if len(fns) == 0 {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		for _, fn := range fns {
+			fn(r)
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,46 @@
+package rig
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPreRoute(t *testing.T) {
+	cfg, err := New(
+		BasePath(`/app`),
+		PreRoute(func(r *http.Request) {
+			if r.URL.Path != `/` {
+				r.URL.Path = strings.TrimSuffix(r.URL.Path, `/`)
+			}
+		}),
+		PreRoute(func(r *http.Request) {
+			if tenant, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, `/t/`), `/`); ok && tenant != `` {
+				r.Header.Set(`X-Tenant`, tenant)
+				r.URL.Path = `/` + rest
+			}
+		}),
+		func(cfg *Config) error {
+			cfg.Hook(testMux{`users`, `GET /users`})
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := cfg.Handler()
+	for _, path := range []string{`/app/users`, `/app/users/`, `/app/t/acme/users/`} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(`GET`, path, nil)
+		handler.ServeHTTP(w, r)
+		body, _ := io.ReadAll(w.Body)
+		if w.Code != http.StatusOK || string(body) != `users` {
+			t.Errorf(`expected %v to reach the users route, got %v %q`, path, w.Code, body)
+		}
+		if r.URL.Path != path || r.Header.Get(`X-Tenant`) != `` {
+			t.Errorf(`expected the request for %v to be left as it was, got %v`, path, r.URL.Path)
+		}
+	}
+}
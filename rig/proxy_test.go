@@ -0,0 +1,78 @@
+package rig
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkerProxyHost(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), `socket`)
+	lr, err := net.Listen(`unix`, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host+` `+r.Header.Get(`X-Forwarded-Host`))
+	})}
+	go worker.Serve(lr)
+	defer worker.Close()
+
+	for _, test := range []struct {
+		preserve bool
+		expect   string
+	}{
+		{true, `example.com example.com`},
+		{false, `rig example.com`},
+	} {
+		cfg, err := New(PreserveHost(test.preserve))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		cfg.workerProxy(addr).ServeHTTP(w, httptest.NewRequest(`GET`, `http://example.com/`, nil))
+		if w.Body.String() != test.expect {
+			t.Errorf(`expected %q with PreserveHost(%v), got %q`, test.expect, test.preserve, w.Body)
+		}
+	}
+}
+
+func TestWorkerProxyForwarded(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), `socket`)
+	lr, err := net.Listen(`unix`, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get(`X-Forwarded-For`)+` | `+r.Header.Get(`Forwarded`))
+	})}
+	go worker.Serve(lr)
+	defer worker.Close()
+
+	cfg, err := New(TrustForwarded(netip.MustParsePrefix(`10.0.0.0/8`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		peer   string
+		expect string
+	}{
+		{`192.0.2.1:1234`, `192.0.2.1 | for=192.0.2.1;proto=http;host=example.com`},
+		{`10.0.0.2:1234`, `198.51.100.7, 10.0.0.2 | for=198.51.100.7, for=10.0.0.2;proto=http;host=example.com`},
+		{`[2001:db8::1]:1234`, `2001:db8::1 | for="[2001:db8::1]";proto=http;host=example.com`},
+	} {
+		r := httptest.NewRequest(`GET`, `http://example.com/`, nil)
+		r.RemoteAddr = test.peer
+		r.Header.Set(`X-Forwarded-For`, `198.51.100.7`)
+		r.Header.Set(`Forwarded`, `for=198.51.100.7`)
+		w := httptest.NewRecorder()
+		cfg.workerProxy(addr).ServeHTTP(w, r)
+		if w.Body.String() != test.expect {
+			t.Errorf(`expected %q from %v, got %q`, test.expect, test.peer, w.Body)
+		}
+	}
+}
@@ -1,19 +1,29 @@
 // Package rig manages a configuration of HTTP handlers rigged together in a way that will rebuild them when their inputs change.
 // Web applications can observe when a restart has occurred by subscribing to server sent events at /_rig/restart.
+//
+// When a rig is Run, the process becomes a supervisor that proxies requests to a worker process serving the rig over
+// a Unix domain socket.  The supervisor binds the socket itself and passes the listening socket to the worker as an
+// inherited file descriptor, named by RIG_SOCKET_FD, so the socket is ready before the worker starts.  RIG_SOCKET
+// names the path of the socket; if RIG_SOCKET_FD is not set, such as when passing descriptors is not supported, the
+// worker binds RIG_SOCKET itself.
 package rig
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
@@ -94,12 +104,41 @@ func New(options ...Option) (*Config, error) {
 
 // A Config is a rig configuration.
 type Config struct {
+	applying sync.Mutex // serializes calls to Apply
+	control  sync.Mutex // protects the fields below
+
 	done    <-chan struct{}
 	serve   bool  // true once Serve has been called
 	serving bool  // true after Serve has been called and before it returns
 	worker  bool  // true if Run with RIG_SOCKET in the environment
 	hooks   []any // hooks to apply
 	watch   []watch
+	logs    *logRing            // set by LogBuffer
+	watched bool                // set by ServeWatched
+	builds  *buildState         // created by Watch or ReportBuild
+	workers []*backgroundWorker // added by Worker
+
+	handover  bool           // set by Handover
+	drain     time.Duration  // how long to wait for requests to finish after a handover, see Handover
+	inherited []net.Listener // listeners inherited from an old supervisor, in the order of the Listen hooks
+
+	rewriteHost    bool           // set by PreserveHost(false)
+	trustedProxies []netip.Prefix // added by TrustForwarded
+	basePath       string         // set by BasePath, "" if the rig is served at the root
+
+	connections *connectionPolicy     // set by ConnectionPolicy
+	tls         *tlsPolicy            // set by TLS, ACME, TLSMinVersion and TLSCipherSuites
+	preRoute    []func(*http.Request) // added by PreRoute
+
+	workerFunc      func(ctx context.Context, socket string) error // set by WorkerFunc
+	awaitExecutable time.Duration                                  // set by AwaitExecutable
+	onShutdown      []func(ctx context.Context)                    // added by OnShutdown
+	conflicts       ConflictStrategy                               // set by PatternConflicts
+	strictHooks     bool                                           // set by StrictHooks
+	cycle           string                                         // the last cyclic dependency logged by Apply
+
+	background sync.WaitGroup // tracks background workers started before serving
+	registry   hook.Registry  // shared by Setup hooks
 }
 
 type watch struct {
@@ -109,21 +148,38 @@ type watch struct {
 
 // Done returns a channel that will be closed when the rig is done serving.
 func (cfg *Config) Done() <-chan struct{} {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
 	return cfg.done
 }
 
+// Registry returns the registry that Setup hooks use to share values, see hook.Setup.  Options may use it directly,
+// such as a BeforeServe function that gets a value published by a hook.
+func (cfg *Config) Registry() *hook.Registry { return &cfg.registry }
+
 // Hook adds hooks to the configuration, see the hook package for interfaces that hooks can implement.  This is
 // normally done by various options.
 func (cfg *Config) Hook(hooks ...any) {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
 	cfg.hooks = append(cfg.hooks, hooks...)
 }
 
-// Apply applies the given options to the config; should not be called after Run.
+// hookList returns a copy of the hooks, so they can be applied without holding the lock.
+func (cfg *Config) hookList() []any {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	return append([]any(nil), cfg.hooks...)
+}
+
+// Apply applies the given options to the config; should not be called after Run.  Concurrent calls to Apply are
+// applied one at a time.
 func (cfg *Config) Apply(options ...Option) error {
-	if cfg.serving {
-		return errors.New(`cannot apply options while a rig is running`)
-	} else if cfg.serve {
-		return errors.New(`cannot apply options after a rig has been run`)
+	cfg.applying.Lock()
+	defer cfg.applying.Unlock()
+	err := cfg.checkApply()
+	if err != nil {
+		return err
 	}
 
 	for _, option := range options {
@@ -133,7 +189,43 @@ func (cfg *Config) Apply(options ...Option) error {
 		}
 	}
 	// Attempt to reorder the hooks.
-	cfg.hooks = hook.Order(cfg.hooks...)
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	hooks, err := hook.StrictOrder(cfg.hooks...)
+	switch {
+	case err == nil:
+	case cfg.strictHooks:
+		return err
+	case err.Error() != cfg.cycle:
+		cfg.cycle = err.Error() // so the same cycle is not logged by every Apply.
+		hog.From(context.Background()).Warn().Err(err).Msg(`hooks will run in a best effort order`)
+	}
+	cfg.hooks = hooks
+	return nil
+}
+
+// StrictHooks returns an option that makes Apply, and therefore New, fail with a hook.CycleError if hooks depend on
+// each other, such as two hooks that each depend on a name provided by the other.  Otherwise, the cycle is logged as a
+// warning and the hooks run in a best effort order.  Since the hooks are ordered once the options have been applied,
+// this also covers hooks added by options given after it.
+func StrictHooks(ok bool) Option {
+	return func(cfg *Config) error {
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.strictHooks = ok
+		return nil
+	}
+}
+
+// checkApply returns an error if options can no longer be applied because the rig has been served.
+func (cfg *Config) checkApply() error {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	if cfg.serving {
+		return errors.New(`cannot apply options while a rig is running`)
+	} else if cfg.serve {
+		return errors.New(`cannot apply options after a rig has been run`)
+	}
 	return nil
 }
 
@@ -160,44 +252,175 @@ func (cfg *Config) Spawn(ctx context.Context, executable string, args ...string)
 	defer os.RemoveAll(dir)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	addr := dir + `/socket`
-	worker, err := startWorker(ctx, addr, executable, args)
+	ready, err := cfg.inherit() // before starting the worker, so it does not inherit the listeners too.
 	if err != nil {
 		return err
 	}
-	defer worker.Wait()
+	addr := dir + `/socket`
+	logs := cfg.logRing()
+	var wk *worker
+	var upstream http.Handler = cfg.workerProxy(addr)
+	fn := cfg.workerFn()
+	if fn != nil {
+		wk = startWorkerFunc(ctx, addr, fn)
+	} else {
+		socket, err := bindSocket(ctx, addr)
+		if err != nil {
+			hog.From(ctx).Warn().Err(err).Msg(`cannot pass socket to worker, the worker will bind it`)
+		} else {
+			defer socket.Close()
+		}
+		stdout, stderr := io.Writer(os.Stdout), io.Writer(os.Stderr)
+		if logs != nil {
+			output := logs.writer(`worker`)
+			stdout, stderr = io.MultiWriter(stdout, output), io.MultiWriter(stderr, output)
+		}
+		start := func(ctx context.Context) (*worker, error) {
+			return startWorker(ctx, addr, socket, executable, args, stdout, stderr)
+		}
+		wk, err = start(ctx)
+		var execErr *ExecutableError
+		switch {
+		case err == nil:
+		case errors.As(err, &execErr) && execErr.missing() && cfg.awaitInterval() > 0:
+			wk, upstream = awaitWorker(ctx, cfg.awaitInterval(), err, start, upstream)
+		default:
+			return err
+		}
+	}
+	defer wk.stop()
 	go func() {
-		defer worker.Kill()
+		defer wk.stop()
 		<-ctx.Done()
 	}()
 	defer cancel() // Note that this is a duplicate that ensures the worker is interrupted if the supervisor is interrupted.
+	if fn != nil {
+		// Unlike a process, a WorkerFunc cannot be handed a socket that is already listening, so wait for it to bind.
+		err = awaitSocket(ctx, addr)
+		if err != nil {
+			return err
+		}
+	}
 
 	listeners, err := cfg.listen(ctx)
 	if err != nil {
 		return err
 	}
+	if ready != nil {
+		// The old supervisor stops accepting connections once we are ready; until then, both of us accept them.
+		_, _ = ready.Write([]byte{1})
+		ready.Close()
+		hog.From(ctx).Info().Int(`listeners`, len(listeners)).Msg(`took over listeners from the old supervisor`)
+	}
+
+	// The supervisor applies only listener and server hooks, but serves the log buffer and the state of the builds
+	// itself, since they survive restarts and builds like esbuild only run in the supervisor, along with the watches
+	// of both.
+	handler := upstream
+	builds, watches := cfg.buildEndpoint()
+	cfg.control.Lock()
+	watched := cfg.watched
+	cfg.control.Unlock()
+	if logs != nil || builds != nil || watched {
+		mux := http.NewServeMux()
+		if logs != nil {
+			logs.RigMux(mux)
+		}
+		if watched {
+			watchedEndpoint{worker: &http.Client{Transport: workerTransport(addr)}}.RigMux(mux)
+		}
+		if builds != nil {
+			builds.watch(ctx, watches)
+			builds.RigMux(mux)
+		}
+		handler = cfg.overlay(mux, upstream)
+	}
+	server := cfg.Server(ctx, handler)
+	drained := func() {}
+	if handover, _ := cfg.handoverDrain(); handover {
+		drained = cfg.awaitHandover(ctx, server, listeners, executable, args)
+	}
+	err = cfg.serveListeners(ctx, server, listeners...)
+	drained() // before stopping the worker, which is still handling the requests in flight.
+	return err
+}
+
+// workerProxy returns a reverse proxy that forwards requests to the worker listening to the given unix address.
+//
+// The worker sees the Host header sent by the client unless PreserveHost(false) is used, so host based routing and
+// absolute URLs work the same behind the supervisor as they do when the rig is served directly.  Either way, the
+// proxy sets X-Forwarded-Host, X-Forwarded-Proto, X-Forwarded-For and Forwarded to describe the original request,
+// since the worker only sees the supervisor's end of a unix socket, see TrustForwarded and api.RealIP.
+func (cfg *Config) workerProxy(addr string) *httputil.ReverseProxy {
+	cfg.control.Lock()
+	rewriteHost := cfg.rewriteHost
+	trusted := cfg.trustedProxies
+	cfg.control.Unlock()
+	target := &url.URL{Scheme: `http`, Host: `rig`}
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			forward(pr, trusted)
+			if !rewriteHost {
+				pr.Out.Host = pr.In.Host
+			}
+		},
+		Transport: workerTransport(addr),
+	}
+}
 
-	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: `http`, Host: `rig`})
-	proxy.Transport = &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+// workerTransport returns a transport that connects to the worker listening to the given unix address.
+func workerTransport(addr string) *http.Transport {
+	return &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
 		return net.Dial(`unix`, addr)
 	}}
-	// The supervisor applies only listener and server hooks.
-	return cfg.serveListeners(ctx, cfg.Server(ctx, proxy), listeners...)
 }
 
-// runWorker will serve the rig at the given unix address.
+// awaitInterval returns the interval set by AwaitExecutable.
+func (cfg *Config) awaitInterval() time.Duration {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	return cfg.awaitExecutable
+}
+
+// PreserveHost returns an option that controls whether the supervisor forwards the Host header of each request to the
+// worker, which is the default.  If ok is false, the worker sees a placeholder host instead and must use
+// X-Forwarded-Host to find the original.  This has no effect on rigs that are served directly.
+func PreserveHost(ok bool) Option {
+	return func(cfg *Config) error {
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.rewriteHost = !ok
+		return nil
+	}
+}
+
+// runWorker will serve the rig at the given unix address, or the socket inherited from the supervisor.
 func (cfg *Config) runWorker(ctx context.Context, addr string) error {
-	var lcf net.ListenConfig
-	listener, err := lcf.Listen(ctx, `unix`, addr)
+	defer cfg.shutdown(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cfg.background.Wait()
+	defer cancel()
+	err := cfg.beforeServe(ctx)
+	if err != nil {
+		return err
+	}
+	listener, err := workerListener(ctx, addr)
 	if err != nil {
 		return err
 	}
 	defer listener.Close()
+	cfg.control.Lock()
 	cfg.worker = true
+	cfg.control.Unlock()
+	handler, err := cfg.buildHandler()
+	if err != nil {
+		return err
+	}
 	// We do not apply server or listener hooks to workers.
 	server := &http.Server{
 		BaseContext: func(net.Listener) context.Context { return ctx },
-		Handler:     cfg.Handler(),
+		Handler:     handler,
 	}
 	// Nor do we use the listener hooks.
 	return cfg.serveListeners(ctx, server, listener)
@@ -207,11 +430,62 @@ func (cfg *Config) runWorker(ctx context.Context, addr string) error {
 // the address starts with "." or "/", it will be interpreted as a Unix domain socket.  Otherwise, it will be interpreted
 // as a TCP address.
 func (cfg *Config) Serve(ctx context.Context) error {
-	listeners, err := cfg.listen(ctx)
+	return cfg.serveWith(ctx, cfg.listen)
+}
+
+// ServeListener will run the configured rig as a server on the provided listener until the context is cancelled, which
+// is intended for embedding the rig in a larger server and for tests, such as with an in-memory listener.  Server hooks
+// and BeforeServe hooks still apply, but listener hooks, including options like local.Rig, are skipped entirely along
+// with listeners handed over by a previous supervisor.  The listener is closed when serving stops.
+func (cfg *Config) ServeListener(ctx context.Context, lr net.Listener) error {
+	defer lr.Close()
+	return cfg.serveWith(ctx, func(context.Context) ([]net.Listener, error) { return []net.Listener{lr}, nil })
+}
+
+// serveWith serves the configured rig on the listeners returned by listen, see Serve.
+func (cfg *Config) serveWith(ctx context.Context, listen func(context.Context) ([]net.Listener, error)) error {
+	defer cfg.shutdown(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cfg.background.Wait()
+	defer cancel()
+	err := cfg.beforeServe(ctx)
+	if err != nil {
+		return err
+	}
+	handler, err := cfg.buildHandler()
+	if err != nil {
+		return err
+	}
+	listeners, err := listen(ctx)
 	if err != nil {
 		return err
 	}
-	return cfg.serveListeners(ctx, cfg.Server(ctx, cfg.Handler()), listeners...)
+	if builds, watches := cfg.buildEndpoint(); builds != nil {
+		builds.watch(ctx, watches)
+	}
+	return cfg.serveListeners(ctx, cfg.Server(ctx, handler), listeners...)
+}
+
+// beforeServe calls the Setup hooks then the BeforeServe hooks in order, stopping at the first error.
+func (cfg *Config) beforeServe(ctx context.Context) error {
+	reg := cfg.Registry()
+	for _, it := range cfg.hookList() {
+		if impl, ok := it.(hook.Setup); ok {
+			err := impl.RigSetup(ctx, reg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, it := range cfg.hookList() {
+		if impl, ok := it.(hook.BeforeServe); ok {
+			err := impl.RigBeforeServe(ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // Server returns an http.Server with the server hooks applied.
@@ -219,7 +493,7 @@ func (cfg *Config) Server(ctx context.Context, handler http.Handler) *http.Serve
 	server := new(http.Server)
 	server.BaseContext = func(net.Listener) context.Context { return ctx }
 	server.Handler = handler
-	for _, it := range cfg.hooks {
+	for _, it := range cfg.hookList() {
 		if impl, ok := it.(hook.Server); ok {
 			impl.RigServer(server)
 		}
@@ -228,27 +502,27 @@ func (cfg *Config) Server(ctx context.Context, handler http.Handler) *http.Serve
 }
 
 func (cfg *Config) serveListeners(ctx context.Context, server *http.Server, listeners ...net.Listener) error {
-	if cfg.serving {
-		return fmt.Errorf(`you are already serving this rig`)
-	}
-	if cfg.serve {
-		return fmt.Errorf(`cannot serve a rig more than once`)
-	}
 	if len(listeners) == 0 {
 		return fmt.Errorf(`no listeners for service`)
 	}
-	// used to spot use of options after service has started
-	cfg.serve = true
-	cfg.serving = true
-	defer func() { cfg.serving = false }()
-
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	worker, err := cfg.startServing(ctx.Done())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cfg.control.Lock()
+		cfg.serving = false
+		cfg.control.Unlock()
+	}()
 	go func() {
 		<-ctx.Done()
 		server.Shutdown(context.Background())
 	}()
-	cfg.done = ctx.Done()
+	if !worker {
+		listeners = cfg.limitListeners(terminateTLS(server, listeners))
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(len(listeners))
@@ -260,7 +534,7 @@ func (cfg *Config) serveListeners(ctx context.Context, server *http.Server, list
 			addr := lr.Addr().String()
 			err := server.Serve(lr)
 			// Do not babble about shutdown if we are a worker
-			if cfg.worker {
+			if worker {
 				return
 			}
 			switch err {
@@ -271,17 +545,76 @@ func (cfg *Config) serveListeners(ctx context.Context, server *http.Server, list
 			}
 		}(lr)
 	}
+	if worker {
+		return nil // the worker's listener is the supervisor's business.
+	}
+	err = cfg.started(ctx, listeners)
+	if err != nil {
+		cancel() // shuts down the server before we return.
+	}
+	return err
+}
+
+// started calls the Started hooks in order with the addresses of the listeners, stopping at the first error.
+func (cfg *Config) started(ctx context.Context, listeners []net.Listener) error {
+	addrs := make([]net.Addr, len(listeners))
+	for i, lr := range listeners {
+		addrs[i] = lr.Addr()
+	}
+	for _, it := range cfg.hookList() {
+		if impl, ok := it.(hook.Started); ok {
+			err := impl.RigStarted(ctx, addrs)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+// startServing marks the rig as serving until done is closed, returning an error if the rig has been served already.
+// This also reports whether the rig is being served by a worker.
+func (cfg *Config) startServing(done <-chan struct{}) (worker bool, err error) {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	if cfg.serving {
+		return false, fmt.Errorf(`you are already serving this rig`)
+	}
+	if cfg.serve {
+		return false, fmt.Errorf(`cannot serve a rig more than once`)
+	}
+	// used to spot use of options after service has started
+	cfg.serve = true
+	cfg.serving = true
+	cfg.done = done
+	return cfg.worker, nil
+}
+
+// logRing returns the log buffer configured by LogBuffer, if any.
+func (cfg *Config) logRing() *logRing {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	return cfg.logs
+}
+
 // listen will return a list of listeners for the configured addresses.
 func (cfg *Config) listen(ctx context.Context) ([]net.Listener, error) {
 	var listeners []net.Listener
-	for _, it := range cfg.hooks {
+	inherited := cfg.takeInherited()
+	defer func() {
+		for _, lr := range inherited[min(len(listeners), len(inherited)):] {
+			_ = lr.Close() // the old supervisor had more listeners than we do.
+		}
+	}()
+	for _, it := range cfg.hookList() {
 		impl, ok := it.(hook.Listen)
 		if !ok {
 			continue
 		}
+		if len(listeners) < len(inherited) {
+			listeners = append(listeners, inherited[len(listeners)])
+			continue
+		}
 		listener, err := impl.Listen(ctx)
 		if err != nil {
 			for _, lr := range listeners {
@@ -294,6 +627,9 @@ func (cfg *Config) listen(ctx context.Context) ([]net.Listener, error) {
 		}
 		listeners = append(listeners, listener)
 	}
+	if len(listeners) < 1 && len(inherited) > 0 {
+		listeners = append(listeners, inherited[0])
+	}
 	if len(listeners) < 1 {
 		lr, err := net.Listen(`tcp`, `localhost:`)
 		if err != nil {
@@ -304,22 +640,27 @@ func (cfg *Config) listen(ctx context.Context) ([]net.Listener, error) {
 	return listeners, nil
 }
 
-// Handler returns an http.Handler that will serve the configured rig.
+// Handler returns an http.Handler that will serve the configured rig.  If hooks add conflicting patterns, Handler
+// panics with a PatternConflictError unless PatternConflicts resolves it; Run and Serve return the error instead.
 func (cfg *Config) Handler() http.Handler {
-	var mux http.ServeMux
-	for _, it := range cfg.hooks {
-		if impl, ok := it.(hook.Mux); ok {
-			impl.RigMux(&mux)
-		}
+	handler, err := cfg.buildHandler()
+	if err != nil {
+		panic(err)
 	}
-	return &mux
+	return handler
 }
 
 // Watch will trigger notifying clients watching "/_rig/build" when any file in the given directory changes that
-// matches the given glob patterns.  This is normally done by various options like esbuild.
+// matches the given glob patterns, which are matched against the base name of the file.  This is normally done by
+// various options like esbuild.
 //
-// If nothing is being watched, the "/_rig/build" endpoint will not be registered.
+// The "/_rig/build" endpoint serves the state of the builds reported by ReportBuild as JSON, or as a stream of server
+// sent events for clients that accept "text/event-stream", such as a development overlay that shows build errors and
+// reloads the page when the build is fixed.  If nothing is being watched and no builds have been reported, the
+// endpoint will not be registered.
 func (cfg *Config) Watch(dir string, patterns ...string) error {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
 	cfg.watch = append(cfg.watch, watch{dir, patterns})
 	return nil
 }
@@ -327,17 +668,98 @@ func (cfg *Config) Watch(dir string, patterns ...string) error {
 // An Option is a function that modifies a Config before it is Run.
 type Option func(*Config) error
 
-// startWorker will start a child process with RIG_SOCKET set to the given address.
-func startWorker(ctx context.Context, addr string, executable string, args []string) (*os.Process, error) {
-	// TODO: watch for changes in the directory and restart the worker
-	worker := exec.CommandContext(ctx, executable, args...)
-	worker.Env = append(os.Environ(), `RIG_SOCKET=`+addr)
-	worker.Stdout = os.Stdout
-	worker.Stderr = os.Stderr
-	worker.Stdin = os.Stdin
-	err := worker.Start()
+// bindSocket binds a unix socket at the given address for a worker, returning the file of the listening socket.
+func bindSocket(ctx context.Context, addr string) (*os.File, error) {
+	var lcf net.ListenConfig
+	listener, err := lcf.Listen(ctx, `unix`, addr)
 	if err != nil {
 		return nil, err
 	}
-	return worker.Process, nil
+	defer listener.Close() // the file is a duplicate, so it will keep listening.
+	ul, ok := listener.(*net.UnixListener)
+	if !ok {
+		return nil, fmt.Errorf(`unexpected %T listening to %q`, listener, addr)
+	}
+	ul.SetUnlinkOnClose(false) // the socket file is removed with its directory.
+	return ul.File()
+}
+
+// workerListener returns a listener for the worker, using the socket inherited from the supervisor if RIG_SOCKET_FD
+// is set, and binding the given address otherwise.
+func workerListener(ctx context.Context, addr string) (net.Listener, error) {
+	fd := os.Getenv(`RIG_SOCKET_FD`)
+	if fd == `` {
+		var lcf net.ListenConfig
+		return lcf.Listen(ctx, `unix`, addr)
+	}
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, fmt.Errorf(`%w in RIG_SOCKET_FD`, err)
+	}
+	file := os.NewFile(uintptr(n), addr)
+	if file == nil {
+		return nil, fmt.Errorf(`invalid RIG_SOCKET_FD %v`, n)
+	}
+	defer file.Close() // the listener is a duplicate.
+	return net.FileListener(file)
+}
+
+// A worker is a child process serving the rig for a supervisor, or a WorkerFunc standing in for one.
+type worker struct {
+	cmd    *exec.Cmd     // nil for a WorkerFunc
+	cancel func()        // cancels a WorkerFunc, nil for a child process
+	doneCh chan struct{} // closed once the process has exited and been reaped
+	err    error         // the result of waiting for the process, valid once doneCh is closed
+}
+
+// startWorker will start a child process with RIG_SOCKET set to the given address and RIG_GENERATION to its
+// generation.  If socket is not nil, it is passed to the worker as a file descriptor named by RIG_SOCKET_FD.
+//
+// Each worker is waited for as soon as it starts, so the process is reaped as soon as it exits, whether it crashes or
+// is stopped, and no zombies accumulate while a supervisor restarts workers.
+func startWorker(
+	ctx context.Context, addr string, socket *os.File, executable string, args []string, stdout, stderr io.Writer,
+) (*worker, error) {
+	// TODO: watch for changes in the directory and restart the worker
+	cmd := exec.CommandContext(ctx, executable, args...)
+	cmd.Env = append(append(os.Environ(), `RIG_SOCKET=`+addr), nextGeneration()...)
+	if socket != nil {
+		cmd.ExtraFiles = []*os.File{socket}
+		cmd.Env = append(cmd.Env, `RIG_SOCKET_FD=3`) // ExtraFiles start after stdin, stdout and stderr.
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Stdin = os.Stdin
+	cmd.WaitDelay = workerWaitDelay // in case the worker leaves children holding its output open.
+	err := cmd.Start()
+	if err != nil {
+		return nil, &ExecutableError{Path: executable, Err: err}
+	}
+	wk := &worker{cmd: cmd, doneCh: make(chan struct{})}
+	go func() {
+		defer close(wk.doneCh)
+		wk.err = cmd.Wait()
+	}()
+	return wk, nil
+}
+
+// workerWaitDelay limits how long to wait for the output of a worker to close after it exits.
+const workerWaitDelay = 5 * time.Second
+
+// done returns a channel that is closed once the worker has exited and been reaped.
+func (wk *worker) done() <-chan struct{} { return wk.doneCh }
+
+// stop kills the worker if it is still running and waits for it to be reaped.
+func (wk *worker) stop() {
+	select {
+	case <-wk.doneCh:
+		return
+	default:
+	}
+	if wk.cmd != nil {
+		_ = wk.cmd.Process.Kill()
+	} else {
+		wk.cancel()
+	}
+	<-wk.doneCh
 }
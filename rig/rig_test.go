@@ -0,0 +1,202 @@
+package rig
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig/hook"
+)
+
+func TestConcurrentApply(t *testing.T) {
+	const n = 64
+	cfg, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			err := cfg.Apply(func(cfg *Config) error {
+				cfg.Hook(i)
+				return cfg.Watch(`.`, `*.go`)
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	hooks := cfg.hookList()
+	if len(hooks) != n {
+		t.Fatalf(`expected %v hooks, got %v`, n, len(hooks))
+	}
+	seen := make(map[int]bool, n)
+	for _, it := range hooks {
+		seen[it.(int)] = true
+	}
+	if len(seen) != n {
+		t.Fatalf(`expected %v distinct hooks, got %v`, n, len(seen))
+	}
+	if len(cfg.watch) != n {
+		t.Fatalf(`expected %v watches, got %v`, n, len(cfg.watch))
+	}
+}
+
+func TestServeListener(t *testing.T) {
+	lr, err := net.Listen(`tcp`, `localhost:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := New(func(cfg *Config) error {
+		cfg.Hook(failListen{}, serverHeader{}, testMux{`ok`, `/`})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- cfg.ServeListener(ctx, lr) }()
+
+	rsp, err := http.Get(`http://` + lr.Addr().String() + `/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if string(body) != `ok` || rsp.Header.Get(`X-Rig-Test`) != `yes` {
+		t.Fatalf(`expected the server hook to apply, got %q with %v`, body, rsp.Header)
+	}
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`ServeListener did not return after the context was cancelled`)
+	}
+}
+
+// failListen is a listener hook that ServeListener should skip.
+type failListen struct{}
+
+func (failListen) Listen(context.Context) (net.Listener, error) {
+	return nil, errors.New(`listener hooks should be skipped`)
+}
+
+// serverHeader is a server hook that adds a header to every response.
+type serverHeader struct{}
+
+func (serverHeader) RigServer(server *http.Server) {
+	next := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`X-Rig-Test`, `yes`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestStarted(t *testing.T) {
+	lr, err := net.Listen(`tcp`, `localhost:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errStarted := errors.New(`started`)
+	var got []net.Addr
+	cfg, err := New(Started(func(ctx context.Context, addrs []net.Addr) error {
+		got = addrs
+		return errStarted
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.ServeListener(context.Background(), lr)
+	if !errors.Is(err, errStarted) {
+		t.Fatalf(`expected ServeListener to stop with the error from Started, got %v`, err)
+	}
+	if len(got) != 1 || got[0].String() != lr.Addr().String() {
+		t.Fatalf(`expected Started to see %v, got %v`, lr.Addr(), got)
+	}
+}
+
+func TestStrictHooks(t *testing.T) {
+	hooks := func(cfg *Config) error {
+		cfg.Hook(testDependency{`a`, `b`}, testDependency{`b`, `c`}, testDependency{`c`, `a`})
+		return nil
+	}
+	_, err := New(hooks)
+	if err != nil {
+		t.Fatalf(`expected a cycle to only be logged, got %v`, err)
+	}
+	_, err = New(StrictHooks(true), hooks)
+	var cycle *hook.CycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf(`expected a hook.CycleError, got %v`, err)
+	}
+	if len(cycle.Hooks) != 3 || !slices.Equal(cycle.Names, []string{`b`, `c`, `a`}) {
+		t.Fatalf(`expected the cycle to name each dependency, got %v`, err)
+	}
+}
+
+// testDependency is a hook that provides one name and depends on another.
+type testDependency struct{ provides, dependsOn string }
+
+func (td testDependency) Provides() []string  { return []string{td.provides} }
+func (td testDependency) DependsOn() []string { return []string{td.dependsOn} }
+
+func TestRegistry(t *testing.T) {
+	var got string
+	user := testUser{fn: func(ctx context.Context, reg *hook.Registry) (err error) {
+		got, err = hook.Get[string](reg, `greeting`)
+		return err
+	}}
+	hooks := func(cfg *Config) error {
+		cfg.Hook(user, testProvider{`greeting`, `hello`}) // the user is set up after the provider.
+		return nil
+	}
+	errStop := errors.New(`stop`)
+	cfg, err := New(hooks, BeforeServe(func(ctx context.Context) error { return errStop }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Serve(context.Background())
+	if !errors.Is(err, errStop) {
+		t.Fatalf(`expected Serve to stop before serving, got %v`, err)
+	}
+	if got != `hello` {
+		t.Fatalf(`expected the user to get the published greeting, got %q`, got)
+	}
+	if _, err := hook.Get[int](cfg.Registry(), `greeting`); err == nil {
+		t.Fatal(`expected getting a string as an int to fail`)
+	}
+}
+
+// testProvider is a Setup hook that publishes a value under the name it provides.
+type testProvider struct {
+	name  string
+	value any
+}
+
+func (tp testProvider) Provides() []string { return []string{tp.name} }
+func (tp testProvider) RigSetup(ctx context.Context, reg *hook.Registry) error {
+	return reg.Publish(tp.name, tp.value)
+}
+
+// testUser is a Setup hook that depends on the greeting.
+type testUser struct {
+	fn func(ctx context.Context, reg *hook.Registry) error
+}
+
+func (tu testUser) DependsOn() []string                                    { return []string{`greeting`} }
+func (tu testUser) RigSetup(ctx context.Context, reg *hook.Registry) error { return tu.fn(ctx, reg) }
@@ -0,0 +1,75 @@
+package rig
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/swdunlop/rig-go/rig/hook"
+)
+
+// RouteInfo describes a route registered with the rig by a hook.
+type RouteInfo struct {
+	Method  string `json:"method,omitempty"` // The method from the pattern, or empty if any method matches.
+	Pattern string `json:"pattern"`          // The pattern without the method.
+	Handler string `json:"handler"`          // A description of the handler.
+	Hook    string `json:"hook"`             // The type of the hook that registered the route.
+	Group   string `json:"group,omitempty"`  // The group that registered the route, if any.
+}
+
+// Routes returns the routes described by hooks implementing hook.Routes, in the order the hooks will be applied.
+// Hooks that add handlers without describing them are omitted, and patterns include the prefix given to BasePath.
+func (cfg *Config) Routes() []RouteInfo {
+	var routes []RouteInfo
+	base := cfg.base()
+	for _, it := range cfg.hookList() {
+		impl, ok := it.(hook.Routes)
+		if !ok {
+			continue
+		}
+		for _, route := range impl.RigRoutes() {
+			full := withBasePath(base, route.Pattern)
+			method, pattern, ok := strings.Cut(full, ` `)
+			if !ok {
+				method, pattern = ``, full
+			}
+			routes = append(routes, RouteInfo{
+				Method:  method,
+				Pattern: strings.TrimLeft(pattern, ` `),
+				Handler: route.Handler,
+				Hook:    fmt.Sprintf(`%T`, it),
+				Group:   route.Group,
+			})
+		}
+	}
+	return routes
+}
+
+// ServeRoutes returns an option that lists the routes of the rig as JSON at "/_rig/routes".
+func ServeRoutes() Option {
+	return func(cfg *Config) error {
+		cfg.Hook(routesHook{cfg})
+		return nil
+	}
+}
+
+type routesHook struct{ cfg *Config }
+
+// RigMux implements hook.Mux by adding the "/_rig/routes" endpoint.
+func (rh routesHook) RigMux(mux *http.ServeMux) {
+	mux.HandleFunc(`GET /_rig/routes`, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Type`, `application/json`)
+		_ = json.NewEncoder(w).Encode(rh.cfg.Routes())
+	})
+}
+
+// RigRoutes implements hook.Routes by describing the "/_rig/routes" endpoint.
+func (rh routesHook) RigRoutes() []hook.Route {
+	return []hook.Route{{Pattern: `GET /_rig/routes`, Handler: `rig.ServeRoutes`}}
+}
+
+var (
+	_ hook.Mux    = routesHook{}
+	_ hook.Routes = routesHook{}
+)
@@ -0,0 +1,42 @@
+package rig
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	cfg, err := New(
+		func(cfg *Config) error {
+			cfg.Hook(testMux{`users`, `GET /users/{id}`}, testMux{`posts`, `/posts/`})
+			return nil
+		},
+		ServeRoutes(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []RouteInfo{
+		{Method: `GET`, Pattern: `/users/{id}`, Handler: `testMux`, Hook: `rig.testMux`, Group: `users`},
+		{Pattern: `/posts/`, Handler: `testMux`, Hook: `rig.testMux`, Group: `posts`},
+		{Method: `GET`, Pattern: `/_rig/routes`, Handler: `rig.ServeRoutes`, Hook: `rig.routesHook`},
+	}
+	if routes := cfg.Routes(); !slices.Equal(routes, expect) {
+		t.Fatalf(`expected %+v, got %+v`, expect, routes)
+	}
+
+	handler, err := cfg.buildHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(`GET`, `/_rig/routes`, nil))
+	var served []RouteInfo
+	err = json.Unmarshal(w.Body.Bytes(), &served)
+	if err != nil || w.Code != http.StatusOK || !slices.Equal(served, expect) {
+		t.Fatalf(`expected the routes to be served in order, got %v %s (%v)`, w.Code, w.Body.Bytes(), err)
+	}
+}
@@ -0,0 +1,57 @@
+// Package rpcerr defines an error type that RPC handlers can return to describe a failure in a way that each RPC
+// transport, like mrpc and jrpc, renders in its own protocol.  This lets the same business logic back multiple
+// transports without duplicating how errors are mapped.
+package rpcerr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// An Error is a failure with a status code, a message for the client, and optional data for transports that support
+// it.  Codes are generally analogous to HTTP status codes.
+type Error struct {
+	Code    int
+	Message string
+	Data    any // Additional information for the client; ignored by transports that do not support it.
+
+	// Retryable is true if the request may succeed if the client sends it again, such as when a dependency is briefly
+	// unavailable, and false if it will fail the same way, such as when its input is invalid.  Like Data, it is
+	// ignored by transports that do not support it.
+	Retryable bool
+}
+
+// Error implements the error interface.
+func (err *Error) Error() string { return fmt.Sprintf(`%v %v`, err.Code, err.Message) }
+
+// New returns an Error with the given code and message.
+func New(code int, message string) *Error { return &Error{Code: code, Message: message} }
+
+// Errorf returns an Error with the given code and a formatted message.
+func Errorf(code int, format string, args ...any) *Error {
+	return New(code, fmt.Sprintf(format, args...))
+}
+
+// NotFound returns an Error indicating that something the client requested does not exist.
+func NotFound(message string) *Error { return New(http.StatusNotFound, message) }
+
+// Unauthorized returns an Error indicating that the client is not permitted to make the request.
+func Unauthorized(message string) *Error { return New(http.StatusUnauthorized, message) }
+
+// Invalid returns an Error indicating that the input of the request was not acceptable.
+func Invalid(message string) *Error { return New(http.StatusBadRequest, message) }
+
+// Unavailable returns a retryable Error indicating that the request could not be handled right now.
+func Unavailable(message string) *Error {
+	return &Error{Code: http.StatusServiceUnavailable, Message: message, Retryable: true}
+}
+
+// From returns the Error wrapped by err, or an Error with code 500 and the message of err if it does not wrap one.
+func From(err error) *Error {
+	var ret *Error
+	if errors.As(err, &ret) {
+		return ret
+	}
+	return New(http.StatusInternalServerError, err.Error())
+}
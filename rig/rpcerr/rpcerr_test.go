@@ -0,0 +1,32 @@
+package rpcerr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		err    *Error
+		expect Error
+	}{
+		{`New`, New(418, `teapot`), Error{Code: 418, Message: `teapot`}},
+		{`Errorf`, Errorf(400, `field %q is %v`, `name`, `missing`), Error{Code: 400, Message: `field "name" is missing`}},
+		{`NotFound`, NotFound(`no such user`), Error{Code: 404, Message: `no such user`}},
+		{`Unauthorized`, Unauthorized(`no token`), Error{Code: 401, Message: `no token`}},
+		{`Invalid`, Invalid(`bad input`), Error{Code: 400, Message: `bad input`}},
+		{`Unavailable`, Unavailable(`busy`), Error{Code: 503, Message: `busy`, Retryable: true}},
+		{`From a wrapped Error`, From(fmt.Errorf(`%w while loading`, Unavailable(`busy`))),
+			Error{Code: 503, Message: `busy`, Retryable: true}},
+		{`From a plain error`, From(errors.New(`oops`)), Error{Code: 500, Message: `oops`}},
+	} {
+		if *test.err != test.expect {
+			t.Errorf(`%v: expected %+v, got %+v`, test.name, test.expect, *test.err)
+		}
+	}
+	if msg := Errorf(400, `bad %v`, `input`).Error(); msg != `400 bad input` {
+		t.Errorf(`expected the code and message, got %q`, msg)
+	}
+}
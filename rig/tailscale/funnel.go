@@ -0,0 +1,63 @@
+package tailscale
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// ErrFunnelUnavailable is wrapped by the error returned when a rig using Funnel is served by a node that may not use
+// Funnel, which is checked as soon as the node is up, before listening to any of its addresses.
+var ErrFunnelUnavailable = errors.New(`tailscale funnel is not available`)
+
+// funnelPorts returns the ports of the addresses that use Funnel, if any.
+func (cfg *config) funnelPorts() ([]uint16, error) {
+	var addresses []string
+	for _, it := range cfg.listeners() {
+		if it.mode == ModeFunnel {
+			addresses = append(addresses, it.address)
+		}
+	}
+	ports := make([]uint16, 0, len(addresses))
+	for _, address := range addresses {
+		n, err := strconv.ParseUint(port(address), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf(`%w in funnel address %q`, err, address)
+		}
+		ports = append(ports, uint16(n))
+	}
+	return ports, nil
+}
+
+// checkFunnel returns an error explaining how to enable Funnel if the node cannot use it for the given ports.  Tailscale
+// checks the same when listening, but only after the rig has waited for the node, with terse messages.
+func checkFunnel(status *ipnstate.Status, ports []uint16) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	self := status.Self
+	switch {
+	case self == nil:
+		return fmt.Errorf(`%w: the status of this node is unknown`, ErrFunnelUnavailable)
+	case !self.HasCap(tailcfg.CapabilityHTTPS):
+		return fmt.Errorf(`%w: HTTPS certificates are not enabled for this tailnet; enable HTTPS in the admin console `+
+			`at https://login.tailscale.com/admin/dns`, ErrFunnelUnavailable)
+	case !self.HasCap(tailcfg.NodeAttrFunnel):
+		return fmt.Errorf(`%w: funnel not enabled for this node; enable it in the admin console by granting the `+
+			`"funnel" node attribute to this node in the tailnet policy at https://login.tailscale.com/admin/acls/file`,
+			ErrFunnelUnavailable)
+	}
+	for _, port := range ports {
+		err := ipn.CheckFunnelPort(port, self)
+		if err != nil {
+			return fmt.Errorf(`%w: %v; use a port allowed by the "funnel" node attribute, which are 443, 8443 and `+
+				`10000 unless the tailnet policy at https://login.tailscale.com/admin/acls/file says otherwise`,
+				ErrFunnelUnavailable, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package tailscale
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+func TestFunnel(t *testing.T) {
+	for _, it := range []struct {
+		name    string
+		options []Option
+		modes   []Mode
+		ports   []uint16
+	}{
+		{`default`, nil, []Mode{ModeTLS}, []uint16{}},
+		{`no tls`, []Option{NoTLS()}, []Mode{ModePlain}, []uint16{}},
+		{`funnel`, []Option{Funnel()}, []Mode{ModeFunnel}, []uint16{443}},
+		{`also`, []Option{Also(`:8443`, ModeFunnel), Also(`:80`, ModeRedirect)},
+			[]Mode{ModeTLS, ModeFunnel, ModeRedirect}, []uint16{8443}},
+		{`both`, []Option{Funnel(), Also(`:10000`, ModeFunnel)}, []Mode{ModeFunnel, ModeFunnel}, []uint16{443, 10000}},
+	} {
+		cfg := config{listen: `:443`}
+		for _, option := range it.options {
+			err := option(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		var modes []Mode
+		for _, lr := range cfg.listeners() {
+			modes = append(modes, lr.mode)
+		}
+		if !slices.Equal(modes, it.modes) {
+			t.Errorf(`%v: expected listeners using %v, got %v`, it.name, it.modes, modes)
+		}
+		ports, err := cfg.funnelPorts()
+		if err != nil || !slices.Equal(ports, it.ports) {
+			t.Errorf(`%v: expected funnel ports %v, got %v (%v)`, it.name, it.ports, ports, err)
+		}
+	}
+
+	node := func(caps ...tailcfg.NodeCapability) *ipnstate.Status {
+		self := &ipnstate.PeerStatus{CapMap: tailcfg.NodeCapMap{}}
+		for _, c := range caps {
+			self.CapMap[c] = nil
+		}
+		return &ipnstate.Status{Self: self}
+	}
+	funnelPorts := tailcfg.NodeCapability(string(tailcfg.CapabilityFunnelPorts) + `?ports=443,8443`)
+	for _, it := range []struct {
+		name   string
+		status *ipnstate.Status
+		ports  []uint16
+		ok     bool
+	}{
+		{`unused`, &ipnstate.Status{}, nil, true},
+		{`unknown`, &ipnstate.Status{}, []uint16{443}, false},
+		{`no https`, node(tailcfg.NodeAttrFunnel, funnelPorts), []uint16{443}, false},
+		{`no funnel`, node(tailcfg.CapabilityHTTPS), []uint16{443}, false},
+		{`allowed`, node(tailcfg.CapabilityHTTPS, tailcfg.NodeAttrFunnel, funnelPorts), []uint16{443, 8443}, true},
+		{`port`, node(tailcfg.CapabilityHTTPS, tailcfg.NodeAttrFunnel, funnelPorts), []uint16{10000}, false},
+	} {
+		err := checkFunnel(it.status, it.ports)
+		switch {
+		case it.ok && err != nil:
+			t.Errorf(`%v: expected funnel to be available, got %v`, it.name, err)
+		case !it.ok && !errors.Is(err, ErrFunnelUnavailable):
+			t.Errorf(`%v: expected ErrFunnelUnavailable, got %v`, it.name, err)
+		}
+	}
+}
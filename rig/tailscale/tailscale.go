@@ -3,15 +3,24 @@ package tailscale
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/swdunlop/html-go/hog"
 	"github.com/swdunlop/rig-go/rig"
 	"github.com/swdunlop/rig-go/rig/hook"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tsnet"
 )
 
-// Rig returns a rig.Option that configures a Tailscale server.
+// Rig returns a rig.Option that configures a Tailscale server listening to the given address, using TLS unless Funnel
+// or NoTLS is specified.  Also adds more addresses to the same Tailscale node.
 func Rig(address string, options ...Option) rig.Option {
 	return func(r *rig.Config) error {
 		var cfg config
@@ -32,39 +41,289 @@ type config struct {
 	noTLS   bool
 	upHooks []func(*tsnet.Server, *ipnstate.Status) error
 	listen  string
+	also    []listener // addresses added by Also
+	whoIs   bool       // if true, requests are identified, see WhoIs
+
+	control sync.Mutex // guards up and open
+	up      bool       // true once tsnet is up and the up hooks succeeded, until it is closed
+	open    int        // listeners of the node that the rig has not closed
+}
+
+// A Mode determines how Tailscale serves an address added by Also.
+type Mode int
+
+const (
+	ModeTLS      Mode = iota // Serve HTTPS using the certificate Tailscale provisions for the node.
+	ModePlain                // Serve HTTP without TLS, see NoTLS.
+	ModeFunnel               // Serve HTTPS to the public internet, see Funnel.
+	ModeRedirect             // Redirect HTTP requests to HTTPS on the first address using TLS, see RedirectHTTPS.
+)
+
+func (mode Mode) String() string {
+	switch mode {
+	case ModeTLS:
+		return `tls`
+	case ModePlain:
+		return `plain`
+	case ModeFunnel:
+		return `funnel`
+	case ModeRedirect:
+		return `redirect`
+	default:
+		return fmt.Sprintf(`mode(%d)`, int(mode))
+	}
+}
+
+// Also adds another address for the Tailscale node to listen to, such as ":80" with ModeRedirect alongside ":443" with
+// ModeTLS.  Each address is a separate rig listener, but all of them share the node and its state, so the node is only
+// brought up once.
+func Also(address string, mode Mode) Option {
+	return func(cfg *config) error {
+		if mode < ModeTLS || mode > ModeRedirect {
+			return fmt.Errorf(`unsupported Tailscale mode %v for %v`, mode, address)
+		}
+		cfg.also = append(cfg.also, listener{cfg: cfg, address: address, mode: mode})
+		return nil
+	}
 }
 
 func (cfg *config) rig(r *rig.Config) error {
 	if cfg.funnel && cfg.noTLS {
 		return errors.New("funnels are required to use TLS by Tailscale")
 	}
-	r.Hook(cfg)
+	if key := cfg.tsnet.AuthKey; key != `` {
+		logf := cfg.tsnet.Logf
+		if logf == nil {
+			logf = log.Printf // which is what tsnet uses by default.
+		}
+		cfg.tsnet.Logf = redactLogf(logf, key)
+	}
+	for _, it := range cfg.listeners() {
+		r.Hook(it)
+	}
+	if cfg.whoIs {
+		r.Hook(whoIsHook{cfg})
+	}
 	return nil
 }
 
-func (cfg *config) Listen(ctx context.Context) (net.Listener, error) {
+// listeners returns a listener for each address of the node, starting with the address given to Rig, which uses Funnel
+// or NoTLS if they were specified, followed by those added by Also.
+func (cfg *config) listeners() []listener {
+	mode := ModeTLS
+	switch {
+	case cfg.funnel:
+		mode = ModeFunnel
+	case cfg.noTLS:
+		mode = ModePlain
+	}
+	return append([]listener{{cfg: cfg, address: cfg.listen, mode: mode}}, cfg.also...)
+}
+
+// isUp returns true once the Tailscale node is up, see bringUp.
+func (cfg *config) isUp() bool {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	return cfg.up
+}
+
+// bringUp brings up the Tailscale node and calls the up hooks, unless an earlier listener already did.
+func (cfg *config) bringUp(ctx context.Context) error {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	if cfg.up {
+		return nil
+	}
+	ports, err := cfg.funnelPorts()
+	if err != nil {
+		return err
+	}
 	status, err := cfg.tsnet.Up(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	err = checkFunnel(status, ports)
+	if err != nil {
+		_ = cfg.tsnet.Close()
+		return err
 	}
 	for _, fn := range cfg.upHooks {
 		err = fn(&cfg.tsnet, status)
 		if err != nil {
 			_ = cfg.tsnet.Close()
-			return nil, err
+			return err
 		}
 	}
-	switch {
-	case cfg.funnel:
-		return cfg.tsnet.ListenFunnel(`tcp`, cfg.listen)
-	case cfg.noTLS:
-		return cfg.tsnet.Listen(`tcp`, cfg.listen)
+	cfg.up = true
+	return nil
+}
+
+// httpsPort returns the port of the first address using TLS, which is where ModeRedirect sends clients.
+func (cfg *config) httpsPort() string {
+	if !cfg.noTLS {
+		return port(cfg.listen)
+	}
+	for _, it := range cfg.also {
+		if it.mode == ModeTLS || it.mode == ModeFunnel {
+			return port(it.address)
+		}
+	}
+	return `443`
+}
+
+// port returns the port of a listen address, such as "443" for ":443".
+func port(address string) string {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil || port == `` {
+		return `443`
+	}
+	return port
+}
+
+// A listener is a hook.Listen for one of the addresses of a Tailscale node.
+type listener struct {
+	cfg     *config
+	address string
+	mode    Mode
+}
+
+func (lr listener) Listen(ctx context.Context) (net.Listener, error) {
+	cfg := lr.cfg
+	err := cfg.bringUp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := lr.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg.control.Lock()
+	cfg.open++
+	cfg.control.Unlock()
+	return &nodeListener{Listener: inner, cfg: cfg}, nil
+}
+
+// listen listens to the address of the node using the mode of the listener.
+func (lr listener) listen(ctx context.Context) (net.Listener, error) {
+	cfg := lr.cfg
+	switch lr.mode {
+	case ModeFunnel:
+		return cfg.tsnet.ListenFunnel(`tcp`, lr.address)
+	case ModePlain:
+		return cfg.tsnet.Listen(`tcp`, lr.address)
+	case ModeRedirect:
+		inner, err := cfg.tsnet.Listen(`tcp`, lr.address)
+		if err != nil {
+			return nil, err
+		}
+		return serveRedirect(ctx, inner, RedirectHTTPS(cfg.httpsPort())), nil
 	default:
-		return cfg.tsnet.ListenTLS(`tcp`, cfg.listen)
+		return cfg.tsnet.ListenTLS(`tcp`, lr.address)
+	}
+}
+
+// A nodeListener closes the Tailscale node once the rig has closed all of the listeners of the node.
+type nodeListener struct {
+	net.Listener
+	cfg  *config
+	once sync.Once
+}
+
+func (nl *nodeListener) Close() error {
+	err := nl.Listener.Close()
+	nl.once.Do(nl.cfg.release)
+	return err
+}
+
+// release closes the node when its last listener is closed, logging it out first if it is ephemeral so it is removed
+// from the tailnet right away instead of once the coordination server notices it is gone.
+func (cfg *config) release() {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	cfg.open--
+	if cfg.open > 0 || !cfg.up {
+		return
+	}
+	cfg.up = false
+	if cfg.tsnet.Ephemeral {
+		ctx, cancel := context.WithTimeout(context.Background(), LogoutTimeout)
+		defer cancel()
+		lc, err := cfg.tsnet.LocalClient()
+		if err == nil {
+			err = lc.Logout(ctx)
+		}
+		if err != nil {
+			hog.From(ctx).Warn().Err(err).Msg(`cannot log out the ephemeral Tailscale node`)
+		}
+	}
+	err := cfg.tsnet.Close()
+	if err != nil {
+		hog.From(context.Background()).Warn().Err(err).Msg(`cannot close the Tailscale node`)
 	}
 }
 
-var _ hook.Listen = (*config)(nil)
+// LogoutTimeout limits how long an Ephemeral node waits to log out of the tailnet when the rig stops.
+var LogoutTimeout = 5 * time.Second
+
+var _ hook.Listen = listener{}
+
+// RedirectHTTPS returns a handler that permanently redirects requests to the same host and path using HTTPS on the
+// given port, omitting the port from the URL if it is "443".  ModeRedirect uses it for plain HTTP addresses.
+func RedirectHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+		if port != `` && port != `443` {
+			host = net.JoinHostPort(host, port)
+		}
+		target := `https://` + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect) // unlike 301, 308 preserves the method and body.
+	})
+}
+
+// serveRedirect serves handler on inner in the background, returning a listener for the rig that accepts nothing, so
+// the redirect is not handled by the rig, but stops when the rig closes it.
+func serveRedirect(ctx context.Context, inner net.Listener, handler http.Handler) net.Listener {
+	rl := &redirectListener{
+		Listener: inner,
+		server: &http.Server{
+			Handler:     handler,
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		},
+		closed: make(chan struct{}),
+	}
+	go func() {
+		err := rl.server.Serve(inner)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			hog.From(ctx).Warn().Err(err).Str(`listener`, inner.Addr().String()).Msg(`HTTPS redirect stopped`)
+		}
+	}()
+	return rl
+}
+
+// A redirectListener stands in for a listener served by its own server, see serveRedirect.
+type redirectListener struct {
+	net.Listener
+	server *http.Server
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (rl *redirectListener) Accept() (net.Conn, error) {
+	<-rl.closed
+	return nil, net.ErrClosed
+}
+
+func (rl *redirectListener) Close() error {
+	var err error
+	rl.once.Do(func() {
+		close(rl.closed)
+		err = rl.server.Close()
+	})
+	return err
+}
 
 type Option func(*config) error
 
@@ -84,7 +343,9 @@ func Hostname(hostname string) Option {
 	}
 }
 
-// Funnel tells Tailscale to allow public IPs to connect to your service.
+// Funnel tells Tailscale to allow public IPs to connect to your service.  Once the node is up, the rig checks that the
+// tailnet has HTTPS enabled and that the node has the "funnel" node attribute for the port, failing with an error that
+// wraps ErrFunnelUnavailable and explains what to change in the admin console if it does not.
 func Funnel() Option {
 	return func(cfg *config) error {
 		cfg.funnel = true
@@ -100,6 +361,36 @@ func NoTLS() Option {
 	}
 }
 
+// AuthKey specifies the auth key used to add the node to the tailnet the first time it starts, instead of logging in
+// interactively, so nodes can be started unattended, such as in containers and CI.  Once the node has joined, its
+// state in Dir is used instead.  Defaults to the TS_AUTHKEY environment variable, like tsnet.  The key is redacted
+// from messages logged by Tailscale, see Logf.
+func AuthKey(key string) Option {
+	return func(cfg *config) error {
+		cfg.tsnet.AuthKey = key
+		return nil
+	}
+}
+
+// redactLogf returns a logging function that replaces secret with "[REDACTED]" before passing messages to logf.
+func redactLogf(logf func(format string, args ...any), secret string) func(format string, args ...any) {
+	return func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		logf(`%s`, strings.ReplaceAll(msg, secret, `[REDACTED]`))
+	}
+}
+
+// Ephemeral tells Tailscale to register the node as an ephemeral node, which is removed from the tailnet when it goes
+// offline instead of lingering in the admin console, such as for preview deployments.  When the rig stops serving and
+// closes the listeners of an ephemeral node, the node logs out so it is removed right away, see LogoutTimeout.
+// Ephemeral nodes are usually started with an AuthKey, since they must join the tailnet again each time they start.
+func Ephemeral(ok bool) Option {
+	return func(cfg *config) error {
+		cfg.tsnet.Ephemeral = ok
+		return nil
+	}
+}
+
 // Logf sets the logging function for the Tailscale server.  Tailscale is EXTREMELY chatty.
 // The default is to log to the standard logger.
 func Logf(f func(format string, args ...interface{})) Option {
@@ -118,3 +409,79 @@ func HookUp(fn func(*tsnet.Server, *ipnstate.Status) error) Option {
 		return nil
 	}
 }
+
+// Env is a rig.EnvParser that configures a Tailscale listener if any of the following are set:
+//
+//   - TAILSCALE_ADDR, the address to listen to, defaults to ":443"
+//   - TAILSCALE_HOSTNAME, see Hostname
+//   - TAILSCALE_DIR, see Dir
+//   - TAILSCALE_AUTH_KEY, see AuthKey
+//   - TAILSCALE_FUNNEL, a boolean, see Funnel
+//   - TAILSCALE_NO_TLS, a boolean, see NoTLS
+//   - TAILSCALE_EPHEMERAL, a boolean, see Ephemeral
+//   - TAILSCALE_REDIRECT_ADDR, an address that redirects HTTP to HTTPS, such as ":80", see Also and ModeRedirect
+func Env(env map[string]string) (rig.Option, error) {
+	found := false
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		if ok {
+			found = true
+			delete(env, key)
+		}
+		return value, ok
+	}
+	flag := func(key string, option Option) (Option, error) {
+		value, ok := lookup(key)
+		if !ok {
+			return nil, nil
+		}
+		set, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf(`%w in %v`, err, key)
+		}
+		if !set {
+			return nil, nil
+		}
+		return option, nil
+	}
+
+	address := `:443`
+	if value, ok := lookup(`TAILSCALE_ADDR`); ok {
+		address = value
+	}
+	var options []Option
+	if value, ok := lookup(`TAILSCALE_HOSTNAME`); ok {
+		options = append(options, Hostname(value))
+	}
+	if value, ok := lookup(`TAILSCALE_DIR`); ok {
+		options = append(options, Dir(value))
+	}
+	if value, ok := lookup(`TAILSCALE_AUTH_KEY`); ok {
+		options = append(options, AuthKey(value))
+	}
+	if value, ok := lookup(`TAILSCALE_REDIRECT_ADDR`); ok {
+		options = append(options, Also(value, ModeRedirect))
+	}
+	for _, it := range []struct {
+		key    string
+		option Option
+	}{
+		{`TAILSCALE_FUNNEL`, Funnel()},
+		{`TAILSCALE_NO_TLS`, NoTLS()},
+		{`TAILSCALE_EPHEMERAL`, Ephemeral(true)},
+	} {
+		option, err := flag(it.key, it.option)
+		if err != nil {
+			return nil, err
+		}
+		if option != nil {
+			options = append(options, option)
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return Rig(address, options...), nil
+}
+
+var _ rig.EnvParser = Env
@@ -0,0 +1,114 @@
+package tailscale
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig/hook"
+	tsclient "tailscale.com/client/tailscale"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tsnet"
+)
+
+// LocalClient returns an option that calls fn with the LocalClient of the Tailscale node once it is up, after the
+// functions given to HookUp, so the rig can ask the node about its status and its peers, such as with WhoIs.  If fn
+// returns an error, the Tailscale connection will be closed.
+func LocalClient(fn func(*tsclient.LocalClient) error) Option {
+	return HookUp(func(server *tsnet.Server, _ *ipnstate.Status) error {
+		lc, err := server.LocalClient()
+		if err != nil {
+			return err
+		}
+		return fn(lc)
+	})
+}
+
+// WhoIs returns an option that identifies the tailnet user and node behind each request using the LocalClient of the
+// Tailscale node, so handlers can use Identify for authorization.  The identity is passed to handlers as the
+// Tailscale-User-Login, Tailscale-User-Name and Tailscale-Node-Name headers, which is how it reaches the worker when
+// the rig is Run, and any such headers sent by clients are removed first, whichever listener they arrive on.
+//
+// Only connections that arrive over the tailnet can be identified.  Requests from the public internet through Funnel,
+// and requests to other listeners of the rig, have no identity.
+func WhoIs() Option {
+	return func(cfg *config) error {
+		cfg.whoIs = true
+		return nil
+	}
+}
+
+// Headers used by WhoIs to pass the identity of a request to its handler.
+const (
+	UserLoginHeader = `Tailscale-User-Login` // the login name of the user, such as "alice@example.com".
+	UserNameHeader  = `Tailscale-User-Name`  // the display name of the user, such as "Alice Smith".
+	NodeNameHeader  = `Tailscale-Node-Name`  // the fully qualified name of the node the user connected from.
+)
+
+// An Identity describes the tailnet user and node behind a request, see WhoIs.
+type Identity struct {
+	LoginName   string // The login name of the user, such as "alice@example.com".
+	DisplayName string // The display name of the user, such as "Alice Smith".
+	NodeName    string // The fully qualified domain name of the node, such as "laptop.example.ts.net.".
+}
+
+// Identify returns the identity that WhoIs found for the request, if any.  Identify trusts the headers of the request,
+// so it must only be used by rigs that use WhoIs, which removes those headers from requests it cannot identify.
+func Identify(r *http.Request) (Identity, bool) {
+	id := Identity{
+		LoginName:   r.Header.Get(UserLoginHeader),
+		DisplayName: r.Header.Get(UserNameHeader),
+		NodeName:    r.Header.Get(NodeNameHeader),
+	}
+	return id, id.LoginName != ``
+}
+
+// A whoIsHook is a hook.Server that identifies requests for WhoIs.
+type whoIsHook struct{ cfg *config }
+
+// whoIsChecked marks the context of a request whose identity headers have been removed, so servers with more than one
+// Tailscale node do not remove the identity found by another node.
+type whoIsChecked struct{}
+
+// RigServer implements hook.Server by identifying each request before it reaches the handler of the server.
+func (wh whoIsHook) RigServer(server *http.Server) {
+	next := server.Handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(whoIsChecked{}) == nil {
+			r.Header.Del(UserLoginHeader)
+			r.Header.Del(UserNameHeader)
+			r.Header.Del(NodeNameHeader)
+			r = r.WithContext(context.WithValue(r.Context(), whoIsChecked{}, true))
+		}
+		if r.Header.Get(UserLoginHeader) == `` {
+			wh.identify(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// identify adds the identity headers to a request if the Tailscale node knows who sent it.
+func (wh whoIsHook) identify(r *http.Request) {
+	if !wh.cfg.isUp() {
+		return
+	}
+	lc, err := wh.cfg.tsnet.LocalClient()
+	if err != nil {
+		hog.For(r).Warn().Err(err).Msg(`cannot reach the Tailscale local client`)
+		return
+	}
+	who, err := lc.WhoIs(r.Context(), r.RemoteAddr)
+	if err != nil || who.UserProfile == nil {
+		return // not a tailnet peer of this node.
+	}
+	r.Header.Set(UserLoginHeader, who.UserProfile.LoginName)
+	r.Header.Set(UserNameHeader, who.UserProfile.DisplayName)
+	if who.Node != nil {
+		r.Header.Set(NodeNameHeader, who.Node.Name)
+	}
+}
+
+var _ hook.Server = whoIsHook{}
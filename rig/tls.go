@@ -0,0 +1,175 @@
+package rig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/swdunlop/rig-go/rig/hook"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS returns an option that serves HTTPS using the certificate and key in the given PEM files, which are loaded when
+// the option is applied, so a renewed certificate is picked up by restarting the rig or handing it over, see Handover.
+// TLS is terminated on the TCP listeners of the rig, such as those of local.TCP, including those inherited from
+// systemd or an old supervisor; other listeners, like Unix sockets and those of tailscale, which terminates TLS itself,
+// are served as they are.
+//
+// Like other server hooks, TLS is terminated by the supervisor, or by a rig that is served directly, while the worker
+// behind the supervisor's proxy continues to speak cleartext over its Unix socket and sees "https" in the forwarded
+// protocol.  HTTP/2 is negotiated with clients that support it, and protocols added by ALPN are offered first.  See
+// TLSMinVersion and TLSCipherSuites to restrict the versions and cipher suites offered.
+func TLS(certFile, keyFile string) Option {
+	return func(cfg *Config) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf(`%w while loading TLS certificate %v`, err, certFile)
+		}
+		return cfg.updateTLS(func(tp *tlsPolicy) error {
+			if tp.getCertificate != nil {
+				return fmt.Errorf(`TLS certificate %v conflicts with a certificate given earlier`, certFile)
+			}
+			tp.getCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil }
+			return nil
+		})
+	}
+}
+
+// ACME returns an option that serves HTTPS like TLS does, using certificates for the given hosts obtained from Let's
+// Encrypt with the ACME protocol, accepting its terms of service.  Certificates are cached in the given directory, so
+// they survive restarts, and renewed before they expire.  Certificates are requested when clients first ask for one of
+// the hosts, using the TLS-ALPN-01 challenge, so the rig must be reachable on port 443 for each host; requests for other
+// hosts fail the TLS handshake.
+func ACME(cacheDir string, hosts ...string) Option {
+	return func(cfg *Config) error {
+		if cacheDir == `` {
+			return fmt.Errorf(`ACME needs a directory to cache certificates`)
+		}
+		if len(hosts) == 0 {
+			return fmt.Errorf(`ACME needs at least one host`)
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cacheDir),
+			HostPolicy: autocert.HostWhitelist(hosts...),
+		}
+		return cfg.updateTLS(func(tp *tlsPolicy) error {
+			if tp.getCertificate != nil {
+				return fmt.Errorf(`ACME conflicts with a certificate given earlier`)
+			}
+			tp.getCertificate = manager.GetCertificate
+			tp.nextProtos = append(tp.nextProtos, acme.ALPNProto)
+			return nil
+		})
+	}
+}
+
+// TLSMinVersion returns an option that sets the minimum version of TLS offered by TLS or ACME, such as
+// tls.VersionTLS13.  The default is TLS 1.2.  Like TLSCipherSuites, it also applies to certificates given to the server
+// by other server hooks, such as autocert.Rig.
+func TLSMinVersion(version uint16) Option {
+	return func(cfg *Config) error {
+		if version < tls.VersionTLS10 || version > tls.VersionTLS13 {
+			return fmt.Errorf(`unsupported TLS version %#04x`, version)
+		}
+		return cfg.updateTLS(func(tp *tlsPolicy) error {
+			tp.minVersion = version
+			return nil
+		})
+	}
+}
+
+// TLSCipherSuites returns an option that limits the cipher suites offered by TLS or ACME for TLS 1.2 and earlier,
+// such as tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384; the cipher suites of TLS 1.3 are not configurable.  Since
+// HTTP/2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, one of them must
+// be included.
+func TLSCipherSuites(suites ...uint16) Option {
+	return func(cfg *Config) error {
+		known := make(map[uint16]bool)
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			known[suite.ID] = true
+		}
+		for _, suite := range suites {
+			if !known[suite] {
+				return fmt.Errorf(`unknown TLS cipher suite %#04x`, suite)
+			}
+		}
+		if !slices.Contains(suites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) &&
+			!slices.Contains(suites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+			return fmt.Errorf(`TLS cipher suites must include one of those required by HTTP/2`)
+		}
+		return cfg.updateTLS(func(tp *tlsPolicy) error {
+			tp.cipherSuites = slices.Clone(suites)
+			return nil
+		})
+	}
+}
+
+// A tlsPolicy is the server hook added by TLS, ACME, TLSMinVersion and TLSCipherSuites.
+type tlsPolicy struct {
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error) // nil until TLS or ACME is applied
+	nextProtos     []string                                             // offered in addition to HTTP
+	minVersion     uint16                                               // zero for TLS 1.2
+	cipherSuites   []uint16                                             // nil for the defaults of crypto/tls
+}
+
+// updateTLS calls fn with the TLS policy of the rig, adding it if this is the first TLS option applied.
+func (cfg *Config) updateTLS(fn func(*tlsPolicy) error) error {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	if cfg.tls == nil {
+		cfg.tls = new(tlsPolicy)
+		cfg.hooks = append(cfg.hooks, cfg.tls)
+	}
+	return fn(cfg.tls)
+}
+
+var _ hook.Server = (*tlsPolicy)(nil)
+
+// RigServer implements hook.Server by giving the server a TLS configuration with the certificate, which serveListeners
+// uses to terminate TLS on TCP listeners.  Without a certificate, such as when only TLSMinVersion was given, the
+// server serves cleartext unless another server hook gives it a certificate.
+func (tp *tlsPolicy) RigServer(server *http.Server) {
+	if server.TLSConfig == nil {
+		server.TLSConfig = new(tls.Config)
+	}
+	conf := server.TLSConfig
+	if tp.getCertificate != nil {
+		conf.GetCertificate = tp.getCertificate
+	}
+	switch {
+	case tp.minVersion != 0:
+		conf.MinVersion = tp.minVersion
+	case conf.MinVersion == 0:
+		conf.MinVersion = tls.VersionTLS12
+	}
+	if tp.cipherSuites != nil {
+		conf.CipherSuites = tp.cipherSuites
+	}
+	// net/http only configures HTTP/2 for servers whose TLS configuration offers it.
+	for _, proto := range append([]string{`h2`, `http/1.1`}, tp.nextProtos...) {
+		if !slices.Contains(conf.NextProtos, proto) {
+			conf.NextProtos = append(conf.NextProtos, proto)
+		}
+	}
+}
+
+// terminateTLS returns the listeners with TLS terminated on those that accept TCP connections, if the server hooks gave
+// the server a certificate, such as with TLS or ACME.
+func terminateTLS(server *http.Server, listeners []net.Listener) []net.Listener {
+	conf := server.TLSConfig
+	if conf == nil || (len(conf.Certificates) == 0 && conf.GetCertificate == nil && conf.GetConfigForClient == nil) {
+		return listeners
+	}
+	terminated := make([]net.Listener, len(listeners))
+	for i, lr := range listeners {
+		terminated[i] = lr
+		if _, ok := lr.(*net.TCPListener); ok {
+			terminated[i] = tls.NewListener(lr, conf)
+		}
+	}
+	return terminated
+}
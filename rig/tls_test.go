@@ -0,0 +1,124 @@
+package rig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile, pool := testCertificate(t)
+	cfg, err := New(
+		TLS(certFile, keyFile),
+		TLSMinVersion(tls.VersionTLS13),
+		func(cfg *Config) error {
+			cfg.Hook(testMux{`ok`, `/`})
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr, err := net.Listen(`tcp`, `localhost:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- cfg.ServeListener(ctx, lr) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}}
+	defer client.CloseIdleConnections()
+	rsp, err := client.Get(`https://` + lr.Addr().String() + `/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if string(body) != `ok` || rsp.ProtoMajor != 2 || rsp.TLS.Version != tls.VersionTLS13 {
+		t.Fatalf(`expected ok over HTTP/2 and TLS 1.3, got %q over %v and %#04x`, body, rsp.Proto, rsp.TLS.Version)
+	}
+	client.CloseIdleConnections()
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`ServeListener did not return after the context was cancelled`)
+	}
+
+	for name, options := range map[string][]Option{
+		`missing certificate`: {TLS(filepath.Join(t.TempDir(), `cert.pem`), keyFile)},
+		`two certificates`:    {TLS(certFile, keyFile), TLS(certFile, keyFile)},
+		`TLS and ACME`:        {TLS(certFile, keyFile), ACME(t.TempDir(), `example.com`)},
+		`unknown version`:     {TLSMinVersion(0x0400)},
+		`no HTTP/2 suite`:     {TLSCipherSuites(tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384)},
+	} {
+		_, err := New(options...)
+		if err == nil {
+			t.Errorf(`expected an error for %v`, name)
+		}
+	}
+}
+
+// testCertificate writes a self-signed certificate for localhost and its key to a temporary directory, returning their
+// paths and a pool that trusts the certificate.
+func testCertificate(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: `localhost`},
+		DNSNames:     []string{`localhost`},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, `cert.pem`), filepath.Join(dir, `key.pem`)
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: der}), 0o600)
+	if err == nil {
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: `PRIVATE KEY`, Bytes: keyDER}), 0o600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, pool
+}
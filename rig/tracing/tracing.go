@@ -0,0 +1,154 @@
+// Package tracing defines the interface that RPC transports, like mrpc and jrpc, use to start a span for each request
+// they handle, and propagates W3C trace context in the "traceparent" header so those spans join the traces of their
+// clients.  It does not depend on a tracing library; an OpenTelemetry tracer can be adapted in a few lines:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+//		if p, ok := tracing.ParentOf(ctx); ok && !trace.SpanContextFromContext(ctx).IsValid() {
+//			ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+//				TraceID: p.TraceID, SpanID: p.SpanID, TraceFlags: trace.TraceFlags(p.Flags), Remote: true,
+//			}))
+//		}
+//		ctx, span := t.Tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+//		sc := span.SpanContext()
+//		ctx = tracing.WithParent(ctx, tracing.Parent{
+//			TraceID: sc.TraceID(), SpanID: sc.SpanID(), Flags: byte(sc.TraceFlags()),
+//		})
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ trace.Span }
+//
+//	func (s otelSpan) Fail(code int, msg string) {
+//		s.Span.SetAttributes(attribute.Int(`rpc.code`, code))
+//		s.Span.SetStatus(codes.Error, msg)
+//	}
+//
+//	func (s otelSpan) End() { s.Span.End() }
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// A Tracer starts a span for each RPC request, such as with mrpc.Trace or jrpc.Trace.  The name is the function or
+// method that was requested, and the context carries the Parent extracted from the request that opened the connection,
+// if any.  The context returned by Start becomes the parent of the scope of the request, so it should carry the span
+// for handlers that start spans of their own, and may use WithParent so clients dialed by handlers propagate it.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span describes the handling of a single request, see Tracer.
+type Span interface {
+	// Fail records that the request failed with a code, such as 504 when it timed out, and a message.
+	Fail(code int, msg string)
+
+	// End records that the request has been handled; the span is not used after End.
+	End()
+}
+
+// Header is the name of the header that carries a Parent, as described by the W3C Trace Context recommendation.  The
+// same name is used for the query parameter read by Extract.
+const Header = `traceparent`
+
+// A Parent identifies the span that caused a request, as carried by the "traceparent" header.
+type Parent struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte // Trace flags, see Sampled.
+}
+
+// Sampled returns true if the caller may have recorded its span, which suggests recording the spans of the request.
+func (p Parent) Sampled() bool { return p.Flags&1 != 0 }
+
+// String returns the parent in the format of the "traceparent" header, using version 00.
+func (p Parent) String() string {
+	return fmt.Sprintf(`00-%x-%x-%02x`, p.TraceID[:], p.SpanID[:], p.Flags)
+}
+
+// ParseParent parses the value of a "traceparent" header.  Values of later versions are accepted as long as they start
+// with the fields of version 00, as the recommendation requires.
+func ParseParent(value string) (Parent, error) {
+	var p Parent
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return p, fmt.Errorf(`invalid traceparent %q`, value)
+	}
+	var version, flags [1]byte
+	ok := decodeHex(version[:], value[0:2]) && decodeHex(p.TraceID[:], value[3:35]) &&
+		decodeHex(p.SpanID[:], value[36:52]) && decodeHex(flags[:], value[53:55])
+	p.Flags = flags[0]
+	switch {
+	case !ok, version[0] == 0xff:
+		return p, fmt.Errorf(`invalid traceparent %q`, value)
+	case version[0] == 0 && len(value) != 55, version[0] > 0 && len(value) > 55 && value[55] != '-':
+		return p, fmt.Errorf(`invalid traceparent %q`, value)
+	case p.TraceID == [16]byte{}, p.SpanID == [8]byte{}:
+		return p, fmt.Errorf(`traceparent %q has a zero ID`, value)
+	}
+	return p, nil
+}
+
+// decodeHex decodes lowercase hex into dst, returning false if src is not exactly that long or not lowercase.
+func decodeHex(dst []byte, src string) bool {
+	for _, ch := range src {
+		if (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') {
+			return false
+		}
+	}
+	n, err := hex.Decode(dst, []byte(src))
+	return err == nil && n == len(dst)
+}
+
+type parentKey struct{}
+
+// WithParent returns a context that carries the parent, see ParentOf.
+func WithParent(ctx context.Context, p Parent) context.Context {
+	return context.WithValue(ctx, parentKey{}, p)
+}
+
+// ParentOf returns the parent carried by a context, if any.
+func ParentOf(ctx context.Context) (Parent, bool) {
+	p, ok := ctx.Value(parentKey{}).(Parent)
+	return p, ok
+}
+
+// Extract returns the context of a request with the parent from its "traceparent" header, or its "traceparent" query
+// parameter if there is no header, since browsers cannot set headers when they open a WebSocket.  Invalid values are
+// ignored, as the recommendation requires, leaving the context as is.
+func Extract(r *http.Request) context.Context {
+	value := r.Header.Get(Header)
+	if value == `` {
+		value = r.URL.Query().Get(Header)
+	}
+	if value == `` {
+		return r.Context()
+	}
+	p, err := ParseParent(value)
+	if err != nil {
+		return r.Context()
+	}
+	return WithParent(r.Context(), p)
+}
+
+// Inject sets the "traceparent" header to the parent carried by the context, if any, such as to propagate it to the
+// service a client dials.
+func Inject(ctx context.Context, h http.Header) {
+	if p, ok := ParentOf(ctx); ok {
+		h.Set(Header, p.String())
+	}
+}
+
+// Middleware returns middleware, such as for api.Use, that extracts the parent of each request into its context, so
+// handlers and the Tracer of an RPC service behind other middleware find it with ParentOf.
+func Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(Extract(r)))
+		})
+	}
+}
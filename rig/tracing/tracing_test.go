@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseParent(t *testing.T) {
+	const value = `00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01`
+	p, err := ParseParent(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Sampled() || p.String() != value {
+		t.Fatalf(`expected a sampled parent that formats as %q, got %q`, value, p.String())
+	}
+	if _, err := ParseParent(`01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra`); err != nil {
+		t.Fatalf(`expected a later version with more fields to be accepted, got %v`, err)
+	}
+	for _, value := range []string{
+		``,
+		`00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01`,
+		`00-00000000000000000000000000000000-00f067aa0ba902b7-01`,
+		`00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01`,
+		`00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra`,
+		`ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01`,
+	} {
+		if _, err := ParseParent(value); err == nil {
+			t.Errorf(`expected %q to be rejected`, value)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	const value = `00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01`
+	r := httptest.NewRequest(`GET`, `/rpc?traceparent=`+value, nil)
+	p, ok := ParentOf(Extract(r))
+	if !ok || p.String() != value {
+		t.Fatalf(`expected the parent from the query, got %v`, p)
+	}
+	r.Header.Set(Header, `garbage`)
+	if _, ok := ParentOf(Extract(r)); ok {
+		t.Fatal(`expected an invalid header to be ignored`)
+	}
+	h := make(http.Header)
+	Inject(WithParent(context.Background(), p), h)
+	if h.Get(Header) != value {
+		t.Fatalf(`expected the parent to be injected, got %q`, h.Get(Header))
+	}
+}
@@ -0,0 +1,67 @@
+package rig
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/swdunlop/rig-go/rig/watcher"
+)
+
+// A WatchedPath is a directory being watched for changes, as listed at "/_rig/watched", see ServeWatched.
+type WatchedPath struct {
+	Source string `json:"source"` // "worker" for the watchers of a spawned worker, otherwise "rig".
+	Path   string `json:"path"`
+}
+
+// A watchedEndpoint serves the directories watched by the watchers in this process at "/_rig/watched", along with
+// those of the worker if it is served by a supervisor.
+type watchedEndpoint struct {
+	worker *http.Client // nil unless the endpoint is served by a supervisor
+}
+
+// RigMux adds the "/_rig/watched" endpoint.
+func (we watchedEndpoint) RigMux(mux *http.ServeMux) { mux.Handle(`GET /_rig/watched`, we) }
+
+// ServeHTTP serves the watched directories as JSON, ordered by source and path.
+func (we watchedEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var paths []WatchedPath
+	for _, wr := range watcher.Active() {
+		for _, path := range wr.Watched() {
+			paths = append(paths, WatchedPath{Source: `rig`, Path: path})
+		}
+	}
+	if we.worker != nil {
+		paths = append(paths, we.workerPaths(r)...)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Source != paths[j].Source {
+			return paths[i].Source < paths[j].Source
+		}
+		return paths[i].Path < paths[j].Path
+	})
+	w.Header().Set(`Content-Type`, `application/json`)
+	_ = json.NewEncoder(w).Encode(paths)
+}
+
+// workerPaths returns the paths watched by the worker, or nothing if the worker cannot be asked, such as while it is
+// restarting.
+func (we watchedEndpoint) workerPaths(r *http.Request) []WatchedPath {
+	req, err := http.NewRequestWithContext(r.Context(), `GET`, `http://rig/_rig/watched`, nil)
+	if err != nil {
+		return nil
+	}
+	rsp, err := we.worker.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer rsp.Body.Close()
+	var paths []WatchedPath
+	if rsp.StatusCode != http.StatusOK || json.NewDecoder(rsp.Body).Decode(&paths) != nil {
+		return nil
+	}
+	for i := range paths {
+		paths[i].Source = `worker`
+	}
+	return paths
+}
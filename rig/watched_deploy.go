@@ -0,0 +1,9 @@
+//go:build deploy
+// +build deploy
+
+package rig
+
+// ServeWatched does nothing in builds with the deploy tag; see the development build for details.
+func ServeWatched() Option {
+	return func(cfg *Config) error { return nil }
+}
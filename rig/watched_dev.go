@@ -0,0 +1,19 @@
+//go:build !deploy
+// +build !deploy
+
+package rig
+
+// ServeWatched returns an option that lists the directories being watched for changes as JSON at "/_rig/watched", to
+// diagnose changes that do not seem to trigger a rebuild or reload.  When the rig spawns a worker, the supervisor
+// serves the endpoint, listing both its own watches, such as those of esbuild, and those of the worker.
+//
+// This option does nothing in builds with the deploy tag.
+func ServeWatched() Option {
+	return func(cfg *Config) error {
+		cfg.control.Lock()
+		cfg.watched = true
+		cfg.control.Unlock()
+		cfg.Hook(watchedEndpoint{})
+		return nil
+	}
+}
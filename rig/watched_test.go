@@ -0,0 +1,63 @@
+package rig
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/swdunlop/rig-go/rig/watcher"
+)
+
+func TestServeWatched(t *testing.T) {
+	dir := t.TempDir()
+	wr, err := watcher.Start(watcher.Directory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Shutdown()
+	lr := &testListener{addr: make(chan string, 1)}
+	var cfg *Config
+	cfg, err = New(
+		ServeWatched(),
+		func(cfg *Config) error { cfg.Hook(lr); return nil },
+		WorkerFunc(func(ctx context.Context, socket string) error {
+			ul, err := net.Listen(`unix`, socket)
+			if err != nil {
+				return err
+			}
+			srv := &http.Server{Handler: cfg.Handler()} // in process, so the worker sees the same watchers.
+			go func() {
+				<-ctx.Done()
+				srv.Close()
+			}()
+			return srv.Serve(ul)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = cfg.Spawn(ctx, `unused`) }()
+	rsp, err := http.Get(`http://` + <-lr.addr + `/_rig/watched`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	var paths []WatchedPath
+	err = json.NewDecoder(rsp.Body).Decode(&paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, it := range paths {
+		if it.Path == dir {
+			seen[it.Source] = true
+		}
+	}
+	if !seen[`rig`] || !seen[`worker`] {
+		t.Fatalf(`expected %v to be watched by both the supervisor and the worker, got %+v`, dir, paths)
+	}
+}
@@ -0,0 +1,159 @@
+package watcher
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// GitIgnore excludes files that are ignored by ".gitignore" files in the watched directories, including nested ones,
+// in addition to any patterns given to Exclude.  Ignored directories, and ".git" directories, are not watched at all,
+// which saves a great deal of effort for directories like "node_modules".
+//
+// Each ".gitignore" file is read when its directory is first watched, so changes to it are not noticed until the
+// watcher is restarted.  Files ignored by a ".gitignore" above the watched directories are not excluded.  Like git, the
+// last matching pattern decides whether a file is ignored, but unlike git, a negated pattern may include a file in a
+// directory that is ignored.
+func GitIgnore() Option {
+	return func(wr *watcher) error {
+		wr.gitIgnore = true
+		return nil
+	}
+}
+
+// enterDir returns filepath.SkipDir if a directory should not be watched because it is ignored, or else loads its
+// ".gitignore" file when GitIgnore is used.
+func (wr *watcher) enterDir(path string, entry fs.DirEntry) error {
+	if !wr.gitIgnore {
+		return nil
+	}
+	if entry.Name() == `.git` || wr.ignored(path, true) {
+		return filepath.SkipDir
+	}
+	return wr.loadGitIgnore(path)
+}
+
+// An ignoreRule is a pattern from a ".gitignore" file.
+type ignoreRule struct {
+	dir      string    // the absolute path of the directory containing the ".gitignore" file
+	pattern  glob.Glob // matches paths relative to dir, using "/" as a separator
+	anchored bool      // if false, the pattern matches the base name of a path at any depth
+	negated  bool      // if true, paths matching the pattern are included instead of ignored
+	dirOnly  bool      // if true, the pattern only matches directories
+}
+
+// loadGitIgnore appends the rules from the ".gitignore" file in dir, if it has one and it has not been loaded already.
+func (wr *watcher) loadGitIgnore(dir string) error {
+	name, err := filepath.Abs(filepath.Join(dir, `.gitignore`))
+	if err != nil || wr.ignoreFiles[name] {
+		return err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if wr.ignoreFiles == nil {
+		wr.ignoreFiles = make(map[string]bool)
+	}
+	wr.ignoreFiles[name] = true
+	dir = filepath.Dir(name)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rule, ok, err := parseIgnoreRule(dir, scanner.Text())
+		if err != nil {
+			return fmt.Errorf(`%w in %v`, err, f.Name())
+		}
+		if ok {
+			wr.ignoreRules = append(wr.ignoreRules, rule)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseIgnoreRule parses a line of a ".gitignore" file, returning false if it is blank or a comment.
+func parseIgnoreRule(dir, line string) (ignoreRule, bool, error) {
+	rule := ignoreRule{dir: dir}
+	line = strings.TrimRight(line, " \t\r")
+	if line == `` || strings.HasPrefix(line, `#`) {
+		return rule, false, nil
+	}
+	if strings.HasPrefix(line, `!`) {
+		rule.negated, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:] // escapes a leading "#" or "!"
+	}
+	if strings.HasSuffix(line, `/`) {
+		rule.dirOnly, line = true, strings.TrimRight(line, `/`)
+	}
+	if line == `` {
+		return rule, false, nil
+	}
+	rule.anchored = strings.Contains(line, `/`)
+	line = strings.TrimPrefix(line, `/`)
+
+	// A "**" between slashes may match no directories at all, which gobwas/glob will not do on its own.
+	line = strings.ReplaceAll(line, `/**/`, `{/,/**/}`)
+	if strings.HasPrefix(line, `**/`) {
+		line = `{,**/}` + line[3:]
+	}
+	var err error
+	rule.pattern, err = glob.Compile(line, '/')
+	if err != nil {
+		return rule, false, fmt.Errorf(`%w in pattern %q`, err, line)
+	}
+	return rule, true, nil
+}
+
+// ignored returns true if a path is ignored by the rules from ".gitignore" files.
+func (wr *watcher) ignored(name string, isDir bool) bool {
+	if len(wr.ignoreRules) == 0 {
+		return false
+	}
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	ignored := false
+	for _, rule := range wr.ignoreRules {
+		if rule.match(name, isDir) {
+			ignored = !rule.negated
+		}
+	}
+	return ignored
+}
+
+// match returns true if the rule matches a path or any of the directories containing it beneath the rule's directory.
+func (rule *ignoreRule) match(name string, isDir bool) bool {
+	rel, err := filepath.Rel(rule.dir, name)
+	if err != nil || rel == `.` || rel == `..` || strings.HasPrefix(rel, `..`+string(filepath.Separator)) {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for candidate := rel; ; {
+		candidateDir := candidate != rel || isDir
+		if candidateDir || !rule.dirOnly {
+			subject := candidate
+			if !rule.anchored {
+				subject = path.Base(candidate)
+			}
+			if rule.pattern.Match(subject) {
+				return true
+			}
+		}
+		parent := path.Dir(candidate)
+		if parent == `.` {
+			return false
+		}
+		candidate = parent
+	}
+}
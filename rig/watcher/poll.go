@@ -0,0 +1,90 @@
+package watcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Poll checks the watched directories for changes at the given interval instead of relying on fsnotify, which does not
+// work on some filesystems, such as network shares and some container volumes.  Polling is slower to notice changes and
+// walks every watched directory on each interval, so GitIgnore and a short list of directories help a great deal.
+//
+// If fsnotify cannot be started, such as when the system has run out of inotify instances, the watcher polls once per
+// second without this option.
+func Poll(interval time.Duration) Option {
+	return func(wr *watcher) error {
+		wr.poll = interval
+		return nil
+	}
+}
+
+// defaultPoll is the interval used when fsnotify cannot be started.
+const defaultPoll = time.Second
+
+// A fileState is what a poll remembers about a file to notice when it changes.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// scan walks the watched directories and returns the state of each file found.
+func (wr *watcher) scan() (map[string]fileState, error) {
+	files := make(map[string]fileState, len(wr.files))
+	var dirs []string
+	for _, dir := range wr.directories {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			switch {
+			case err != nil && path == dir:
+				return err
+			case err != nil:
+				return nil // the file may have been removed since its directory was read.
+			case entry.IsDir():
+				err := wr.enterDir(path, entry)
+				if err == nil {
+					dirs = append(dirs, path)
+				}
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			files[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	wr.polledMu.Lock()
+	wr.polled = dirs
+	wr.polledMu.Unlock()
+	return files, nil
+}
+
+// processPoll scans the watched directories and issues an alert for each file that was created, written or removed
+// since the last poll.
+func (wr *watcher) processPoll() {
+	files, err := wr.scan()
+	if err != nil {
+		return // a watched directory may be missing for a moment, so we will try again on the next poll.
+	}
+	for path, state := range files {
+		previous, ok := wr.files[path]
+		switch {
+		case !ok:
+			wr.issueAlert(fsnotify.Event{Name: path, Op: fsnotify.Create | fsnotify.Write})
+		case !previous.modTime.Equal(state.modTime) || previous.size != state.size:
+			wr.issueAlert(fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+	}
+	for path := range wr.files {
+		if _, ok := files[path]; !ok {
+			wr.issueAlert(fsnotify.Event{Name: path, Op: fsnotify.Remove})
+		}
+	}
+	wr.files = files
+}
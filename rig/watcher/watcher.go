@@ -5,6 +5,10 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/gobwas/glob"
@@ -68,61 +72,155 @@ func Directory(paths ...string) Option {
 	}
 }
 
+// Debounce coalesces a burst of changes into a single alert that is sent once no changes have been observed for the
+// given interval, such as when an editor saves several files at once.  An alert is not dropped while debouncing; if
+// nobody is receiving when the burst settles, the alert is sent when they next receive, unless more changes start another burst.
+// Without Debounce, an alert is sent immediately for each change but dropped if nobody is receiving.
+func Debounce(interval time.Duration) Option {
+	return func(wr *watcher) error {
+		wr.debounce = interval
+		return nil
+	}
+}
+
 // Interface describes the watcher interface
 type Interface interface {
+	// Alert returns a channel that receives a value when the watcher observes a change, see Debounce.
 	Alert() <-chan struct{}
+
+	// Events returns a channel that receives each change observed by the watcher, for consumers that need to know
+	// what changed.  Events are not debounced, and are dropped if the channel is full, so consumers that must not
+	// miss a change should also use Alert.
+	Events() <-chan Event
+
+	// Watched returns the directories currently being watched, for debugging watches that seem to miss changes.  It
+	// may be called at any time, even while the watcher is processing changes.
+	Watched() []string
+
 	Shutdown()
 }
 
+// Active returns the watchers in this process that have been started and not shut down, for debugging.
+func Active() []Interface {
+	active.Lock()
+	defer active.Unlock()
+	ret := make([]Interface, 0, len(active.watchers))
+	for wr := range active.watchers {
+		ret = append(ret, wr)
+	}
+	return ret
+}
+
+// active tracks the watchers returned by Active.
+var active struct {
+	sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+// An Event describes a change observed by the watcher.
+type Event struct {
+	Name string      // The path of the file or directory that changed.
+	Op   fsnotify.Op // The operations that changed it, such as fsnotify.Write or fsnotify.Rename.
+}
+
+// eventBuffer is the capacity of the channel returned by Events.
+const eventBuffer = 256
+
 type watcher struct {
 	includes    []glob.Glob
 	excludes    []glob.Glob
 	directories []string
+	debounce    time.Duration
+	gitIgnore   bool
+	poll        time.Duration // if not zero, directories are polled at this interval instead of using fsnotify
 
-	fsnotify   *fsnotify.Watcher
-	alertCh    chan struct{} // sent when the watcher has observed a change
-	shutdownCh chan struct{} // sent when the watcher should shut down
-	doneCh     chan struct{} // closed when the watcher is done
+	// These are only used while starting and then by the process goroutine when GitIgnore is used.
+	ignoreRules []ignoreRule
+	ignoreFiles map[string]bool // the absolute paths of the ".gitignore" files that have been loaded
+
+	fsnotify   *fsnotify.Watcher    // nil when polling
+	files      map[string]fileState // the files seen by the last poll
+	alertCh    chan struct{}        // sent when the watcher has observed a change
+	eventCh    chan Event           // sent each change the watcher has observed, if there is room
+	shutdownCh chan struct{}        // sent when the watcher should shut down
+	doneCh     chan struct{}        // closed when the watcher is done
+
+	// These are guarded by polledMu, since Watched may be called while polling.
+	polledMu sync.Mutex
+	polled   []string // the directories walked by the last poll
+
+	// These are only used by the process goroutine when debouncing.
+	settle  <-chan time.Time // fires when a burst of changes has settled
+	pending bool             // true if an alert should be sent
 }
 
 func (wr *watcher) start() (err error) {
-	wr.fsnotify, err = fsnotify.NewWatcher()
-	if err != nil {
-		return err
-	}
 	if len(wr.directories) == 0 {
 		wr.directories = []string{`.`}
 	}
 	if len(wr.excludes) == 0 {
 		wr.excludes = []glob.Glob{glob.MustCompile(`.*`, filepath.Separator)}
 	}
-	for _, dir := range wr.directories {
-		err := filepath.WalkDir(dir, func(path string, info fs.DirEntry, err error) error {
+	if wr.poll == 0 {
+		wr.fsnotify, err = fsnotify.NewWatcher()
+		if err != nil {
+			// This can happen if the system has run out of inotify instances, polling is slower but still works.
+			wr.poll = defaultPoll
+		}
+	}
+	if wr.poll > 0 {
+		wr.files, err = wr.scan()
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, dir := range wr.directories {
+			err := wr.addTree(dir)
 			if err != nil {
+				wr.fsnotify.Close()
 				return err
 			}
-			if info.IsDir() {
-				return wr.fsnotify.Add(path)
-			}
-			return nil
-		})
-		if err != nil {
-			wr.fsnotify.Close()
-			return err
 		}
 	}
 	wr.alertCh = make(chan struct{})
+	wr.eventCh = make(chan Event, eventBuffer)
 	wr.shutdownCh = make(chan struct{})
 	wr.doneCh = make(chan struct{})
+	active.Lock()
+	if active.watchers == nil {
+		active.watchers = make(map[*watcher]struct{})
+	}
+	active.watchers[wr] = struct{}{}
+	active.Unlock()
 	go wr.process()
 	return nil
 }
 
+func (wr *watcher) Watched() []string {
+	var watched []string
+	if wr.fsnotify != nil {
+		watched = wr.fsnotify.WatchList() // fsnotify guards its own list.
+	} else {
+		wr.polledMu.Lock()
+		watched = append(watched, wr.polled...)
+		wr.polledMu.Unlock()
+	}
+	sort.Strings(watched)
+	return watched
+}
+
 func (wr *watcher) Alert() <-chan struct{} {
 	return wr.alertCh
 }
 
+func (wr *watcher) Events() <-chan Event {
+	return wr.eventCh
+}
+
 func (wr *watcher) Shutdown() {
+	active.Lock()
+	delete(active.watchers, wr)
+	active.Unlock()
 	select {
 	case wr.shutdownCh <- struct{}{}:
 	case <-wr.doneCh:
@@ -130,13 +228,32 @@ func (wr *watcher) Shutdown() {
 }
 
 func (wr *watcher) process() {
+	var events <-chan fsnotify.Event
+	var polls <-chan time.Time
+	if wr.fsnotify != nil {
+		events = wr.fsnotify.Events
+	} else {
+		ticker := time.NewTicker(wr.poll)
+		defer ticker.Stop()
+		polls = ticker.C
+	}
 	for {
+		var alertCh chan<- struct{} // only set when a debounced alert is pending
+		if wr.pending {
+			alertCh = wr.alertCh
+		}
 		select {
 		case <-wr.shutdownCh:
 			close(wr.doneCh)
 			return
-		case event := <-wr.fsnotify.Events:
+		case event := <-events:
 			wr.processNotification(event)
+		case <-polls:
+			wr.processPoll()
+		case <-wr.settle:
+			wr.settle, wr.pending = nil, true
+		case alertCh <- struct{}{}:
+			wr.pending = false
 		}
 	}
 }
@@ -148,23 +265,70 @@ func (wr *watcher) processNotification(event fsnotify.Event) {
 			return
 		}
 		if info.IsDir() {
-			wr.fsnotify.Add(event.Name)
+			// A new directory may have been renamed from elsewhere with its children, so we watch the whole tree.
+			_ = wr.addTree(event.Name)
 			return // creating a new directory should not issue an alert, but we should watch it
 		}
 	}
 
 	if event.Has(fsnotify.Write) {
-		wr.issueAlert(event.Name)
+		wr.issueAlert(event)
 	} else if event.Has(fsnotify.Remove) {
-		_ = wr.fsnotify.Remove(event.Name)
-		wr.issueAlert(event.Name)
+		wr.removeTree(event.Name)
+		wr.issueAlert(event)
 	} else if event.Has(fsnotify.Rename) {
-		wr.issueAlert(event.Name)
+		info, err := os.Stat(event.Name)
+		if err == nil && info.IsDir() {
+			// fsnotify drops the watch of a directory when it is moved, and reports it using the latest path for the
+			// directory, which may be the new path we already watched when it was created.
+			_ = wr.addTree(event.Name)
+		} else {
+			// The watches of the children of a directory follow it when it is moved, but fsnotify still knows them by
+			// their old paths, so we forget them before watching the new path.
+			wr.removeTree(event.Name)
+		}
+		wr.issueAlert(event)
+	}
+}
+
+// addTree watches a directory and all of its subdirectories.
+func (wr *watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, info fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		err = wr.enterDir(path, info)
+		if err != nil {
+			return err
+		}
+		return wr.fsnotify.Add(path)
+	})
+}
+
+// removeTree stops watching a path and anything watched beneath it.
+func (wr *watcher) removeTree(name string) {
+	name = filepath.Clean(name)
+	prefix := name + string(filepath.Separator)
+	for _, path := range wr.fsnotify.WatchList() {
+		if path == name || strings.HasPrefix(path, prefix) {
+			_ = wr.fsnotify.Remove(path)
+		}
 	}
 }
 
-func (wr *watcher) issueAlert(name string) {
-	if !wr.shouldInclude(name) {
+func (wr *watcher) issueAlert(event fsnotify.Event) {
+	if !wr.shouldInclude(event.Name) {
+		return
+	}
+	select {
+	case wr.eventCh <- Event{Name: event.Name, Op: event.Op}:
+	default:
+	}
+	if wr.debounce > 0 {
+		wr.settle, wr.pending = time.After(wr.debounce), false
 		return
 	}
 	select {
@@ -182,7 +346,7 @@ func (wr *watcher) shouldInclude(name string) bool {
 			break
 		}
 	}
-	if !included {
+	if !included || wr.ignored(name, false) {
 		return false
 	}
 	for _, rx := range wr.excludes {
@@ -192,5 +356,3 @@ func (wr *watcher) shouldInclude(name string) bool {
 	}
 	return true
 }
-
-//TODO: BUG: if a directory is renamed, the watcher may not watch the new name.
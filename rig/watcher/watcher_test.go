@@ -0,0 +1,227 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestRenamedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, `old`, `child`), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wr, err := Start(Directory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Shutdown()
+
+	err = os.Rename(filepath.Join(dir, `old`), filepath.Join(dir, `new`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet(wr)
+
+	for _, name := range []string{`new/file`, `new/child/file`} {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(`changed`), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-wr.Alert():
+		case <-time.After(2 * time.Second):
+			t.Fatalf(`expected an alert after writing %v`, name)
+		}
+		quiet(wr)
+	}
+}
+
+// quiet consumes alerts until the watcher has been quiet for a moment.
+func quiet(wr Interface) {
+	for {
+		select {
+		case <-wr.Alert():
+		case <-time.After(100 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	dir := t.TempDir()
+	wr, err := Start(Directory(dir), Debounce(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Shutdown()
+
+	for i := 0; i < 10; i++ {
+		err = os.WriteFile(filepath.Join(dir, `file`), []byte{byte(i)}, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond) // the alert must not be dropped while nobody is receiving.
+	select {
+	case <-wr.Alert():
+	case <-time.After(2 * time.Second):
+		t.Fatal(`expected an alert after the burst settled`)
+	}
+	select {
+	case <-wr.Alert():
+		t.Fatal(`expected the burst to be coalesced into one alert`)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestEvents(t *testing.T) {
+	dir := t.TempDir()
+	wr, err := Start(Directory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Shutdown()
+
+	name := filepath.Join(dir, `file`)
+	err = os.WriteFile(name, []byte(`changed`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		select {
+		case event := <-wr.Events():
+			if event.Name == name && event.Op.Has(fsnotify.Write) {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal(`expected a write event for the file`)
+		}
+	}
+}
+
+func TestGitIgnore(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		`.gitignore`:                "# dependencies\nnode_modules/\n*.log\n!keep.log\n/build\ndocs/**/draft.md\n",
+		`sub/.gitignore`:            "secret.txt\n",
+		`node_modules/pkg/index.js`: ``,
+		`build/app.js`:              ``,
+		`src/build/app.js`:          ``,
+		`.git/HEAD`:                 ``,
+	} {
+		name = filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(name), 0o755)
+		if err == nil {
+			err = os.WriteFile(name, []byte(content), 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wr, err := Start(Directory(dir), GitIgnore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Shutdown()
+	impl := wr.(*watcher)
+
+	for name, ignored := range map[string]bool{
+		`app.log`:                   true,
+		`keep.log`:                  false,
+		`build/app.js`:              true,
+		`src/build/app.js`:          false,
+		`node_modules/pkg/index.js`: true,
+		`sub/secret.txt`:            true,
+		`secret.txt`:                false,
+		`src/app.js`:                false,
+		`docs/draft.md`:             true,
+		`docs/a/b/draft.md`:         true,
+	} {
+		if impl.ignored(filepath.Join(dir, name), false) != ignored {
+			t.Errorf(`expected %v to be ignored: %v`, name, ignored)
+		}
+	}
+	for _, path := range impl.fsnotify.WatchList() {
+		rel, _ := filepath.Rel(dir, path)
+		switch rel {
+		case `node_modules`, `build`, `.git`:
+			t.Errorf(`expected %v not to be watched`, rel)
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, `sub`, `file`)
+	err := os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wr, err := Start(Directory(dir), Poll(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Shutdown()
+	if wr.(*watcher).fsnotify != nil {
+		t.Fatal(`expected the watcher to poll instead of using fsnotify`)
+	}
+
+	for _, step := range []struct {
+		op    fsnotify.Op
+		apply func() error
+	}{
+		{fsnotify.Create, func() error { return os.WriteFile(name, []byte(`created`), 0o644) }},
+		{fsnotify.Write, func() error { return os.WriteFile(name, []byte(`changed`), 0o644) }},
+		{fsnotify.Remove, func() error { return os.Remove(name) }},
+	} {
+		err = step.apply()
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case event := <-wr.Events():
+			if event.Name != name || !event.Op.Has(step.op) {
+				t.Fatalf(`expected %v of %v, got %+v`, step.op, name, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf(`expected %v of %v to be polled`, step.op, name)
+		}
+	}
+}
+
+func TestWatched(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, `child`), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, options := range [][]Option{{Directory(dir)}, {Directory(dir), Poll(time.Hour)}} {
+		wr, err := Start(options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		watched := wr.Watched()
+		if len(watched) != 2 || watched[0] != dir || watched[1] != filepath.Join(dir, `child`) {
+			t.Errorf(`expected %v and its child to be watched, got %v`, dir, watched)
+		}
+		found := false
+		for _, it := range Active() {
+			found = found || it == wr
+		}
+		wr.Shutdown()
+		if !found {
+			t.Error(`expected the watcher to be active until it is shut down`)
+		}
+		for _, it := range Active() {
+			if it == wr {
+				t.Error(`expected the watcher to be inactive once it is shut down`)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package rig
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestWorkerRestartsLeaveNoZombies(t *testing.T) {
+	if runtime.GOOS != `linux` {
+		t.Skip(`requires /proc to find child processes`)
+	}
+	sh, err := shellPath(`/bin/sh`)
+	if err != nil {
+		t.Skip(err)
+	}
+	ctx := context.Background()
+	addr := filepath.Join(t.TempDir(), `socket`)
+	for i := 0; i < 50; i++ {
+		// Alternate between workers that crash on their own and workers that are stopped by the supervisor.
+		script := `exit 1`
+		if i%2 == 0 {
+			script = `exec sleep 60`
+		}
+		wk, err := startWorker(ctx, addr, nil, sh, []string{`-c`, script}, io.Discard, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			wk.stop()
+		} else {
+			<-wk.done()
+		}
+	}
+	if children := childProcesses(t); len(children) > 0 {
+		t.Fatalf(`leaked child processes: %v`, children)
+	}
+}
+
+// shellPath returns path if it is an executable file.
+func shellPath(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ``, err
+	}
+	if info.Mode()&0o111 == 0 {
+		return ``, os.ErrPermission
+	}
+	return path, nil
+}
+
+// childProcesses returns the IDs of processes, including zombies, whose parent is this process.
+func childProcesses(t *testing.T) []int {
+	pid := os.Getpid()
+	matches, err := filepath.Glob(`/proc/[0-9]*/stat`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var children []int
+	for _, path := range matches {
+		stat, err := os.ReadFile(path)
+		if err != nil {
+			continue // the process has exited
+		}
+		// The command name is parenthesized and may contain spaces, so fields are counted after the last ")".
+		i := bytes.LastIndexByte(stat, ')')
+		if i < 0 {
+			continue
+		}
+		fields := bytes.Fields(stat[i+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		ppid, err := strconv.Atoi(string(fields[1]))
+		if err != nil || ppid != pid {
+			continue
+		}
+		child, _ := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		children = append(children, child)
+	}
+	return children
+}
@@ -0,0 +1,72 @@
+//go:build unix
+
+package rig
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWorkerSocket(t *testing.T) {
+	for _, inherit := range []bool{false, true} {
+		addr := filepath.Join(t.TempDir(), `socket`)
+		t.Setenv(`RIG_SOCKET`, addr)
+		t.Setenv(`RIG_SOCKET_FD`, ``)
+		if inherit {
+			// The supervisor binds the socket and passes it on, so the worker would fail if it bound the address too.
+			socket, err := bindSocket(context.Background(), addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fd, err := syscall.Dup(int(socket.Fd())) // the worker closes its descriptor once it is listening.
+			socket.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv(`RIG_SOCKET_FD`, strconv.Itoa(fd))
+		}
+		cfg, err := New(func(cfg *Config) error {
+			cfg.Hook(testMux{`worker`, `/`})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() { errCh <- cfg.Run(ctx) }()
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, `unix`, addr)
+			},
+		}}
+		var body []byte
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			rsp, err := client.Get(`http://worker/`)
+			if err != nil {
+				continue // the worker binds the socket itself unless it inherited it.
+			}
+			body, _ = io.ReadAll(rsp.Body)
+			rsp.Body.Close()
+			break
+		}
+		client.CloseIdleConnections()
+		cancel()
+		err = <-errCh
+		if err != nil {
+			t.Fatalf(`expected the worker to serve with inherit %v, got %v`, inherit, err)
+		}
+		if string(body) != `worker` {
+			t.Fatalf(`expected the worker to respond with inherit %v, got %q`, inherit, body)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package rig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/swdunlop/html-go/hog"
+)
+
+// WorkerFunc returns an option that makes Spawn and Run call fn in a goroutine of the supervisor instead of starting
+// a child process as the worker, so tests can exercise the supervisor, such as its proxy and the endpoints it serves
+// itself, without building an executable.  fn should listen to the Unix domain socket at the given path, since the
+// supervisor cannot hand a listening socket to a goroutine, and serve HTTP requests on it until ctx is cancelled.  The
+// supervisor waits for the socket to accept connections before it starts serving.
+//
+// Since nothing is executed, changes that would rebuild and restart a worker process do not affect fn.  Instead, if
+// fn returns before the supervisor stops, it is logged and fn is called again after WorkerFuncRestart.
+func WorkerFunc(fn func(ctx context.Context, socket string) error) Option {
+	return func(cfg *Config) error {
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		cfg.workerFunc = fn
+		return nil
+	}
+}
+
+// WorkerFuncRestart is the delay before calling a WorkerFunc again after it returns.
+var WorkerFuncRestart = 100 * time.Millisecond
+
+// workerFn returns the function set by WorkerFunc, if any.
+func (cfg *Config) workerFn() func(ctx context.Context, socket string) error {
+	cfg.control.Lock()
+	defer cfg.control.Unlock()
+	return cfg.workerFunc
+}
+
+// startWorkerFunc calls fn in a goroutine as a worker for the given address, calling it again whenever it returns,
+// until the worker is stopped or ctx is cancelled.
+func startWorkerFunc(ctx context.Context, addr string, fn func(ctx context.Context, socket string) error) *worker {
+	ctx, cancel := context.WithCancel(ctx)
+	wk := &worker{cancel: cancel, doneCh: make(chan struct{})}
+	go func() {
+		defer close(wk.doneCh)
+		for {
+			wk.err = fn(ctx, addr)
+			if ctx.Err() != nil {
+				return
+			}
+			hog.From(ctx).Warn().Err(wk.err).Msg(`worker function returned, restarting it`)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(WorkerFuncRestart):
+			}
+		}
+	}()
+	return wk
+}
+
+// awaitSocket waits until the Unix domain socket at addr accepts connections, or ctx is cancelled.
+func awaitSocket(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, `unix`, addr)
+		if err == nil {
+			return conn.Close()
+		}
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ECONNREFUSED) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(`%w while waiting for the worker to listen to %v`, ctx.Err(), addr)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
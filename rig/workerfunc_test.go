@@ -0,0 +1,72 @@
+package rig
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWorkerFunc(t *testing.T) {
+	var calls atomic.Int32
+	lr := &testListener{addr: make(chan string, 1)}
+	cfg, err := New(
+		func(cfg *Config) error { cfg.Hook(lr); return nil },
+		WorkerFunc(func(ctx context.Context, socket string) error {
+			if calls.Add(1) == 1 {
+				return errors.New(`failed to start`) // the supervisor should call us again.
+			}
+			ul, err := net.Listen(`unix`, socket)
+			if err != nil {
+				return err
+			}
+			srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, `hello `+r.Header.Get(`X-Forwarded-Host`))
+			})}
+			go func() {
+				<-ctx.Done()
+				srv.Close()
+			}()
+			return srv.Serve(ul)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	spawned := make(chan error, 1)
+	go func() { spawned <- cfg.Spawn(ctx, `unused`) }()
+	addr := <-lr.addr
+
+	rsp, err := http.Get(`http://` + addr + `/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if string(body) != `hello `+addr {
+		t.Fatalf(`expected the worker function to answer through the proxy, got %q`, body)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf(`expected the worker function to be restarted once, got %v calls`, calls.Load())
+	}
+	cancel()
+	err = <-spawned
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A testListener is a Listen hook that reports the address of the listener it creates.
+type testListener struct{ addr chan string }
+
+func (tl *testListener) Listen(ctx context.Context) (net.Listener, error) {
+	lr, err := net.Listen(`tcp`, `localhost:0`)
+	if err == nil {
+		tl.addr <- lr.Addr().String()
+	}
+	return lr, err
+}
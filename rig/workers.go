@@ -0,0 +1,208 @@
+package rig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig/hook"
+)
+
+// Worker returns an option that runs fn in the background while the rig is serving, such as to consume a queue.  If
+// fn fails or panics, it is restarted after a backoff delay, logging each restart with the name of the worker; if fn
+// returns nil, it is not restarted.  This is the goroutine analog of the supervisor restarting a worker process that
+// crashes.
+//
+// Like BeforeServe, workers are started in the worker process or when the rig is served directly, not by the
+// supervisor.  The context passed to fn is cancelled when the rig stops serving, and Serve does not return until
+// every worker has returned.  The state of each worker is listed as JSON at "/_rig/workers".
+func Worker(name string, fn func(ctx context.Context) error, options ...WorkerOption) Option {
+	return func(cfg *Config) error {
+		bw := &backgroundWorker{
+			name:       name,
+			fn:         fn,
+			minBackoff: time.Second,
+			maxBackoff: time.Minute,
+			state:      WorkerStatus{Name: name, State: `idle`},
+		}
+		for _, option := range options {
+			option(bw)
+		}
+		if bw.minBackoff <= 0 || bw.maxBackoff < bw.minBackoff {
+			return fmt.Errorf(`invalid backoff for worker %q`, name)
+		}
+		cfg.control.Lock()
+		defer cfg.control.Unlock()
+		for _, other := range cfg.workers {
+			if other.name == name {
+				return fmt.Errorf(`duplicate worker %q`, name)
+			}
+		}
+		if len(cfg.workers) == 0 {
+			cfg.hooks = append(cfg.hooks, workersHook{cfg})
+		}
+		cfg.workers = append(cfg.workers, bw)
+		return nil
+	}
+}
+
+// A WorkerOption adjusts how a background worker is restarted.
+type WorkerOption func(*backgroundWorker)
+
+// Backoff returns a worker option that sets the delay before restarting a worker that failed, which starts at min and
+// doubles with each consecutive failure up to max.  The default is to start at one second, up to one minute.  A worker
+// that runs for at least max before failing is considered healthy, and the delay starts over.
+func Backoff(min, max time.Duration) WorkerOption {
+	return func(bw *backgroundWorker) { bw.minBackoff, bw.maxBackoff = min, max }
+}
+
+// MaxRestarts returns a worker option that limits how many consecutive times a worker is restarted before it is left
+// failed.  The default, zero, restarts a worker without limit.
+func MaxRestarts(n int) WorkerOption {
+	return func(bw *backgroundWorker) { bw.maxRestarts = n }
+}
+
+// WorkerStatus describes the state of a background worker.
+type WorkerStatus struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`           // One of "idle", "running", "backoff", "stopped" or "failed".
+	Restarts int       `json:"restarts"`        // The number of times the worker has been restarted.
+	Error    string    `json:"error,omitempty"` // The reason the worker last failed, if it has failed.
+	Since    time.Time `json:"since"`           // When the worker entered its current state.
+}
+
+// Workers returns the status of the background workers added by Worker.
+func (cfg *Config) Workers() []WorkerStatus {
+	cfg.control.Lock()
+	workers := cfg.workers
+	cfg.control.Unlock()
+	ret := make([]WorkerStatus, len(workers))
+	for i, bw := range workers {
+		ret[i] = bw.status()
+	}
+	return ret
+}
+
+// backgroundWorker tracks a function added by Worker.
+type backgroundWorker struct {
+	name        string
+	fn          func(ctx context.Context) error
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	maxRestarts int
+
+	control sync.Mutex
+	state   WorkerStatus
+}
+
+// run calls fn until it returns nil, the context is done, or it has been restarted too many times.
+func (bw *backgroundWorker) run(ctx context.Context) {
+	backoff, failures := bw.minBackoff, 0
+	for {
+		bw.setState(`running`, nil)
+		started := time.Now()
+		err := bw.call(ctx)
+		switch {
+		case ctx.Err() != nil:
+			bw.setState(`stopped`, nil)
+			return
+		case err == nil:
+			hog.From(ctx).Info().Str(`worker`, bw.name).Msg(`background worker finished`)
+			bw.setState(`stopped`, nil)
+			return
+		}
+		if time.Since(started) >= bw.maxBackoff {
+			backoff, failures = bw.minBackoff, 0
+		}
+		failures++
+		if bw.maxRestarts > 0 && failures > bw.maxRestarts {
+			hog.From(ctx).Error().Err(err).Str(`worker`, bw.name).Msg(`background worker failed too many times`)
+			bw.setState(`failed`, err)
+			return
+		}
+		hog.From(ctx).Warn().Err(err).Str(`worker`, bw.name).Dur(`backoff`, backoff).Msg(`restarting background worker`)
+		bw.setState(`backoff`, err)
+		select {
+		case <-ctx.Done():
+			bw.setState(`stopped`, err)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, bw.maxBackoff)
+		bw.control.Lock()
+		bw.state.Restarts++
+		bw.control.Unlock()
+	}
+}
+
+// call calls fn, converting a panic into an error.
+func (bw *backgroundWorker) call(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf(`panic: %v`, r)
+		}
+	}()
+	err = bw.fn(ctx)
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		err = nil
+	}
+	return err
+}
+
+func (bw *backgroundWorker) setState(state string, err error) {
+	bw.control.Lock()
+	defer bw.control.Unlock()
+	bw.state.State, bw.state.Since, bw.state.Error = state, time.Now(), ``
+	if err != nil {
+		bw.state.Error = err.Error()
+	}
+}
+
+func (bw *backgroundWorker) status() WorkerStatus {
+	bw.control.Lock()
+	defer bw.control.Unlock()
+	return bw.state
+}
+
+// workersHook starts the background workers and serves their status.
+type workersHook struct{ cfg *Config }
+
+// RigBeforeServe implements hook.BeforeServe by starting the background workers, which are stopped when the context
+// is done.
+func (wh workersHook) RigBeforeServe(ctx context.Context) error {
+	wh.cfg.control.Lock()
+	workers := wh.cfg.workers
+	wh.cfg.control.Unlock()
+	for _, bw := range workers {
+		wh.cfg.background.Add(1)
+		go func(bw *backgroundWorker) {
+			defer wh.cfg.background.Done()
+			bw.run(ctx)
+		}(bw)
+	}
+	return nil
+}
+
+// RigMux implements hook.Mux by adding the "/_rig/workers" endpoint.
+func (wh workersHook) RigMux(mux *http.ServeMux) {
+	mux.HandleFunc(`GET /_rig/workers`, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Type`, `application/json`)
+		_ = json.NewEncoder(w).Encode(wh.cfg.Workers())
+	})
+}
+
+// RigRoutes implements hook.Routes by describing the "/_rig/workers" endpoint.
+func (wh workersHook) RigRoutes() []hook.Route {
+	return []hook.Route{{Pattern: `GET /_rig/workers`, Handler: `rig.Worker`}}
+}
+
+var (
+	_ hook.BeforeServe = workersHook{}
+	_ hook.Mux         = workersHook{}
+	_ hook.Routes      = workersHook{}
+)
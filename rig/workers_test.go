@@ -0,0 +1,75 @@
+package rig
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackgroundWorkerRestarts(t *testing.T) {
+	var calls int
+	cfg, err := New(Worker(`flaky`, func(ctx context.Context) error {
+		calls++
+		switch calls {
+		case 1:
+			return errors.New(`failed`)
+		case 2:
+			panic(`panicked`)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}, Backoff(time.Millisecond, 10*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = cfg.beforeServe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := waitForWorker(t, cfg, `running`, 2)
+	if status.Error != `` {
+		t.Fatalf(`expected a running worker to have no error, got %q`, status.Error)
+	}
+	cancel()
+	cfg.background.Wait()
+	if status := cfg.Workers()[0]; status.State != `stopped` {
+		t.Fatalf(`expected worker to be stopped, got %q`, status.State)
+	}
+}
+
+func TestBackgroundWorkerMaxRestarts(t *testing.T) {
+	cfg, err := New(Worker(`broken`, func(ctx context.Context) error {
+		return errors.New(`broken`)
+	}, Backoff(time.Millisecond, time.Millisecond), MaxRestarts(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = cfg.beforeServe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.background.Wait()
+	if status := cfg.Workers()[0]; status.State != `failed` || status.Restarts != 2 || status.Error != `broken` {
+		t.Fatalf(`expected worker to fail after 2 restarts, got %+v`, status)
+	}
+}
+
+// waitForWorker waits for the first worker to reach the given state after the given number of restarts.
+func waitForWorker(t *testing.T, cfg *Config, state string, restarts int) WorkerStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := cfg.Workers()[0]
+		if status.State == state && status.Restarts == restarts {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf(`expected worker to be %v after %v restarts, got %+v`, state, restarts, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,267 @@
+// Package www provides a rig option that serves static files from a directory with entity tags that change when the
+// files change, so browsers can cheaply revalidate them.
+package www
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swdunlop/html-go/hog"
+	"github.com/swdunlop/rig-go/rig"
+	"github.com/swdunlop/rig-go/rig/hook"
+	"github.com/swdunlop/rig-go/rig/watcher"
+)
+
+// Rig returns a rig option that serves the files in the given directory at the root of the rig, responding to any GET
+// or HEAD request that is not handled by a more specific pattern.
+//
+// Each file is served with an ETag derived from a hash of its content, and requests with a matching If-None-Match
+// header get a 304 Not Modified response.  The directory is watched for changes while the rig is serving, and a file's
+// hash is computed again after it changes.  By default, files are served with "Cache-Control: no-cache" so browsers
+// revalidate them on every use; use CacheControl to change this.
+//
+// If a file has a precompressed copy with a ".br" or ".gz" suffix, such as those written by esbuild.Precompress, that
+// copy is served instead to clients that accept its encoding.
+//
+// Like api.FS, a request for a directory serves its "index.html" if it has one, or lists the directory if it does not,
+// and requests for directories without a trailing slash are redirected.
+func Rig(dir string, options ...Option) rig.Option {
+	return func(r *rig.Config) error {
+		srv := &server{
+			dir:          dir,
+			root:         http.Dir(dir),
+			cacheControl: `no-cache`,
+			etags:        make(map[string]etag),
+		}
+		srv.fallback = http.FileServer(srv.root)
+		for _, option := range options {
+			option(srv)
+		}
+		r.Hook(srv)
+		return nil
+	}
+}
+
+// An Option adjusts how static files are served.
+type Option func(*server)
+
+// CacheControl returns an option that sets the Cache-Control header sent with each file, such as
+// "public, max-age=31536000, immutable" for files with hashed names.  An empty value omits the header.
+func CacheControl(value string) Option {
+	return func(srv *server) { srv.cacheControl = value }
+}
+
+type server struct {
+	dir          string
+	root         http.FileSystem
+	fallback     http.Handler // serves redirects, listings and errors like api.FS
+	cacheControl string
+
+	control sync.Mutex
+	etags   map[string]etag // cached entity tags by file name, cleared when the directory changes
+}
+
+// An etag is the entity tag of a file along with the modification time and size it was computed from.
+type etag struct {
+	modTime time.Time
+	size    int64
+	tag     string
+}
+
+var (
+	_ hook.BeforeServe = (*server)(nil)
+	_ hook.Mux         = (*server)(nil)
+	_ hook.Routes      = (*server)(nil)
+)
+
+// RigBeforeServe implements hook.BeforeServe by watching the directory for changes until the context is done.
+func (srv *server) RigBeforeServe(ctx context.Context) error {
+	wr, err := watcher.Start(watcher.Directory(srv.dir))
+	if err != nil {
+		return fmt.Errorf(`%w while watching %v`, err, srv.dir)
+	}
+	go func() {
+		defer wr.Shutdown()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-wr.Alert():
+				srv.forget()
+			}
+		}
+	}()
+	return nil
+}
+
+// RigMux implements hook.Mux.
+func (srv *server) RigMux(mux *http.ServeMux) { mux.Handle(`GET /`, srv) }
+
+// RigRoutes implements hook.Routes.
+func (srv *server) RigRoutes() []hook.Route {
+	return []hook.Route{{Pattern: `GET /`, Handler: fmt.Sprintf(`www.Rig(%q)`, srv.dir)}}
+}
+
+// ServeHTTP serves a file with its entity tag, leaving anything other than a file or directory index to the fallback.
+func (srv *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := r.URL.Path
+	if !strings.HasPrefix(urlPath, `/`) {
+		urlPath = `/` + urlPath
+	}
+	if strings.HasSuffix(urlPath, `/index.html`) {
+		srv.fallback.ServeHTTP(w, r) // redirects to the directory.
+		return
+	}
+	name := path.Clean(urlPath)
+	f, info, ok := srv.open(name)
+	if ok && info.IsDir() {
+		f.Close()
+		if !strings.HasSuffix(urlPath, `/`) {
+			ok = false // the fallback will redirect to the directory.
+		} else {
+			name = path.Join(name, `index.html`)
+			f, info, ok = srv.open(name)
+			ok = ok && !info.IsDir()
+		}
+	}
+	if !ok {
+		srv.fallback.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	modTime := info.ModTime()
+	if srv.hasPrecompressed(name) {
+		w.Header().Add(`Vary`, `Accept-Encoding`)
+	}
+	if encoding, cf, cinfo, ok := srv.openPrecompressed(r, name); ok {
+		defer cf.Close()
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == `` {
+			contentType = `application/octet-stream` // instead of sniffing the compressed content.
+		}
+		w.Header().Set(`Content-Type`, contentType)
+		w.Header().Set(`Content-Encoding`, encoding)
+		name, f, info = name+precompressed[encoding], cf, cinfo
+	}
+	tag, err := srv.etag(name, f, info)
+	if err != nil {
+		hog.For(r).Warn().Err(err).Str(`file`, name).Msg(`failed to compute entity tag`)
+	} else {
+		w.Header().Set(`ETag`, tag)
+	}
+	if srv.cacheControl != `` {
+		w.Header().Set(`Cache-Control`, srv.cacheControl)
+	}
+	http.ServeContent(w, r, info.Name(), modTime, f)
+}
+
+// precompressed maps content encodings to the extension of files precompressed with them, such as those written by
+// esbuild.Precompress.
+var precompressed = map[string]string{`br`: `.br`, `gzip`: `.gz`}
+
+// openPrecompressed opens a precompressed copy of a file in an encoding the client accepts, preferring brotli.
+func (srv *server) openPrecompressed(r *http.Request, name string) (string, http.File, fs.FileInfo, bool) {
+	for _, encoding := range []string{`br`, `gzip`} {
+		if !acceptsEncoding(r, encoding) {
+			continue
+		}
+		f, info, ok := srv.open(name + precompressed[encoding])
+		if !ok {
+			continue
+		}
+		if info.Mode().IsRegular() {
+			return encoding, f, info, true
+		}
+		f.Close()
+	}
+	return ``, nil, nil, false
+}
+
+// hasPrecompressed returns true if a file has any precompressed copies, so its response varies by the encodings
+// accepted by the client.
+func (srv *server) hasPrecompressed(name string) bool {
+	for _, ext := range precompressed {
+		f, _, ok := srv.open(name + ext)
+		if ok {
+			f.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsEncoding returns true if the Accept-Encoding header of the request includes the encoding with a non-zero
+// quality.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, header := range r.Header.Values(`Accept-Encoding`) {
+		for _, item := range strings.Split(header, `,`) {
+			item, params, _ := strings.Cut(item, `;`)
+			if !strings.EqualFold(strings.TrimSpace(item), encoding) {
+				continue
+			}
+			param, value, ok := strings.Cut(params, `=`)
+			if !ok || strings.TrimSpace(param) != `q` {
+				return true
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			return err == nil && q > 0
+		}
+	}
+	return false
+}
+
+// open opens and stats a file, returning false if either fails.
+func (srv *server) open(name string) (http.File, fs.FileInfo, bool) {
+	f, err := srv.root.Open(name)
+	if err != nil {
+		return nil, nil, false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, false
+	}
+	return f, info, true
+}
+
+// etag returns the entity tag for a file, hashing its content if it has changed since its tag was last computed.  The
+// file is left positioned at its start.
+func (srv *server) etag(name string, f http.File, info fs.FileInfo) (string, error) {
+	srv.control.Lock()
+	cached, ok := srv.etags[name]
+	srv.control.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.tag, nil
+	}
+	hash := sha256.New()
+	_, err := io.Copy(hash, f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return ``, err
+	}
+	tag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	srv.control.Lock()
+	defer srv.control.Unlock()
+	srv.etags[name] = etag{modTime: info.ModTime(), size: info.Size(), tag: tag}
+	return tag, nil
+}
+
+// forget clears the cached entity tags after the directory changes.
+func (srv *server) forget() {
+	srv.control.Lock()
+	defer srv.control.Unlock()
+	clear(srv.etags)
+}
@@ -0,0 +1,96 @@
+package www
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/swdunlop/rig-go/rig"
+)
+
+func TestETag(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, `index.html`)
+	err := os.WriteFile(file, []byte(`<p>one</p>`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rig.New(Rig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := r.Handler()
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(`GET`, `/`, nil)
+		if etag != `` {
+			req.Header.Set(`If-None-Match`, etag)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rsp := get(``)
+	tag := rsp.Header().Get(`ETag`)
+	if rsp.Code != http.StatusOK || tag == `` {
+		t.Fatalf(`expected 200 with an ETag, got %v with %q`, rsp.Code, tag)
+	}
+	if ct := rsp.Header().Get(`Content-Type`); ct != `text/html; charset=utf-8` {
+		t.Fatalf(`expected an HTML content type, got %q`, ct)
+	}
+	if rsp := get(tag); rsp.Code != http.StatusNotModified {
+		t.Fatalf(`expected 304 for a matching ETag, got %v`, rsp.Code)
+	}
+
+	err = os.WriteFile(file, []byte(`<p>two</p>`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	err = os.Chtimes(file, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp = get(tag)
+	if rsp.Code != http.StatusOK || rsp.Header().Get(`ETag`) == tag {
+		t.Fatalf(`expected 200 with a new ETag after a change, got %v with %q`, rsp.Code, rsp.Header().Get(`ETag`))
+	}
+}
+
+func TestPrecompressed(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{`app.js`: `plain`, `app.js.gz`: `gzipped`} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := rig.New(Rig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := r.Handler()
+	for _, test := range []struct{ accept, encoding, body string }{
+		{``, ``, `plain`},
+		{`gzip, br`, `gzip`, `gzipped`},
+		{`gzip;q=0`, ``, `plain`},
+	} {
+		req := httptest.NewRequest(`GET`, `/app.js`, nil)
+		req.Header.Set(`Accept-Encoding`, test.accept)
+		rsp := httptest.NewRecorder()
+		handler.ServeHTTP(rsp, req)
+		if got := rsp.Header().Get(`Content-Encoding`); got != test.encoding || rsp.Body.String() != test.body {
+			t.Errorf(`expected %q encoded %q for %q, got %q encoded %q`, test.body, test.encoding, test.accept, rsp.Body.String(), got)
+		}
+		if ct := rsp.Header().Get(`Content-Type`); ct != `text/javascript; charset=utf-8` {
+			t.Errorf(`expected a JavaScript content type for %q, got %q`, test.accept, ct)
+		}
+		if rsp.Header().Get(`Vary`) != `Accept-Encoding` {
+			t.Errorf(`expected responses to vary by Accept-Encoding`)
+		}
+	}
+}